@@ -0,0 +1,45 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/mailer"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+// issueActionToken replaces the outstanding tokens of the user for purpose
+// with a new one and returns its secret, so only the latest mailed token
+// can be used.
+func issueActionToken(ctx context.Context, store *db.Store, user *types.User, purpose types.TokenPurpose, ttl time.Duration) (string, error) {
+	if err := store.ActionToken.DeleteActionTokens(ctx, user.ID.Hex(), purpose); err != nil {
+		return "", err
+	}
+	secret, err := types.NewSecret()
+	if err != nil {
+		return "", err
+	}
+	token := types.NewActionToken(user.ID, purpose, types.HashSecret(secret), ttl)
+	if _, err := store.ActionToken.InsertActionToken(ctx, token); err != nil {
+		return "", err
+	}
+	return secret, nil
+}
+
+// actionTokenMessage mails the secret to the user. When the linkEnv
+// variable is set the mail also links to it with the token.
+func actionTokenMessage(user *types.User, subject, intro, linkEnv, secret string) mailer.Message {
+	body := fmt.Sprintf("Hi %s,\n\n%s\n\ntoken: %s\n", user.FirstName, intro, secret)
+	if link := os.Getenv(linkEnv); link != "" {
+		body += fmt.Sprintf("\nor open %s?token=%s\n", link, url.QueryEscape(secret))
+	}
+	return mailer.Message{
+		To:      user.Email,
+		Subject: subject,
+		Body:    body,
+	}
+}
@@ -1,15 +1,38 @@
 package api
 
 import (
+	"net/http"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/tomekzakrzewski/go-movierental/types"
 )
 
+// AdminAuth lets staff into the admin routes once they logged in with a
+// second factor, or with an API key of a staff user, which only admins
+// logged in that way can create. Every admin route still declares the
+// permission it needs with RequirePermission.
 func AdminAuth(c *fiber.Ctx) error {
 	user, ok := c.Context().Value("user").(*types.User)
-	if !ok || !user.IsAdmin {
+	if !ok || !user.IsStaff() {
 		return ErrUnAuthorized()
 	}
+	if _, ok := c.Context().Value("apiKey").(*types.APIKey); ok {
+		return c.Next()
+	}
+	session, ok := c.Context().Value("session").(*types.Session)
+	if !ok || !session.TwoFactor {
+		return NewError(http.StatusForbidden, "two-factor authentication required")
+	}
 	return c.Next()
+}
 
+// RequirePermission rejects users without a role granting perm.
+func RequirePermission(perm types.Permission) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		user, ok := c.Context().Value("user").(*types.User)
+		if !ok || !user.Can(perm) {
+			return ErrForbidden()
+		}
+		return c.Next()
+	}
 }
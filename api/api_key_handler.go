@@ -0,0 +1,136 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type APIKeyHandler struct {
+	store *db.Store
+}
+
+func NewAPIKeyHandler(store *db.Store) *APIKeyHandler {
+	return &APIKeyHandler{
+		store: store,
+	}
+}
+
+// APIKeyResponse is a newly created key together with its secret, which is
+// not shown again.
+type APIKeyResponse struct {
+	*types.APIKey
+	Key string `json:"key"`
+}
+
+// @Summary		Create an API key
+// @Description	Handle creating a named API key of a user, the signed in admin when userID is empty. The key is sent in the Api-Key header instead of a token and can only use its scopes, each of which the user and the admin need to hold. The key is only shown in this response
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			params	body	types.CreateAPIKeyParams	true	"name, user, scopes and optional expiry"
+// @Router			/api-keys [post]
+func (h *APIKeyHandler) HandlePostAPIKey(c *fiber.Ctx) error {
+	actor, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	var params types.CreateAPIKeyParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+	user := actor
+	if params.UserID != "" && params.UserID != actor.ID.Hex() {
+		var err error
+		if user, err = h.store.User.GetUserByID(c.Context(), params.UserID); err != nil {
+			return ErrResourceNotFound("User")
+		}
+	}
+	// checking the admin too keeps a key that can manage keys from creating
+	// keys wider than itself
+	for _, scope := range params.Scopes {
+		if !user.Can(scope) || !actor.Can(scope) {
+			return c.Status(http.StatusBadRequest).JSON(map[string]string{
+				"scopes": fmt.Sprintf("scope not granted: %s", scope),
+			})
+		}
+	}
+	secret, err := types.NewSecret()
+	if err != nil {
+		return err
+	}
+	key, err := h.store.APIKey.InsertAPIKey(c.Context(), types.NewAPIKeyFromParams(params, user.ID, actor.ID, types.HashSecret(secret)))
+	if err != nil {
+		return err
+	}
+	entry := types.NewAuditEntry(types.AuditAPIKeyCreate, actor.ID, "user:"+user.ID.Hex(), key.ID.Hex()+" "+key.Name)
+	if _, err := h.store.Audit.InsertAuditEntry(c.Context(), entry); err != nil {
+		return err
+	}
+	return c.JSON(APIKeyResponse{
+		APIKey: key,
+		Key:    key.ID.Hex() + "." + secret,
+	})
+}
+
+// @Summary		Get API keys
+// @Description	Handle listing API keys, newest first, optionally of a single user. Revoked and expired keys are listed too
+// @Tags			admin
+// @Produce		json
+// @Param			userID	query	string	false	"user id"
+// @Param			limit	query	int		false	"page size, 20 by default and at most 100"
+// @Param			cursor	query	string	false	"nextCursor of the previous page"
+// @Router			/api-keys [get]
+func (h *APIKeyHandler) HandleGetAPIKeys(c *fiber.Ctx) error {
+	filter := map[string]any{}
+	if userID := c.Query("userID"); userID != "" {
+		oid, err := primitive.ObjectIDFromHex(userID)
+		if err != nil {
+			return NewError(http.StatusBadRequest, fmt.Sprintf("invalid user id: %s", userID))
+		}
+		filter["userID"] = oid
+	}
+	pag, err := paginationFromQuery(c)
+	if err != nil {
+		return err
+	}
+	page, err := h.store.APIKey.GetAPIKeys(c.Context(), filter, pag)
+	if err != nil {
+		return listError(err, "API keys")
+	}
+	return c.JSON(newResourceResp(page))
+}
+
+// @Summary		Revoke an API key
+// @Description	Handle revoking an API key, it stops being accepted right away
+// @Tags			admin
+// @Produce		json
+// @Param			id	path	string	true	"api key id"
+// @Router			/api-keys/:id/revoke [post]
+func (h *APIKeyHandler) HandleRevokeAPIKey(c *fiber.Ctx) error {
+	actor, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	id := c.Params("id")
+	if err := h.store.APIKey.RevokeAPIKey(c.Context(), id, time.Now()); err != nil {
+		return ErrResourceNotFound("API key")
+	}
+	key, err := h.store.APIKey.GetAPIKeyByID(c.Context(), id)
+	if err != nil {
+		return ErrResourceNotFound("API key")
+	}
+	entry := types.NewAuditEntry(types.AuditAPIKeyRevoke, actor.ID, "user:"+key.UserID.Hex(), key.ID.Hex()+" "+key.Name)
+	if _, err := h.store.Audit.InsertAuditEntry(c.Context(), entry); err != nil {
+		return err
+	}
+	return c.JSON(key)
+}
@@ -0,0 +1,115 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/mailer"
+	"github.com/tomekzakrzewski/go-movierental/payment"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestAPIKeys(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		app           = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1         = app.Group("", JWTAuthentication(tdb.Store))
+		admin         = apiv1.Group("/admin", AdminAuth)
+		userHandler   = NewUserHandler(tdb.Store, mailer.NewLogMailer(io.Discard))
+		rentHandler   = NewRentHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
+		apiKeyHandler = NewAPIKeyHandler(tdb.Store)
+		adminUser     = fixtures.AddUser(tdb.Store, "admin", "admin", true)
+		kiosk         = fixtures.AddUser(tdb.Store, "kiosk", "service", false)
+		tomek         = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		adminToken    = tdb.token(t, adminUser)
+	)
+	if err := tdb.User.UpdateUserRoles(context.TODO(), kiosk.ID.Hex(), []types.Role{types.RoleClerk}); err != nil {
+		t.Fatal(err)
+	}
+	apiv1.Get("/me", userHandler.HandleGetMe)
+	admin.Get("/users", RequirePermission(types.PermReadUsers), userHandler.HandleGetUsers)
+	admin.Get("/rents", RequirePermission(types.PermManageRents), rentHandler.HandleGetRents)
+	admin.Post("/api-keys", RequirePermission(types.PermManageAPIKeys), apiKeyHandler.HandlePostAPIKey)
+	admin.Get("/api-keys", RequirePermission(types.PermManageAPIKeys), apiKeyHandler.HandleGetAPIKeys)
+	admin.Post("/api-keys/:id/revoke", RequirePermission(types.PermManageAPIKeys), apiKeyHandler.HandleRevokeAPIKey)
+
+	create := func(params types.CreateAPIKeyParams, expected int) APIKeyResponse {
+		t.Helper()
+		var created APIKeyResponse
+		resp := request(t, app, "POST", "/admin/api-keys", adminToken, params, expected)
+		if expected == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&created); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return created
+	}
+	readUsers := []types.Permission{types.PermReadUsers}
+
+	// clerks can't manage users and every key needs a scope
+	create(types.CreateAPIKeyParams{Name: "kiosk", UserID: kiosk.ID.Hex(), Scopes: []types.Permission{types.PermManageUsers}}, 400)
+	create(types.CreateAPIKeyParams{Name: "kiosk", UserID: kiosk.ID.Hex()}, 400)
+	create(types.CreateAPIKeyParams{Name: "kiosk", UserID: kiosk.ID.Hex(), Scopes: []types.Permission{"movies:steal"}}, 400)
+	past := time.Now().Add(-time.Hour)
+	create(types.CreateAPIKeyParams{Name: "kiosk", UserID: kiosk.ID.Hex(), Scopes: readUsers, ExpiresAt: &past}, 400)
+
+	created := create(types.CreateAPIKeyParams{Name: "kiosk", UserID: kiosk.ID.Hex(), Scopes: readUsers}, 200)
+	if created.Key == "" || created.UserID != kiosk.ID || created.CreatedBy != adminUser.ID {
+		t.Fatalf("unexpected api key %+v", created)
+	}
+
+	var me types.User
+	json.NewDecoder(requestWithHeader(t, app, "GET", "/me", http.Header{"Api-Key": {created.Key}}, nil, 200).Body).Decode(&me)
+	if me.ID != kiosk.ID {
+		t.Fatalf("expected the key to act as %s but got %s", kiosk.ID.Hex(), me.ID.Hex())
+	}
+	requestWithHeader(t, app, "GET", "/admin/users", http.Header{"Api-Key": {created.Key}}, nil, 200)
+	// the clerk can manage rents but the key is not scoped to
+	requestWithHeader(t, app, "GET", "/admin/rents", http.Header{"Api-Key": {created.Key}}, nil, 403)
+	requestWithHeader(t, app, "GET", "/admin/users", http.Header{"Api-Key": {created.ID.Hex() + ".wrong"}}, nil, 401)
+	requestWithHeader(t, app, "GET", "/admin/users", http.Header{"Api-Key": {"garbage"}}, nil, 401)
+
+	key, err := tdb.APIKey.GetAPIKeyByID(context.TODO(), created.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.LastUsedAt == nil {
+		t.Fatal("expected the use of the key to be recorded")
+	}
+
+	// keys of customers don't get into admin routes
+	customerKey := create(types.CreateAPIKeyParams{Name: "reports", UserID: tomek.ID.Hex(), Scopes: []types.Permission{types.PermRentMovies}}, 200)
+	requestWithHeader(t, app, "GET", "/admin/users", http.Header{"Api-Key": {customerKey.Key}}, nil, 401)
+	requestWithHeader(t, app, "GET", "/me", http.Header{"Api-Key": {customerKey.Key}}, nil, 200)
+
+	expired, err := tdb.APIKey.GetAPIKeyByID(context.TODO(), customerKey.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired.ExpiresAt = &past
+	expired.ID = primitive.NilObjectID
+	secret, _ := types.NewSecret()
+	expired.Hash = types.HashSecret(secret)
+	if _, err := tdb.APIKey.InsertAPIKey(context.TODO(), expired); err != nil {
+		t.Fatal(err)
+	}
+	requestWithHeader(t, app, "GET", "/me", http.Header{"Api-Key": {expired.ID.Hex() + "." + secret}}, nil, 401)
+
+	var page ResourceResp
+	json.NewDecoder(request(t, app, "GET", "/admin/api-keys?userID="+kiosk.ID.Hex(), adminToken, nil, 200).Body).Decode(&page)
+	if page.Results != 1 {
+		t.Fatalf("expected 1 key of the kiosk but got %d", page.Results)
+	}
+
+	request(t, app, "POST", "/admin/api-keys/"+created.ID.Hex()+"/revoke", adminToken, nil, 200)
+	requestWithHeader(t, app, "GET", "/admin/users", http.Header{"Api-Key": {created.Key}}, nil, 401)
+	request(t, app, "POST", "/admin/api-keys/"+tomek.ID.Hex()+"/revoke", adminToken, nil, 404)
+}
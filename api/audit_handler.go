@@ -0,0 +1,45 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+type AuditHandler struct {
+	store db.AuditStore
+}
+
+func NewAuditHandler(store db.AuditStore) *AuditHandler {
+	return &AuditHandler{
+		store: store,
+	}
+}
+
+// @Summary		Get the audit log
+// @Description	Handle listing audit entries, newest first, optionally filtered by action and subject
+// @Tags			admin
+// @Produce		json
+// @Param			action	query	string	false	"audit action"	Enums(login.locked, login.unlocked, totp.reset, identity.linked, apikey.created, apikey.revoked)
+// @Param			subject	query	string	false	"audit subject, such as account:<email>, ip:<address> or user:<id>"
+// @Param			limit	query	int		false	"page size, 20 by default and at most 100"
+// @Param			cursor	query	string	false	"nextCursor of the previous page"
+// @Router			/audit [get]
+func (h *AuditHandler) HandleGetAudit(c *fiber.Ctx) error {
+	filter := map[string]any{}
+	if action := c.Query("action"); action != "" {
+		filter["action"] = types.AuditAction(action)
+	}
+	if subject := c.Query("subject"); subject != "" {
+		filter["subject"] = subject
+	}
+	pag, err := paginationFromQuery(c)
+	if err != nil {
+		return err
+	}
+	page, err := h.store.GetAuditEntries(c.Context(), filter, pag)
+	if err != nil {
+		return listError(err, "Audit entries")
+	}
+	return c.JSON(newResourceResp(page))
+}
@@ -1,26 +1,33 @@
 package api
 
 import (
+	"context"
 	"errors"
-	"fmt"
 	"net/http"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/tomekzakrzewski/go-movierental/db"
 	"github.com/tomekzakrzewski/go-movierental/types"
-	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	accessTokenTTL = 15 * time.Minute
+	sessionTTL     = 30 * 24 * time.Hour
+	challengeTTL   = 5 * time.Minute
 )
 
 type AuthHandler struct {
-	userStore db.UserStore
+	store    *db.Store
+	throttle *LoginThrottle
 }
 
-func NewAuthHandler(userStore db.UserStore) *AuthHandler {
+func NewAuthHandler(store *db.Store, throttle *LoginThrottle) *AuthHandler {
 	return &AuthHandler{
-		userStore: userStore,
+		store:    store,
+		throttle: throttle,
 	}
 }
 
@@ -30,8 +37,28 @@ type AuthParams struct {
 }
 
 type AuthResponse struct {
-	User  *types.User `json:"user"`
-	Token string      `json:"token"`
+	User         *types.User `json:"user"`
+	Token        string      `json:"token"`
+	RefreshToken string      `json:"refreshToken"`
+}
+
+// ChallengeResponse is the first step of a login with a second factor.
+// The challenge is exchanged for the tokens together with a code.
+type ChallengeResponse struct {
+	TwoFactorRequired bool   `json:"twoFactorRequired"`
+	Challenge         string `json:"challenge"`
+}
+
+// TwoFactorParams completes a login with a code of the authenticator app
+// or, when it is lost, with one of the recovery codes.
+type TwoFactorParams struct {
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recoveryCode"`
+}
+
+type RefreshParams struct {
+	RefreshToken string `json:"refreshToken"`
 }
 
 type genericResp struct {
@@ -47,7 +74,7 @@ func invalidCredentials(c *fiber.Ctx) error {
 }
 
 //	@Summary		Authenticate user
-//	@Description	Handle authenticating user
+//	@Description	Handle authenticating user. Users with two-factor authentication get a 202 with a challenge to complete at /auth/2fa instead of the tokens. Failed logins are throttled per account and per client IP, too many of them answer 429 with a Retry-After header
 //	@Tags			authentication
 //	@Accept			json
 //	@Produce		json
@@ -57,37 +84,275 @@ func (h *AuthHandler) HandleAuthenticate(c *fiber.Ctx) error {
 	if err := c.BodyParser(&params); err != nil {
 		return err
 	}
-	user, err := h.userStore.GetUserByEmail(c.Context(), params.Email)
-	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return invalidCredentials(c)
+	if err := h.throttle.check(c, params.Email); err != nil {
+		return err
+	}
+	user, err := h.store.User.GetUserByEmail(c.Context(), params.Email)
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
+		return err
+	}
+	if user == nil || !types.IsValidPassword(user.EncryptedPassword, params.Password) {
+		if err := h.throttle.fail(c, params.Email); err != nil {
+			return err
 		}
+		return invalidCredentials(c)
+	}
+	return h.firstFactor(c, user)
+}
+
+// firstFactor continues a login whose first factor was verified. Users with
+// two-factor authentication get a challenge, everyone else is logged in.
+func (h *AuthHandler) firstFactor(c *fiber.Ctx, user *types.User) error {
+	totp, err := h.store.TOTP.GetTOTP(c.Context(), user.ID.Hex())
+	if err != nil && !errors.Is(err, db.ErrNotFound) {
 		return err
 	}
+	if totp != nil && totp.Confirmed {
+		// the failures are kept until the second step succeeds, otherwise
+		// knowing the password would allow guessing codes endlessly
+		challenge, err := issueActionToken(c.Context(), h.store, user, types.TokenTwoFactor, challengeTTL)
+		if err != nil {
+			return err
+		}
+		return c.Status(http.StatusAccepted).JSON(ChallengeResponse{
+			TwoFactorRequired: true,
+			Challenge:         challenge,
+		})
+	}
+	return h.login(c, user, false)
+}
 
-	if !types.IsValidPassword(user.EncryptedPassword, params.Password) {
+//	@Summary		Complete a two-factor login
+//	@Description	Handle exchanging the challenge of /auth and a code of the authenticator app, or a recovery code, for the tokens. A challenge can be used once, a wrong code requires logging in again
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Param			params	body	TwoFactorParams	true	"challenge and code"
+//	@Router			/auth/2fa [post]
+func (h *AuthHandler) HandleTwoFactor(c *fiber.Ctx) error {
+	var params TwoFactorParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	now := time.Now()
+	challenge, err := h.store.ActionToken.UseActionToken(c.Context(), types.TokenTwoFactor, types.HashSecret(params.Challenge), now)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return ErrUnAuthorized()
+		}
+		return err
+	}
+	user, err := h.store.User.GetUserByID(c.Context(), challenge.UserID.Hex())
+	if err != nil {
+		return ErrUnAuthorized()
+	}
+	if err := h.throttle.check(c, user.Email); err != nil {
+		return err
+	}
+	if err := verifySecondFactor(c.Context(), h.store.TOTP, user, params.Code, params.RecoveryCode, now); err != nil {
+		if !errors.Is(err, errInvalidCode) {
+			return err
+		}
+		if err := h.throttle.fail(c, user.Email); err != nil {
+			return err
+		}
 		return invalidCredentials(c)
 	}
+	return h.login(c, user, true)
+}
+
+// login ends a successful login by clearing the failed attempts of the
+// account and starting a session.
+func (h *AuthHandler) login(c *fiber.Ctx, user *types.User, twoFactor bool) error {
+	if err := h.throttle.succeed(c.Context(), user.Email); err != nil {
+		return err
+	}
+	token, refreshToken, err := IssueTokens(c.Context(), h.store.Session, user, twoFactor)
+	if err != nil {
+		return err
+	}
 	resp := AuthResponse{
-		User:  user,
-		Token: CreateTokenFromUser(user),
+		User:         user,
+		Token:        token,
+		RefreshToken: refreshToken,
 	}
 	return c.JSON(resp)
 }
 
-func CreateTokenFromUser(user *types.User) string {
-	now := time.Now()
-	expires := now.Add(time.Hour * 4).Unix()
-	claims := jwt.MapClaims{
-		"id":      user.ID,
-		"email":   user.Email,
-		"expires": expires,
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	secret := os.Getenv("JWT_SECRET")
-	tokenStr, err := token.SignedString([]byte(secret))
+//	@Summary		Refresh tokens
+//	@Description	Handle exchanging a refresh token for a new access token and refresh token. Every refresh token can be used once, using it again revokes the session
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Router			/auth/refresh [post]
+func (h *AuthHandler) HandleRefresh(c *fiber.Ctx) error {
+	var params RefreshParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	session, secret, err := h.sessionFromRefreshToken(c.Context(), params.RefreshToken)
+	if err != nil {
+		return err
+	}
+	newSecret, err := types.NewSecret()
+	if err != nil {
+		return err
+	}
+	sessionID := session.ID.Hex()
+	if err := h.store.Session.RotateSession(c.Context(), sessionID, types.HashSecret(secret), types.HashSecret(newSecret)); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			// a concurrent refresh used the same token first
+			return h.revoke(c.Context(), sessionID)
+		}
+		return err
+	}
+	user, err := h.store.User.GetUserByID(c.Context(), session.UserID.Hex())
+	if err != nil {
+		return ErrUnAuthorized()
+	}
+	token, err := CreateTokenFromUser(user, session)
+	if err != nil {
+		return err
+	}
+	resp := AuthResponse{
+		User:         user,
+		Token:        token,
+		RefreshToken: refreshToken(session, newSecret),
+	}
+	return c.JSON(resp)
+}
+
+//	@Summary		Log out
+//	@Description	Handle revoking the session of a refresh token, its access tokens stop being accepted
+//	@Tags			authentication
+//	@Accept			json
+//	@Produce		json
+//	@Router			/auth/logout [post]
+func (h *AuthHandler) HandleLogout(c *fiber.Ctx) error {
+	var params RefreshParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	session, _, err := h.sessionFromRefreshToken(c.Context(), params.RefreshToken)
+	if err != nil {
+		return err
+	}
+	if err := h.store.Session.RevokeSession(c.Context(), session.ID.Hex(), time.Now()); err != nil {
+		return err
+	}
+	return c.JSON(genericResp{
+		Type: "msg",
+		Msg:  "logged out",
+	})
+}
+
+//	@Summary		Revoke user sessions
+//	@Description	Handle revoking every session of a user, logging them out everywhere
+//	@Tags			admin
+//	@Produce		json
+//	@Router			/users/:id/sessions/revoke [post]
+func (h *AuthHandler) HandleRevokeUserSessions(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if _, err := h.store.User.GetUserByID(c.Context(), id); err != nil {
+		return ErrResourceNotFound("User")
+	}
+	n, err := h.store.Session.RevokeUserSessions(c.Context(), id, time.Now())
+	if err != nil {
+		return err
+	}
+	return c.JSON(map[string]int{"revoked": n})
+}
+
+//	@Summary		Unlock user login
+//	@Description	Handle lifting the login lockout and the failed login count of a user
+//	@Tags			admin
+//	@Produce		json
+//	@Router			/users/:id/unlock [post]
+func (h *AuthHandler) HandleUnlockUser(c *fiber.Ctx) error {
+	user, err := h.store.User.GetUserByID(c.Context(), c.Params("id"))
 	if err != nil {
-		fmt.Println("failed to sign token with secret", err)
+		return ErrResourceNotFound("User")
+	}
+	actor, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	if err := h.throttle.Unlock(c.Context(), user.Email, actor); err != nil {
+		return err
+	}
+	return c.JSON(genericResp{
+		Type: "msg",
+		Msg:  "login unlocked",
+	})
+}
+
+// sessionFromRefreshToken returns the active session of a refresh token and
+// its secret. A token that no longer matches the session was already used,
+// so it was probably stolen and the whole session is revoked.
+func (h *AuthHandler) sessionFromRefreshToken(ctx context.Context, token string) (*types.Session, string, error) {
+	sessionID, secret, ok := strings.Cut(token, ".")
+	if !ok {
+		return nil, "", ErrUnAuthorized()
+	}
+	session, err := h.store.Session.GetSessionByID(ctx, sessionID)
+	if err != nil {
+		return nil, "", ErrUnAuthorized()
+	}
+	if !session.IsActive(time.Now()) {
+		return nil, "", ErrUnAuthorized()
+	}
+	if types.HashSecret(secret) != session.RefreshHash {
+		return nil, "", h.revoke(ctx, sessionID)
+	}
+	return session, secret, nil
+}
+
+func (h *AuthHandler) revoke(ctx context.Context, sessionID string) error {
+	if err := h.store.Session.RevokeSession(ctx, sessionID, time.Now()); err != nil {
+		return err
+	}
+	return ErrUnAuthorized()
+}
+
+// IssueTokens starts a new session for the user and returns its access token
+// and refresh token. twoFactor marks a login completed with a second
+// factor, which the admin routes require.
+func IssueTokens(ctx context.Context, sessions db.SessionStore, user *types.User, twoFactor bool) (string, string, error) {
+	secret, err := types.NewSecret()
+	if err != nil {
+		return "", "", err
+	}
+	session := types.NewSession(user.ID, types.HashSecret(secret), sessionTTL)
+	session.TwoFactor = twoFactor
+	session, err = sessions.InsertSession(ctx, session)
+	if err != nil {
+		return "", "", err
+	}
+	token, err := CreateTokenFromUser(user, session)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken(session, secret), nil
+}
+
+// refreshToken prefixes the secret with the session id so refreshing does
+// not need to look sessions up by hash.
+func refreshToken(session *types.Session, secret string) string {
+	return session.ID.Hex() + "." + secret
+}
+
+// CreateTokenFromUser returns an access token of the session. It stops
+// being accepted when it expires or when the session is revoked.
+func CreateTokenFromUser(user *types.User, session *types.Session) (string, error) {
+	now := time.Now()
+	claims := TokenClaims{
+		SessionID: session.ID.Hex(),
+		Email:     user.Email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   user.ID.Hex(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(accessTokenTTL)),
+		},
 	}
-	return tokenStr
+	return tokenKeys.Sign(claims)
 }
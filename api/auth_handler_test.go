@@ -2,13 +2,20 @@ package api
 
 import (
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/keys"
+	"github.com/tomekzakrzewski/go-movierental/types"
 )
 
 func TestAuthenticateFailure(t *testing.T) {
@@ -17,7 +24,7 @@ func TestAuthenticateFailure(t *testing.T) {
 	userAded := fixtures.AddUser(tdb.Store, "tomek", "tesk", false)
 
 	app := fiber.New()
-	authHandler := NewAuthHandler(tdb.User)
+	authHandler := tdb.authHandler()
 	app.Post("/auth", authHandler.HandleAuthenticate)
 
 	params := AuthParams{
@@ -52,7 +59,7 @@ func TestAuthenticateSuccess(t *testing.T) {
 	user := fixtures.AddUser(tdb.Store, "tomek", "test", false)
 
 	app := fiber.New()
-	authHandler := NewAuthHandler(tdb.User)
+	authHandler := tdb.authHandler()
 	app.Post("/auth", authHandler.HandleAuthenticate)
 
 	params := AuthParams{
@@ -75,3 +82,285 @@ func TestAuthenticateSuccess(t *testing.T) {
 		t.Fatalf("expected http status of 400 but got %d", resp.StatusCode)
 	}
 }
+
+func TestRefreshAndLogout(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		app         = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		authHandler = tdb.authHandler()
+		apiv1       = app.Group("/v1", JWTAuthentication(tdb.Store))
+		user        = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+	)
+	app.Post("/auth", authHandler.HandleAuthenticate)
+	app.Post("/auth/refresh", authHandler.HandleRefresh)
+	app.Post("/auth/logout", authHandler.HandleLogout)
+	apiv1.Get("/me", func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+
+	post := func(path string, body any, expected int) AuthResponse {
+		t.Helper()
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != expected {
+			t.Fatalf("%s: expected status code %d but got %d", path, expected, resp.StatusCode)
+		}
+		var authResp AuthResponse
+		json.NewDecoder(resp.Body).Decode(&authResp)
+		return authResp
+	}
+	access := func(token string, expected int) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/v1/me", nil)
+		req.Header.Add("Api-Token", token)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != expected {
+			t.Fatalf("expected status code %d but got %d", expected, resp.StatusCode)
+		}
+	}
+
+	login := post("/auth", AuthParams{Email: user.Email, Password: "test123"}, 200)
+	if login.RefreshToken == "" {
+		t.Fatal("expected a refresh token")
+	}
+	access(login.Token, 200)
+
+	refreshed := post("/auth/refresh", RefreshParams{RefreshToken: login.RefreshToken}, 200)
+	if refreshed.RefreshToken == login.RefreshToken {
+		t.Fatal("expected the refresh token to be rotated")
+	}
+	if refreshed.User.ID != user.ID {
+		t.Fatalf("expected user id %s but got %s", user.ID, refreshed.User.ID)
+	}
+	access(refreshed.Token, 200)
+
+	// reusing a rotated refresh token revokes the session
+	post("/auth/refresh", RefreshParams{RefreshToken: login.RefreshToken}, 401)
+	post("/auth/refresh", RefreshParams{RefreshToken: refreshed.RefreshToken}, 401)
+	access(refreshed.Token, 401)
+
+	login = post("/auth", AuthParams{Email: user.Email, Password: "test123"}, 200)
+	post("/auth/logout", RefreshParams{RefreshToken: login.RefreshToken}, 200)
+	access(login.Token, 401)
+	post("/auth/refresh", RefreshParams{RefreshToken: login.RefreshToken}, 401)
+	post("/auth/refresh", RefreshParams{RefreshToken: "garbage"}, 401)
+}
+
+func TestRevokeUserSessions(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		app         = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		authHandler = tdb.authHandler()
+		apiv1       = app.Group("", JWTAuthentication(tdb.Store))
+		admin       = apiv1.Group("/admin", AdminAuth)
+		user        = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		adminUser   = fixtures.AddUser(tdb.Store, "admin", "admin", true)
+		adminToken  = tdb.token(t, adminUser)
+		tokens      = []string{tdb.token(t, user), tdb.token(t, user)}
+	)
+	admin.Post("/users/:id/sessions/revoke", authHandler.HandleRevokeUserSessions)
+
+	revoke := func(token string, expected int) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/admin/users/"+user.ID.Hex()+"/sessions/revoke", nil)
+		req.Header.Add("Api-Token", token)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != expected {
+			t.Fatalf("expected status code %d but got %d", expected, resp.StatusCode)
+		}
+		return resp
+	}
+
+	revoke(tokens[0], 401)
+	var res map[string]int
+	json.NewDecoder(revoke(adminToken, 200).Body).Decode(&res)
+	if res["revoked"] != 2 {
+		t.Fatalf("expected 2 revoked sessions but got %v", res)
+	}
+	for _, token := range tokens {
+		revoke(token, 401)
+	}
+	revoke(adminToken, 200)
+}
+
+func TestLoginThrottle(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		app      = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		now      = time.Now()
+		throttle = NewLoginThrottle(tdb.Store, LoginPolicy{
+			MaxFailures:   3,
+			IPMaxFailures: 5,
+			Window:        15 * time.Minute,
+			Lockout:       15 * time.Minute,
+			Delay:         time.Second,
+			MaxDelay:      time.Minute,
+		})
+		authHandler  = NewAuthHandler(tdb.Store, throttle)
+		auditHandler = NewAuditHandler(tdb.Audit)
+		admin        = app.Group("/admin", JWTAuthentication(tdb.Store), AdminAuth)
+		user         = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		other        = fixtures.AddUser(tdb.Store, "other", "test", false)
+		adminUser    = fixtures.AddUser(tdb.Store, "admin", "admin", true)
+		adminToken   = tdb.token(t, adminUser)
+	)
+	throttle.now = func() time.Time { return now }
+	app.Post("/auth", authHandler.HandleAuthenticate)
+	admin.Post("/users/:id/unlock", RequirePermission(types.PermManageUsers), authHandler.HandleUnlockUser)
+	admin.Get("/audit", RequirePermission(types.PermReadAudit), auditHandler.HandleGetAudit)
+
+	login := func(email, password string, expected int, retryAfter string) {
+		t.Helper()
+		b, _ := json.Marshal(AuthParams{Email: email, Password: password})
+		req := httptest.NewRequest("POST", "/auth", bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != expected {
+			t.Fatalf("expected status code %d but got %d", expected, resp.StatusCode)
+		}
+		if got := resp.Header.Get("Retry-After"); got != retryAfter {
+			t.Fatalf("expected Retry-After %q but got %q", retryAfter, got)
+		}
+	}
+	audit := func(action types.AuditAction) []*types.AuditEntry {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/admin/audit?action="+string(action), nil)
+		req.Header.Add("Api-Token", adminToken)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status code 200 but got %d", resp.StatusCode)
+		}
+		var page struct {
+			Data []*types.AuditEntry `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+			t.Fatal(err)
+		}
+		return page.Data
+	}
+
+	// every failure doubles the delay before the next attempt
+	login(user.Email, "wrong", 400, "")
+	login(user.Email, "test123", 429, "1")
+	now = now.Add(time.Second)
+	login(user.Email, "wrong", 400, "")
+	login(user.Email, "test123", 429, "2")
+	now = now.Add(2 * time.Second)
+	login(strings.ToUpper(user.Email), "wrong", 400, "")
+
+	// the third failure locked the account, even the right password is refused
+	login(user.Email, "test123", 429, "900")
+	now = now.Add(10 * time.Minute)
+	login(user.Email, "test123", 429, "300")
+	login(other.Email, "test123", 200, "")
+	locked := audit(types.AuditLoginLocked)
+	if len(locked) != 1 || locked[0].Subject != types.AccountLoginKey(user.Email) || !locked[0].ActorID.IsZero() {
+		t.Fatalf("expected the lockout to be audited but got %+v", locked)
+	}
+
+	req := httptest.NewRequest("POST", "/admin/users/"+user.ID.Hex()+"/unlock", nil)
+	req.Header.Add("Api-Token", adminToken)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status code 200 but got %d", resp.StatusCode)
+	}
+	unlocked := audit(types.AuditLoginUnlocked)
+	if len(unlocked) != 1 || unlocked[0].ActorID != adminUser.ID {
+		t.Fatalf("expected the unlock to be audited but got %+v", unlocked)
+	}
+	login(user.Email, "test123", 200, "")
+
+	// the client IP is locked once it failed for any accounts too often
+	login("nobody@test.com", "wrong", 400, "")
+	login("someone@test.com", "wrong", 400, "")
+	login(other.Email, "test123", 429, "900")
+	if locked := audit(types.AuditLoginLocked); len(locked) != 2 || !strings.HasPrefix(locked[0].Subject, "ip:") {
+		t.Fatalf("expected the IP lockout to be audited but got %+v", locked)
+	}
+	now = now.Add(15 * time.Minute)
+	login(other.Email, "test123", 200, "")
+}
+
+func TestAccessTokenKeys(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	defer SetTokenKeys(tokenKeys)
+	var (
+		app   = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		tomek = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+	)
+	app.Get("/.well-known/jwks.json", HandleGetJWKS)
+	app.Get("/me", JWTAuthentication(tdb.Store), func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+	get := func(token string, expected int) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/me", nil)
+		req.Header.Add("Api-Token", token)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != expected {
+			t.Fatalf("expected status code %d but got %d", expected, resp.StatusCode)
+		}
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldKeys, _ := keys.NewSet(rsaKey)
+	SetTokenKeys(oldKeys)
+	token := tdb.token(t, tomek)
+	claims, err := validateToken(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != tomek.ID.Hex() || claims.IssuedAt == nil || claims.ExpiresAt == nil {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+	get(token, 200)
+
+	// during a rotation the tokens of the old key are still accepted
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rotated, _ := keys.NewSet(edKey, rsaKey.Public())
+	SetTokenKeys(rotated)
+	get(token, 200)
+	get(tdb.token(t, tomek), 200)
+
+	resp, err := app.Test(httptest.NewRequest("GET", "/.well-known/jwks.json", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jwks keys.JWKS
+	if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		t.Fatal(err)
+	}
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Alg != "EdDSA" || jwks.Keys[1].Alg != "RS256" {
+		t.Fatalf("unexpected key set %+v", jwks)
+	}
+
+	newKeys, _ := keys.NewSet(edKey)
+	SetTokenKeys(newKeys)
+	get(token, 401)
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+type CopyHandler struct {
+	store *db.Store
+}
+
+func NewCopyHandler(store *db.Store) *CopyHandler {
+	return &CopyHandler{
+		store: store,
+	}
+}
+
+// @Summary		Add copies of a movie
+// @Description	Handle adding one or more copies of a movie in a given format
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Router			/movies/:id/copies [post]
+func (h *CopyHandler) HandlePostCopies(c *fiber.Ctx) error {
+	var params types.CreateCopyParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+	if params.Count == 0 {
+		params.Count = 1
+	}
+	movie, err := h.store.Movie.GetMovieByID(c.Context(), c.Params("id"))
+	if err != nil {
+		return ErrResourceNotFound("Movie")
+	}
+	copies := make([]*types.Copy, 0, params.Count)
+	for i := 0; i < params.Count; i++ {
+		cp, err := h.store.Copy.InsertCopy(c.Context(), types.NewCopyFromParams(movie.ID, params))
+		if err != nil {
+			return err
+		}
+		copies = append(copies, cp)
+	}
+	return c.JSON(copies)
+}
+
+// @Summary		Get copies of a movie
+// @Description	Handle listing the copies of a movie
+// @Tags			admin
+// @Produce		json
+// @Param			format	query	string	false	"copy format"	Enums(dvd, bluray, 4k, digital)
+// @Param			rented	query	bool	false	"only rented or available copies"
+// @Param			retired	query	bool	false	"only retired or active copies"
+// @Router			/movies/:id/copies [get]
+func (h *CopyHandler) HandleGetCopies(c *fiber.Ctx) error {
+	filter := map[string]any{}
+	if format := types.CopyFormat(c.Query("format")); format != "" {
+		if !format.IsValid() {
+			return NewError(http.StatusBadRequest, fmt.Sprintf("invalid format: %s", format))
+		}
+		filter["format"] = format
+	}
+	for _, key := range []string{"rented", "retired"} {
+		if value := c.Query(key); value != "" {
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return NewError(http.StatusBadRequest, fmt.Sprintf("invalid %s value: %s", key, value))
+			}
+			filter[key] = b
+		}
+	}
+	copies, err := h.store.Copy.GetCopies(c.Context(), c.Params("id"), filter)
+	if err != nil {
+		return ErrInvalidID()
+	}
+	return c.JSON(copies)
+}
+
+// @Summary		Retire a copy
+// @Description	Handle taking a copy out of circulation
+// @Tags			admin
+// @Produce		json
+// @Router			/copies/:id/retire [post]
+func (h *CopyHandler) HandleRetireCopy(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := h.store.Copy.RetireCopy(c.Context(), id, time.Now()); err != nil {
+		return ErrResourceNotFound("Copy")
+	}
+	return c.JSON(map[string]string{"retired": id})
+}
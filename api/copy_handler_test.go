@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+func TestPostCopies(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		movieAdded  = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		app         = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		copyHandler = NewCopyHandler(tdb.Store)
+	)
+	app.Post("/movies/:id/copies", copyHandler.HandlePostCopies)
+	app.Get("/movies/:id/copies", copyHandler.HandleGetCopies)
+
+	b, _ := json.Marshal(types.CreateCopyParams{Format: types.Format4K, Count: 3})
+	req := httptest.NewRequest("POST", "/movies/"+movieAdded.ID.Hex()+"/copies", bytes.NewReader(b))
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var copies []types.Copy
+	json.NewDecoder(resp.Body).Decode(&copies)
+	if len(copies) != 3 {
+		t.Fatalf("expected 3 copies but got %d", len(copies))
+	}
+	for _, cp := range copies {
+		if cp.MovieID != movieAdded.ID || cp.Format != types.Format4K {
+			t.Errorf("unexpected copy %+v", cp)
+		}
+	}
+
+	b, _ = json.Marshal(types.CreateCopyParams{Format: "vhs"})
+	req = httptest.NewRequest("POST", "/movies/"+movieAdded.ID.Hex()+"/copies", bytes.NewReader(b))
+	req.Header.Add("Content-Type", "application/json")
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 400 {
+		t.Errorf("expected status code 400 for an invalid format but got %d", resp.StatusCode)
+	}
+
+	req = httptest.NewRequest("GET", "/movies/"+movieAdded.ID.Hex()+"/copies?format=4k", nil)
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copies = nil
+	json.NewDecoder(resp.Body).Decode(&copies)
+	if len(copies) != 3 {
+		t.Errorf("expected 3 copies but got %d", len(copies))
+	}
+}
+
+func TestRetireCopy(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		movieAdded  = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		copyAdded   = fixtures.AddCopy(tdb.Store, movieAdded, types.FormatDVD)
+		app         = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		copyHandler = NewCopyHandler(tdb.Store)
+	)
+	app.Post("/copies/:id/retire", copyHandler.HandleRetireCopy)
+	app.Get("/movies/:id/copies", copyHandler.HandleGetCopies)
+
+	req := httptest.NewRequest("POST", "/copies/"+copyAdded.ID.Hex()+"/retire", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("expected status code 200 but got %d", resp.StatusCode)
+	}
+
+	req = httptest.NewRequest("GET", "/movies/"+movieAdded.ID.Hex()+"/copies?retired=false", nil)
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var copies []types.Copy
+	json.NewDecoder(resp.Body).Decode(&copies)
+	if len(copies) != 0 {
+		t.Errorf("expected no active copies but got %d", len(copies))
+	}
+}
@@ -38,6 +38,13 @@ func ErrUnAuthorized() Error {
 	}
 }
 
+func ErrForbidden() Error {
+	return Error{
+		Code: http.StatusForbidden,
+		Err:  "forbidden",
+	}
+}
+
 func ErrResourceNotFound(res string) Error {
 	return Error{
 		Code: http.StatusNotFound,
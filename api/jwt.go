@@ -2,16 +2,26 @@ package api
 
 import (
 	"fmt"
-	"os"
+	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
 )
 
-func JWTAuthentication(userStore db.UserStore) fiber.Handler {
+// apiKeyTouchInterval limits how often the last use of an API key is
+// written, a busy integration would otherwise write on every request.
+const apiKeyTouchInterval = time.Minute
+
+// JWTAuthentication accepts access tokens whose session is still active.
+// Integrations send an API key in the Api-Key header instead.
+func JWTAuthentication(store *db.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		if key, ok := c.GetReqHeaders()["Api-Key"]; ok {
+			return apiKeyAuthentication(c, store, key[0])
+		}
 		token, ok := c.GetReqHeaders()["Api-Token"]
 		if !ok {
 			fmt.Println("token not present in the header")
@@ -21,30 +31,62 @@ func JWTAuthentication(userStore db.UserStore) fiber.Handler {
 		if err != nil {
 			return ErrUnAuthorized()
 		}
-		expiresFloat := claims["expires"].(float64)
-		expires := int64(expiresFloat)
-		if time.Now().Unix() > expires {
+		userID := claims.Subject
+		session, err := store.Session.GetSessionByID(c.Context(), claims.SessionID)
+		if err != nil {
+			return ErrUnAuthorized()
+		}
+		if !session.IsActive(time.Now()) || session.UserID.Hex() != userID {
 			return ErrUnAuthorized()
 		}
-		userID := claims["id"].(string)
-		user, err := userStore.GetUserByID(c.Context(), userID)
+		user, err := store.User.GetUserByID(c.Context(), userID)
 		if err != nil {
 			return ErrUnAuthorized()
 		}
 		c.Context().SetUserValue("user", user)
+		c.Context().SetUserValue("session", session)
 		return c.Next()
 	}
 }
 
-func validateToken(tokenStr string) (jwt.MapClaims, error) {
-	token, err := jwt.Parse(tokenStr, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			fmt.Println("invalid signing method", token.Header["alg"])
-			return nil, ErrUnAuthorized()
+// apiKeyAuthentication signs in the user of an active API key, restricted
+// to the scopes of the key.
+func apiKeyAuthentication(c *fiber.Ctx, store *db.Store, token string) error {
+	keyID, secret, ok := strings.Cut(token, ".")
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	key, err := store.APIKey.GetAPIKeyByID(c.Context(), keyID)
+	if err != nil {
+		return ErrUnAuthorized()
+	}
+	now := time.Now()
+	if !key.IsActive(now) || types.HashSecret(secret) != key.Hash {
+		return ErrUnAuthorized()
+	}
+	user, err := store.User.GetUserByID(c.Context(), key.UserID.Hex())
+	if err != nil {
+		return ErrUnAuthorized()
+	}
+	if key.LastUsedAt == nil || now.Sub(*key.LastUsedAt) >= apiKeyTouchInterval {
+		if err := store.APIKey.TouchAPIKey(c.Context(), keyID, now); err != nil {
+			return err
 		}
-		secret := os.Getenv("JWT_SECRET")
-		return []byte(secret), nil
-	})
+	}
+	user.Restrict(key.Scopes)
+	c.Context().SetUserValue("user", user)
+	c.Context().SetUserValue("apiKey", key)
+	return c.Next()
+}
+
+// validateToken checks the signature and the expiry of an access token.
+func validateToken(tokenStr string) (*TokenClaims, error) {
+	var claims TokenClaims
+	token, err := jwt.ParseWithClaims(tokenStr, &claims, tokenKeys.Keyfunc,
+		jwt.WithValidMethods(tokenKeys.Methods()),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 	if err != nil {
 		fmt.Println("failed to parse JWT token:", err)
 		return nil, ErrUnAuthorized()
@@ -53,9 +95,5 @@ func validateToken(tokenStr string) (jwt.MapClaims, error) {
 		fmt.Println("invalid token")
 		return nil, ErrUnAuthorized()
 	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return nil, ErrUnAuthorized()
-	}
-	return claims, nil
+	return &claims, nil
 }
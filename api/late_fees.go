@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+// LateFeePolicy configures the fees of rents returned late, in minor units
+// of the rental currency. A rent returned within Grace of its end is not
+// late, after that every started day since the end costs PerDay, up to Cap
+// when it is above zero. Users owing more than MaxOwed can't rent until
+// they settle. Overdue rents are swept every SweepInterval.
+type LateFeePolicy struct {
+	PerDay        int64
+	Cap           int64
+	Grace         time.Duration
+	MaxOwed       int64
+	SweepInterval time.Duration
+}
+
+func DefaultLateFeePolicy() LateFeePolicy {
+	return LateFeePolicy{
+		PerDay:        100,
+		Cap:           2000,
+		Grace:         time.Hour,
+		MaxOwed:       0,
+		SweepInterval: 10 * time.Minute,
+	}
+}
+
+// lateFeePolicyFromEnv overrides the defaults with LATE_FEE_PER_DAY,
+// LATE_FEE_CAP, LATE_FEE_GRACE, LATE_FEE_MAX_OWED and
+// LATE_FEE_SWEEP_INTERVAL.
+func lateFeePolicyFromEnv() (LateFeePolicy, error) {
+	policy := DefaultLateFeePolicy()
+	for name, n := range map[string]*int64{
+		"LATE_FEE_PER_DAY":  &policy.PerDay,
+		"LATE_FEE_CAP":      &policy.Cap,
+		"LATE_FEE_MAX_OWED": &policy.MaxOwed,
+	} {
+		if v := os.Getenv(name); v != "" {
+			i, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || i < 0 {
+				return policy, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*n = i
+		}
+	}
+	for name, d := range map[string]*time.Duration{
+		"LATE_FEE_GRACE":          &policy.Grace,
+		"LATE_FEE_SWEEP_INTERVAL": &policy.SweepInterval,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed < 0 {
+				return policy, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*d = parsed
+		}
+	}
+	if policy.SweepInterval == 0 {
+		return policy, fmt.Errorf("invalid LATE_FEE_SWEEP_INTERVAL %q", os.Getenv("LATE_FEE_SWEEP_INTERVAL"))
+	}
+	return policy, nil
+}
+
+// Fee is the late fee of a rent that ended at due, at the time at.
+func (p LateFeePolicy) Fee(due, at time.Time) int64 {
+	late := at.Sub(due)
+	if late <= p.Grace {
+		return 0
+	}
+	days := int64((late + 24*time.Hour - 1) / (24 * time.Hour))
+	fee := days * p.PerDay
+	if p.Cap > 0 && fee > p.Cap {
+		fee = p.Cap
+	}
+	return fee
+}
+
+// OverdueSweeper marks the rents past their end overdue and accrues their
+// late fees.
+type OverdueSweeper struct {
+	store  *db.Store
+	policy LateFeePolicy
+}
+
+func NewOverdueSweeper(store *db.Store, policy LateFeePolicy) *OverdueSweeper {
+	return &OverdueSweeper{
+		store:  store,
+		policy: policy,
+	}
+}
+
+// Run sweeps every SweepInterval until ctx is done. Failed sweeps are
+// logged and retried on the next tick.
+func (s *OverdueSweeper) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.policy.SweepInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.Sweep(ctx, time.Now()); err != nil {
+			log.Printf("overdue sweep: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Sweep brings every rent due before now up to date. Rents returned while
+// the sweep runs are skipped, their fee was settled on return.
+func (s *OverdueSweeper) Sweep(ctx context.Context, now time.Time) error {
+	rents, err := s.store.Rent.GetRentsDueBefore(ctx, now)
+	if err != nil {
+		return err
+	}
+	for _, rent := range rents {
+		err := accrueLateFee(ctx, s.store, s.policy, rent, now)
+		if err != nil && !errors.Is(err, db.ErrInvalidTransition) {
+			return err
+		}
+	}
+	return nil
+}
+
+// accrueLateFee marks an active rent past its end overdue and raises its
+// fee to what it owes at now.
+func accrueLateFee(ctx context.Context, store *db.Store, policy LateFeePolicy, rent *types.Rent, now time.Time) error {
+	if !now.After(rent.To) {
+		return nil
+	}
+	if rent.State == types.RentActive {
+		if _, err := store.Rent.UpdateRentState(ctx, rent.ID.Hex(), types.RentOverdue, now); err != nil {
+			return err
+		}
+	}
+	if fee := policy.Fee(rent.To, now); fee > rent.LateFee {
+		return store.Rent.AccrueLateFee(ctx, rent.ID.Hex(), fee)
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// LoginPolicy configures the login throttling. Every failed login of an
+// account doubles the delay before the next attempt is accepted, starting
+// at Delay and capped at MaxDelay. After MaxFailures failures within Window
+// the account is locked for Lockout; a client IP is locked after
+// IPMaxFailures failures across every account it tried. IPs only get the
+// lockout, not the delays, so users behind a shared address are not slowed
+// down by each other.
+type LoginPolicy struct {
+	MaxFailures   int
+	IPMaxFailures int
+	Window        time.Duration
+	Lockout       time.Duration
+	Delay         time.Duration
+	MaxDelay      time.Duration
+}
+
+func DefaultLoginPolicy() LoginPolicy {
+	return LoginPolicy{
+		MaxFailures:   5,
+		IPMaxFailures: 50,
+		Window:        15 * time.Minute,
+		Lockout:       15 * time.Minute,
+		Delay:         time.Second,
+		MaxDelay:      30 * time.Second,
+	}
+}
+
+// LoginPolicyFromEnv overrides the defaults with LOGIN_MAX_FAILURES,
+// LOGIN_IP_MAX_FAILURES, LOGIN_FAILURE_WINDOW, LOGIN_LOCKOUT, LOGIN_DELAY
+// and LOGIN_MAX_DELAY. Durations use the time.ParseDuration format.
+func LoginPolicyFromEnv() (LoginPolicy, error) {
+	policy := DefaultLoginPolicy()
+	for name, n := range map[string]*int{
+		"LOGIN_MAX_FAILURES":    &policy.MaxFailures,
+		"LOGIN_IP_MAX_FAILURES": &policy.IPMaxFailures,
+	} {
+		if v := os.Getenv(name); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 1 {
+				return policy, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*n = i
+		}
+	}
+	for name, d := range map[string]*time.Duration{
+		"LOGIN_FAILURE_WINDOW": &policy.Window,
+		"LOGIN_LOCKOUT":        &policy.Lockout,
+		"LOGIN_DELAY":          &policy.Delay,
+		"LOGIN_MAX_DELAY":      &policy.MaxDelay,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed < 0 {
+				return policy, fmt.Errorf("invalid %s %q", name, v)
+			}
+			*d = parsed
+		}
+	}
+	return policy, nil
+}
+
+// delay is how long an account has to wait after its nth failure.
+func (p LoginPolicy) delay(failures int) time.Duration {
+	if failures < 1 || p.Delay <= 0 {
+		return 0
+	}
+	d := float64(p.Delay) * math.Pow(2, float64(failures-1))
+	if d > float64(p.MaxDelay) {
+		return p.MaxDelay
+	}
+	return time.Duration(d)
+}
+
+// LoginThrottle tracks failed logins per account and per client IP in the
+// store, so instances sharing a database share the limits.
+type LoginThrottle struct {
+	store  *db.Store
+	policy LoginPolicy
+	now    func() time.Time
+}
+
+func NewLoginThrottle(store *db.Store, policy LoginPolicy) *LoginThrottle {
+	return &LoginThrottle{
+		store:  store,
+		policy: policy,
+		now:    time.Now,
+	}
+}
+
+func errTooManyAttempts(c *fiber.Ctx, wait time.Duration) error {
+	c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	return NewError(http.StatusTooManyRequests, "too many failed logins, try again later")
+}
+
+// check rejects the login while the account or the IP is locked or the
+// account still has to wait after its last failure.
+func (t *LoginThrottle) check(c *fiber.Ctx, email string) error {
+	var (
+		now     = t.now()
+		account = types.AccountLoginKey(email)
+	)
+	for _, key := range []string{account, types.IPLoginKey(c.IP())} {
+		attempts, err := t.store.LoginAttempt.GetLoginAttempts(c.Context(), key)
+		if err != nil {
+			if errors.Is(err, db.ErrNotFound) {
+				continue
+			}
+			return err
+		}
+		if attempts.IsLocked(now) {
+			return errTooManyAttempts(c, attempts.LockedUntil.Sub(now))
+		}
+		if key != account || now.Sub(attempts.LastFailure) > t.policy.Window {
+			continue
+		}
+		if ready := attempts.LastFailure.Add(t.policy.delay(attempts.Failures)); now.Before(ready) {
+			return errTooManyAttempts(c, ready.Sub(now))
+		}
+	}
+	return nil
+}
+
+// fail records a failed login of the account from the IP of the request and
+// locks whichever reached its threshold.
+func (t *LoginThrottle) fail(c *fiber.Ctx, email string) error {
+	now := t.now()
+	for key, max := range map[string]int{
+		types.AccountLoginKey(email): t.policy.MaxFailures,
+		types.IPLoginKey(c.IP()):     t.policy.IPMaxFailures,
+	} {
+		attempts, err := t.store.LoginAttempt.RecordLoginFailure(c.Context(), key, now, t.policy.Window)
+		if err != nil {
+			return err
+		}
+		if attempts.Failures < max {
+			continue
+		}
+		if err := t.lock(c.Context(), key, attempts.Failures, now.Add(t.policy.Lockout)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *LoginThrottle) lock(ctx context.Context, key string, failures int, until time.Time) error {
+	if err := t.store.LoginAttempt.LockLogin(ctx, key, until); err != nil {
+		return err
+	}
+	detail := fmt.Sprintf("locked for %s after %d failed logins", t.policy.Lockout, failures)
+	_, err := t.store.Audit.InsertAuditEntry(ctx, types.NewAuditEntry(types.AuditLoginLocked, primitive.NilObjectID, key, detail))
+	return err
+}
+
+// succeed forgets the failures of the account. Those of the IP are kept so
+// logging into an account of their own doesn't let clients guess the
+// passwords of others forever.
+func (t *LoginThrottle) succeed(ctx context.Context, email string) error {
+	return t.store.LoginAttempt.ResetLoginAttempts(ctx, types.AccountLoginKey(email))
+}
+
+// Unlock lifts the lock and the failures of the account with the email on
+// behalf of actor.
+func (t *LoginThrottle) Unlock(ctx context.Context, email string, actor *types.User) error {
+	key := types.AccountLoginKey(email)
+	if err := t.store.LoginAttempt.ResetLoginAttempts(ctx, key); err != nil {
+		return err
+	}
+	_, err := t.store.Audit.InsertAuditEntry(ctx, types.NewAuditEntry(types.AuditLoginUnlocked, actor.ID, key, ""))
+	return err
+}
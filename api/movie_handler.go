@@ -1,32 +1,38 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/payment"
 	"github.com/tomekzakrzewski/go-movierental/types"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type MovieHandler struct {
-	store *db.Store
+	store    *db.Store
+	rentals  RentalPolicy
+	payments payment.Provider
 }
 
-func NewMovieHandler(store *db.Store) *MovieHandler {
+func NewMovieHandler(store *db.Store, rentals RentalPolicy, payments payment.Provider) *MovieHandler {
 	return &MovieHandler{
-		store: store,
+		store:    store,
+		rentals:  rentals,
+		payments: payments,
 	}
 }
 
-//	@Summary		Add movie
-//	@Description	Handle posting movie to database
-//	@Tags			admin
-//	@Accept			json
-//	@Produce		json
-//	@Router			/movies [post]
+// @Summary		Add movie
+// @Description	Handle posting movie to database
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Router			/movies [post]
 func (h *MovieHandler) HandlePostMovie(c *fiber.Ctx) error {
 	var params types.CreateMovieParams
 	if err := c.BodyParser(&params); err != nil {
@@ -44,48 +50,54 @@ func (h *MovieHandler) HandlePostMovie(c *fiber.Ctx) error {
 	return c.JSON(insertedHotel)
 }
 
-type ResourceResp struct {
-	Results int `json:"results"`
-	Data    any `json:"data"`
-	Page    int `json:"page"`
+type MovieSearchResp struct {
+	ResourceResp
+	Facets types.MovieFacets `json:"facets"`
 }
 
-type MovieQueryParams struct {
-	db.Pagination
-	Rating int
-}
-
-//	@Summary		Get all movies
-//	@Description	Handle getting all movies from database
-//	@Tags			user
-//	@Produce		json
-//	@Router			/movies [get]
+// @Summary		Search movies
+// @Description	Handle searching movies with facet counts per genre and decade over all matches
+// @Tags			user
+// @Produce		json
+// @Param			q			query	string		false	"words that must all appear in the title"
+// @Param			genre		query	[]string	false	"any of these genres"	collectionFormat(multi)
+// @Param			genreAll	query	[]string	false	"all of these genres"	collectionFormat(multi)
+// @Param			yearFrom	query	int			false	"earliest year"
+// @Param			yearTo		query	int			false	"latest year"
+// @Param			lengthMin	query	int			false	"minimum length in minutes"
+// @Param			lengthMax	query	int			false	"maximum length in minutes"
+// @Param			minRating	query	number		false	"minimum average rating"
+// @Param			sort		query	string		false	"sort key, prefix with - for descending"	Enums(title, -title, year, -year, rating, -rating, popularity, -popularity)
+// @Param			limit		query	int			false	"page size, 20 by default and at most 100"
+// @Param			cursor		query	string		false	"nextCursor of the previous page"
+// @Router			/movies [get]
 func (h *MovieHandler) HandleGetMovies(c *fiber.Ctx) error {
-	var params MovieQueryParams
+	var params types.MovieSearchParams
 	if err := c.QueryParser(&params); err != nil {
 		return ErrBadRequest()
 	}
-	filter := map[string]any{
-		"rating": params.Rating,
+	if errors := params.Validate(); len(errors) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(errors)
 	}
-	movies, err := h.store.Movie.GetMovies(c.Context(), filter, &params.Pagination)
+	pag, err := paginationFromQuery(c)
 	if err != nil {
-		return ErrResourceNotFound("Movies")
+		return err
 	}
-
-	res := ResourceResp{
-		Results: len(movies),
-		Data:    movies,
-		Page:    params.Page,
+	res, err := h.store.Movie.SearchMovies(c.Context(), params, pag)
+	if err != nil {
+		return listError(err, "Movies")
 	}
-	return c.JSON(res)
+	return c.JSON(MovieSearchResp{
+		ResourceResp: newResourceResp(&res.Page),
+		Facets:       res.Facets,
+	})
 }
 
-//	@Summary		Update movie
-//	@Description	Handle updating movie
-//	@Tags			admin
-//	@Produce		json
-//	@Router			/movies/:id [put]
+// @Summary		Update movie
+// @Description	Handle updating movie
+// @Tags			admin
+// @Produce		json
+// @Router			/movies/:id [put]
 func (h *MovieHandler) HandleUpdateMovie(c *fiber.Ctx) error {
 	var (
 		params  types.UpdateMovieParams
@@ -101,11 +113,11 @@ func (h *MovieHandler) HandleUpdateMovie(c *fiber.Ctx) error {
 	return c.JSON(map[string]string{"updated": movieID})
 }
 
-//	@Summary		Delete movie
-//	@Description	Handle deleting movie
-//	@Tags			admin
-//	@Produce		json
-//	@Router			/movies/:id [delete]
+// @Summary		Delete movie
+// @Description	Handle deleting movie
+// @Tags			admin
+// @Produce		json
+// @Router			/movies/:id [delete]
 func (h *MovieHandler) HandleDeleteMovie(c *fiber.Ctx) error {
 	movieID := c.Params("id")
 	if err := h.store.Movie.DeleteMovie(c.Context(), movieID); err != nil {
@@ -114,11 +126,11 @@ func (h *MovieHandler) HandleDeleteMovie(c *fiber.Ctx) error {
 	return c.JSON(map[string]string{"deleted": movieID})
 }
 
-//	@Summary		Get movie by ID
-//	@Description	Handle getting movie by id
-//	@Tags			user
-//	@Produce		json
-//	@Router			/movies/:id [get]
+// @Summary		Get movie by ID
+// @Description	Handle getting movie by id
+// @Tags			user
+// @Produce		json
+// @Router			/movies/:id [get]
 func (h *MovieHandler) HandleGetMovieByID(c *fiber.Ctx) error {
 	movieID := c.Params("id")
 	movie, err := h.store.Movie.GetMovieByID(c.Context(), movieID)
@@ -128,39 +140,72 @@ func (h *MovieHandler) HandleGetMovieByID(c *fiber.Ctx) error {
 	return c.JSON(movie)
 }
 
-//	@Summary		Update movie movie rating
-//	@Description	Handle updating movie rating
-//	@Tags			user
-//	@Produce		json
-//	@Router			/movies/:id/rate [put]
+// @Summary		Rate a movie
+// @Description	Handle rating a movie, rating again replaces the user's previous rating
+// @Tags			user
+// @Accept			json
+// @Produce		json
+// @Router			/movies/:id/rate [put]
 func (h *MovieHandler) HandleUpdateMovieRating(c *fiber.Ctx) error {
-	type Rating struct {
-		Rating int `json:"rating"`
-	}
-
 	var (
 		movieID = c.Params("id")
-		rating  Rating
+		params  types.UpdateMovieRating
 	)
-	if err := c.BodyParser(&rating); err != nil {
-		return ErrInvalidID()
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
 	}
-
-	if rating.Rating < 0 || rating.Rating > 10 {
+	if err := c.BodyParser(&params); err != nil {
 		return ErrBadRequest()
 	}
-	if err := h.store.Movie.UpdateRating(c.Context(), movieID, rating.Rating); err != nil {
+	if errors := params.Validate(); len(errors) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+	movie, err := h.store.Movie.GetMovieByID(c.Context(), movieID)
+	if err != nil {
 		return ErrResourceNotFound("Movie")
 	}
+	rating, err := h.store.Rating.UpsertRating(c.Context(), types.NewRatingFromParams(user.ID, movie.ID, params))
+	if err != nil {
+		return err
+	}
+	if _, err := h.store.Rating.RefreshMovieRating(c.Context(), movieID); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return ErrResourceNotFound("Movie")
+		}
+		return err
+	}
 
-	return c.JSON(map[string]string{"updated": movieID})
+	return c.JSON(rating)
 }
 
-//	@Summary		Rent a movie
-//	@Description	Handle renting movie
-//	@Tags			user
-//	@Produce		json
-//	@Router			/movies/:id/rent [post]
+// @Summary		Get own movie rating
+// @Description	Handle getting the current user's rating of a movie
+// @Tags			user
+// @Produce		json
+// @Router			/movies/:id/rating [get]
+func (h *MovieHandler) HandleGetMovieRating(c *fiber.Ctx) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	rating, err := h.store.Rating.GetRating(c.Context(), user.ID.Hex(), c.Params("id"))
+	if err != nil {
+		return ErrResourceNotFound("Rating")
+	}
+	return c.JSON(rating)
+}
+
+// @Summary		Rent a movie
+// @Description	Handle renting movie, reserves one available copy. Users have to verify their email first and can't owe more late fees than the configured limit
+// @Description	The rental duration is picked from the durations of the movie or the copy format, the first one by default. The rent records its price from the price list
+// @Description	Paid rents are charged to the payment method and stay pending until the payment is captured. A declined payment cancels the rent with 402, a capture the provider confirms later returns the pending rent with 202
+// @Tags			user
+// @Produce		json
+// @Param			format			query	string	false	"copy format"	Enums(dvd, bluray, 4k, digital)
+// @Param			hours			query	int		false	"rental duration in hours"
+// @Param			paymentMethod	query	string	false	"payment method token of the provider, or wallet to pay from the renter's wallet"
+// @Router			/movies/:id/rent [post]
 func (h *MovieHandler) HandleRentMovie(c *fiber.Ctx) error {
 	movieID, err := primitive.ObjectIDFromHex(c.Params("id"))
 	if err != nil {
@@ -170,44 +215,145 @@ func (h *MovieHandler) HandleRentMovie(c *fiber.Ctx) error {
 	if !ok {
 		return ErrUnAuthorized()
 	}
+	if !user.EmailVerified {
+		return NewError(http.StatusForbidden, "verify your email before renting")
+	}
+	owed, err := h.store.Rent.GetLateFees(c.Context(), user.ID.Hex())
+	if err != nil {
+		return err
+	}
+	if owed > h.rentals.LateFees.MaxOwed {
+		return NewError(http.StatusPaymentRequired, fmt.Sprintf("outstanding late fees of %d %s, settle them before renting", owed, h.rentals.Currency))
+	}
+	format := types.CopyFormat(c.Query("format"))
+	if format != "" && !format.IsValid() {
+		return NewError(http.StatusBadRequest, fmt.Sprintf("invalid format: %s", format))
+	}
 
+	movie, err := h.store.Movie.GetMovieByID(c.Context(), movieID.Hex())
+	if err != nil {
+		return ErrResourceNotFound("Movie")
+	}
+
+	cp, err := h.store.Copy.ReserveCopy(c.Context(), movieID.Hex(), format)
+	if err != nil {
+		if errors.Is(err, db.ErrNoCopiesAvailable) {
+			return NewError(http.StatusConflict, "no copies available")
+		}
+		return err
+	}
+	// the durations depend on the format of the reserved copy, so the copy
+	// is released again when the rent can't be made
+	release := func(cause error) error {
+		if err := h.store.Copy.ReleaseCopy(c.Context(), cp.ID.Hex()); err != nil {
+			return err
+		}
+		return cause
+	}
+	hours, err := rentalHours(c.Query("hours"), h.rentals.HoursFor(movie, cp.Format))
+	if err != nil {
+		return release(err)
+	}
+	now := time.Now()
+	q, err := quote(c.Context(), h.store, h.rentals, movie, cp.Format, hours, now)
+	if err != nil {
+		return release(err)
+	}
 	if err := h.store.Rent.CheckRent(c.Context(), types.CheckRentParams{
 		UserID:  user.ID,
 		MovieID: movieID,
-		From:    time.Now(),
-		To:      time.Now().Add(time.Hour * 24),
+		From:    now,
+		To:      now.Add(time.Duration(hours) * time.Hour),
 	}); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(genericResp{
-			Type: "error",
-			Msg:  fmt.Sprintf("Movie already rented, id: %s", movieID),
-		})
+		if err := release(nil); err != nil {
+			return err
+		}
+		return alreadyRented(c, movieID)
 	}
 	params := types.CreateRentParams{
-		UserID:  user.ID,
-		MovieID: movieID,
+		UserID:   user.ID,
+		MovieID:  movieID,
+		CopyID:   cp.ID,
+		Format:   cp.Format,
+		Hours:    hours,
+		Price:    q.Amount,
+		Currency: q.Currency,
 	}
 	rent := types.NewRentFromParams(params)
+	if rent.Price > 0 {
+		// the rent holds the copy but only starts once it is paid for
+		rent.State = types.RentPending
+	}
 	insertedRent, err := h.store.Rent.InsertRent(c.Context(), rent)
 	if err != nil {
+		if err := release(nil); err != nil {
+			return err
+		}
+		if errors.Is(err, db.ErrAlreadyRented) {
+			return alreadyRented(c, movieID)
+		}
 		return ErrBadRequest()
 	}
-	return c.JSON(insertedRent)
+	if insertedRent.State != types.RentPending {
+		return c.JSON(insertedRent)
+	}
+	p, err := charge(c.Context(), h.store, h.payments, insertedRent, insertedRent.Price, types.PaymentForRent, 0, c.Query("paymentMethod"))
+	if err != nil {
+		if err := cancelRent(c.Context(), h.store, insertedRent.ID.Hex()); err != nil {
+			return err
+		}
+		return declined(err)
+	}
+	switch p.Status {
+	case types.PaymentPending:
+		return c.Status(http.StatusAccepted).JSON(insertedRent)
+	case types.PaymentFailed:
+		// the webhook of a failed capture already cancelled the rent
+		return declined(payment.ErrDeclined)
+	}
+	activeRent, err := activateRent(c.Context(), h.store, insertedRent.ID.Hex())
+	if errors.Is(err, db.ErrInvalidTransition) {
+		// or the one of a successful capture activated it
+		return c.JSON(insertedRent)
+	}
+	if err != nil {
+		return err
+	}
+	return c.JSON(activeRent)
 }
 
-//	@Summary		Get movies rented by user
-//	@Description	Handle getting movies rented by user
-//	@Tags			user
-//	@Produce		json
-//	@Router			/movies/rented [post]
+func alreadyRented(c *fiber.Ctx, movieID primitive.ObjectID) error {
+	return c.Status(http.StatusBadRequest).JSON(genericResp{
+		Type: "error",
+		Msg:  fmt.Sprintf("Movie already rented, id: %s", movieID),
+	})
+}
+
+// @Summary		Get movies rented by user
+// @Description	Handle getting movies rented by user, optionally filtered by state
+// @Tags			user
+// @Produce		json
+// @Param			state	query	string	false	"rent state"	Enums(pending, active, returned, overdue, cancelled)
+// @Param			limit	query	int		false	"page size, 20 by default and at most 100"
+// @Param			cursor	query	string	false	"nextCursor of the previous page"
+// @Router			/movies/rented [post]
 func (h *MovieHandler) HandleGetRentedMovies(c *fiber.Ctx) error {
 	user, ok := c.Context().Value("user").(*types.User)
 	if !ok {
 		return ErrBadRequest()
 	}
+	filter, err := rentFilterFromQuery(c)
+	if err != nil {
+		return err
+	}
 
-	movies, err := h.store.Rent.GetRentsByUser(c.Context(), user.ID.Hex())
+	pag, err := paginationFromQuery(c)
+	if err != nil {
+		return err
+	}
+	page, err := h.store.Rent.GetRentsByUser(c.Context(), user.ID.Hex(), filter, pag)
 	if err != nil {
-		return ErrResourceNotFound("rented movies")
+		return listError(err, "rented movies")
 	}
-	return c.JSON(movies)
+	return c.JSON(newResourceResp(page))
 }
@@ -9,6 +9,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/payment"
 	"github.com/tomekzakrzewski/go-movierental/types"
 )
 
@@ -17,7 +18,7 @@ func TestPostMovie(t *testing.T) {
 	defer tdb.teardown(t)
 	var (
 		app          = fiber.New()
-		movieHandler = NewMovieHandler(tdb.Store)
+		movieHandler = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
 	)
 
 	app.Post("/", movieHandler.HandlePostMovie)
@@ -59,7 +60,7 @@ func TestGetMovies(t *testing.T) {
 	var (
 		_            = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
 		app          = fiber.New()
-		movieHandler = NewMovieHandler(tdb.Store)
+		movieHandler = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
 	)
 	app.Get("/", movieHandler.HandleGetMovies)
 
@@ -83,13 +84,59 @@ func TestGetMovies(t *testing.T) {
 	}
 }
 
+func TestSearchMovies(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		_            = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action", "Sci-Fi"}, 136, 1999)
+		_            = fixtures.AddMovie(tdb.Store, "The Matrix Reloaded", []string{"Action", "Sci-Fi"}, 138, 2003)
+		_            = fixtures.AddMovie(tdb.Store, "Titanic", []string{"Drama", "Romance"}, 195, 1997)
+		app          = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		movieHandler = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
+	)
+	app.Get("/", movieHandler.HandleGetMovies)
+
+	req := httptest.NewRequest("GET", "/?q=matrix&genre=Sci-Fi&genre=Drama&lengthMax=140&sort=-year&limit=1", nil)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res struct {
+		Results int               `json:"results"`
+		Total   int               `json:"total"`
+		Data    []types.Movie     `json:"data"`
+		Facets  types.MovieFacets `json:"facets"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Results != 1 || res.Total != 2 {
+		t.Fatalf("expected 1 of 2 movies but got %d of %d", res.Results, res.Total)
+	}
+	if res.Data[0].Title != "The Matrix Reloaded" {
+		t.Errorf("expected The Matrix Reloaded first but got %s", res.Data[0].Title)
+	}
+	if res.Facets.Genre["Sci-Fi"] != 2 || res.Facets.Decade[1990] != 1 || res.Facets.Decade[2000] != 1 {
+		t.Errorf("unexpected facets %+v", res.Facets)
+	}
+
+	req = httptest.NewRequest("GET", "/?sort=director", nil)
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 400 {
+		t.Errorf("expected status code 400 for an invalid sort but got %d", resp.StatusCode)
+	}
+}
+
 func TestGetMovieByID(t *testing.T) {
 	tdb := setup(t)
 	defer tdb.teardown(t)
 	var (
 		movieAdded   = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
 		app          = fiber.New()
-		movieHandler = NewMovieHandler(tdb.Store)
+		movieHandler = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
 	)
 	app.Get("/:id", movieHandler.HandleGetMovieByID)
 	req := httptest.NewRequest("GET", "/"+movieAdded.ID.Hex(), nil)
@@ -121,12 +168,15 @@ func TestUpdateMovieRating(t *testing.T) {
 	defer tdb.teardown(t)
 	var (
 		app          = fiber.New()
-		movieHandler = NewMovieHandler(tdb.Store)
+		apiv1        = app.Group("", JWTAuthentication(tdb.Store))
+		movieHandler = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
 		movieAdded   = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		userAdded    = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		token        = tdb.token(t, userAdded)
 	)
 
-	app.Put("/:id/rate", movieHandler.HandleUpdateMovieRating)
-	app.Get("/:id", movieHandler.HandleGetMovieByID)
+	apiv1.Put("/:id/rate", movieHandler.HandleUpdateMovieRating)
+	apiv1.Get("/:id", movieHandler.HandleGetMovieByID)
 
 	type Rating struct {
 		Rating int `json:"rating"`
@@ -138,6 +188,7 @@ func TestUpdateMovieRating(t *testing.T) {
 	b, _ := json.Marshal(rating)
 	req := httptest.NewRequest("PUT", "/"+movieAdded.ID.Hex()+"/rate", bytes.NewReader(b))
 	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Api-Token", token)
 	resp, err := app.Test(req)
 	if err != nil {
 		t.Error(err)
@@ -146,6 +197,7 @@ func TestUpdateMovieRating(t *testing.T) {
 		t.Errorf("expected status code 200 but got %d", resp.StatusCode)
 	}
 	req = httptest.NewRequest("GET", "/"+movieAdded.ID.Hex(), nil)
+	req.Header.Add("Api-Token", token)
 	resp, err = app.Test(req)
 	if err != nil {
 		t.Error(err)
@@ -164,7 +216,7 @@ func TestDeleteMovie(t *testing.T) {
 	var (
 		movieAdded   = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
 		app          = fiber.New()
-		movieHandler = NewMovieHandler(tdb.Store)
+		movieHandler = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
 	)
 	app.Delete("/:id", movieHandler.HandleDeleteMovie)
 	req := httptest.NewRequest("DELETE", "/"+movieAdded.ID.Hex(), nil)
@@ -182,12 +234,13 @@ func TestRentMovie(t *testing.T) {
 	defer tdb.teardown(t)
 	var (
 		movieAdded   = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		_            = fixtures.AddCopy(tdb.Store, movieAdded, types.FormatDVD)
 		userAdded    = fixtures.AddUser(tdb.Store, "tomek", "test", false)
 		app          = fiber.New()
-		apiv1        = app.Group("", JWTAuthentication(tdb.User))
-		movieHandler = NewMovieHandler(tdb.Store)
+		apiv1        = app.Group("", JWTAuthentication(tdb.Store))
+		movieHandler = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
 	)
-	token := CreateTokenFromUser(userAdded)
+	token := tdb.token(t, userAdded)
 	apiv1.Put("/:id/rent", movieHandler.HandleRentMovie)
 	req := httptest.NewRequest("PUT", "/"+movieAdded.ID.Hex()+"/rent", nil)
 	req.Header.Add("Content-Type", "application/json")
@@ -207,12 +260,13 @@ func TestGetRentedMovies(t *testing.T) {
 	defer tdb.teardown(t)
 	var (
 		movieAdded   = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		_            = fixtures.AddCopy(tdb.Store, movieAdded, types.FormatDVD)
 		userAdded    = fixtures.AddUser(tdb.Store, "tomek", "test", false)
 		app          = fiber.New()
-		apiv1        = app.Group("", JWTAuthentication(tdb.User))
-		movieHandler = NewMovieHandler(tdb.Store)
+		apiv1        = app.Group("", JWTAuthentication(tdb.Store))
+		movieHandler = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
 	)
-	token := CreateTokenFromUser(userAdded)
+	token := tdb.token(t, userAdded)
 	apiv1.Put("/:id/rent", movieHandler.HandleRentMovie)
 	apiv1.Post("/rented", movieHandler.HandleGetRentedMovies)
 	req := httptest.NewRequest("PUT", "/"+movieAdded.ID.Hex()+"/rent", nil)
@@ -229,15 +283,148 @@ func TestGetRentedMovies(t *testing.T) {
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Api-Token", token)
 	resp, err = app.Test(req)
-	var rents []types.Rent
-	json.NewDecoder(resp.Body).Decode(&rents)
+	var res struct {
+		Data  []types.Rent `json:"data"`
+		Total int          `json:"total"`
+	}
+	json.NewDecoder(resp.Body).Decode(&res)
 	if err != nil {
 		t.Error(err)
 	}
 	if resp.StatusCode != 200 {
 		t.Errorf("expected status code 200 but got %d", resp.StatusCode)
 	}
-	if len(rents) != 1 {
-		t.Errorf("expected 1 rent but got %d", len(rents))
+	if len(res.Data) != 1 || res.Total != 1 {
+		t.Errorf("expected 1 rent but got %d", len(res.Data))
+	}
+}
+
+func TestRentMovieNoCopiesAvailable(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		movieAdded   = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		copyAdded    = fixtures.AddCopy(tdb.Store, movieAdded, types.FormatBluRay)
+		userAdded    = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		otherUser    = fixtures.AddUser(tdb.Store, "zuzia", "test", false)
+		app          = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1        = app.Group("", JWTAuthentication(tdb.Store))
+		movieHandler = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
+	)
+	apiv1.Put("/:id/rent", movieHandler.HandleRentMovie)
+
+	req := httptest.NewRequest("PUT", "/"+movieAdded.ID.Hex()+"/rent?format=dvd", nil)
+	req.Header.Add("Api-Token", tdb.token(t, userAdded))
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 409 {
+		t.Errorf("expected status code 409 without a dvd copy but got %d", resp.StatusCode)
+	}
+
+	req = httptest.NewRequest("PUT", "/"+movieAdded.ID.Hex()+"/rent", nil)
+	req.Header.Add("Api-Token", tdb.token(t, userAdded))
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("expected status code 200 but got %d", resp.StatusCode)
+	}
+	var rent types.Rent
+	json.NewDecoder(resp.Body).Decode(&rent)
+	if rent.CopyID != copyAdded.ID {
+		t.Errorf("expected copy %s to be reserved but got %s", copyAdded.ID, rent.CopyID)
+	}
+	if rent.Format != types.FormatBluRay {
+		t.Errorf("expected format %s but got %s", types.FormatBluRay, rent.Format)
+	}
+
+	req = httptest.NewRequest("PUT", "/"+movieAdded.ID.Hex()+"/rent", nil)
+	req.Header.Add("Api-Token", tdb.token(t, otherUser))
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 409 {
+		t.Errorf("expected status code 409 with every copy rented but got %d", resp.StatusCode)
+	}
+	var apiErr Error
+	json.NewDecoder(resp.Body).Decode(&apiErr)
+	if apiErr.Err != "no copies available" {
+		t.Errorf("expected error <no copies available> but got %s", apiErr.Err)
+	}
+}
+
+func TestMovieRatingAverage(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		app          = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1        = app.Group("", JWTAuthentication(tdb.Store))
+		movieHandler = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
+		movieAdded   = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		tomek        = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		zuzia        = fixtures.AddUser(tdb.Store, "zuzia", "test", false)
+	)
+	apiv1.Put("/:id/rate", movieHandler.HandleUpdateMovieRating)
+	apiv1.Get("/:id/rating", movieHandler.HandleGetMovieRating)
+	apiv1.Get("/:id", movieHandler.HandleGetMovieByID)
+
+	rate := func(user *types.User, rating int) {
+		b, _ := json.Marshal(types.UpdateMovieRating{Rating: rating})
+		req := httptest.NewRequest("PUT", "/"+movieAdded.ID.Hex()+"/rate", bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		req.Header.Add("Api-Token", tdb.token(t, user))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != 200 {
+			t.Fatalf("expected status code 200 but got %d", resp.StatusCode)
+		}
+	}
+	rate(tomek, 10)
+	rate(zuzia, 6)
+	rate(tomek, 8)
+
+	req := httptest.NewRequest("GET", "/"+movieAdded.ID.Hex(), nil)
+	req.Header.Add("Api-Token", tdb.token(t, tomek))
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var movie types.Movie
+	json.NewDecoder(resp.Body).Decode(&movie)
+	if movie.RatingCount != 2 {
+		t.Errorf("expected 2 ratings but got %d", movie.RatingCount)
+	}
+	if movie.RatingAvg != 7 {
+		t.Errorf("expected an average rating of 7 but got %v", movie.RatingAvg)
+	}
+
+	req = httptest.NewRequest("GET", "/"+movieAdded.ID.Hex()+"/rating", nil)
+	req.Header.Add("Api-Token", tdb.token(t, tomek))
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rating types.Rating
+	json.NewDecoder(resp.Body).Decode(&rating)
+	if rating.Rating != 8 {
+		t.Errorf("expected own rating 8 but got %d", rating.Rating)
+	}
+
+	b, _ := json.Marshal(types.UpdateMovieRating{Rating: 11})
+	req = httptest.NewRequest("PUT", "/"+movieAdded.ID.Hex()+"/rate", bytes.NewReader(b))
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Api-Token", tdb.token(t, tomek))
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 400 {
+		t.Errorf("expected status code 400 for an out of range rating but got %d", resp.StatusCode)
 	}
 }
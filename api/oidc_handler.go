@@ -0,0 +1,193 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/oidc"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	oidcFlowCookie = "oidc_flow"
+	oidcFlowTTL    = 10 * time.Minute
+)
+
+// OIDCHandler logs users in through an OpenID Connect provider. A user is
+// found by the provider identity, linked by a verified email or created on
+// the first login.
+type OIDCHandler struct {
+	store    *db.Store
+	provider *oidc.Provider
+	auth     *AuthHandler
+}
+
+func NewOIDCHandler(store *db.Store, provider *oidc.Provider, auth *AuthHandler) *OIDCHandler {
+	return &OIDCHandler{
+		store:    store,
+		provider: provider,
+		auth:     auth,
+	}
+}
+
+// @Summary		Start an OIDC login
+// @Description	Handle redirecting to the OIDC provider. The state, nonce and PKCE verifier of the login are kept in a short lived cookie until the callback
+// @Tags			authentication
+// @Router			/auth/oidc/login [get]
+func (h *OIDCHandler) HandleLogin(c *fiber.Ctx) error {
+	flow, err := oidc.NewFlow()
+	if err != nil {
+		return err
+	}
+	value, err := json.Marshal(flow)
+	if err != nil {
+		return err
+	}
+	c.Cookie(&fiber.Cookie{
+		Name:     oidcFlowCookie,
+		Value:    base64.RawURLEncoding.EncodeToString(value),
+		Path:     "/api/auth/oidc",
+		Expires:  time.Now().Add(oidcFlowTTL),
+		Secure:   c.Protocol() == "https",
+		HTTPOnly: true,
+		// Lax so the cookie comes along with the provider redirecting back
+		SameSite: fiber.CookieSameSiteLaxMode,
+	})
+	return c.Redirect(h.provider.AuthURL(flow), http.StatusFound)
+}
+
+// @Summary		Complete an OIDC login
+// @Description	Handle the redirect back from the OIDC provider. The code is exchanged for the identity of the user, who is linked to an account with the same verified email or created on the first login. Responds like /auth, with a 202 challenge for users with two-factor authentication. With OIDC_TRUST_MFA=true a multi-factor login at the provider counts as a two-factor login instead
+// @Tags			authentication
+// @Produce		json
+// @Param			code	query	string	true	"authorization code"
+// @Param			state	query	string	true	"state of the login"
+// @Router			/auth/oidc/callback [get]
+func (h *OIDCHandler) HandleCallback(c *fiber.Ctx) error {
+	flow, ok := flowFromCookie(c.Cookies(oidcFlowCookie))
+	c.ClearCookie(oidcFlowCookie)
+	if !ok || c.Query("error") != "" || c.Query("code") == "" || c.Query("state") != flow.State {
+		return ErrUnAuthorized()
+	}
+	claims, err := h.provider.Exchange(c.Context(), c.Query("code"), flow)
+	if err != nil {
+		log.Printf("oidc callback: %s: %v", h.provider.Issuer(), err)
+		return ErrUnAuthorized()
+	}
+	user, err := h.resolveUser(c, claims)
+	if err != nil {
+		return err
+	}
+	// staff finish with the local second factor unless the provider's is
+	// trusted explicitly
+	if h.provider.TrustsMFA() && claims.MultiFactor() {
+		return h.auth.login(c, user, true)
+	}
+	return h.auth.firstFactor(c, user)
+}
+
+func flowFromCookie(value string) (oidc.Flow, bool) {
+	var flow oidc.Flow
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || json.Unmarshal(b, &flow) != nil || flow.State == "" {
+		return oidc.Flow{}, false
+	}
+	return flow, true
+}
+
+// resolveUser returns the user of the identity. An existing user is linked
+// only when the provider verified the email, otherwise anyone able to
+// register the email with the provider could take the account over.
+func (h *OIDCHandler) resolveUser(c *fiber.Ctx, claims *oidc.Claims) (*types.User, error) {
+	ctx := c.Context()
+	identity := types.Identity{Issuer: h.provider.Issuer(), Subject: claims.Subject}
+	user, err := h.store.User.GetUserByIdentity(ctx, identity)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, db.ErrNotFound) {
+		return nil, err
+	}
+	email := types.NormalizeEmail(claims.Email)
+	if email == "" {
+		return nil, NewError(http.StatusForbidden, "the identity provider did not share an email address")
+	}
+	existing, err := h.store.User.GetUserByEmail(ctx, email)
+	switch {
+	case err == nil:
+		if !claims.EmailVerified {
+			return nil, NewError(http.StatusConflict, "email is registered, the identity provider has to verify it to link the account")
+		}
+		return h.link(c, existing, identity)
+	case !errors.Is(err, db.ErrNotFound):
+		return nil, err
+	}
+	return h.create(c, claims, identity, email)
+}
+
+func (h *OIDCHandler) link(c *fiber.Ctx, user *types.User, identity types.Identity) (*types.User, error) {
+	id := user.ID.Hex()
+	if err := h.store.User.LinkIdentity(c.Context(), id, identity); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return nil, NewError(http.StatusConflict, "account is linked to another identity")
+		}
+		return nil, err
+	}
+	entry := types.NewAuditEntry(types.AuditIdentityLink, user.ID, "user:"+id, identity.Issuer)
+	if _, err := h.store.Audit.InsertAuditEntry(c.Context(), entry); err != nil {
+		return nil, err
+	}
+	user.Identity = &identity
+	return user, nil
+}
+
+// create registers the user of a first login. Usernames taken already get
+// a random suffix.
+func (h *OIDCHandler) create(c *fiber.Ctx, claims *oidc.Claims, identity types.Identity, email string) (*types.User, error) {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" && lastName == "" {
+		firstName, lastName, _ = strings.Cut(claims.Name, " ")
+	}
+	username := claims.PreferredUsername
+	if username == "" {
+		username, _, _ = strings.Cut(email, "@")
+	}
+	base := username
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 || !types.IsValidUsername(username) {
+			// the counter part of an object id is unique to this process
+			username = base + "-" + primitive.NewObjectID().Hex()[18:]
+		}
+		user := &types.User{
+			Username:      username,
+			FirstName:     firstName,
+			LastName:      lastName,
+			Email:         email,
+			Roles:         []types.Role{types.RoleCustomer},
+			EmailVerified: claims.EmailVerified,
+			Identity:      &identity,
+		}
+		inserted, err := h.store.User.InsertUser(c.Context(), user)
+		var dup *db.DuplicateError
+		if !errors.As(err, &dup) {
+			return inserted, err
+		}
+		switch {
+		case dup.Field == "username" && attempt < 3:
+			continue
+		case dup.Field == "identity":
+			// a concurrent callback of the same identity created it first
+			return h.store.User.GetUserByIdentity(c.Context(), identity)
+		default:
+			return nil, NewError(http.StatusConflict, dup.Error())
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/oidc"
+	"github.com/tomekzakrzewski/go-movierental/oidc/oidctest"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	iss := oidctest.NewIssuer("movies")
+	defer iss.Close()
+	provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+		Issuer:      iss.URL,
+		ClientID:    "movies",
+		RedirectURL: "http://localhost/auth/oidc/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		app         = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		oidcHandler = NewOIDCHandler(tdb.Store, provider, tdb.authHandler())
+		tomek       = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		anna        = fixtures.AddUser(tdb.Store, "anna", "test", false)
+	)
+	app.Get("/auth/oidc/login", oidcHandler.HandleLogin)
+	app.Get("/auth/oidc/callback", oidcHandler.HandleCallback)
+
+	// login runs the whole redirect dance and returns the callback response
+	login := func(claims oidctest.Claims, state string, expected int) *http.Response {
+		t.Helper()
+		iss.SetClaims(claims)
+		resp, err := app.Test(httptest.NewRequest("GET", "/auth/oidc/login", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusFound {
+			t.Fatalf("expected a redirect to the provider but got %d", resp.StatusCode)
+		}
+		redirect, err := iss.Authorize(resp.Header.Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state != "" {
+			q := redirect.Query()
+			q.Set("state", state)
+			redirect.RawQuery = q.Encode()
+		}
+		req := httptest.NewRequest("GET", redirect.RequestURI(), nil)
+		for _, cookie := range resp.Cookies() {
+			req.AddCookie(cookie)
+		}
+		resp, err = app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != expected {
+			t.Fatalf("expected status code %d but got %d", expected, resp.StatusCode)
+		}
+		return resp
+	}
+	decodeUser := func(resp *http.Response) *types.User {
+		t.Helper()
+		var auth AuthResponse
+		if err := json.NewDecoder(resp.Body).Decode(&auth); err != nil {
+			t.Fatal(err)
+		}
+		if auth.Token == "" || auth.RefreshToken == "" {
+			t.Fatal("expected tokens in the response")
+		}
+		return auth.User
+	}
+
+	t.Run("creates a user on the first login", func(t *testing.T) {
+		claims := oidctest.Claims{Subject: "staff-1", Email: "Jane@Corp.com", EmailVerified: true, GivenName: "Jane", FamilyName: "Doe", PreferredUsername: "jane"}
+		user := decodeUser(login(claims, "", http.StatusOK))
+		if user.Email != "jane@corp.com" || user.Username != "jane" || user.FirstName != "Jane" || !user.EmailVerified {
+			t.Fatalf("unexpected user %+v", user)
+		}
+		if again := decodeUser(login(claims, "", http.StatusOK)); again.ID != user.ID {
+			t.Fatalf("expected the second login to find user %s but got %s", user.ID.Hex(), again.ID.Hex())
+		}
+		stored, err := tdb.User.GetUserByIdentity(context.TODO(), types.Identity{Issuer: iss.URL, Subject: "staff-1"})
+		if err != nil || stored.ID != user.ID {
+			t.Fatalf("expected the identity to be linked, got %v", err)
+		}
+	})
+
+	t.Run("suffixes a taken username", func(t *testing.T) {
+		claims := oidctest.Claims{Subject: "staff-2", Email: "other@corp.com", EmailVerified: true, PreferredUsername: tomek.Username}
+		user := decodeUser(login(claims, "", http.StatusOK))
+		if user.Username == tomek.Username || user.ID == tomek.ID {
+			t.Fatalf("expected a new user with another username, got %+v", user)
+		}
+	})
+
+	t.Run("links a verified email", func(t *testing.T) {
+		claims := oidctest.Claims{Subject: "staff-3", Email: tomek.Email, EmailVerified: true}
+		if user := decodeUser(login(claims, "", http.StatusOK)); user.ID != tomek.ID {
+			t.Fatalf("expected to log in as %s but got %s", tomek.ID.Hex(), user.ID.Hex())
+		}
+		page, err := tdb.Audit.GetAuditEntries(context.TODO(), map[string]any{"subject": "user:" + tomek.ID.Hex()}, nil)
+		if err != nil || len(page.Items) != 1 || page.Items[0].Action != types.AuditIdentityLink {
+			t.Fatalf("expected the link to be audited, got %v", err)
+		}
+		// another identity with the same email can't take the account
+		claims.Subject = "staff-4"
+		login(claims, "", http.StatusConflict)
+	})
+
+	t.Run("does not link an unverified email", func(t *testing.T) {
+		login(oidctest.Claims{Subject: "staff-5", Email: anna.Email}, "", http.StatusConflict)
+	})
+
+	t.Run("rejects a wrong state", func(t *testing.T) {
+		login(oidctest.Claims{Subject: "staff-1", Email: "jane@corp.com", EmailVerified: true}, "forged", http.StatusUnauthorized)
+	})
+
+	t.Run("asks for the second factor", func(t *testing.T) {
+		if err := tdb.TOTP.StartTOTP(context.TODO(), types.NewTOTP(anna.ID, "JBSWY3DPEHPK3PXP")); err != nil {
+			t.Fatal(err)
+		}
+		if err := tdb.TOTP.ConfirmTOTP(context.TODO(), anna.ID.Hex(), 1, nil); err != nil {
+			t.Fatal(err)
+		}
+		claims := oidctest.Claims{Subject: "staff-6", Email: anna.Email, EmailVerified: true}
+		login(claims, "", http.StatusAccepted)
+		// a provider login with a second factor doesn't replace the local one
+		claims.AMR = []string{"pwd", "mfa"}
+		login(claims, "", http.StatusAccepted)
+	})
+
+	t.Run("trusts the provider's second factor when configured", func(t *testing.T) {
+		trusting, err := oidc.NewProvider(context.Background(), oidc.Config{
+			Issuer:      iss.URL,
+			ClientID:    "movies",
+			RedirectURL: "http://localhost/auth/oidc/callback",
+			TrustMFA:    true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		oidcHandler.provider = trusting
+		defer func() { oidcHandler.provider = provider }()
+		claims := oidctest.Claims{Subject: "staff-6", Email: anna.Email, EmailVerified: true}
+		login(claims, "", http.StatusAccepted)
+		claims.AMR = []string{"pwd", "mfa"}
+		decodeUser(login(claims, "", http.StatusOK))
+	})
+}
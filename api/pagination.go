@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+)
+
+// ResourceResp is the envelope of every list endpoint. Total counts all
+// matching items; pass NextCursor as ?cursor= to fetch the following page.
+type ResourceResp struct {
+	Results    int    `json:"results"`
+	Data       any    `json:"data"`
+	Total      int    `json:"total"`
+	HasNext    bool   `json:"hasNext"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
+
+func newResourceResp[T any](page *db.Page[T]) ResourceResp {
+	return ResourceResp{
+		Results:    len(page.Items),
+		Data:       page.Items,
+		Total:      page.Total,
+		HasNext:    page.HasNext,
+		NextCursor: page.NextCursor,
+	}
+}
+
+// paginationFromQuery reads the ?limit= and ?cursor= query parameters shared
+// by the list endpoints, applying the default and maximum limits.
+func paginationFromQuery(c *fiber.Ctx) (*db.Pagination, error) {
+	pag := &db.Pagination{Cursor: c.Query("cursor")}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 0 {
+			return nil, NewError(http.StatusBadRequest, fmt.Sprintf("invalid limit: %s", value))
+		}
+		pag.Limit = limit
+	}
+	pag.Clamp()
+	return pag, nil
+}
+
+// listError maps the error of a store list method to an API error.
+func listError(err error, res string) error {
+	if errors.Is(err, db.ErrInvalidCursor) {
+		return NewError(http.StatusBadRequest, "invalid cursor")
+	}
+	return ErrResourceNotFound(res)
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/mailer"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+const passwordResetTTL = time.Hour
+
+type PasswordHandler struct {
+	store  *db.Store
+	mailer mailer.Mailer
+}
+
+func NewPasswordHandler(store *db.Store, mailer mailer.Mailer) *PasswordHandler {
+	return &PasswordHandler{
+		store:  store,
+		mailer: mailer,
+	}
+}
+
+type ForgotPasswordParams struct {
+	Email string `json:"email"`
+}
+
+// @Summary		Forgot password
+// @Description	Handle mailing a password reset token. The response is the same whether the email is registered or not
+// @Tags			authentication
+// @Accept			json
+// @Produce		json
+// @Param			params	body	ForgotPasswordParams	true	"email"
+// @Router			/auth/password/forgot [post]
+func (h *PasswordHandler) HandleForgotPassword(c *fiber.Ctx) error {
+	var params ForgotPasswordParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	resp := genericResp{
+		Type: "msg",
+		Msg:  "if the email is registered, a password reset token was sent to it",
+	}
+	user, err := h.store.User.GetUserByEmail(c.Context(), params.Email)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return c.JSON(resp)
+		}
+		return err
+	}
+	secret, err := issueActionToken(c.Context(), h.store, user, types.TokenPasswordReset, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+	msg := actionTokenMessage(user, "Reset your password",
+		"Use this token to reset your password within the next hour:", "PASSWORD_RESET_URL", secret)
+	msg.Body += "\nIf you didn't ask to reset your password, ignore this email.\n"
+	if err := h.mailer.Send(c.Context(), msg); err != nil {
+		return err
+	}
+	return c.JSON(resp)
+}
+
+// @Summary		Reset password
+// @Description	Handle setting a new password with a password reset token. The token can be used once and every session of the user is revoked
+// @Tags			authentication
+// @Accept			json
+// @Produce		json
+// @Param			params	body	types.ResetPasswordParams	true	"token and new password"
+// @Router			/auth/password/reset [post]
+func (h *PasswordHandler) HandleResetPassword(c *fiber.Ctx) error {
+	var params types.ResetPasswordParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+	now := time.Now()
+	token, err := h.store.ActionToken.UseActionToken(c.Context(), types.TokenPasswordReset, types.HashSecret(params.Token), now)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return NewError(http.StatusBadRequest, "invalid or expired token")
+		}
+		return err
+	}
+	userID := token.UserID.Hex()
+	encpw, err := types.EncryptPassword(params.Password)
+	if err != nil {
+		return err
+	}
+	if err := h.store.User.UpdateUserPassword(c.Context(), userID, encpw); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return NewError(http.StatusBadRequest, "invalid or expired token")
+		}
+		return err
+	}
+	if _, err := h.store.Session.RevokeUserSessions(c.Context(), userID, now); err != nil {
+		return err
+	}
+	// the mailed token proves the account is theirs, so a lockout caused
+	// by someone guessing the old password is lifted
+	user, err := h.store.User.GetUserByID(c.Context(), userID)
+	if err != nil {
+		return err
+	}
+	if err := h.store.LoginAttempt.ResetLoginAttempts(c.Context(), types.AccountLoginKey(user.Email)); err != nil {
+		return err
+	}
+	return c.JSON(genericResp{
+		Type: "msg",
+		Msg:  "password updated",
+	})
+}
@@ -0,0 +1,83 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/mailer"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+var mailedToken = regexp.MustCompile(`token: (\S+)`)
+
+func TestPasswordReset(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		mail            bytes.Buffer
+		app             = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		authHandler     = tdb.authHandler()
+		passwordHandler = NewPasswordHandler(tdb.Store, mailer.NewLogMailer(&mail))
+		user            = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		oldToken        = tdb.token(t, user)
+	)
+	app.Post("/auth", authHandler.HandleAuthenticate)
+	app.Post("/auth/password/forgot", passwordHandler.HandleForgotPassword)
+	app.Post("/auth/password/reset", passwordHandler.HandleResetPassword)
+	app.Get("/me", JWTAuthentication(tdb.Store), func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+
+	post := func(path string, body any, expected int) {
+		t.Helper()
+		b, _ := json.Marshal(body)
+		req := httptest.NewRequest("POST", path, bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != expected {
+			t.Fatalf("%s: expected status code %d but got %d", path, expected, resp.StatusCode)
+		}
+	}
+	forgot := func() string {
+		t.Helper()
+		mail.Reset()
+		post("/auth/password/forgot", ForgotPasswordParams{Email: user.Email}, 200)
+		m := mailedToken.FindStringSubmatch(mail.String())
+		if m == nil {
+			t.Fatalf("expected a reset token in the mail but got %q", mail.String())
+		}
+		return m[1]
+	}
+
+	post("/auth/password/forgot", ForgotPasswordParams{Email: "nobody@test.com"}, 200)
+	if mail.Len() != 0 {
+		t.Fatal("expected no mail for an unknown email")
+	}
+
+	stale := forgot()
+	token := forgot()
+	post("/auth/password/reset", types.ResetPasswordParams{Token: stale, Password: "newpassword"}, 400)
+	post("/auth/password/reset", types.ResetPasswordParams{Token: token, Password: "short"}, 400)
+	post("/auth/password/reset", types.ResetPasswordParams{Token: token, Password: "newpassword"}, 200)
+	post("/auth/password/reset", types.ResetPasswordParams{Token: token, Password: "otherpassword"}, 400)
+
+	post("/auth", AuthParams{Email: user.Email, Password: "test123"}, 400)
+	post("/auth", AuthParams{Email: user.Email, Password: "newpassword"}, 200)
+
+	req := httptest.NewRequest("GET", "/me", nil)
+	req.Header.Add("Api-Token", oldToken)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected sessions from before the reset to be revoked but got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,253 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/payment"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+type PaymentHandler struct {
+	store    *db.Store
+	provider payment.Provider
+}
+
+func NewPaymentHandler(store *db.Store, provider payment.Provider) *PaymentHandler {
+	return &PaymentHandler{
+		store:    store,
+		provider: provider,
+	}
+}
+
+// charge authorizes and captures amount for a rent through the provider and
+// records the payment. A declined authorization leaves no payment behind, a
+// declined capture is recorded as failed. Both return payment.ErrDeclined.
+// A capture failing otherwise voids the authorization and returns its error.
+// The wallet method debits the renter's wallet instead. Hours is the length
+// of an extension and zero for rents.
+func charge(ctx context.Context, store *db.Store, provider payment.Provider, rent *types.Rent, amount int64, purpose types.PaymentPurpose, hours int, method string) (*types.Payment, error) {
+	if method == walletMethod {
+		return debitWallet(ctx, store, rent, amount, purpose, hours)
+	}
+	providerID, err := provider.Authorize(ctx, payment.Charge{
+		Amount:    amount,
+		Currency:  rent.Currency,
+		Method:    method,
+		Reference: rent.ID.Hex(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	p, err := store.Payment.InsertPayment(ctx, &types.Payment{
+		RentID:     rent.ID,
+		UserID:     rent.UserID,
+		Purpose:    purpose,
+		Provider:   provider.Name(),
+		ProviderID: providerID,
+		Amount:     amount,
+		Hours:      hours,
+		Currency:   rent.Currency,
+		Status:     types.PaymentAuthorized,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	status, err := provider.Capture(ctx, providerID)
+	if err != nil {
+		declined := errors.Is(err, payment.ErrDeclined)
+		if !declined {
+			// the capture failed for another reason, like a timeout, so the
+			// hold on the renter's payment method is released. Should that
+			// fail too the payment stays authorized for the provider's
+			// webhook to settle.
+			if voidErr := provider.Void(ctx, providerID); voidErr != nil {
+				return nil, errors.Join(err, voidErr)
+			}
+		}
+		if _, err := store.Payment.UpdatePaymentStatus(ctx, p.ID.Hex(), types.PaymentAuthorized, types.PaymentFailed, time.Now()); err != nil {
+			return nil, err
+		}
+		if declined {
+			return nil, payment.ErrDeclined
+		}
+		return nil, err
+	}
+	// a webhook about an asynchronous capture may have beaten us to it
+	updated, err := store.Payment.UpdatePaymentStatus(ctx, p.ID.Hex(), types.PaymentAuthorized, status, time.Now())
+	if errors.Is(err, db.ErrInvalidTransition) {
+		return store.Payment.GetPaymentByID(ctx, p.ID.Hex())
+	}
+	return updated, err
+}
+
+// refund returns a captured payment through the provider, or to the
+// wallet it was paid from.
+func refund(ctx context.Context, store *db.Store, provider payment.Provider, p *types.Payment) (*types.Payment, error) {
+	if p.Status != types.PaymentCaptured {
+		return nil, NewError(http.StatusConflict, fmt.Sprintf("payment can't be refunded, status: %s", p.Status))
+	}
+	if p.Provider == walletMethod {
+		return refundWallet(ctx, store, p)
+	}
+	if err := provider.Refund(ctx, p.ProviderID); err != nil {
+		return nil, err
+	}
+	return store.Payment.UpdatePaymentStatus(ctx, p.ID.Hex(), types.PaymentCaptured, types.PaymentRefunded, time.Now())
+}
+
+func declined(err error) error {
+	if errors.Is(err, payment.ErrDeclined) {
+		return NewError(http.StatusPaymentRequired, "payment declined")
+	}
+	if errors.Is(err, db.ErrInsufficientFunds) {
+		return NewError(http.StatusPaymentRequired, "insufficient wallet balance")
+	}
+	return err
+}
+
+// activateRent starts a pending rent once its payment is captured.
+func activateRent(ctx context.Context, store *db.Store, rentID string) (*types.Rent, error) {
+	return store.Rent.UpdateRentState(ctx, rentID, types.RentActive, time.Now())
+}
+
+// cancelRent cancels a pending rent whose payment failed and releases its
+// copy.
+func cancelRent(ctx context.Context, store *db.Store, rentID string) error {
+	rent, err := store.Rent.UpdateRentState(ctx, rentID, types.RentCancelled, time.Now())
+	if err != nil {
+		return err
+	}
+	if !rent.CopyID.IsZero() {
+		return store.Copy.ReleaseCopy(ctx, rent.CopyID.Hex())
+	}
+	return nil
+}
+
+// extendRent applies a paid extension to its rent once a webhook confirms
+// the capture. The payment is refunded when the rent can't be extended
+// anymore, like after it was returned in the meantime.
+func extendRent(ctx context.Context, store *db.Store, provider payment.Provider, p *types.Payment) error {
+	rent, err := store.Rent.GetRentByID(ctx, p.RentID.Hex())
+	if err != nil {
+		return err
+	}
+	_, err = store.Rent.ExtendRent(ctx, rent.ID.Hex(), rent.To, rent.To.Add(time.Duration(p.Hours)*time.Hour), p.Amount)
+	if errors.Is(err, db.ErrInvalidTransition) {
+		_, err = refund(ctx, store, provider, p)
+	}
+	return err
+}
+
+// @Summary		Receive a payment webhook
+// @Description	Handle a signed webhook of the payment provider. A captured rent payment activates its pending rent, a failed one cancels it and releases its copy
+// @Description	A captured extension payment that was pending extends its rent, or is refunded when the rent can't be extended anymore
+// @Description	Redelivered webhooks are acknowledged without changing anything
+// @Tags			payments
+// @Accept			json
+// @Produce		json
+// @Router			/payments/webhook [post]
+func (h *PaymentHandler) HandleWebhook(c *fiber.Ctx) error {
+	event, err := h.provider.VerifyWebhook(http.Header(c.GetReqHeaders()), c.Body())
+	if err != nil {
+		return NewError(http.StatusBadRequest, "invalid webhook")
+	}
+	p, err := h.store.Payment.GetPaymentByProviderID(c.Context(), h.provider.Name(), event.PaymentID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return ErrResourceNotFound("Payment")
+		}
+		return err
+	}
+	var to types.PaymentStatus
+	from := []types.PaymentStatus{types.PaymentAuthorized, types.PaymentPending}
+	switch event.Type {
+	case payment.EventCaptured:
+		to = types.PaymentCaptured
+	case payment.EventFailed:
+		to = types.PaymentFailed
+	case payment.EventRefunded:
+		to = types.PaymentRefunded
+		from = []types.PaymentStatus{types.PaymentCaptured}
+	default:
+		return NewError(http.StatusBadRequest, fmt.Sprintf("unknown event type: %s", event.Type))
+	}
+	if p.Status == to {
+		return c.JSON(p)
+	}
+	if !slices.Contains(from, p.Status) {
+		return NewError(http.StatusConflict, fmt.Sprintf("payment can't become %s, status: %s", to, p.Status))
+	}
+	updated, err := h.store.Payment.UpdatePaymentStatus(c.Context(), p.ID.Hex(), p.Status, to, time.Now())
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidTransition) {
+			return NewError(http.StatusConflict, "payment changed while applying the webhook, try again")
+		}
+		return err
+	}
+	switch updated.Purpose {
+	case types.PaymentForRent:
+		switch to {
+		case types.PaymentCaptured:
+			_, err = activateRent(c.Context(), h.store, updated.RentID.Hex())
+		case types.PaymentFailed:
+			err = cancelRent(c.Context(), h.store, updated.RentID.Hex())
+		}
+	case types.PaymentForExtension:
+		// a capture beating the extend request is applied by the request
+		// itself, only pending ones are left to the webhook
+		if to == types.PaymentCaptured && p.Status == types.PaymentPending {
+			err = extendRent(c.Context(), h.store, h.provider, updated)
+		}
+	}
+	if err != nil && !errors.Is(err, db.ErrInvalidTransition) {
+		return err
+	}
+	return c.JSON(updated)
+}
+
+// @Summary		Get the payments of a rent
+// @Description	Handle getting the payments of a rent oldest first, users can only see the payments of their own rents unless they can manage rents
+// @Tags			user
+// @Produce		json
+// @Router			/rents/:id/payments [get]
+func (h *PaymentHandler) HandleGetRentPayments(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if _, err := authorize(c, rentPolicy, h.store.Rent.GetRentByID, id); err != nil {
+		return err
+	}
+	payments, err := h.store.Payment.GetPaymentsByRent(c.Context(), id)
+	if err != nil {
+		return err
+	}
+	return c.JSON(payments)
+}
+
+// @Summary		Refund a payment
+// @Description	Handle refunding a captured payment in full through the payment provider, payments made from a wallet are credited back to it
+// @Tags			admin
+// @Produce		json
+// @Router			/admin/payments/:id/refund [post]
+func (h *PaymentHandler) HandleRefundPayment(c *fiber.Ctx) error {
+	p, err := h.store.Payment.GetPaymentByID(c.Context(), c.Params("id"))
+	if err != nil {
+		return ErrResourceNotFound("Payment")
+	}
+	refunded, err := refund(c.Context(), h.store, h.provider, p)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidTransition) {
+			return NewError(http.StatusConflict, "payment changed while refunding, try again")
+		}
+		return err
+	}
+	return c.JSON(refunded)
+}
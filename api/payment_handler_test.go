@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/payment"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+func TestPayments(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		app            = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1          = app.Group("/v1", JWTAuthentication(tdb.Store))
+		admin          = apiv1.Group("/admin", AdminAuth)
+		fake           = payment.NewFake("secret")
+		movieHandler   = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), fake)
+		rentHandler    = NewRentHandler(tdb.Store, DefaultRentalPolicy(), fake)
+		paymentHandler = NewPaymentHandler(tdb.Store, fake)
+		adminUser      = fixtures.AddUser(tdb.Store, "admin", "admin", true)
+		tomek          = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		zuzia          = fixtures.AddUser(tdb.Store, "zuzia", "test", false)
+		adminToken     = tdb.token(t, adminUser)
+		tomekToken     = tdb.token(t, tomek)
+		zuziaToken     = tdb.token(t, zuzia)
+		movie          = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		cp             = fixtures.AddCopy(tdb.Store, movie, types.FormatDVD)
+	)
+	if _, err := tdb.Store.PriceRule.InsertPriceRule(context.Background(), &types.PriceRule{Kind: types.PriceBase, Amount: 399}); err != nil {
+		t.Fatal(err)
+	}
+	app.Post("/payments/webhook", paymentHandler.HandleWebhook)
+	apiv1.Post("/movies/:id/rent", movieHandler.HandleRentMovie)
+	apiv1.Post("/rents/:id/extend", rentHandler.HandleExtendRent)
+	apiv1.Get("/rents/:id/payments", paymentHandler.HandleGetRentPayments)
+	admin.Post("/payments/:id/refund", RequirePermission(types.PermManageRents), paymentHandler.HandleRefundPayment)
+
+	rent := func(token, method string, expected int) *types.Rent {
+		t.Helper()
+		var rent types.Rent
+		json.NewDecoder(request(t, app, "POST", "/v1/movies/"+movie.ID.Hex()+"/rent?paymentMethod="+method, token, nil, expected).Body).Decode(&rent)
+		return &rent
+	}
+	payments := func(token string, rent *types.Rent) []types.Payment {
+		t.Helper()
+		var payments []types.Payment
+		json.NewDecoder(request(t, app, "GET", "/v1/rents/"+rent.ID.Hex()+"/payments", token, nil, 200).Body).Decode(&payments)
+		return payments
+	}
+	rentState := func(rent *types.Rent) types.RentState {
+		t.Helper()
+		got, err := tdb.Store.Rent.GetRentByID(context.Background(), rent.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.State
+	}
+	copyRented := func() bool {
+		t.Helper()
+		got, err := tdb.Store.Copy.GetCopyByID(context.Background(), cp.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got.Rented
+	}
+
+	// a declined payment cancels the rent and frees the copy again
+	rent(tomekToken, payment.FakeDeclined, http.StatusPaymentRequired)
+	rent(tomekToken, payment.FakeCaptureDeclined, http.StatusPaymentRequired)
+	if copyRented() {
+		t.Fatal("expected the copy to be released after declined payments")
+	}
+	// a capture failing for another reason isn't a decline, the hold is
+	// released and the error reported
+	rent(tomekToken, payment.FakeCaptureUnavailable, http.StatusInternalServerError)
+	if copyRented() {
+		t.Fatal("expected the copy to be released after a failed capture")
+	}
+	page, err := tdb.Store.Rent.GetRentsByUser(context.Background(), tomek.ID.Hex(), map[string]any{"state": types.RentCancelled}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := page.Items[len(page.Items)-1]
+	if paid := payments(tomekToken, last); len(paid) != 1 || paid[0].Status != types.PaymentFailed || fake.Status(paid[0].ProviderID) != types.PaymentFailed {
+		t.Fatalf("expected a failed payment voided at the provider but got %+v", paid)
+	}
+
+	pending := rent(tomekToken, payment.FakeAsync, http.StatusAccepted)
+	if pending.State != types.RentPending || !copyRented() {
+		t.Fatalf("expected a pending rent holding the copy but got %s", pending.State)
+	}
+	paid := payments(tomekToken, pending)
+	if len(paid) != 1 || paid[0].Status != types.PaymentPending || paid[0].Amount != 399 || paid[0].Purpose != types.PaymentForRent {
+		t.Fatalf("expected one pending payment but got %+v", paid)
+	}
+	request(t, app, "GET", "/v1/rents/"+pending.ID.Hex()+"/payments", zuziaToken, nil, 404)
+
+	header, body, err := fake.Settle(paid[0].ProviderID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestWithHeader(t, app, "POST", "/payments/webhook", http.Header{payment.FakeSignatureHeader: {"00"}}, body, 400)
+	requestWithHeader(t, app, "POST", "/payments/webhook", header, body, 200)
+	if state := rentState(pending); state != types.RentActive {
+		t.Fatalf("expected the captured rent to be active but got %s", state)
+	}
+	// redelivered webhooks change nothing
+	requestWithHeader(t, app, "POST", "/payments/webhook", header, body, 200)
+
+	request(t, app, "POST", "/v1/admin/payments/"+paid[0].ID.Hex()+"/refund", tomekToken, nil, 401)
+	request(t, app, "POST", "/v1/admin/payments/"+paid[0].ID.Hex()+"/refund", adminToken, nil, 200)
+	request(t, app, "POST", "/v1/admin/payments/"+paid[0].ID.Hex()+"/refund", adminToken, nil, 409)
+	if status := fake.Status(paid[0].ProviderID); status != types.PaymentRefunded {
+		t.Fatalf("expected the provider to refund the payment but got %s", status)
+	}
+	if paid = payments(adminToken, pending); paid[0].Status != types.PaymentRefunded {
+		t.Fatalf("expected a refunded payment but got %s", paid[0].Status)
+	}
+
+	// a capture failing later cancels the rent
+	if _, err := tdb.Store.Rent.UpdateRentState(context.Background(), pending.ID.Hex(), types.RentReturned, pending.To); err != nil {
+		t.Fatal(err)
+	}
+	if err := tdb.Store.Copy.ReleaseCopy(context.Background(), cp.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	failing := rent(zuziaToken, payment.FakeAsync, http.StatusAccepted)
+	header, body, err = fake.Settle(payments(zuziaToken, failing)[0].ProviderID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestWithHeader(t, app, "POST", "/payments/webhook", header, body, 200)
+	if state := rentState(failing); state != types.RentCancelled || copyRented() {
+		t.Fatalf("expected the rent to be cancelled and its copy released but got %s", state)
+	}
+
+	// a pending extension waits for its webhook and a failed one changes
+	// nothing
+	active := rent(tomekToken, "card", http.StatusOK)
+	fixtures.AddCopy(tdb.Store, movie, types.FormatDVD)
+	extend := "/v1/rents/" + active.ID.Hex() + "/extend?paymentMethod="
+	getRent := func() *types.Rent {
+		t.Helper()
+		got, err := tdb.Store.Rent.GetRentByID(context.Background(), active.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+	request(t, app, "POST", extend+payment.FakeAsync, tomekToken, nil, http.StatusAccepted)
+	request(t, app, "POST", extend+payment.FakeAsync, tomekToken, nil, http.StatusConflict)
+	if got := getRent(); !got.To.Equal(active.To) || got.Extensions != 0 {
+		t.Fatalf("expected the rent to wait for the payment but it ends at %s after %d extensions", got.To, got.Extensions)
+	}
+	paid = payments(tomekToken, active)
+	header, body, err = fake.Settle(paid[1].ProviderID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestWithHeader(t, app, "POST", "/payments/webhook", header, body, 200)
+	if got := getRent(); !got.To.Equal(active.To) || got.Extensions != 0 || got.Price != active.Price {
+		t.Fatalf("expected a failed payment to leave the rent alone but it ends at %s after %d extensions", got.To, got.Extensions)
+	}
+	if paid = payments(tomekToken, active); paid[1].Purpose != types.PaymentForExtension || paid[1].Status != types.PaymentFailed {
+		t.Fatalf("expected a failed extension payment but got %+v", paid[1])
+	}
+
+	request(t, app, "POST", extend+payment.FakeAsync, tomekToken, nil, http.StatusAccepted)
+	header, body, err = fake.Settle(payments(tomekToken, active)[2].ProviderID, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requestWithHeader(t, app, "POST", "/payments/webhook", header, body, 200)
+	requestWithHeader(t, app, "POST", "/payments/webhook", header, body, 200)
+	if got := getRent(); got.To.Sub(active.To) != 24*time.Hour || got.Extensions != 1 || got.Price != 2*active.Price {
+		t.Fatalf("expected the capture to extend the rent once but it ends at %s after %d extensions", got.To, got.Extensions)
+	}
+}
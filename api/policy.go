@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+// Policy guards resources that belong to a user. Owners can always act on
+// them, other users need the Override permission. Users who can't get a
+// not found error so they can't tell whether the resource exists.
+type Policy struct {
+	Resource string
+	// Override is the permission to act on resources of other users. Only
+	// owners are allowed when it is empty.
+	Override types.Permission
+}
+
+var (
+	userPolicy   = Policy{Resource: "User", Override: types.PermReadUsers}
+	rentPolicy   = Policy{Resource: "Rent", Override: types.PermManageRents}
+	reviewPolicy = Policy{Resource: "Review"}
+)
+
+func (p Policy) Allows(user *types.User, resource types.Owned) bool {
+	if resource.OwnerID() == user.ID {
+		return true
+	}
+	return p.Override != "" && user.Can(p.Override)
+}
+
+// authorize loads the resource with get and checks that the signed in user
+// is allowed to act on it.
+func authorize[T types.Owned](c *fiber.Ctx, p Policy, get func(context.Context, string) (T, error), id string) (T, error) {
+	var zero T
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return zero, ErrUnAuthorized()
+	}
+	resource, err := get(c.Context(), id)
+	if err != nil || !p.Allows(user, resource) {
+		return zero, ErrResourceNotFound(p.Resource)
+	}
+	return resource, nil
+}
@@ -0,0 +1,172 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/pricing"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+type PriceHandler struct {
+	store   *db.Store
+	rentals RentalPolicy
+}
+
+func NewPriceHandler(store *db.Store, rentals RentalPolicy) *PriceHandler {
+	return &PriceHandler{
+		store:   store,
+		rentals: rentals,
+	}
+}
+
+// quote prices a rent of the movie with the current price list.
+func quote(ctx context.Context, store *db.Store, rentals RentalPolicy, movie *types.Movie, format types.CopyFormat, hours int, start time.Time) (pricing.Quote, error) {
+	rules, err := store.PriceRule.GetPriceRules(ctx)
+	if err != nil {
+		return pricing.Quote{}, err
+	}
+	req := pricing.Request{Movie: movie, Format: format, Hours: hours, Start: start}
+	return pricing.Price(rules, req, rentals.Currency), nil
+}
+
+// @Summary		Quote the price of a rent
+// @Description	Handle pricing a rent of the movie starting now, per format with copies available or for the format asked for
+// @Tags			user
+// @Produce		json
+// @Param			format	query	string	false	"copy format"	Enums(dvd, bluray, 4k, digital)
+// @Param			hours	query	int		false	"rental duration in hours, the first one of each format by default"
+// @Router			/movies/:id/quote [get]
+func (h *PriceHandler) HandleGetQuote(c *fiber.Ctx) error {
+	movie, err := h.store.Movie.GetMovieByID(c.Context(), c.Params("id"))
+	if err != nil {
+		return ErrResourceNotFound("Movie")
+	}
+	formats := []types.CopyFormat{types.CopyFormat(c.Query("format"))}
+	if formats[0] == "" {
+		if formats, err = h.availableFormats(c.Context(), movie); err != nil {
+			return err
+		}
+	} else if !formats[0].IsValid() {
+		return NewError(http.StatusBadRequest, fmt.Sprintf("invalid format: %s", formats[0]))
+	}
+	now := time.Now()
+	quotes := []pricing.Quote{}
+	for _, format := range formats {
+		hours, err := rentalHours(c.Query("hours"), h.rentals.HoursFor(movie, format))
+		if err != nil {
+			return err
+		}
+		q, err := quote(c.Context(), h.store, h.rentals, movie, format, hours, now)
+		if err != nil {
+			return err
+		}
+		quotes = append(quotes, q)
+	}
+	return c.JSON(quotes)
+}
+
+// availableFormats lists the formats the movie can be rented in right now.
+func (h *PriceHandler) availableFormats(ctx context.Context, movie *types.Movie) ([]types.CopyFormat, error) {
+	copies, err := h.store.Copy.GetCopies(ctx, movie.ID.Hex(), map[string]any{"rented": false, "retired": false})
+	if err != nil {
+		return nil, err
+	}
+	formats := []types.CopyFormat{}
+	for _, format := range copyFormats {
+		for _, cp := range copies {
+			if cp.Format == format {
+				formats = append(formats, format)
+				break
+			}
+		}
+	}
+	return formats, nil
+}
+
+// @Summary		Get price rules
+// @Description	Handle listing the whole price list, oldest rule first
+// @Tags			admin
+// @Produce		json
+// @Router			/price-rules [get]
+func (h *PriceHandler) HandleGetPriceRules(c *fiber.Ctx) error {
+	rules, err := h.store.PriceRule.GetPriceRules(c.Context())
+	if err != nil {
+		return err
+	}
+	return c.JSON(rules)
+}
+
+// @Summary		Add a price rule
+// @Description	Handle adding a base price, new release premium, duration multiplier or weekend rate to the price list. Amounts are in minor units of the rental currency
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			params	body	types.PriceRuleParams	true	"price rule"
+// @Router			/price-rules [post]
+func (h *PriceHandler) HandlePostPriceRule(c *fiber.Ctx) error {
+	rule, err := h.ruleFromBody(c)
+	if err != nil || rule == nil {
+		return err
+	}
+	inserted, err := h.store.PriceRule.InsertPriceRule(c.Context(), rule)
+	if err != nil {
+		return err
+	}
+	return c.JSON(inserted)
+}
+
+// @Summary		Replace a price rule
+// @Description	Handle replacing every field of a price rule
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			id		path	string					true	"price rule id"
+// @Param			params	body	types.PriceRuleParams	true	"price rule"
+// @Router			/price-rules/:id [put]
+func (h *PriceHandler) HandlePutPriceRule(c *fiber.Ctx) error {
+	rule, err := h.ruleFromBody(c)
+	if err != nil || rule == nil {
+		return err
+	}
+	if err := h.store.PriceRule.ReplacePriceRule(c.Context(), c.Params("id"), rule); err != nil {
+		return ErrResourceNotFound("Price rule")
+	}
+	return c.JSON(rule)
+}
+
+// @Summary		Delete a price rule
+// @Description	Handle removing a rule from the price list, rents already made keep their price
+// @Tags			admin
+// @Produce		json
+// @Param			id	path	string	true	"price rule id"
+// @Router			/price-rules/:id [delete]
+func (h *PriceHandler) HandleDeletePriceRule(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := h.store.PriceRule.DeletePriceRule(c.Context(), id); err != nil {
+		return ErrResourceNotFound("Price rule")
+	}
+	return c.JSON(map[string]string{"deleted": id})
+}
+
+// ruleFromBody reads and validates a price rule. A nil rule means the
+// validation errors were already sent.
+func (h *PriceHandler) ruleFromBody(c *fiber.Ctx) (*types.PriceRule, error) {
+	var params types.PriceRuleParams
+	if err := c.BodyParser(&params); err != nil {
+		return nil, ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		return nil, c.Status(http.StatusBadRequest).JSON(errors)
+	}
+	if params.MovieID != "" {
+		if _, err := h.store.Movie.GetMovieByID(c.Context(), params.MovieID); err != nil {
+			return nil, ErrResourceNotFound("Movie")
+		}
+	}
+	return types.NewPriceRuleFromParams(params), nil
+}
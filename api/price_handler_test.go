@@ -0,0 +1,90 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/payment"
+	"github.com/tomekzakrzewski/go-movierental/pricing"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPricing(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		app          = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1        = app.Group("", JWTAuthentication(tdb.Store))
+		admin        = apiv1.Group("/admin", AdminAuth)
+		policy       = DefaultRentalPolicy()
+		priceHandler = NewPriceHandler(tdb.Store, policy)
+		movieHandler = NewMovieHandler(tdb.Store, policy, payment.NewFake("secret"))
+		adminUser    = fixtures.AddUser(tdb.Store, "admin", "admin", true)
+		tomek        = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		adminToken   = tdb.token(t, adminUser)
+		token        = tdb.token(t, tomek)
+		classic      = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		newRelease   = fixtures.AddMovie(tdb.Store, "Dune", []string{"Sci-Fi"}, 155, time.Now().Year())
+		_            = fixtures.AddCopy(tdb.Store, classic, types.FormatDVD)
+		_            = fixtures.AddCopy(tdb.Store, classic, types.Format4K)
+		_            = fixtures.AddCopy(tdb.Store, newRelease, types.FormatDVD)
+	)
+	canManagePricing := RequirePermission(types.PermManagePricing)
+	admin.Get("/price-rules", canManagePricing, priceHandler.HandleGetPriceRules)
+	admin.Post("/price-rules", canManagePricing, priceHandler.HandlePostPriceRule)
+	admin.Put("/price-rules/:id", canManagePricing, priceHandler.HandlePutPriceRule)
+	admin.Delete("/price-rules/:id", canManagePricing, priceHandler.HandleDeletePriceRule)
+	apiv1.Get("/movies/:id/quote", priceHandler.HandleGetQuote)
+	apiv1.Post("/movies/:id/rent", movieHandler.HandleRentMovie)
+
+	post := func(params types.PriceRuleParams) types.PriceRule {
+		t.Helper()
+		var rule types.PriceRule
+		json.NewDecoder(request(t, app, "POST", "/admin/price-rules", adminToken, params, 200).Body).Decode(&rule)
+		return rule
+	}
+
+	request(t, app, "POST", "/admin/price-rules", token, types.PriceRuleParams{Kind: types.PriceBase, Amount: 399}, 401)
+	request(t, app, "POST", "/admin/price-rules", adminToken, types.PriceRuleParams{Kind: types.PriceWeekend, Amount: 399}, 400)
+	request(t, app, "POST", "/admin/price-rules", adminToken, types.PriceRuleParams{Kind: types.PriceBase, MovieID: tomek.ID.Hex(), Amount: 399}, 404)
+	post(types.PriceRuleParams{Kind: types.PriceBase, Amount: 399})
+	fourK := post(types.PriceRuleParams{Kind: types.PriceBase, Format: types.Format4K, Amount: 599})
+	post(types.PriceRuleParams{Kind: types.PriceNewRelease, MaxAge: 1, Percent: 150})
+	post(types.PriceRuleParams{Kind: types.PriceDuration, Hours: 168, Percent: 300})
+
+	var quotes []pricing.Quote
+	json.NewDecoder(request(t, app, "GET", "/movies/"+classic.ID.Hex()+"/quote", token, nil, 200).Body).Decode(&quotes)
+	if len(quotes) != 2 || quotes[0].Format != types.FormatDVD || quotes[0].Amount != 399 || quotes[1].Format != types.Format4K || quotes[1].Amount != 599 {
+		t.Fatalf("expected a quote per available format but got %+v", quotes)
+	}
+	json.NewDecoder(request(t, app, "GET", "/movies/"+newRelease.ID.Hex()+"/quote?hours=168", token, nil, 200).Body).Decode(&quotes)
+	// 399 * 1.5 = 598.5 -> 599, * 3 = 1797
+	if len(quotes) != 1 || quotes[0].Amount != 1797 || quotes[0].Currency != "USD" || len(quotes[0].Adjustments) != 2 {
+		t.Fatalf("expected a new release premium and a week long rent but got %+v", quotes)
+	}
+	request(t, app, "GET", "/movies/"+newRelease.ID.Hex()+"/quote?hours=5", token, nil, 400)
+
+	var rent types.Rent
+	json.NewDecoder(request(t, app, "POST", "/movies/"+newRelease.ID.Hex()+"/rent?hours=168", token, nil, 200).Body).Decode(&rent)
+	if rent.Price != 1797 || rent.Currency != "USD" {
+		t.Fatalf("expected the rent to record the quoted price but got %d %s", rent.Price, rent.Currency)
+	}
+
+	var rules []types.PriceRule
+	json.NewDecoder(request(t, app, "GET", "/admin/price-rules", adminToken, nil, 200).Body).Decode(&rules)
+	if len(rules) != 4 || rules[1] != fourK {
+		t.Fatalf("expected 4 price rules, oldest first, but got %+v", rules)
+	}
+	request(t, app, "PUT", "/admin/price-rules/"+primitive.NewObjectID().Hex(), adminToken, types.PriceRuleParams{Kind: types.PriceBase, Amount: 100}, 404)
+	request(t, app, "PUT", "/admin/price-rules/"+fourK.ID.Hex(), adminToken, types.PriceRuleParams{Kind: types.PriceBase, Format: types.Format4K, Amount: 499}, 200)
+	json.NewDecoder(request(t, app, "GET", "/movies/"+classic.ID.Hex()+"/quote?format=4k", token, nil, 200).Body).Decode(&quotes)
+	if len(quotes) != 1 || quotes[0].Amount != 499 {
+		t.Fatalf("expected the replaced 4k price but got %+v", quotes)
+	}
+	request(t, app, "DELETE", "/admin/price-rules/"+fourK.ID.Hex(), adminToken, nil, 200)
+	request(t, app, "DELETE", "/admin/price-rules/"+fourK.ID.Hex(), adminToken, nil, 404)
+}
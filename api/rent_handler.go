@@ -1,29 +1,248 @@
 package api
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/payment"
+	"github.com/tomekzakrzewski/go-movierental/types"
 )
 
 type RentHandler struct {
-	store db.RentStore
+	store    *db.Store
+	rentals  RentalPolicy
+	payments payment.Provider
 }
 
-func NewRentHandler(store db.RentStore) *RentHandler {
+func NewRentHandler(store *db.Store, rentals RentalPolicy, payments payment.Provider) *RentHandler {
 	return &RentHandler{
-		store: store,
+		store:    store,
+		rentals:  rentals,
+		payments: payments,
+	}
+}
+
+// rentFilterFromQuery reads the optional ?state= query parameter shared by
+// the rent list endpoints.
+func rentFilterFromQuery(c *fiber.Ctx) (map[string]any, error) {
+	filter := map[string]any{}
+	if state := types.RentState(c.Query("state")); state != "" {
+		if !state.IsValid() {
+			return nil, NewError(http.StatusBadRequest, fmt.Sprintf("invalid rent state: %s", state))
+		}
+		filter["state"] = state
 	}
+	return filter, nil
 }
 
 // @Summary		Get all rents(user id, movie id, from, to)
-// @Description	Handle getting all rents made by users
+// @Description	Handle getting all rents made by users, optionally filtered by state
 // @Tags			admin
 // @Produce		json
+// @Param			state	query	string	false	"rent state"	Enums(pending, active, returned, overdue, cancelled)
+// @Param			limit	query	int		false	"page size, 20 by default and at most 100"
+// @Param			cursor	query	string	false	"nextCursor of the previous page"
 // @Router			/rents [get]
 func (h *RentHandler) HandleGetRents(c *fiber.Ctx) error {
-	rents, err := h.store.GetRents(c.Context())
+	filter, err := rentFilterFromQuery(c)
+	if err != nil {
+		return err
+	}
+	pag, err := paginationFromQuery(c)
+	if err != nil {
+		return err
+	}
+	page, err := h.store.Rent.GetRents(c.Context(), filter, pag)
+	if err != nil {
+		return listError(err, "Rents")
+	}
+	return c.JSON(newResourceResp(page))
+}
+
+// @Summary		Return a rented movie
+// @Description	Handle returning a rent, users can only return their own rents unless they can manage rents. A late return settles the late fee of the rent, which is paid from the renter's wallet when its balance covers it
+// @Tags			user
+// @Produce		json
+// @Router			/rents/:id/return [post]
+func (h *RentHandler) HandleReturnRent(c *fiber.Ctx) error {
+	id := c.Params("id")
+	rent, err := authorize(c, rentPolicy, h.store.Rent.GetRentByID, id)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	// settle the fee up to the return, the sweep may not have caught up yet
+	if fee := h.rentals.LateFees.Fee(rent.To, now); fee > rent.LateFee && rent.State.AccruesLateFees() {
+		if err := h.store.Rent.AccrueLateFee(c.Context(), id, fee); err != nil && !errors.Is(err, db.ErrInvalidTransition) {
+			return err
+		}
+	}
+	returned, err := h.store.Rent.UpdateRentState(c.Context(), id, types.RentReturned, now)
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidTransition) {
+			return NewError(http.StatusConflict, fmt.Sprintf("rent can't be returned, state: %s", rent.State))
+		}
+		return ErrResourceNotFound("Rent")
+	}
+	if !returned.CopyID.IsZero() {
+		if err := h.store.Copy.ReleaseCopy(c.Context(), returned.CopyID.Hex()); err != nil {
+			return err
+		}
+	}
+	if returned.LateFee > 0 {
+		if err := payLateFee(c.Context(), h.store, returned); err != nil {
+			return err
+		}
+	}
+	return c.JSON(returned)
+}
+
+// @Summary		Extend a rent
+// @Description	Handle extending an active rent by one of the rental durations of its movie or format, the first one by default. Its price is added to the rent
+// @Description	A rent can only be extended while another copy of its format is available, so renters waiting for one aren't held up
+// @Description	Paid extensions are charged to the payment method first, a declined payment returns 402. A payment the provider confirms later returns 202 and the rent is extended once the capture webhook arrives
+// @Tags			user
+// @Produce		json
+// @Param			hours			query	int		false	"extension in hours"
+// @Param			paymentMethod	query	string	false	"payment method token of the provider, or wallet to pay from the renter's wallet"
+// @Router			/rents/:id/extend [post]
+func (h *RentHandler) HandleExtendRent(c *fiber.Ctx) error {
+	id := c.Params("id")
+	rent, err := authorize(c, rentPolicy, h.store.Rent.GetRentByID, id)
 	if err != nil {
-		return ErrResourceNotFound("Rents")
+		return err
+	}
+	if rent.State != types.RentActive {
+		return NewError(http.StatusConflict, fmt.Sprintf("rent can't be extended, state: %s", rent.State))
+	}
+	if rent.Extensions >= h.rentals.MaxExtensions {
+		return NewError(http.StatusConflict, fmt.Sprintf("rent can be extended at most %d times", h.rentals.MaxExtensions))
+	}
+	movie, err := h.store.Movie.GetMovieByID(c.Context(), rent.MovieID.Hex())
+	if err != nil {
+		return ErrResourceNotFound("Movie")
+	}
+	hours, err := rentalHours(c.Query("hours"), h.rentals.HoursFor(movie, rent.Format))
+	if err != nil {
+		return err
+	}
+	// Keeping the copy longer is only fair when someone else could still
+	// rent the movie in the meantime.
+	filter := map[string]any{"rented": false, "retired": false}
+	if rent.Format != "" {
+		filter["format"] = rent.Format
+	}
+	available, err := h.store.Copy.GetCopies(c.Context(), rent.MovieID.Hex(), filter)
+	if err != nil {
+		return err
+	}
+	if len(available) == 0 {
+		return NewError(http.StatusConflict, "rent can't be extended, no other copies available")
+	}
+	payments, err := h.store.Payment.GetPaymentsByRent(c.Context(), id)
+	if err != nil {
+		return err
+	}
+	for _, p := range payments {
+		if p.Purpose == types.PaymentForExtension && (p.Status == types.PaymentAuthorized || p.Status == types.PaymentPending) {
+			return NewError(http.StatusConflict, "rent has an extension waiting for its payment")
+		}
+	}
+	// the extension is priced like a rent starting when the current one ends
+	q, err := quote(c.Context(), h.store, h.rentals, movie, rent.Format, hours, rent.To)
+	if err != nil {
+		return err
+	}
+	var paid *types.Payment
+	if q.Amount > 0 {
+		if paid, err = charge(c.Context(), h.store, h.payments, rent, q.Amount, types.PaymentForExtension, hours, c.Query("paymentMethod")); err != nil {
+			return declined(err)
+		}
+		switch paid.Status {
+		case types.PaymentPending:
+			// the webhook of the capture extends the rent
+			return c.Status(http.StatusAccepted).JSON(rent)
+		case types.PaymentFailed:
+			return declined(payment.ErrDeclined)
+		}
+	}
+	extended, err := h.store.Rent.ExtendRent(c.Context(), id, rent.To, rent.To.Add(time.Duration(hours)*time.Hour), q.Amount)
+	if err != nil {
+		// the rent wasn't extended, so neither is the renter charged
+		if paid != nil && paid.Status == types.PaymentCaptured {
+			if _, err := refund(c.Context(), h.store, h.payments, paid); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, db.ErrInvalidTransition) {
+			return NewError(http.StatusConflict, "rent changed while extending, try again")
+		}
+		return ErrResourceNotFound("Rent")
+	}
+	return c.JSON(extended)
+}
+
+// BalanceResponse is what a user owes.
+type BalanceResponse struct {
+	LateFees int64  `json:"lateFees"`
+	Currency string `json:"currency"`
+	CanRent  bool   `json:"canRent"`
+}
+
+// @Summary		Get my balance
+// @Description	Handle getting the late fees the signed in user owes, in minor units. Renting is blocked while they are above the configured limit
+// @Tags			user
+// @Produce		json
+// @Router			/me/balance [get]
+func (h *RentHandler) HandleGetBalance(c *fiber.Ctx) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	owed, err := h.store.Rent.GetLateFees(c.Context(), user.ID.Hex())
+	if err != nil {
+		return err
+	}
+	return c.JSON(BalanceResponse{
+		LateFees: owed,
+		Currency: h.rentals.Currency,
+		CanRent:  owed <= h.rentals.LateFees.MaxOwed,
+	})
+}
+
+// @Summary		Settle a late fee
+// @Description	Handle marking the late fee of a returned rent as settled, when the renter paid it at the counter or staff waive it. It no longer counts towards what the renter owes
+// @Tags			admin
+// @Produce		json
+// @Param			id	path	string	true	"rent id"
+// @Router			/admin/rents/:id/late-fee/settle [post]
+func (h *RentHandler) HandleSettleLateFee(c *fiber.Ctx) error {
+	actor, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	id := c.Params("id")
+	rent, err := h.store.Rent.GetRentByID(c.Context(), id)
+	if err != nil {
+		return ErrResourceNotFound("Rent")
+	}
+	if rent.LateFee == 0 {
+		return NewError(http.StatusConflict, "rent has no late fee")
+	}
+	if err := h.store.Rent.SettleLateFee(c.Context(), id); err != nil {
+		if errors.Is(err, db.ErrInvalidTransition) {
+			return NewError(http.StatusConflict, fmt.Sprintf("late fee can't be settled, state: %s, paid: %t", rent.State, rent.LateFeePaid))
+		}
+		return err
+	}
+	rent.LateFeePaid = true
+	audit := types.NewAuditEntry(types.AuditLateFeeSettle, actor.ID, "user:"+rent.UserID.Hex(), fmt.Sprintf("%s %d", rent.ID.Hex(), rent.LateFee))
+	if _, err := h.store.Audit.InsertAuditEntry(c.Context(), audit); err != nil {
+		return err
 	}
-	return c.JSON(rents)
+	return c.JSON(rent)
 }
@@ -1,12 +1,15 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/payment"
 	"github.com/tomekzakrzewski/go-movierental/types"
 )
 
@@ -14,16 +17,17 @@ func TestGetRents(t *testing.T) {
 	tdb := setup(t)
 	defer tdb.teardown(t)
 	var (
-		rentHandler  = NewRentHandler(tdb.Rent)
-		movieHandler = NewMovieHandler(tdb.Store)
+		rentHandler  = NewRentHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
+		movieHandler = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
 		movieAdded   = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		_            = fixtures.AddCopy(tdb.Store, movieAdded, types.FormatDVD)
 		userAdded    = fixtures.AddUser(tdb.Store, "tomek", "test", false)
 		app          = fiber.New()
-		apiv1        = app.Group("", JWTAuthentication(tdb.User))
+		apiv1        = app.Group("", JWTAuthentication(tdb.Store))
 	)
 	apiv1.Get("/", rentHandler.HandleGetRents)
 	apiv1.Put("/:id/rent", movieHandler.HandleRentMovie)
-	token := CreateTokenFromUser(userAdded)
+	token := tdb.token(t, userAdded)
 	req := httptest.NewRequest("PUT", "/"+movieAdded.ID.Hex()+"/rent", nil)
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("Api-Token", token)
@@ -37,10 +41,12 @@ func TestGetRents(t *testing.T) {
 	req.Header.Add("Api-Token", token)
 	resp, err := app.Test(req)
 
-	var rents []types.Rent
-	json.NewDecoder(resp.Body).Decode(&rents)
-	if len(rents) != 1 {
-		t.Errorf("expected 1 rent but got %d", len(rents))
+	var res struct {
+		Data []types.Rent `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&res)
+	if len(res.Data) != 1 {
+		t.Errorf("expected 1 rent but got %d", len(res.Data))
 	}
 	if err != nil {
 		t.Error(err)
@@ -49,3 +55,212 @@ func TestGetRents(t *testing.T) {
 		t.Errorf("expected status code 200 but got %d", resp.StatusCode)
 	}
 }
+
+func TestReturnRent(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		rentHandler  = NewRentHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
+		movieHandler = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
+		movieAdded   = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		_            = fixtures.AddCopy(tdb.Store, movieAdded, types.FormatDVD)
+		userAdded    = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		otherUser    = fixtures.AddUser(tdb.Store, "zuzia", "test", false)
+		app          = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1        = app.Group("", JWTAuthentication(tdb.Store))
+	)
+	apiv1.Put("/:id/rent", movieHandler.HandleRentMovie)
+	apiv1.Post("/rents/:id/return", rentHandler.HandleReturnRent)
+	token := tdb.token(t, userAdded)
+	req := httptest.NewRequest("PUT", "/"+movieAdded.ID.Hex()+"/rent", nil)
+	req.Header.Add("Api-Token", token)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rent types.Rent
+	json.NewDecoder(resp.Body).Decode(&rent)
+
+	req = httptest.NewRequest("POST", "/rents/"+rent.ID.Hex()+"/return", nil)
+	req.Header.Add("Api-Token", tdb.token(t, otherUser))
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 404 {
+		t.Errorf("expected status code 404 for another user's rent but got %d", resp.StatusCode)
+	}
+
+	req = httptest.NewRequest("POST", "/rents/"+rent.ID.Hex()+"/return", nil)
+	req.Header.Add("Api-Token", token)
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 200 {
+		t.Errorf("expected status code 200 but got %d", resp.StatusCode)
+	}
+	var returned types.Rent
+	json.NewDecoder(resp.Body).Decode(&returned)
+	if returned.State != types.RentReturned {
+		t.Errorf("expected rent state %s but got %s", types.RentReturned, returned.State)
+	}
+	if returned.ReturnedAt == nil {
+		t.Errorf("expected returnedAt to be set")
+	}
+
+	req = httptest.NewRequest("POST", "/rents/"+rent.ID.Hex()+"/return", nil)
+	req.Header.Add("Api-Token", token)
+	resp, err = app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != 409 {
+		t.Errorf("expected status code 409 for a returned rent but got %d", resp.StatusCode)
+	}
+}
+
+func TestExtendRent(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		policy       = RentalPolicy{Hours: map[types.CopyFormat][]int{types.FormatDVD: {48, 24}}, DefaultHours: []int{24}, MaxExtensions: 1}
+		rentHandler  = NewRentHandler(tdb.Store, policy, payment.NewFake("secret"))
+		movieHandler = NewMovieHandler(tdb.Store, policy, payment.NewFake("secret"))
+		movieAdded   = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		_            = fixtures.AddCopy(tdb.Store, movieAdded, types.FormatDVD)
+		userAdded    = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		otherUser    = fixtures.AddUser(tdb.Store, "zuzia", "test", false)
+		app          = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1        = app.Group("", JWTAuthentication(tdb.Store))
+	)
+	apiv1.Put("/:id/rent", movieHandler.HandleRentMovie)
+	apiv1.Post("/rents/:id/extend", rentHandler.HandleExtendRent)
+	token := tdb.token(t, userAdded)
+
+	request(t, app, "PUT", "/"+movieAdded.ID.Hex()+"/rent?hours=168", token, nil, 400)
+	var rent types.Rent
+	json.NewDecoder(request(t, app, "PUT", "/"+movieAdded.ID.Hex()+"/rent", token, nil, 200).Body).Decode(&rent)
+	if d := rent.To.Sub(rent.From); d != 48*time.Hour {
+		t.Fatalf("expected the first duration of the format, 48h, but got %s", d)
+	}
+
+	// the only copy is rented, extending would keep everyone else waiting
+	request(t, app, "POST", "/rents/"+rent.ID.Hex()+"/extend", token, nil, 409)
+	fixtures.AddCopy(tdb.Store, movieAdded, types.FormatDVD)
+	request(t, app, "POST", "/rents/"+rent.ID.Hex()+"/extend?hours=24", tdb.token(t, otherUser), nil, 404)
+	var extended types.Rent
+	json.NewDecoder(request(t, app, "POST", "/rents/"+rent.ID.Hex()+"/extend?hours=24", token, nil, 200).Body).Decode(&extended)
+	if d := extended.To.Sub(rent.To); d != 24*time.Hour || extended.Extensions != 1 {
+		t.Fatalf("expected the rent to be extended by 24h once but got %s, %d", d, extended.Extensions)
+	}
+	request(t, app, "POST", "/rents/"+rent.ID.Hex()+"/extend?hours=24", token, nil, 409)
+}
+
+func TestLateFees(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		policy       = DefaultRentalPolicy()
+		rentHandler  = NewRentHandler(tdb.Store, policy, payment.NewFake("secret"))
+		movieHandler = NewMovieHandler(tdb.Store, policy, payment.NewFake("secret"))
+		sweeper      = NewOverdueSweeper(tdb.Store, policy.LateFees)
+		matrix       = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		titanic      = fixtures.AddMovie(tdb.Store, "Titanic", []string{"Drama"}, 195, 1997)
+		_            = fixtures.AddCopy(tdb.Store, titanic, types.FormatDVD)
+		userAdded    = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		adminUser    = fixtures.AddUser(tdb.Store, "admin", "admin", true)
+		app          = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1        = app.Group("", JWTAuthentication(tdb.Store))
+		admin        = apiv1.Group("/admin", AdminAuth)
+		now          = time.Now()
+	)
+	apiv1.Put("/:id/rent", movieHandler.HandleRentMovie)
+	apiv1.Post("/rents/:id/return", rentHandler.HandleReturnRent)
+	apiv1.Get("/me/balance", rentHandler.HandleGetBalance)
+	admin.Post("/rents/:id/late-fee/settle", RequirePermission(types.PermManageRents), rentHandler.HandleSettleLateFee)
+	token := tdb.token(t, userAdded)
+	balance := func() BalanceResponse {
+		var b BalanceResponse
+		json.NewDecoder(request(t, app, "GET", "/me/balance", token, nil, 200).Body).Decode(&b)
+		return b
+	}
+
+	for _, tt := range []struct {
+		late time.Duration
+		fee  int64
+	}{
+		{30 * time.Minute, 0},
+		{2 * time.Hour, 100},
+		{49 * time.Hour, 300},
+		{30 * 24 * time.Hour, 2000},
+	} {
+		if fee := policy.LateFees.Fee(now, now.Add(tt.late)); fee != tt.fee {
+			t.Fatalf("expected a fee of %d after %s but got %d", tt.fee, tt.late, fee)
+		}
+	}
+
+	// due two and a half days ago, so three started days late
+	rent, err := tdb.Rent.InsertRent(context.Background(), &types.Rent{
+		UserID:  userAdded.ID,
+		MovieID: matrix.ID,
+		From:    now.Add(-84 * time.Hour),
+		To:      now.Add(-60 * time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := balance(); b.LateFees != 0 || !b.CanRent {
+		t.Fatalf("expected nothing owed before the sweep but got %+v", b)
+	}
+	if err := sweeper.Sweep(context.Background(), now); err != nil {
+		t.Fatal(err)
+	}
+	swept, err := tdb.Rent.GetRentByID(context.Background(), rent.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if swept.State != types.RentOverdue || swept.LateFee != 300 {
+		t.Fatalf("expected an overdue rent owing 300 but got %s owing %d", swept.State, swept.LateFee)
+	}
+	if b := balance(); b.LateFees != 300 || b.Currency != "USD" || b.CanRent {
+		t.Fatalf("expected 300 USD owed blocking rentals but got %+v", b)
+	}
+	request(t, app, "PUT", "/"+titanic.ID.Hex()+"/rent", token, nil, 402)
+
+	var returned types.Rent
+	json.NewDecoder(request(t, app, "POST", "/rents/"+rent.ID.Hex()+"/return", token, nil, 200).Body).Decode(&returned)
+	if returned.State != types.RentReturned || !returned.Late || returned.LateFee != 300 {
+		t.Fatalf("expected a late return owing 300 but got %+v", returned)
+	}
+	// a later sweep leaves the final fee alone
+	if err := sweeper.Sweep(context.Background(), now.Add(48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if b := balance(); b.LateFees != 300 {
+		t.Fatalf("expected the fee to stay at 300 after the return but got %d", b.LateFees)
+	}
+	request(t, app, "PUT", "/"+titanic.ID.Hex()+"/rent", token, nil, 402)
+
+	// staff settle the fee once it's paid at the counter, which unblocks renting
+	settle := "/admin/rents/" + rent.ID.Hex() + "/late-fee/settle"
+	adminToken := tdb.token(t, adminUser)
+	request(t, app, "POST", settle, token, nil, 401)
+	var settled types.Rent
+	json.NewDecoder(request(t, app, "POST", settle, adminToken, nil, 200).Body).Decode(&settled)
+	if !settled.LateFeePaid || settled.LateFee != 300 {
+		t.Fatalf("expected a settled fee of 300 but got %+v", settled)
+	}
+	request(t, app, "POST", settle, adminToken, nil, 409)
+	if b := balance(); b.LateFees != 0 || !b.CanRent {
+		t.Fatalf("expected nothing owed after settling but got %+v", b)
+	}
+	request(t, app, "PUT", "/"+titanic.ID.Hex()+"/rent", token, nil, 200)
+	audit, err := tdb.Store.Audit.GetAuditEntries(context.Background(), map[string]any{"action": types.AuditLateFeeSettle}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audit.Total != 1 || audit.Items[0].ActorID != adminUser.ID {
+		t.Fatalf("expected the settlement to be audited but got %+v", audit.Items)
+	}
+}
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+// RentalPolicy configures the rental durations renters pick from. A movie
+// with its own RentalHours overrides the durations of its formats, formats
+// without an entry in Hours use DefaultHours. The first duration of a set is
+// the one used when the renter doesn't pick. A rent can be extended at most
+// MaxExtensions times. Prices and late fees are charged in Currency, an
+// ISO 4217 code.
+type RentalPolicy struct {
+	Hours         map[types.CopyFormat][]int
+	DefaultHours  []int
+	MaxExtensions int
+	Currency      string
+	LateFees      LateFeePolicy
+}
+
+func DefaultRentalPolicy() RentalPolicy {
+	return RentalPolicy{
+		Hours:         map[types.CopyFormat][]int{},
+		DefaultHours:  []int{24, 48, 168},
+		MaxExtensions: 2,
+		Currency:      "USD",
+		LateFees:      DefaultLateFeePolicy(),
+	}
+}
+
+var copyFormats = []types.CopyFormat{types.FormatDVD, types.FormatBluRay, types.Format4K, types.FormatDigital}
+
+// RentalPolicyFromEnv overrides the defaults with RENTAL_HOURS, the
+// durations per format RENTAL_HOURS_DVD, RENTAL_HOURS_BLURAY,
+// RENTAL_HOURS_4K and RENTAL_HOURS_DIGITAL, RENTAL_MAX_EXTENSIONS,
+// RENTAL_CURRENCY and the LATE_FEE variables of the late fee policy.
+// Durations are comma separated hours, e.g. "24,48,168".
+func RentalPolicyFromEnv() (RentalPolicy, error) {
+	policy := DefaultRentalPolicy()
+	lateFees, err := lateFeePolicyFromEnv()
+	if err != nil {
+		return policy, err
+	}
+	policy.LateFees = lateFees
+	if v := os.Getenv("RENTAL_HOURS"); v != "" {
+		hours, err := parseRentalHours("RENTAL_HOURS", v)
+		if err != nil {
+			return policy, err
+		}
+		policy.DefaultHours = hours
+	}
+	for _, format := range copyFormats {
+		name := "RENTAL_HOURS_" + strings.ToUpper(string(format))
+		if v := os.Getenv(name); v != "" {
+			hours, err := parseRentalHours(name, v)
+			if err != nil {
+				return policy, err
+			}
+			policy.Hours[format] = hours
+		}
+	}
+	if v := os.Getenv("RENTAL_MAX_EXTENSIONS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return policy, fmt.Errorf("invalid RENTAL_MAX_EXTENSIONS %q", v)
+		}
+		policy.MaxExtensions = n
+	}
+	if v := os.Getenv("RENTAL_CURRENCY"); v != "" {
+		if len(v) != 3 || strings.Trim(v, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+			return policy, fmt.Errorf("invalid RENTAL_CURRENCY %q", v)
+		}
+		policy.Currency = v
+	}
+	return policy, nil
+}
+
+func parseRentalHours(name, v string) ([]int, error) {
+	var hours []int
+	for _, s := range strings.Split(v, ",") {
+		h, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", name, v)
+		}
+		hours = append(hours, h)
+	}
+	if !types.ValidRentalHours(hours) {
+		return nil, fmt.Errorf("invalid %s %q", name, v)
+	}
+	return hours, nil
+}
+
+// HoursFor returns the durations a copy of the movie in format can be
+// rented for.
+func (p RentalPolicy) HoursFor(movie *types.Movie, format types.CopyFormat) []int {
+	if len(movie.RentalHours) > 0 {
+		return movie.RentalHours
+	}
+	if hours := p.Hours[format]; len(hours) > 0 {
+		return hours
+	}
+	return p.DefaultHours
+}
+
+// rentalHours reads the ?hours= query parameter against the allowed
+// durations, returning the first one when it is missing.
+func rentalHours(raw string, allowed []int) (int, error) {
+	if raw == "" {
+		return allowed[0], nil
+	}
+	hours, err := strconv.Atoi(raw)
+	if err != nil || !slices.Contains(allowed, hours) {
+		return 0, NewError(http.StatusBadRequest, fmt.Sprintf("invalid hours %q, expected one of %v", raw, allowed))
+	}
+	return hours, nil
+}
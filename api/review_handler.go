@@ -0,0 +1,175 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+type ReviewHandler struct {
+	store *db.Store
+}
+
+func NewReviewHandler(store *db.Store) *ReviewHandler {
+	return &ReviewHandler{
+		store: store,
+	}
+}
+
+// @Summary		Review a movie
+// @Description	Handle posting a text review, it is listed after an admin approves it
+// @Tags			user
+// @Accept			json
+// @Produce		json
+// @Router			/movies/:id/reviews [post]
+func (h *ReviewHandler) HandlePostReview(c *fiber.Ctx) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	var params types.ReviewParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+	movie, err := h.store.Movie.GetMovieByID(c.Context(), c.Params("id"))
+	if err != nil {
+		return ErrResourceNotFound("Movie")
+	}
+	review, err := h.store.Review.InsertReview(c.Context(), types.NewReviewFromParams(user.ID, movie.ID, params))
+	if err != nil {
+		return err
+	}
+	return c.JSON(review)
+}
+
+// @Summary		Get reviews of a movie
+// @Description	Handle listing the approved reviews of a movie, newest first
+// @Tags			user
+// @Produce		json
+// @Param			limit	query	int		false	"page size, 20 by default and at most 100"
+// @Param			cursor	query	string	false	"nextCursor of the previous page"
+// @Router			/movies/:id/reviews [get]
+func (h *ReviewHandler) HandleGetMovieReviews(c *fiber.Ctx) error {
+	pag, err := paginationFromQuery(c)
+	if err != nil {
+		return err
+	}
+	movie, err := h.store.Movie.GetMovieByID(c.Context(), c.Params("id"))
+	if err != nil {
+		return ErrResourceNotFound("Movie")
+	}
+	filter := map[string]any{
+		"movieID": movie.ID,
+		"status":  types.ReviewApproved,
+	}
+	page, err := h.store.Review.GetReviews(c.Context(), filter, pag)
+	if err != nil {
+		return listError(err, "Reviews")
+	}
+	return c.JSON(newResourceResp(page))
+}
+
+// @Summary		Edit a review
+// @Description	Handle editing the text of the user's own review, it goes back to the moderation queue
+// @Tags			user
+// @Accept			json
+// @Produce		json
+// @Router			/reviews/:id [put]
+func (h *ReviewHandler) HandlePutReview(c *fiber.Ctx) error {
+	review, err := authorize(c, reviewPolicy, h.store.Review.GetReviewByID, c.Params("id"))
+	if err != nil {
+		return err
+	}
+	var params types.ReviewParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+	now := time.Now()
+	if err := h.store.Review.UpdateReviewBody(c.Context(), review.ID.Hex(), params.Body, now); err != nil {
+		return ErrResourceNotFound("Review")
+	}
+	review.Body = params.Body
+	review.Status = types.ReviewPending
+	review.UpdatedAt = now
+	return c.JSON(review)
+}
+
+// @Summary		Delete a review
+// @Description	Handle deleting the user's own review
+// @Tags			user
+// @Produce		json
+// @Router			/reviews/:id [delete]
+func (h *ReviewHandler) HandleDeleteReview(c *fiber.Ctx) error {
+	review, err := authorize(c, reviewPolicy, h.store.Review.GetReviewByID, c.Params("id"))
+	if err != nil {
+		return err
+	}
+	if err := h.store.Review.DeleteReview(c.Context(), review.ID.Hex()); err != nil {
+		return ErrResourceNotFound("Review")
+	}
+	return c.JSON(map[string]string{"deleted": review.ID.Hex()})
+}
+
+// @Summary		Get the moderation queue
+// @Description	Handle listing reviews by status, pending reviews by default
+// @Tags			admin
+// @Produce		json
+// @Param			status	query	string	false	"review status"	Enums(pending, approved, hidden)
+// @Param			limit	query	int		false	"page size, 20 by default and at most 100"
+// @Param			cursor	query	string	false	"nextCursor of the previous page"
+// @Router			/reviews [get]
+func (h *ReviewHandler) HandleGetReviews(c *fiber.Ctx) error {
+	status := types.ReviewStatus(c.Query("status", string(types.ReviewPending)))
+	if !status.IsValid() {
+		return NewError(http.StatusBadRequest, fmt.Sprintf("invalid review status: %s", status))
+	}
+	pag, err := paginationFromQuery(c)
+	if err != nil {
+		return err
+	}
+	page, err := h.store.Review.GetReviews(c.Context(), map[string]any{"status": status}, pag)
+	if err != nil {
+		return listError(err, "Reviews")
+	}
+	return c.JSON(newResourceResp(page))
+}
+
+// @Summary		Approve a review
+// @Description	Handle approving a review so it is listed on the movie
+// @Tags			admin
+// @Produce		json
+// @Router			/reviews/:id/approve [post]
+func (h *ReviewHandler) HandleApproveReview(c *fiber.Ctx) error {
+	return h.moderate(c, types.ReviewApproved)
+}
+
+// @Summary		Hide a review
+// @Description	Handle hiding a review from the movie listing
+// @Tags			admin
+// @Produce		json
+// @Router			/reviews/:id/hide [post]
+func (h *ReviewHandler) HandleHideReview(c *fiber.Ctx) error {
+	return h.moderate(c, types.ReviewHidden)
+}
+
+func (h *ReviewHandler) moderate(c *fiber.Ctx, status types.ReviewStatus) error {
+	id := c.Params("id")
+	if err := h.store.Review.UpdateReviewStatus(c.Context(), id, status, time.Now()); err != nil {
+		return ErrResourceNotFound("Review")
+	}
+	review, err := h.store.Review.GetReviewByID(c.Context(), id)
+	if err != nil {
+		return ErrResourceNotFound("Review")
+	}
+	return c.JSON(review)
+}
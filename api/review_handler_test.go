@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+func TestReviewModeration(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		app           = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1         = app.Group("", JWTAuthentication(tdb.Store))
+		admin         = apiv1.Group("/admin", AdminAuth)
+		reviewHandler = NewReviewHandler(tdb.Store)
+		movieAdded    = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		tomek         = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		zuzia         = fixtures.AddUser(tdb.Store, "zuzia", "test", false)
+		adminUser     = fixtures.AddUser(tdb.Store, "admin", "admin", true)
+		tomekToken    = tdb.token(t, tomek)
+		zuziaToken    = tdb.token(t, zuzia)
+		adminToken    = tdb.token(t, adminUser)
+	)
+	apiv1.Post("/movies/:id/reviews", reviewHandler.HandlePostReview)
+	apiv1.Get("/movies/:id/reviews", reviewHandler.HandleGetMovieReviews)
+	apiv1.Put("/reviews/:id", reviewHandler.HandlePutReview)
+	apiv1.Delete("/reviews/:id", reviewHandler.HandleDeleteReview)
+	admin.Get("/reviews", reviewHandler.HandleGetReviews)
+	admin.Post("/reviews/:id/approve", reviewHandler.HandleApproveReview)
+	admin.Post("/reviews/:id/hide", reviewHandler.HandleHideReview)
+
+	listed := func() []types.Review {
+		t.Helper()
+		var res struct {
+			Data []types.Review `json:"data"`
+		}
+		json.NewDecoder(request(t, app, "GET", "/movies/"+movieAdded.ID.Hex()+"/reviews", zuziaToken, nil, 200).Body).Decode(&res)
+		return res.Data
+	}
+
+	request(t, app, "POST", "/movies/"+movieAdded.ID.Hex()+"/reviews", tomekToken, types.ReviewParams{Body: "ok"}, 400)
+	var review types.Review
+	json.NewDecoder(request(t, app, "POST", "/movies/"+movieAdded.ID.Hex()+"/reviews", tomekToken, types.ReviewParams{Body: "great movie"}, 200).Body).Decode(&review)
+	if review.Status != types.ReviewPending {
+		t.Fatalf("expected a pending review but got %s", review.Status)
+	}
+	if reviews := listed(); len(reviews) != 0 {
+		t.Fatalf("expected no listed reviews before approval but got %d", len(reviews))
+	}
+
+	request(t, app, "GET", "/admin/reviews", tomekToken, nil, 401)
+	var queue struct {
+		Data []types.Review `json:"data"`
+	}
+	json.NewDecoder(request(t, app, "GET", "/admin/reviews", adminToken, nil, 200).Body).Decode(&queue)
+	if len(queue.Data) != 1 || queue.Data[0].ID != review.ID {
+		t.Fatalf("expected review %s in the moderation queue but got %+v", review.ID, queue.Data)
+	}
+	request(t, app, "POST", "/admin/reviews/"+review.ID.Hex()+"/approve", adminToken, nil, 200)
+	if reviews := listed(); len(reviews) != 1 || reviews[0].Body != "great movie" {
+		t.Fatalf("expected the approved review to be listed but got %+v", reviews)
+	}
+
+	request(t, app, "PUT", "/reviews/"+review.ID.Hex(), zuziaToken, types.ReviewParams{Body: "not my review"}, 404)
+	request(t, app, "PUT", "/reviews/"+review.ID.Hex(), tomekToken, types.ReviewParams{Body: "still great"}, 200)
+	if reviews := listed(); len(reviews) != 0 {
+		t.Fatalf("expected the edited review to wait for moderation but got %+v", reviews)
+	}
+	request(t, app, "POST", "/admin/reviews/"+review.ID.Hex()+"/hide", adminToken, nil, 200)
+	if reviews := listed(); len(reviews) != 0 {
+		t.Fatalf("expected the hidden review not to be listed but got %+v", reviews)
+	}
+
+	request(t, app, "DELETE", "/reviews/"+review.ID.Hex(), zuziaToken, nil, 404)
+	request(t, app, "DELETE", "/reviews/"+review.ID.Hex(), tomekToken, nil, 200)
+	request(t, app, "POST", "/admin/reviews/"+review.ID.Hex()+"/approve", adminToken, nil, 404)
+}
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+type RoleHandler struct {
+	store db.UserStore
+}
+
+func NewRoleHandler(store db.UserStore) *RoleHandler {
+	return &RoleHandler{
+		store: store,
+	}
+}
+
+// @Summary		Get roles
+// @Description	Handle listing every role with its permissions
+// @Tags			admin
+// @Produce		json
+// @Router			/roles [get]
+func (h *RoleHandler) HandleGetRoles(c *fiber.Ctx) error {
+	return c.JSON(types.RolePermissions)
+}
+
+// @Summary		Set user roles
+// @Description	Handle replacing the roles of a user, admins can't take the permission to assign roles away from themselves
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			id		path	string					true	"user id"
+// @Param			roles	body	types.UpdateRolesParams	true	"roles"
+// @Router			/users/:id/roles [put]
+func (h *RoleHandler) HandlePutUserRoles(c *fiber.Ctx) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	var params types.UpdateRolesParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+	id := c.Params("id")
+	updated := &types.User{Roles: params.Roles}
+	if id == user.ID.Hex() && !updated.Can(types.PermAssignRoles) {
+		return NewError(http.StatusConflict, "can't remove your own permission to assign roles")
+	}
+	roles := slices.Clone(params.Roles)
+	slices.Sort(roles)
+	roles = slices.Compact(roles)
+	if err := h.store.UpdateUserRoles(c.Context(), id, roles); err != nil {
+		return ErrResourceNotFound("User")
+	}
+	target, err := h.store.GetUserByID(c.Context(), id)
+	if err != nil {
+		return ErrResourceNotFound("User")
+	}
+	return c.JSON(target)
+}
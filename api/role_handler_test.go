@@ -0,0 +1,49 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/mailer"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+func TestRolePermissions(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		app         = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1       = app.Group("", JWTAuthentication(tdb.Store))
+		admin       = apiv1.Group("/admin", AdminAuth)
+		userHandler = NewUserHandler(tdb.Store, mailer.NewLogMailer(io.Discard))
+		roleHandler = NewRoleHandler(tdb.User)
+		tomek       = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		zuzia       = fixtures.AddUser(tdb.Store, "zuzia", "test", false)
+		adminUser   = fixtures.AddUser(tdb.Store, "admin", "admin", true)
+	)
+	admin.Get("/users", RequirePermission(types.PermReadUsers), userHandler.HandleGetUsers)
+	admin.Delete("/users/:id", RequirePermission(types.PermManageUsers), userHandler.HandleDeleteUser)
+	admin.Put("/users/:id/roles", RequirePermission(types.PermAssignRoles), roleHandler.HandlePutUserRoles)
+
+	rolesPath := "/admin/users/" + tomek.ID.Hex() + "/roles"
+
+	request(t, app, "GET", "/admin/users", tdb.token(t, tomek), nil, 401)
+	request(t, app, "PUT", rolesPath, tdb.token(t, tomek), types.UpdateRolesParams{Roles: []types.Role{types.RoleAdmin}}, 401)
+	request(t, app, "PUT", rolesPath, tdb.token(t, adminUser), types.UpdateRolesParams{Roles: []types.Role{"owner"}}, 400)
+
+	clerk := types.UpdateRolesParams{Roles: []types.Role{types.RoleClerk, types.RoleCustomer, types.RoleClerk}}
+	var updated types.User
+	json.NewDecoder(request(t, app, "PUT", rolesPath, tdb.token(t, adminUser), clerk, 200).Body).Decode(&updated)
+	if len(updated.Roles) != 2 || !updated.Can(types.PermManageRents) {
+		t.Fatalf("expected the clerk and customer roles but got %v", updated.Roles)
+	}
+	request(t, app, "GET", "/admin/users", tdb.token(t, tomek), nil, 200)
+	request(t, app, "DELETE", "/admin/users/"+zuzia.ID.Hex(), tdb.token(t, tomek), nil, 403)
+	request(t, app, "PUT", "/admin/users/"+zuzia.ID.Hex()+"/roles", tdb.token(t, tomek), clerk, 403)
+
+	request(t, app, "PUT", "/admin/users/"+adminUser.ID.Hex()+"/roles", tdb.token(t, adminUser), clerk, 409)
+	request(t, app, "DELETE", "/admin/users/"+zuzia.ID.Hex(), tdb.token(t, adminUser), nil, 200)
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/tomekzakrzewski/go-movierental/keys"
+)
+
+// TokenClaims are the claims of an access token. The subject is the id of
+// the user and SessionID the session the token belongs to.
+type TokenClaims struct {
+	SessionID string `json:"sid"`
+	Email     string `json:"email"`
+	jwt.RegisteredClaims
+}
+
+// tokenKeys signs and verifies access tokens. Until SetTokenKeys is called
+// it holds a key generated at startup, which is enough for tests.
+var tokenKeys = mustGenerateKeys()
+
+func mustGenerateKeys() *keys.Set {
+	set, err := keys.Generate()
+	if err != nil {
+		panic(err)
+	}
+	return set
+}
+
+// SetTokenKeys replaces the keys access tokens are signed and verified
+// with. It is meant to be called once before serving requests.
+func SetTokenKeys(set *keys.Set) {
+	tokenKeys = set
+}
+
+// @Summary		Get the token verification keys
+// @Description	Handle publishing the public keys access tokens are signed with as a JWK set. Tokens name their key in the kid header, keys being rotated out stay listed until their tokens expire
+// @Tags			authentication
+// @Produce		json
+// @Router			/.well-known/jwks.json [get]
+func HandleGetJWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(tokenKeys.JWKS())
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/totp"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+const defaultTOTPIssuer = "Movie Rental"
+
+var errInvalidCode = errors.New("invalid code")
+
+// verifySecondFactor accepts a code of the confirmed authenticator of the
+// user or one of its recovery codes. Either is accepted only once. It
+// returns errInvalidCode for anything else.
+func verifySecondFactor(ctx context.Context, store db.TOTPStore, user *types.User, code, recoveryCode string, now time.Time) error {
+	userID := user.ID.Hex()
+	enrolment, err := store.GetTOTP(ctx, userID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return errInvalidCode
+		}
+		return err
+	}
+	if !enrolment.Confirmed {
+		return errInvalidCode
+	}
+	if recoveryCode != "" {
+		err = store.UseRecoveryCode(ctx, userID, types.HashRecoveryCode(recoveryCode))
+	} else {
+		step, ok := totp.Validate(enrolment.Secret, code, now)
+		if !ok {
+			return errInvalidCode
+		}
+		err = store.UseTOTPStep(ctx, userID, step)
+	}
+	if errors.Is(err, db.ErrNotFound) {
+		return errInvalidCode
+	}
+	return err
+}
+
+type TwoFactorHandler struct {
+	store  *db.Store
+	issuer string
+}
+
+// NewTwoFactorHandler names the accounts issuer in authenticator apps,
+// "Movie Rental" when it is empty.
+func NewTwoFactorHandler(store *db.Store, issuer string) *TwoFactorHandler {
+	if issuer == "" {
+		issuer = defaultTOTPIssuer
+	}
+	return &TwoFactorHandler{
+		store:  store,
+		issuer: issuer,
+	}
+}
+
+// TOTPEnrolment is shown once when enrolment starts. URI is meant to be
+// rendered as a QR code, Secret can be typed in instead.
+type TOTPEnrolment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+func errTOTPEnabled() Error {
+	return NewError(http.StatusConflict, "two-factor authentication already enabled")
+}
+
+// @Summary		Start two-factor enrolment
+// @Description	Handle generating an authenticator secret for the signed in user. It guards logins once confirmed, starting again replaces an unconfirmed secret
+// @Tags			user
+// @Produce		json
+// @Router			/me/2fa [post]
+func (h *TwoFactorHandler) HandleStartTOTP(c *fiber.Ctx) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	secret, err := totp.NewSecret()
+	if err != nil {
+		return err
+	}
+	if err := h.store.TOTP.StartTOTP(c.Context(), types.NewTOTP(user.ID, secret)); err != nil {
+		if errors.Is(err, db.ErrTOTPEnabled) {
+			return errTOTPEnabled()
+		}
+		return err
+	}
+	return c.JSON(TOTPEnrolment{
+		Secret: secret,
+		URI:    totp.URI(h.issuer, user.Email, secret),
+	})
+}
+
+// @Summary		Confirm two-factor enrolment
+// @Description	Handle enabling two-factor authentication with a first code of the authenticator app. The response lists recovery codes, they are not shown again. Log in again to get a session allowed on the admin routes
+// @Tags			user
+// @Accept			json
+// @Produce		json
+// @Param			params	body	types.TOTPCodeParams	true	"authenticator code"
+// @Router			/me/2fa/confirm [post]
+func (h *TwoFactorHandler) HandleConfirmTOTP(c *fiber.Ctx) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	var params types.TOTPCodeParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	userID := user.ID.Hex()
+	enrolment, err := h.store.TOTP.GetTOTP(c.Context(), userID)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return ErrResourceNotFound("TOTP")
+		}
+		return err
+	}
+	if enrolment.Confirmed {
+		return errTOTPEnabled()
+	}
+	step, ok := totp.Validate(enrolment.Secret, params.Code, time.Now())
+	if !ok {
+		return c.Status(http.StatusBadRequest).JSON(map[string]string{"code": "invalid code"})
+	}
+	codes, hashes, err := types.NewRecoveryCodes()
+	if err != nil {
+		return err
+	}
+	if err := h.store.TOTP.ConfirmTOTP(c.Context(), userID, step, hashes); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			// confirmed or deleted by a concurrent request
+			return errTOTPEnabled()
+		}
+		return err
+	}
+	return c.JSON(RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+// @Summary		Disable two-factor authentication
+// @Description	Handle removing the authenticator of the signed in user, which takes the password and a current code. Every session is revoked and the response carries new tokens
+// @Tags			user
+// @Accept			json
+// @Produce		json
+// @Param			params	body	types.DisableTOTPParams	true	"password and authenticator code"
+// @Router			/me/2fa [delete]
+func (h *TwoFactorHandler) HandleDeleteTOTP(c *fiber.Ctx) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	var params types.DisableTOTPParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if !types.IsValidPassword(user.EncryptedPassword, params.Password) {
+		return c.Status(http.StatusBadRequest).JSON(map[string]string{"password": "incorrect password"})
+	}
+	now := time.Now()
+	if err := verifySecondFactor(c.Context(), h.store.TOTP, user, params.Code, "", now); err != nil {
+		if errors.Is(err, errInvalidCode) {
+			return c.Status(http.StatusBadRequest).JSON(map[string]string{"code": "invalid code"})
+		}
+		return err
+	}
+	userID := user.ID.Hex()
+	if err := h.store.TOTP.DeleteTOTP(c.Context(), userID); err != nil {
+		return err
+	}
+	if _, err := h.store.Session.RevokeUserSessions(c.Context(), userID, now); err != nil {
+		return err
+	}
+	token, refreshToken, err := IssueTokens(c.Context(), h.store.Session, user, false)
+	if err != nil {
+		return err
+	}
+	return c.JSON(AuthResponse{
+		User:         user,
+		Token:        token,
+		RefreshToken: refreshToken,
+	})
+}
+
+// @Summary		Reset two-factor authentication
+// @Description	Handle removing the authenticator of a user who lost it and their recovery codes, revoking every session of the user
+// @Tags			admin
+// @Produce		json
+// @Router			/users/:id/2fa/reset [post]
+func (h *TwoFactorHandler) HandleResetTOTP(c *fiber.Ctx) error {
+	actor, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	id := c.Params("id")
+	if err := h.store.TOTP.DeleteTOTP(c.Context(), id); err != nil {
+		return ErrResourceNotFound("TOTP")
+	}
+	if _, err := h.store.Session.RevokeUserSessions(c.Context(), id, time.Now()); err != nil {
+		return err
+	}
+	if _, err := h.store.Audit.InsertAuditEntry(c.Context(), types.NewAuditEntry(types.AuditTOTPReset, actor.ID, "user:"+id, "")); err != nil {
+		return err
+	}
+	return c.JSON(genericResp{
+		Type: "msg",
+		Msg:  "two-factor authentication reset",
+	})
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/totp"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+func TestTwoFactor(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		app         = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		authHandler = tdb.authHandler()
+		totpHandler = NewTwoFactorHandler(tdb.Store, "")
+		apiv1       = app.Group("/v1", JWTAuthentication(tdb.Store))
+		admin       = apiv1.Group("/admin", AdminAuth)
+		adminUser   = fixtures.AddUser(tdb.Store, "admin", "admin", true)
+		tomek       = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		tomekToken  = tdb.token(t, tomek)
+		// codes of this and the next step stay valid if the test crosses
+		// into the next step
+		step = totp.Step(time.Now())
+	)
+	app.Post("/auth", authHandler.HandleAuthenticate)
+	app.Post("/auth/2fa", authHandler.HandleTwoFactor)
+	apiv1.Post("/me/2fa", totpHandler.HandleStartTOTP)
+	apiv1.Post("/me/2fa/confirm", totpHandler.HandleConfirmTOTP)
+	apiv1.Delete("/me/2fa", totpHandler.HandleDeleteTOTP)
+	admin.Get("/ping", func(c *fiber.Ctx) error { return c.SendStatus(http.StatusOK) })
+	admin.Post("/users/:id/2fa/reset", RequirePermission(types.PermManageUsers), totpHandler.HandleResetTOTP)
+
+	code := func(secret string, step int64) string {
+		t.Helper()
+		code, err := totp.Code(secret, step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+	enrol := func(token string) string {
+		t.Helper()
+		var enrolment TOTPEnrolment
+		json.NewDecoder(request(t, app, "POST", "/v1/me/2fa", token, nil, 200).Body).Decode(&enrolment)
+		if enrolment.Secret == "" || !strings.HasPrefix(enrolment.URI, "otpauth://totp/Movie%20Rental:") {
+			t.Fatalf("unexpected enrolment %+v", enrolment)
+		}
+		return enrolment.Secret
+	}
+	challenge := func() string {
+		t.Helper()
+		var resp ChallengeResponse
+		json.NewDecoder(request(t, app, "POST", "/auth", "", AuthParams{Email: adminUser.Email, Password: "admin123"}, 202).Body).Decode(&resp)
+		if !resp.TwoFactorRequired || resp.Challenge == "" {
+			t.Fatalf("expected a challenge but got %+v", resp)
+		}
+		return resp.Challenge
+	}
+
+	// a password alone doesn't open the admin routes
+	var login AuthResponse
+	json.NewDecoder(request(t, app, "POST", "/auth", "", AuthParams{Email: adminUser.Email, Password: "admin123"}, 200).Body).Decode(&login)
+	request(t, app, "GET", "/v1/admin/ping", login.Token, nil, 403)
+
+	secret := enrol(login.Token)
+	request(t, app, "POST", "/v1/me/2fa/confirm", login.Token, types.TOTPCodeParams{Code: "000000"}, 400)
+	var recovery RecoveryCodesResponse
+	json.NewDecoder(request(t, app, "POST", "/v1/me/2fa/confirm", login.Token, types.TOTPCodeParams{Code: code(secret, step)}, 200).Body).Decode(&recovery)
+	if len(recovery.RecoveryCodes) != 10 {
+		t.Fatalf("expected 10 recovery codes but got %v", recovery.RecoveryCodes)
+	}
+	request(t, app, "POST", "/v1/me/2fa", login.Token, nil, 409)
+
+	// the code used to confirm can't be replayed and a failed challenge is spent
+	first := challenge()
+	request(t, app, "POST", "/auth/2fa", "", TwoFactorParams{Challenge: first, Code: code(secret, step)}, 400)
+	request(t, app, "POST", "/auth/2fa", "", TwoFactorParams{Challenge: first, Code: code(secret, step+1)}, 401)
+	json.NewDecoder(request(t, app, "POST", "/auth/2fa", "", TwoFactorParams{Challenge: challenge(), Code: code(secret, step+1)}, 200).Body).Decode(&login)
+	request(t, app, "GET", "/v1/admin/ping", login.Token, nil, 200)
+
+	request(t, app, "POST", "/auth/2fa", "", TwoFactorParams{Challenge: challenge(), RecoveryCode: recovery.RecoveryCodes[0]}, 200)
+	request(t, app, "POST", "/auth/2fa", "", TwoFactorParams{Challenge: challenge(), RecoveryCode: recovery.RecoveryCodes[0]}, 400)
+
+	// disabling takes the password and a code and signs out everywhere
+	secret = enrol(tomekToken)
+	request(t, app, "POST", "/v1/me/2fa/confirm", tomekToken, types.TOTPCodeParams{Code: code(secret, step)}, 200)
+	request(t, app, "DELETE", "/v1/me/2fa", tomekToken, types.DisableTOTPParams{Password: "wrong", Code: code(secret, step+1)}, 400)
+	request(t, app, "DELETE", "/v1/me/2fa", tomekToken, types.DisableTOTPParams{Password: "test123", Code: code(secret, step)}, 400)
+	var disabled AuthResponse
+	json.NewDecoder(request(t, app, "DELETE", "/v1/me/2fa", tomekToken, types.DisableTOTPParams{Password: "test123", Code: code(secret, step+1)}, 200).Body).Decode(&disabled)
+	request(t, app, "POST", "/v1/me/2fa", tomekToken, nil, 401)
+	if _, err := tdb.TOTP.GetTOTP(context.TODO(), tomek.ID.Hex()); err == nil {
+		t.Fatal("expected the enrolment to be deleted")
+	}
+
+	// admins reset the authenticator of users who lost it
+	secret = enrol(disabled.Token)
+	request(t, app, "POST", "/v1/me/2fa/confirm", disabled.Token, types.TOTPCodeParams{Code: code(secret, step)}, 200)
+	resetPath := "/v1/admin/users/" + tomek.ID.Hex() + "/2fa/reset"
+	request(t, app, "POST", resetPath, login.Token, nil, 200)
+	request(t, app, "POST", resetPath, login.Token, nil, 404)
+	request(t, app, "POST", "/v1/me/2fa", disabled.Token, nil, 401)
+	page, err := tdb.Audit.GetAuditEntries(context.TODO(), map[string]any{"action": types.AuditTOTPReset}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || page.Items[0].ActorID != adminUser.ID || page.Items[0].Subject != "user:"+tomek.ID.Hex() {
+		t.Fatalf("expected the reset to be audited but got %+v", page.Items)
+	}
+}
@@ -1,18 +1,26 @@
 package api
 
 import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/mailer"
 	"github.com/tomekzakrzewski/go-movierental/types"
 )
 
 type UserHandler struct {
-	store db.UserStore
+	store  *db.Store
+	mailer mailer.Mailer
 }
 
-func NewUserHandler(store db.UserStore) *UserHandler {
+func NewUserHandler(store *db.Store, mailer mailer.Mailer) *UserHandler {
 	return &UserHandler{
-		store: store,
+		store:  store,
+		mailer: mailer,
 	}
 }
 
@@ -20,17 +28,23 @@ func NewUserHandler(store db.UserStore) *UserHandler {
 // @Description	Handle getting users
 // @Tags			admin
 // @Produce		json
+// @Param			limit	query	int		false	"page size, 20 by default and at most 100"
+// @Param			cursor	query	string	false	"nextCursor of the previous page"
 // @Router			/users [get]
 func (h *UserHandler) HandleGetUsers(c *fiber.Ctx) error {
-	users, err := h.store.GetUsers(c.Context())
+	pag, err := paginationFromQuery(c)
+	if err != nil {
+		return err
+	}
+	page, err := h.store.User.GetUsers(c.Context(), pag)
 	if err != nil {
-		return ErrResourceNotFound("Users")
+		return listError(err, "Users")
 	}
-	return c.JSON(users)
+	return c.JSON(newResourceResp(page))
 }
 
 // @Summary		Post user
-// @Description	Handle posting user
+// @Description	Handle posting user, the user has to verify their email with the mailed token before renting. Emails are case insensitive, a taken email or username is a 409 naming the field
 // @Tags			user
 // @Produce		json
 // @Router			/users [post]
@@ -46,27 +60,32 @@ func (h *UserHandler) HandlePostUser(c *fiber.Ctx) error {
 	if err != nil {
 		return ErrBadRequest()
 	}
-	insertedUser, err := h.store.InsertUser(c.Context(), user)
+	insertedUser, err := h.store.User.InsertUser(c.Context(), user)
 	if err != nil {
+		var dup *db.DuplicateError
+		if errors.As(err, &dup) {
+			return c.Status(http.StatusConflict).JSON(map[string]string{dup.Field: dup.Error()})
+		}
 		return ErrBadRequest()
 	}
+	// the user can ask for another token if this one doesn't arrive
+	if err := sendVerification(c.Context(), h.store, h.mailer, insertedUser); err != nil {
+		log.Printf("sign up: verification email to %s: %v", insertedUser.Email, err)
+	}
 	return c.JSON(insertedUser)
 }
 
 // @Summary		Get user by id
-// @Description	Handle getting user by id
-// @Tags			admin
+// @Description	Handle getting user by id, users can only get themselves unless they can read users
+// @Tags			user
 // @Produce		json
 // @Router			/users/:id [get]
 func (h *UserHandler) HandleGetUser(c *fiber.Ctx) error {
-	var (
-		id = c.Params("id")
-	)
-	users, err := h.store.GetUserByID(c.Context(), id)
+	user, err := authorize(c, userPolicy, h.store.User.GetUserByID, c.Params("id"))
 	if err != nil {
-		return ErrResourceNotFound("User")
+		return err
 	}
-	return c.JSON(users)
+	return c.JSON(user)
 }
 
 // @Summary		Delete user by id
@@ -78,8 +97,117 @@ func (h *UserHandler) HandleDeleteUser(c *fiber.Ctx) error {
 	var (
 		id = c.Params("id")
 	)
-	if err := h.store.DeleteUser(c.Context(), id); err != nil {
+	if err := h.store.User.DeleteUser(c.Context(), id); err != nil {
 		return ErrResourceNotFound("User")
 	}
 	return c.JSON(map[string]string{"deleted": id})
 }
+
+// @Summary		Get me
+// @Description	Handle getting the signed in user
+// @Tags			user
+// @Produce		json
+// @Router			/me [get]
+func (h *UserHandler) HandleGetMe(c *fiber.Ctx) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	return c.JSON(user)
+}
+
+// @Summary		Update me
+// @Description	Handle updating the profile of the signed in user, empty fields are kept. Changing the email requires the current password and the new email has to be verified again
+// @Tags			user
+// @Accept			json
+// @Produce		json
+// @Param			params	body	types.UpdateUserParams	true	"changed fields"
+// @Router			/me [patch]
+func (h *UserHandler) HandlePatchMe(c *fiber.Ctx) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	var params types.UpdateUserParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+	params.Email = types.NormalizeEmail(params.Email)
+	if params.Email == user.Email {
+		params.Email = ""
+	}
+	if params.Email != "" && !types.IsValidPassword(user.EncryptedPassword, params.CurrentPassword) {
+		return invalidCurrentPassword(c)
+	}
+	updated, err := h.store.User.UpdateUser(c.Context(), user.ID.Hex(), params)
+	if err != nil {
+		var dup *db.DuplicateError
+		if errors.As(err, &dup) {
+			return c.Status(http.StatusConflict).JSON(map[string]string{dup.Field: dup.Error()})
+		}
+		return err
+	}
+	if params.Email != "" {
+		if err := sendVerification(c.Context(), h.store, h.mailer, updated); err != nil {
+			log.Printf("email change: verification email to %s: %v", updated.Email, err)
+		}
+	}
+	return c.JSON(updated)
+}
+
+// @Summary		Change my password
+// @Description	Handle changing the password of the signed in user. Every session is revoked and the response carries new tokens
+// @Tags			user
+// @Accept			json
+// @Produce		json
+// @Param			params	body	types.ChangePasswordParams	true	"current and new password"
+// @Router			/me/password [put]
+func (h *UserHandler) HandlePutMyPassword(c *fiber.Ctx) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	session, ok := c.Context().Value("session").(*types.Session)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	var params types.ChangePasswordParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+	if !types.IsValidPassword(user.EncryptedPassword, params.CurrentPassword) {
+		return invalidCurrentPassword(c)
+	}
+	encpw, err := types.EncryptPassword(params.Password)
+	if err != nil {
+		return err
+	}
+	userID := user.ID.Hex()
+	if err := h.store.User.UpdateUserPassword(c.Context(), userID, encpw); err != nil {
+		return err
+	}
+	if _, err := h.store.Session.RevokeUserSessions(c.Context(), userID, time.Now()); err != nil {
+		return err
+	}
+	token, refreshToken, err := IssueTokens(c.Context(), h.store.Session, user, session.TwoFactor)
+	if err != nil {
+		return err
+	}
+	return c.JSON(AuthResponse{
+		User:         user,
+		Token:        token,
+		RefreshToken: refreshToken,
+	})
+}
+
+func invalidCurrentPassword(c *fiber.Ctx) error {
+	return c.Status(http.StatusBadRequest).JSON(map[string]string{
+		"currentPassword": "incorrect password",
+	})
+}
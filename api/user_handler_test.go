@@ -2,13 +2,18 @@ package api
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"io"
+	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/mailer"
 	"github.com/tomekzakrzewski/go-movierental/types"
 )
 
@@ -17,7 +22,7 @@ func TestPostUser(t *testing.T) {
 	defer tdb.teardown(t)
 	var (
 		app         = fiber.New()
-		userHandler = NewUserHandler(tdb.User)
+		userHandler = NewUserHandler(tdb.Store, mailer.NewLogMailer(io.Discard))
 	)
 
 	app.Post("/", userHandler.HandlePostUser)
@@ -62,7 +67,7 @@ func TestGetUsers(t *testing.T) {
 	var (
 		_           = fixtures.AddUser(tdb.Store, "tomek", "test", false)
 		app         = fiber.New()
-		userHandler = NewUserHandler(tdb.User)
+		userHandler = NewUserHandler(tdb.Store, mailer.NewLogMailer(io.Discard))
 	)
 
 	app.Get("/", userHandler.HandleGetUsers)
@@ -72,10 +77,55 @@ func TestGetUsers(t *testing.T) {
 	if err != nil {
 		t.Error(err)
 	}
-	var users []types.User
-	json.NewDecoder(resp.Body).Decode(&users)
-	if len(users) != 1 {
-		t.Errorf("expected 1 user but got %d", len(users))
+	var res struct {
+		Data []types.User `json:"data"`
+	}
+	json.NewDecoder(resp.Body).Decode(&res)
+	if len(res.Data) != 1 {
+		t.Errorf("expected 1 user but got %d", len(res.Data))
+	}
+}
+
+func TestGetUsersPagination(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+
+	var (
+		app         = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		userHandler = NewUserHandler(tdb.Store, mailer.NewLogMailer(io.Discard))
+	)
+	for _, name := range []string{"tomek", "zuzia", "ania"} {
+		fixtures.AddUser(tdb.Store, name, "test", false)
+	}
+	app.Get("/", userHandler.HandleGetUsers)
+
+	get := func(query string) (*http.Response, ResourceResp, []types.User) {
+		t.Helper()
+		resp, err := app.Test(httptest.NewRequest("GET", "/"+query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res struct {
+			ResourceResp
+			Data []types.User `json:"data"`
+		}
+		json.NewDecoder(resp.Body).Decode(&res)
+		return resp, res.ResourceResp, res.Data
+	}
+
+	_, res, users := get("?limit=2")
+	if len(users) != 2 || res.Total != 3 || !res.HasNext || res.NextCursor == "" {
+		t.Fatalf("expected 2 of 3 users with a next cursor but got %+v", res)
+	}
+	_, res, users = get("?limit=2&cursor=" + res.NextCursor)
+	if len(users) != 1 || users[0].FirstName != "ania" || res.HasNext || res.NextCursor != "" {
+		t.Fatalf("expected only ania on the last page but got %+v", users)
+	}
+	for _, query := range []string{"?limit=two", "?cursor=garbage"} {
+		resp, _, _ := get(query)
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status code 400 for %s but got %d", query, resp.StatusCode)
+		}
 	}
 }
 
@@ -85,7 +135,7 @@ func TestDeleteUser(t *testing.T) {
 	var (
 		userAdded   = fixtures.AddUser(tdb.Store, "tomek", "test", false)
 		app         = fiber.New()
-		userHandler = NewUserHandler(tdb.User)
+		userHandler = NewUserHandler(tdb.Store, mailer.NewLogMailer(io.Discard))
 	)
 	app.Delete("/:id", userHandler.HandleDeleteUser)
 
@@ -105,10 +155,12 @@ func TestGetUserUser(t *testing.T) {
 	var (
 		userAdded   = fixtures.AddUser(tdb.Store, "tomek", "test", false)
 		app         = fiber.New()
-		userHandler = NewUserHandler(tdb.User)
+		apiv1       = app.Group("", JWTAuthentication(tdb.Store))
+		userHandler = NewUserHandler(tdb.Store, mailer.NewLogMailer(io.Discard))
 	)
-	app.Get("/:id", userHandler.HandleGetUser)
+	apiv1.Get("/:id", userHandler.HandleGetUser)
 	req := httptest.NewRequest("GET", "/"+userAdded.ID.Hex(), nil)
+	req.Header.Add("Api-Token", tdb.token(t, userAdded))
 	resp, err := app.Test(req)
 
 	var user types.User
@@ -125,3 +177,140 @@ func TestGetUserUser(t *testing.T) {
 		t.Errorf("expected %v but got %v", userAdded, user)
 	}
 }
+
+func TestPostUserDuplicate(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		app         = fiber.New()
+		userHandler = NewUserHandler(tdb.Store, mailer.NewLogMailer(io.Discard))
+		existing    = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+	)
+	app.Post("/", userHandler.HandlePostUser)
+
+	for field, params := range map[string]types.CreateUserParams{
+		"email": {
+			Username:  "someone",
+			Email:     "TOMEK@test.com",
+			FirstName: "tomek",
+			LastName:  "test",
+			Password:  "tomektestpass",
+		},
+		"username": {
+			Username:  existing.Username,
+			Email:     "someone@test.com",
+			FirstName: "tomek",
+			LastName:  "test",
+			Password:  "tomektestpass",
+		},
+	} {
+		b, _ := json.Marshal(params)
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(b))
+		req.Header.Add("Content-Type", "application/json")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusConflict {
+			t.Fatalf("expected status code 409 for a taken %s but got %d", field, resp.StatusCode)
+		}
+		var errs map[string]string
+		json.NewDecoder(resp.Body).Decode(&errs)
+		if _, ok := errs[field]; !ok || len(errs) != 1 {
+			t.Fatalf("expected the %s to be reported but got %v", field, errs)
+		}
+	}
+}
+
+func TestMe(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		mail        bytes.Buffer
+		app         = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1       = app.Group("", JWTAuthentication(tdb.Store))
+		userHandler = NewUserHandler(tdb.Store, mailer.NewLogMailer(&mail))
+		tomek       = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		zuzia       = fixtures.AddUser(tdb.Store, "zuzia", "test", false)
+		token       = tdb.token(t, tomek)
+	)
+	apiv1.Get("/me", userHandler.HandleGetMe)
+	apiv1.Patch("/me", userHandler.HandlePatchMe)
+	apiv1.Put("/me/password", userHandler.HandlePutMyPassword)
+
+	var me types.User
+	json.NewDecoder(request(t, app, "GET", "/me", token, nil, 200).Body).Decode(&me)
+	if me.ID != tomek.ID {
+		t.Fatalf("expected user %s but got %s", tomek.ID, me.ID)
+	}
+
+	request(t, app, "PATCH", "/me", token, types.UpdateUserParams{FirstName: "t"}, 400)
+	request(t, app, "PATCH", "/me", token, types.UpdateUserParams{Username: zuzia.Username}, 409)
+	json.NewDecoder(request(t, app, "PATCH", "/me", token, types.UpdateUserParams{FirstName: "tom"}, 200).Body).Decode(&me)
+	if me.FirstName != "tom" || me.LastName != tomek.LastName || !me.EmailVerified {
+		t.Fatalf("expected only the first name to change but got %+v", me)
+	}
+	json.NewDecoder(request(t, app, "PATCH", "/me", token, types.UpdateUserParams{FirstName: "$encryptedPassword"}, 200).Body).Decode(&me)
+	if me.FirstName != "$encryptedPassword" {
+		t.Fatalf("expected the first name to be stored as sent but got %q", me.FirstName)
+	}
+
+	request(t, app, "PATCH", "/me", token, types.UpdateUserParams{Email: "new@test.com"}, 400)
+	request(t, app, "PATCH", "/me", token, types.UpdateUserParams{Email: zuzia.Email, CurrentPassword: "test123"}, 409)
+	json.NewDecoder(request(t, app, "PATCH", "/me", token, types.UpdateUserParams{Email: "New@test.com", CurrentPassword: "test123"}, 200).Body).Decode(&me)
+	if me.Email != "new@test.com" || me.EmailVerified {
+		t.Fatalf("expected the new email to be unverified but got %+v", me)
+	}
+	if !strings.Contains(mail.String(), "To: new@test.com") || mailedToken.FindString(mail.String()) == "" {
+		t.Fatalf("expected a verification token mailed to the new email but got %q", mail.String())
+	}
+
+	request(t, app, "PUT", "/me/password", token, types.ChangePasswordParams{CurrentPassword: "wrong", Password: "newpassword"}, 400)
+	request(t, app, "PUT", "/me/password", token, types.ChangePasswordParams{CurrentPassword: "test123", Password: "short"}, 400)
+	var authResp AuthResponse
+	json.NewDecoder(request(t, app, "PUT", "/me/password", token, types.ChangePasswordParams{CurrentPassword: "test123", Password: "newpassword"}, 200).Body).Decode(&authResp)
+	request(t, app, "GET", "/me", token, nil, 401)
+	request(t, app, "GET", "/me", authResp.Token, nil, 200)
+	user, err := tdb.User.GetUserByID(context.TODO(), tomek.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !types.IsValidPassword(user.EncryptedPassword, "newpassword") {
+		t.Fatal("expected the password to be changed")
+	}
+}
+
+func TestGetUserPolicy(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		app         = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1       = app.Group("", JWTAuthentication(tdb.Store))
+		userHandler = NewUserHandler(tdb.Store, mailer.NewLogMailer(io.Discard))
+		tomek       = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		zuzia       = fixtures.AddUser(tdb.Store, "zuzia", "test", false)
+		adminUser   = fixtures.AddUser(tdb.Store, "admin", "admin", true)
+	)
+	apiv1.Get("/users/:id", userHandler.HandleGetUser)
+
+	for _, tc := range []struct {
+		user     *types.User
+		id       string
+		expected int
+	}{
+		{tomek, tomek.ID.Hex(), 200},
+		{tomek, zuzia.ID.Hex(), 404},
+		{tomek, "garbage", 404},
+		{adminUser, zuzia.ID.Hex(), 200},
+	} {
+		req := httptest.NewRequest("GET", "/users/"+tc.id, nil)
+		req.Header.Add("Api-Token", tdb.token(t, tc.user))
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != tc.expected {
+			t.Fatalf("%s getting %s: expected status code %d but got %d", tc.user.FirstName, tc.id, tc.expected, resp.StatusCode)
+		}
+	}
+}
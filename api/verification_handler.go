@@ -0,0 +1,130 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/mailer"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+type VerificationHandler struct {
+	store  *db.Store
+	mailer mailer.Mailer
+}
+
+func NewVerificationHandler(store *db.Store, mailer mailer.Mailer) *VerificationHandler {
+	return &VerificationHandler{
+		store:  store,
+		mailer: mailer,
+	}
+}
+
+// sendVerification mails a new email verification token to the user.
+func sendVerification(ctx context.Context, store *db.Store, m mailer.Mailer, user *types.User) error {
+	secret, err := issueActionToken(ctx, store, user, types.TokenEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+	msg := actionTokenMessage(user, "Verify your email",
+		"Use this token to verify your email within the next 24 hours:", "EMAIL_VERIFY_URL", secret)
+	return m.Send(ctx, msg)
+}
+
+type VerifyEmailParams struct {
+	Token string `json:"token"`
+}
+
+// @Summary		Verify email
+// @Description	Handle confirming the email of a user with a mailed verification token
+// @Tags			authentication
+// @Accept			json
+// @Produce		json
+// @Param			params	body	VerifyEmailParams	true	"verification token"
+// @Router			/auth/verify [post]
+func (h *VerificationHandler) HandleVerifyEmail(c *fiber.Ctx) error {
+	var params VerifyEmailParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	token, err := h.store.ActionToken.UseActionToken(c.Context(), types.TokenEmailVerification, types.HashSecret(params.Token), time.Now())
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return NewError(http.StatusBadRequest, "invalid or expired token")
+		}
+		return err
+	}
+	if err := h.store.User.SetEmailVerified(c.Context(), token.UserID.Hex(), true); err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return NewError(http.StatusBadRequest, "invalid or expired token")
+		}
+		return err
+	}
+	return c.JSON(genericResp{
+		Type: "msg",
+		Msg:  "email verified",
+	})
+}
+
+type ResendVerificationParams struct {
+	Email string `json:"email"`
+}
+
+// @Summary		Resend verification
+// @Description	Handle mailing a new verification token, earlier tokens stop working. The response is the same whether the email is registered or not
+// @Tags			authentication
+// @Accept			json
+// @Produce		json
+// @Param			params	body	ResendVerificationParams	true	"email"
+// @Router			/auth/verify/resend [post]
+func (h *VerificationHandler) HandleResendVerification(c *fiber.Ctx) error {
+	var params ResendVerificationParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	resp := genericResp{
+		Type: "msg",
+		Msg:  "if the email is registered and not verified yet, a verification token was sent to it",
+	}
+	user, err := h.store.User.GetUserByEmail(c.Context(), params.Email)
+	if err != nil {
+		if errors.Is(err, db.ErrNotFound) {
+			return c.JSON(resp)
+		}
+		return err
+	}
+	if user.EmailVerified {
+		return c.JSON(resp)
+	}
+	if err := sendVerification(c.Context(), h.store, h.mailer, user); err != nil {
+		return err
+	}
+	return c.JSON(resp)
+}
+
+// @Summary		Verify user email
+// @Description	Handle marking the email of a user as verified without a token
+// @Tags			admin
+// @Produce		json
+// @Param			id	path	string	true	"user id"
+// @Router			/users/:id/verify [post]
+func (h *VerificationHandler) HandleAdminVerify(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := h.store.User.SetEmailVerified(c.Context(), id, true); err != nil {
+		return ErrResourceNotFound("User")
+	}
+	if err := h.store.ActionToken.DeleteActionTokens(c.Context(), id, types.TokenEmailVerification); err != nil {
+		return err
+	}
+	user, err := h.store.User.GetUserByID(c.Context(), id)
+	if err != nil {
+		return ErrResourceNotFound("User")
+	}
+	return c.JSON(user)
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/mailer"
+	"github.com/tomekzakrzewski/go-movierental/payment"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+func TestEmailVerification(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		mail          bytes.Buffer
+		m             = mailer.NewLogMailer(&mail)
+		app           = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1         = app.Group("/v1", JWTAuthentication(tdb.Store))
+		admin         = apiv1.Group("/admin", AdminAuth)
+		userHandler   = NewUserHandler(tdb.Store, m)
+		verifyHandler = NewVerificationHandler(tdb.Store, m)
+		movieHandler  = NewMovieHandler(tdb.Store, DefaultRentalPolicy(), payment.NewFake("secret"))
+		movieAdded    = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		adminUser     = fixtures.AddUser(tdb.Store, "admin", "admin", true)
+	)
+	fixtures.AddCopy(tdb.Store, movieAdded, types.FormatDVD)
+	fixtures.AddCopy(tdb.Store, movieAdded, types.FormatDVD)
+	app.Post("/users", userHandler.HandlePostUser)
+	app.Post("/auth/verify", verifyHandler.HandleVerifyEmail)
+	app.Post("/auth/verify/resend", verifyHandler.HandleResendVerification)
+	apiv1.Post("/movies/:id/rent", movieHandler.HandleRentMovie)
+	admin.Post("/users/:id/verify", verifyHandler.HandleAdminVerify)
+
+	register := func(name string) (*types.User, string) {
+		t.Helper()
+		mail.Reset()
+		var user types.User
+		json.NewDecoder(request(t, app, "POST", "/users", "", types.CreateUserParams{
+			Username:  name,
+			FirstName: name,
+			LastName:  "test",
+			Password:  "password123",
+			Email:     name + "@test.com",
+		}, 200).Body).Decode(&user)
+		if user.EmailVerified {
+			t.Fatal("expected a new user to be unverified")
+		}
+		return &user, mailedToken.FindStringSubmatch(mail.String())[1]
+	}
+	rentPath := "/v1/movies/" + movieAdded.ID.Hex() + "/rent"
+
+	tomek, stale := register("tomek")
+	request(t, app, "POST", rentPath, tdb.token(t, tomek), nil, 403)
+
+	mail.Reset()
+	request(t, app, "POST", "/auth/verify/resend", "", ResendVerificationParams{Email: tomek.Email}, 200)
+	token := mailedToken.FindStringSubmatch(mail.String())[1]
+	request(t, app, "POST", "/auth/verify", "", VerifyEmailParams{Token: stale}, 400)
+	request(t, app, "POST", "/auth/verify", "", VerifyEmailParams{Token: token}, 200)
+	request(t, app, "POST", "/auth/verify", "", VerifyEmailParams{Token: token}, 400)
+	request(t, app, "POST", rentPath, tdb.token(t, tomek), nil, 200)
+
+	mail.Reset()
+	request(t, app, "POST", "/auth/verify/resend", "", ResendVerificationParams{Email: tomek.Email}, 200)
+	if mail.Len() != 0 {
+		t.Fatal("expected no mail for a verified user")
+	}
+
+	zuzia, token := register("zuzia")
+	request(t, app, "POST", "/v1/admin/users/"+zuzia.ID.Hex()+"/verify", tdb.token(t, zuzia), nil, 401)
+	var verified types.User
+	json.NewDecoder(request(t, app, "POST", "/v1/admin/users/"+zuzia.ID.Hex()+"/verify", tdb.token(t, adminUser), nil, 200).Body).Decode(&verified)
+	if !verified.EmailVerified {
+		t.Fatal("expected the admin to verify the user")
+	}
+	request(t, app, "POST", "/auth/verify", "", VerifyEmailParams{Token: token}, 400)
+	request(t, app, "POST", rentPath, tdb.token(t, zuzia), nil, 200)
+}
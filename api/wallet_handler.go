@@ -0,0 +1,318 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+// walletMethod is the payment method that pays a rent from the renter's
+// wallet instead of through the payment provider. Wallet payments are
+// recorded with it as their provider and the ledger entry as their
+// provider id.
+const walletMethod = "wallet"
+
+type WalletHandler struct {
+	store   *db.Store
+	rentals RentalPolicy
+}
+
+func NewWalletHandler(store *db.Store, rentals RentalPolicy) *WalletHandler {
+	return &WalletHandler{
+		store:   store,
+		rentals: rentals,
+	}
+}
+
+// WalletResponse is the balance of a wallet, in minor units of Currency.
+type WalletResponse struct {
+	Balance  int64  `json:"balance"`
+	Currency string `json:"currency"`
+}
+
+// debitWallet charges amount for a rent to the renter's wallet, failing
+// with db.ErrInsufficientFunds when the balance doesn't cover it.
+func debitWallet(ctx context.Context, store *db.Store, rent *types.Rent, amount int64, purpose types.PaymentPurpose, hours int) (*types.Payment, error) {
+	now := time.Now()
+	entry, err := store.Wallet.AppendWalletEntry(ctx, &types.WalletEntry{
+		UserID:    rent.UserID,
+		Kind:      types.WalletRentalCharge,
+		Amount:    -amount,
+		Currency:  rent.Currency,
+		RentID:    rent.ID,
+		CreatedAt: now,
+	})
+	if err != nil {
+		return nil, err
+	}
+	p, err := store.Payment.InsertPayment(ctx, &types.Payment{
+		RentID:     rent.ID,
+		UserID:     rent.UserID,
+		Purpose:    purpose,
+		Provider:   walletMethod,
+		ProviderID: entry.ID.Hex(),
+		Amount:     amount,
+		Hours:      hours,
+		Currency:   rent.Currency,
+		Status:     types.PaymentCaptured,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	})
+	if err != nil {
+		// without a payment to refund later the debit is given back now
+		if err := creditRenter(ctx, store, rent, amount); err != nil {
+			return nil, err
+		}
+		return nil, err
+	}
+	return p, nil
+}
+
+// creditRenter gives amount back to the wallet of a rent's renter, for
+// debits that didn't go through in the end.
+func creditRenter(ctx context.Context, store *db.Store, rent *types.Rent, amount int64) error {
+	_, err := store.Wallet.AppendWalletEntry(ctx, &types.WalletEntry{
+		UserID:    rent.UserID,
+		Kind:      types.WalletRefund,
+		Amount:    amount,
+		Currency:  rent.Currency,
+		RentID:    rent.ID,
+		CreatedAt: time.Now(),
+	})
+	return err
+}
+
+// refundWallet credits a captured wallet payment back. The payment is
+// marked refunded first so it can't be credited twice.
+func refundWallet(ctx context.Context, store *db.Store, p *types.Payment) (*types.Payment, error) {
+	refunded, err := store.Payment.UpdatePaymentStatus(ctx, p.ID.Hex(), types.PaymentCaptured, types.PaymentRefunded, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	_, err = store.Wallet.AppendWalletEntry(ctx, &types.WalletEntry{
+		UserID:    p.UserID,
+		Kind:      types.WalletRefund,
+		Amount:    p.Amount,
+		Currency:  p.Currency,
+		RentID:    p.RentID,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refunded, nil
+}
+
+// payLateFee pays the final late fee of a returned rent from the renter's
+// wallet when its balance covers it. Fees it doesn't cover stay owed.
+func payLateFee(ctx context.Context, store *db.Store, rent *types.Rent) error {
+	_, err := store.Wallet.AppendWalletEntry(ctx, &types.WalletEntry{
+		UserID:    rent.UserID,
+		Kind:      types.WalletLateFee,
+		Amount:    -rent.LateFee,
+		Currency:  rent.Currency,
+		RentID:    rent.ID,
+		CreatedAt: time.Now(),
+	})
+	if errors.Is(err, db.ErrInsufficientFunds) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := store.Rent.SettleLateFee(ctx, rent.ID.Hex()); err != nil {
+		// the fee stays open or was settled by staff in the meantime, so
+		// the debit is given back either way
+		if creditErr := creditRenter(ctx, store, rent, rent.LateFee); creditErr != nil {
+			return errors.Join(err, creditErr)
+		}
+		if errors.Is(err, db.ErrInvalidTransition) {
+			return nil
+		}
+		return err
+	}
+	rent.LateFeePaid = true
+	return nil
+}
+
+// payLateFees pays the outstanding late fees of a user's returned rents
+// from their wallet, oldest first, as far as its balance covers them.
+func payLateFees(ctx context.Context, store *db.Store, userID string) error {
+	page, err := store.Rent.GetRentsByUser(ctx, userID, map[string]any{"state": types.RentReturned}, nil)
+	if err != nil {
+		return err
+	}
+	for _, rent := range page.Items {
+		if rent.LateFee == 0 || rent.LateFeePaid {
+			continue
+		}
+		if err := payLateFee(ctx, store, rent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *WalletHandler) wallet(c *fiber.Ctx, userID string) error {
+	balance, err := h.store.Wallet.GetWalletBalance(c.Context(), userID)
+	if err != nil {
+		return err
+	}
+	return c.JSON(WalletResponse{
+		Balance:  balance,
+		Currency: h.rentals.Currency,
+	})
+}
+
+func (h *WalletHandler) statement(c *fiber.Ctx, userID string) error {
+	pag, err := paginationFromQuery(c)
+	if err != nil {
+		return err
+	}
+	page, err := h.store.Wallet.GetWalletEntries(c.Context(), userID, pag)
+	if err != nil {
+		return listError(err, "Wallet entries")
+	}
+	return c.JSON(newResourceResp(page))
+}
+
+// @Summary		Get my wallet
+// @Description	Handle getting the balance of the signed in user's prepaid wallet, in minor units
+// @Tags			user
+// @Produce		json
+// @Router			/me/wallet [get]
+func (h *WalletHandler) HandleGetMyWallet(c *fiber.Ctx) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	return h.wallet(c, user.ID.Hex())
+}
+
+// @Summary		Get my wallet statement
+// @Description	Handle listing the entries of the signed in user's wallet, newest first. Every entry carries the balance right after it
+// @Tags			user
+// @Produce		json
+// @Param			limit	query	int		false	"page size, 20 by default and at most 100"
+// @Param			cursor	query	string	false	"nextCursor of the previous page"
+// @Router			/me/wallet/statement [get]
+func (h *WalletHandler) HandleGetMyStatement(c *fiber.Ctx) error {
+	user, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	return h.statement(c, user.ID.Hex())
+}
+
+// @Summary		Get a user's wallet
+// @Description	Handle getting the balance of a user's prepaid wallet, in minor units
+// @Tags			admin
+// @Produce		json
+// @Param			id	path	string	true	"user id"
+// @Router			/admin/users/:id/wallet [get]
+func (h *WalletHandler) HandleGetWallet(c *fiber.Ctx) error {
+	user, err := h.store.User.GetUserByID(c.Context(), c.Params("id"))
+	if err != nil {
+		return ErrResourceNotFound("User")
+	}
+	return h.wallet(c, user.ID.Hex())
+}
+
+// @Summary		Get a user's wallet statement
+// @Description	Handle listing the entries of a user's wallet, newest first
+// @Tags			admin
+// @Produce		json
+// @Param			id		path	string	true	"user id"
+// @Param			limit	query	int		false	"page size, 20 by default and at most 100"
+// @Param			cursor	query	string	false	"nextCursor of the previous page"
+// @Router			/admin/users/:id/wallet/statement [get]
+func (h *WalletHandler) HandleGetStatement(c *fiber.Ctx) error {
+	user, err := h.store.User.GetUserByID(c.Context(), c.Params("id"))
+	if err != nil {
+		return ErrResourceNotFound("User")
+	}
+	return h.statement(c, user.ID.Hex())
+}
+
+// @Summary		Credit a user's wallet
+// @Description	Handle adding an amount to a user's wallet with a reason, as an adjustment or, with kind top_up, as money the user paid in
+// @Description	Late fees the user still owes are then paid from the wallet as far as its balance covers them
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			id	path	string	true	"user id"
+// @Router			/admin/users/:id/wallet/credit [post]
+func (h *WalletHandler) HandleCredit(c *fiber.Ctx) error {
+	return h.adjust(c, 1)
+}
+
+// @Summary		Debit a user's wallet
+// @Description	Handle taking an amount from a user's wallet with a reason. The balance can't go below zero
+// @Tags			admin
+// @Accept			json
+// @Produce		json
+// @Param			id	path	string	true	"user id"
+// @Router			/admin/users/:id/wallet/debit [post]
+func (h *WalletHandler) HandleDebit(c *fiber.Ctx) error {
+	return h.adjust(c, -1)
+}
+
+// adjust appends an entry of the posted amount times sign to a wallet and
+// records the staff member who made it in the entry and the audit log.
+func (h *WalletHandler) adjust(c *fiber.Ctx, sign int64) error {
+	actor, ok := c.Context().Value("user").(*types.User)
+	if !ok {
+		return ErrUnAuthorized()
+	}
+	var params types.WalletAdjustmentParams
+	if err := c.BodyParser(&params); err != nil {
+		return ErrBadRequest()
+	}
+	if errors := params.Validate(); len(errors) > 0 {
+		return c.Status(http.StatusBadRequest).JSON(errors)
+	}
+	kind := params.Kind
+	if kind == "" {
+		kind = types.WalletAdjustment
+	}
+	if sign < 0 && kind != types.WalletAdjustment {
+		return c.Status(http.StatusBadRequest).JSON(map[string]string{
+			"kind": "debits can only be adjustments",
+		})
+	}
+	user, err := h.store.User.GetUserByID(c.Context(), c.Params("id"))
+	if err != nil {
+		return ErrResourceNotFound("User")
+	}
+	entry, err := h.store.Wallet.AppendWalletEntry(c.Context(), &types.WalletEntry{
+		UserID:    user.ID,
+		Kind:      kind,
+		Amount:    sign * params.Amount,
+		Currency:  h.rentals.Currency,
+		ActorID:   actor.ID,
+		Reason:    params.Reason,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		if errors.Is(err, db.ErrInsufficientFunds) {
+			return NewError(http.StatusConflict, "debit exceeds the wallet balance")
+		}
+		return err
+	}
+	audit := types.NewAuditEntry(types.AuditWalletAdjust, actor.ID, "user:"+user.ID.Hex(), fmt.Sprintf("%s %d %s", entry.ID.Hex(), entry.Amount, entry.Reason))
+	if _, err := h.store.Audit.InsertAuditEntry(c.Context(), audit); err != nil {
+		return err
+	}
+	if sign > 0 {
+		if err := payLateFees(c.Context(), h.store, user.ID.Hex()); err != nil {
+			return err
+		}
+	}
+	return c.JSON(entry)
+}
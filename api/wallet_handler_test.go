@@ -0,0 +1,255 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/payment"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestWallet(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		app            = fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
+		apiv1          = app.Group("", JWTAuthentication(tdb.Store))
+		admin          = apiv1.Group("/admin", AdminAuth)
+		policy         = DefaultRentalPolicy()
+		fake           = payment.NewFake("secret")
+		movieHandler   = NewMovieHandler(tdb.Store, policy, fake)
+		rentHandler    = NewRentHandler(tdb.Store, policy, fake)
+		paymentHandler = NewPaymentHandler(tdb.Store, fake)
+		walletHandler  = NewWalletHandler(tdb.Store, policy)
+		adminUser      = fixtures.AddUser(tdb.Store, "admin", "admin", true)
+		tomek          = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		adminToken     = tdb.token(t, adminUser)
+		token          = tdb.token(t, tomek)
+		matrix         = fixtures.AddMovie(tdb.Store, "The Matrix", []string{"Action"}, 120, 1999)
+		dune           = fixtures.AddMovie(tdb.Store, "Dune", []string{"Sci-Fi"}, 155, 2021)
+		_              = fixtures.AddCopy(tdb.Store, matrix, types.FormatDVD)
+		duneCopy       = fixtures.AddCopy(tdb.Store, dune, types.FormatDVD)
+		now            = time.Now()
+	)
+	for _, rule := range []*types.PriceRule{
+		{Kind: types.PriceBase, Amount: 399},
+		{Kind: types.PriceBase, MovieID: dune.ID, Amount: 999},
+	} {
+		if _, err := tdb.Store.PriceRule.InsertPriceRule(context.Background(), rule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	canManageWallets := RequirePermission(types.PermManageWallets)
+	apiv1.Post("/movies/:id/rent", movieHandler.HandleRentMovie)
+	apiv1.Post("/rents/:id/return", rentHandler.HandleReturnRent)
+	apiv1.Get("/me/balance", rentHandler.HandleGetBalance)
+	apiv1.Get("/rents/:id/payments", paymentHandler.HandleGetRentPayments)
+	apiv1.Get("/me/wallet", walletHandler.HandleGetMyWallet)
+	apiv1.Get("/me/wallet/statement", walletHandler.HandleGetMyStatement)
+	admin.Post("/payments/:id/refund", RequirePermission(types.PermManageRents), paymentHandler.HandleRefundPayment)
+	admin.Get("/users/:id/wallet", canManageWallets, walletHandler.HandleGetWallet)
+	admin.Get("/users/:id/wallet/statement", canManageWallets, walletHandler.HandleGetStatement)
+	admin.Post("/users/:id/wallet/credit", canManageWallets, walletHandler.HandleCredit)
+	admin.Post("/users/:id/wallet/debit", canManageWallets, walletHandler.HandleDebit)
+
+	balance := func() int64 {
+		t.Helper()
+		var wallet WalletResponse
+		json.NewDecoder(request(t, app, "GET", "/me/wallet", token, nil, 200).Body).Decode(&wallet)
+		return wallet.Balance
+	}
+	wallet := "/admin/users/" + tomek.ID.Hex() + "/wallet"
+
+	request(t, app, "POST", wallet+"/credit", token, types.WalletAdjustmentParams{Amount: 1000, Reason: "cash"}, 401)
+	request(t, app, "POST", wallet+"/credit", adminToken, types.WalletAdjustmentParams{Amount: 0, Reason: "cash"}, 400)
+	request(t, app, "POST", wallet+"/credit", adminToken, types.WalletAdjustmentParams{Amount: 1000}, 400)
+	request(t, app, "POST", wallet+"/debit", adminToken, types.WalletAdjustmentParams{Amount: 100, Reason: "cash", Kind: types.WalletTopUp}, 400)
+	request(t, app, "POST", "/admin/users/"+matrix.ID.Hex()+"/wallet/credit", adminToken, types.WalletAdjustmentParams{Amount: 1000, Reason: "cash"}, 404)
+	var entry types.WalletEntry
+	json.NewDecoder(request(t, app, "POST", wallet+"/credit", adminToken, types.WalletAdjustmentParams{Amount: 1000, Reason: "paid in cash at the counter", Kind: types.WalletTopUp}, 200).Body).Decode(&entry)
+	if entry.Kind != types.WalletTopUp || entry.Balance != 1000 || entry.ActorID != adminUser.ID || entry.Currency != "USD" {
+		t.Fatalf("expected a top-up by the admin but got %+v", entry)
+	}
+
+	// renting from the wallet debits it right away
+	var rent types.Rent
+	json.NewDecoder(request(t, app, "POST", "/movies/"+matrix.ID.Hex()+"/rent?paymentMethod=wallet", token, nil, 200).Body).Decode(&rent)
+	if rent.State != types.RentActive || balance() != 601 {
+		t.Fatalf("expected an active rent paid from the wallet but got %s with a balance of %d", rent.State, balance())
+	}
+	var payments []types.Payment
+	json.NewDecoder(request(t, app, "GET", "/rents/"+rent.ID.Hex()+"/payments", token, nil, 200).Body).Decode(&payments)
+	if len(payments) != 1 || payments[0].Provider != "wallet" || payments[0].Status != types.PaymentCaptured || payments[0].Amount != 399 {
+		t.Fatalf("expected a captured wallet payment but got %+v", payments)
+	}
+	request(t, app, "POST", "/movies/"+dune.ID.Hex()+"/rent?paymentMethod=wallet", token, nil, http.StatusPaymentRequired)
+	if cp, err := tdb.Store.Copy.GetCopyByID(context.Background(), duneCopy.ID.Hex()); err != nil || cp.Rented {
+		t.Fatalf("expected the copy to be released after an unpaid rent but got %+v, %v", cp, err)
+	}
+
+	request(t, app, "POST", wallet+"/debit", adminToken, types.WalletAdjustmentParams{Amount: 700, Reason: "damaged case"}, 409)
+	request(t, app, "POST", wallet+"/debit", adminToken, types.WalletAdjustmentParams{Amount: 101, Reason: "damaged case"}, 200)
+	request(t, app, "POST", "/admin/payments/"+payments[0].ID.Hex()+"/refund", adminToken, nil, 200)
+	request(t, app, "POST", "/admin/payments/"+payments[0].ID.Hex()+"/refund", adminToken, nil, 409)
+	if got := balance(); got != 899 {
+		t.Fatalf("expected the refund to be credited back but got a balance of %d", got)
+	}
+
+	// the late fee of a rent returned three started days late is paid from
+	// the wallet
+	late, err := tdb.Store.Rent.InsertRent(context.Background(), &types.Rent{
+		UserID:   tomek.ID,
+		MovieID:  dune.ID,
+		From:     now.Add(-84 * time.Hour),
+		To:       now.Add(-60 * time.Hour),
+		Currency: "USD",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	json.NewDecoder(request(t, app, "POST", "/rents/"+late.ID.Hex()+"/return", token, nil, 200).Body).Decode(&rent)
+	if rent.LateFee != 300 || !rent.LateFeePaid || balance() != 599 {
+		t.Fatalf("expected a paid late fee of 300 but got %d with a balance of %d", rent.LateFee, balance())
+	}
+	var owed BalanceResponse
+	json.NewDecoder(request(t, app, "GET", "/me/balance", token, nil, 200).Body).Decode(&owed)
+	if owed.LateFees != 0 || !owed.CanRent {
+		t.Fatalf("expected nothing owed but got %+v", owed)
+	}
+
+	var page struct {
+		Data       []types.WalletEntry `json:"data"`
+		Total      int                 `json:"total"`
+		NextCursor string              `json:"nextCursor"`
+	}
+	json.NewDecoder(request(t, app, "GET", "/me/wallet/statement?limit=2", token, nil, 200).Body).Decode(&page)
+	if page.Total != 5 || len(page.Data) != 2 || page.Data[0].Kind != types.WalletLateFee || page.Data[1].Kind != types.WalletRefund {
+		t.Fatalf("expected the newest 2 of 5 entries but got %+v", page)
+	}
+	json.NewDecoder(request(t, app, "GET", wallet+"/statement?limit=3&cursor="+page.NextCursor, adminToken, nil, 200).Body).Decode(&page)
+	if len(page.Data) != 3 || page.Data[0].Reason != "damaged case" || page.Data[0].Amount != -101 || page.Data[2].Kind != types.WalletTopUp {
+		t.Fatalf("expected the oldest 3 entries but got %+v", page.Data)
+	}
+	request(t, app, "GET", wallet, token, nil, 401)
+	var got WalletResponse
+	json.NewDecoder(request(t, app, "GET", wallet, adminToken, nil, 200).Body).Decode(&got)
+	if got.Balance != 599 {
+		t.Fatalf("expected a balance of 599 but got %d", got.Balance)
+	}
+
+	audit, err := tdb.Store.Audit.GetAuditEntries(context.Background(), map[string]any{"action": types.AuditWalletAdjust}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if audit.Total != 2 {
+		t.Fatalf("expected both adjustments to be audited but got %d", audit.Total)
+	}
+
+	// a fee the wallet can't cover stays owed until the next top-up pays it
+	overdue, err := tdb.Store.Rent.InsertRent(context.Background(), &types.Rent{
+		UserID:   tomek.ID,
+		MovieID:  dune.ID,
+		From:     now.Add(-31 * 24 * time.Hour),
+		To:       now.Add(-30 * 24 * time.Hour),
+		Currency: "USD",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unpaid types.Rent
+	json.NewDecoder(request(t, app, "POST", "/rents/"+overdue.ID.Hex()+"/return", token, nil, 200).Body).Decode(&unpaid)
+	if unpaid.LateFee != 2000 || unpaid.LateFeePaid || balance() != 599 {
+		t.Fatalf("expected an unpaid late fee of 2000 but got %d with a balance of %d", unpaid.LateFee, balance())
+	}
+	request(t, app, "POST", "/movies/"+dune.ID.Hex()+"/rent?paymentMethod=wallet", token, nil, http.StatusPaymentRequired)
+	request(t, app, "POST", wallet+"/credit", adminToken, types.WalletAdjustmentParams{Amount: 2400, Reason: "paid in cash at the counter", Kind: types.WalletTopUp}, 200)
+	json.NewDecoder(request(t, app, "GET", "/me/balance", token, nil, 200).Body).Decode(&owed)
+	if owed.LateFees != 0 || !owed.CanRent || balance() != 999 {
+		t.Fatalf("expected the top-up to pay the fee but got %+v with a balance of %d", owed, balance())
+	}
+	json.NewDecoder(request(t, app, "POST", "/movies/"+dune.ID.Hex()+"/rent?paymentMethod=wallet", token, nil, 200).Body).Decode(&rent)
+	if rent.State != types.RentActive || balance() != 0 {
+		t.Fatalf("expected an active rent paid from the wallet but got %s with a balance of %d", rent.State, balance())
+	}
+}
+
+// brokenPaymentStore fails to record any payment.
+type brokenPaymentStore struct {
+	db.PaymentStore
+}
+
+func (brokenPaymentStore) InsertPayment(context.Context, *types.Payment) (*types.Payment, error) {
+	return nil, errors.New("payments are unavailable")
+}
+
+type brokenRentStore struct {
+	db.RentStore
+}
+
+func (brokenRentStore) SettleLateFee(context.Context, string) error {
+	return errors.New("rents are unavailable")
+}
+
+func TestPayLateFeeWithoutSettling(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		ctx   = context.Background()
+		tomek = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		rent  = &types.Rent{ID: primitive.NewObjectID(), UserID: tomek.ID, Currency: "USD", LateFee: 150}
+	)
+	if _, err := tdb.Store.Wallet.AppendWalletEntry(ctx, &types.WalletEntry{UserID: tomek.ID, Kind: types.WalletTopUp, Amount: 500, Currency: "USD", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	tdb.Store.Rent = brokenRentStore{tdb.Store.Rent}
+	if err := payLateFee(ctx, tdb.Store, rent); err == nil || rent.LateFeePaid {
+		t.Fatal("expected the late fee to stay open")
+	}
+	balance, err := tdb.Store.Wallet.GetWalletBalance(ctx, tomek.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := tdb.Store.Wallet.GetWalletEntries(ctx, tomek.ID.Hex(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 500 || entries.Total != 3 || entries.Items[0].Kind != types.WalletRefund || entries.Items[0].Amount != 150 {
+		t.Fatalf("expected the debit to be given back but got a balance of %d after %+v", balance, entries.Items)
+	}
+}
+
+func TestDebitWalletWithoutPayment(t *testing.T) {
+	tdb := setup(t)
+	defer tdb.teardown(t)
+	var (
+		ctx   = context.Background()
+		tomek = fixtures.AddUser(tdb.Store, "tomek", "test", false)
+		rent  = &types.Rent{ID: primitive.NewObjectID(), UserID: tomek.ID, Currency: "USD"}
+	)
+	if _, err := tdb.Store.Wallet.AppendWalletEntry(ctx, &types.WalletEntry{UserID: tomek.ID, Kind: types.WalletTopUp, Amount: 500, Currency: "USD", CreatedAt: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	tdb.Store.Payment = brokenPaymentStore{tdb.Store.Payment}
+	if _, err := debitWallet(ctx, tdb.Store, rent, 399, types.PaymentForRent, 0); err == nil {
+		t.Fatal("expected the debit to fail without a payment")
+	}
+	balance, err := tdb.Store.Wallet.GetWalletBalance(ctx, tomek.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := tdb.Store.Wallet.GetWalletEntries(ctx, tomek.ID.Hex(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 500 || entries.Total != 3 || entries.Items[0].Kind != types.WalletRefund || entries.Items[0].Amount != 399 {
+		t.Fatalf("expected the debit to be given back but got a balance of %d after %+v", balance, entries.Items)
+	}
+}
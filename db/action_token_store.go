@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	actionTokenColl = "actionTokens"
+)
+
+type ActionTokenStore interface {
+	InsertActionToken(context.Context, *types.ActionToken) (*types.ActionToken, error)
+	UseActionToken(context.Context, types.TokenPurpose, string, time.Time) (*types.ActionToken, error)
+	DeleteActionTokens(context.Context, string, types.TokenPurpose) error
+}
+
+type MongoActionTokenStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewActionTokenStore(client *mongo.Client) *MongoActionTokenStore {
+	return &MongoActionTokenStore{
+		client: client,
+		coll:   client.Database(MongoDBName).Collection(actionTokenColl),
+	}
+}
+
+// createIndexes adds the hash index used to redeem tokens and lets Mongo
+// delete tokens once they expire.
+func (s *MongoActionTokenStore) createIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "purpose", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+func (s *MongoActionTokenStore) InsertActionToken(ctx context.Context, token *types.ActionToken) (*types.ActionToken, error) {
+	res, err := s.coll.InsertOne(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+	token.ID = res.InsertedID.(primitive.ObjectID)
+	return token, nil
+}
+
+// UseActionToken marks the unused and unexpired token with the hash as used
+// and returns it, so concurrent requests can't redeem it twice. Otherwise
+// it returns ErrNotFound.
+func (s *MongoActionTokenStore) UseActionToken(ctx context.Context, purpose types.TokenPurpose, hash string, at time.Time) (*types.ActionToken, error) {
+	filter := bson.M{
+		"hash":      hash,
+		"purpose":   purpose,
+		"usedAt":    nil,
+		"expiresAt": bson.M{"$gt": at},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var token types.ActionToken
+	if err := s.coll.FindOneAndUpdate(ctx, filter, bson.M{"$set": bson.M{"usedAt": at}}, opts).Decode(&token); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &token, nil
+}
+
+// DeleteActionTokens deletes the tokens of the user for the purpose, used
+// or not.
+func (s *MongoActionTokenStore) DeleteActionTokens(ctx context.Context, userID string, purpose types.TokenPurpose) error {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	_, err = s.coll.DeleteMany(ctx, bson.M{"userID": oid, "purpose": purpose})
+	return err
+}
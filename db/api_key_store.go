@@ -0,0 +1,118 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	apiKeyColl = "apiKeys"
+)
+
+type APIKeyStore interface {
+	InsertAPIKey(context.Context, *types.APIKey) (*types.APIKey, error)
+	GetAPIKeyByID(context.Context, string) (*types.APIKey, error)
+	GetAPIKeys(context.Context, map[string]any, *Pagination) (*Page[*types.APIKey], error)
+	TouchAPIKey(context.Context, string, time.Time) error
+	RevokeAPIKey(context.Context, string, time.Time) error
+}
+
+type MongoAPIKeyStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewAPIKeyStore(client *mongo.Client) *MongoAPIKeyStore {
+	return &MongoAPIKeyStore{
+		client: client,
+		coll:   client.Database(MongoDBName).Collection(apiKeyColl),
+	}
+}
+
+// createIndexes adds the index backing the keys of a user listing.
+func (s *MongoAPIKeyStore) createIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "userID", Value: 1}, {Key: "_id", Value: -1}},
+	})
+	return err
+}
+
+func (s *MongoAPIKeyStore) InsertAPIKey(ctx context.Context, key *types.APIKey) (*types.APIKey, error) {
+	res, err := s.coll.InsertOne(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	key.ID = res.InsertedID.(primitive.ObjectID)
+	return key, nil
+}
+
+func (s *MongoAPIKeyStore) GetAPIKeyByID(ctx context.Context, id string) (*types.APIKey, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var key types.APIKey
+	if err := s.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&key); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &key, nil
+}
+
+// GetAPIKeys returns the newest keys first. The filter accepts "userID".
+func (s *MongoAPIKeyStore) GetAPIKeys(ctx context.Context, filter map[string]any, pag *Pagination) (*Page[*types.APIKey], error) {
+	m := bson.M{}
+	for key, value := range filter {
+		switch key {
+		case "userID":
+			m[key] = value
+		default:
+			return nil, fmt.Errorf("unsupported api key filter %q", key)
+		}
+	}
+	return findPage(ctx, s.coll, findQuery{filter: m, desc: true}, pag, APIKeyCursor)
+}
+
+// TouchAPIKey records that the key was used at the time.
+func (s *MongoAPIKeyStore) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"lastUsedAt": at}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeAPIKey revokes the key. Revoking it again keeps the original
+// revocation time.
+func (s *MongoAPIKeyStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.A{
+		bson.M{"$set": bson.M{"revokedAt": bson.M{"$ifNull": bson.A{"$revokedAt", at}}}},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	auditColl = "audit"
+)
+
+type AuditStore interface {
+	InsertAuditEntry(context.Context, *types.AuditEntry) (*types.AuditEntry, error)
+	GetAuditEntries(context.Context, map[string]any, *Pagination) (*Page[*types.AuditEntry], error)
+}
+
+type MongoAuditStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewAuditStore(client *mongo.Client) *MongoAuditStore {
+	return &MongoAuditStore{
+		client: client,
+		coll:   client.Database(MongoDBName).Collection(auditColl),
+	}
+}
+
+// createIndexes adds the indexes backing the filtered audit listings.
+func (s *MongoAuditStore) createIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "action", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "subject", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	return err
+}
+
+func (s *MongoAuditStore) InsertAuditEntry(ctx context.Context, entry *types.AuditEntry) (*types.AuditEntry, error) {
+	res, err := s.coll.InsertOne(ctx, entry)
+	if err != nil {
+		return nil, err
+	}
+	entry.ID = res.InsertedID.(primitive.ObjectID)
+	return entry, nil
+}
+
+// GetAuditEntries returns the newest entries first. The filter accepts
+// "action" and "subject".
+func (s *MongoAuditStore) GetAuditEntries(ctx context.Context, filter map[string]any, pag *Pagination) (*Page[*types.AuditEntry], error) {
+	m := bson.M{}
+	for key, value := range filter {
+		switch key {
+		case "action", "subject":
+			m[key] = value
+		default:
+			return nil, fmt.Errorf("unsupported audit filter %q", key)
+		}
+	}
+	return findPage(ctx, s.coll, findQuery{filter: m, desc: true}, pag, AuditCursor)
+}
@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	copyColl = "copies"
+)
+
+type CopyStore interface {
+	InsertCopy(context.Context, *types.Copy) (*types.Copy, error)
+	GetCopies(context.Context, string, map[string]any) ([]*types.Copy, error)
+	GetCopyByID(context.Context, string) (*types.Copy, error)
+	RetireCopy(context.Context, string, time.Time) error
+	ReserveCopy(context.Context, string, types.CopyFormat) (*types.Copy, error)
+	ReleaseCopy(context.Context, string) error
+}
+
+type MongoCopyStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewCopyStore(client *mongo.Client) *MongoCopyStore {
+	return &MongoCopyStore{
+		client: client,
+		coll:   client.Database(MongoDBName).Collection(copyColl),
+	}
+}
+
+func (s *MongoCopyStore) InsertCopy(ctx context.Context, cp *types.Copy) (*types.Copy, error) {
+	res, err := s.coll.InsertOne(ctx, cp)
+	if err != nil {
+		return nil, err
+	}
+	cp.ID = res.InsertedID.(primitive.ObjectID)
+	return cp, nil
+}
+
+// GetCopies lists the copies of a movie. The filter accepts "format",
+// "rented" and "retired".
+func (s *MongoCopyStore) GetCopies(ctx context.Context, movieID string, filter map[string]any) ([]*types.Copy, error) {
+	oid, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return nil, err
+	}
+	m := bson.M{"movieID": oid}
+	for key, value := range filter {
+		switch key {
+		case "format", "rented", "retired":
+			m[key] = value
+		default:
+			return nil, fmt.Errorf("unsupported copy filter %q", key)
+		}
+	}
+	res, err := s.coll.Find(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	var copies []*types.Copy
+	if err := res.All(ctx, &copies); err != nil {
+		return nil, err
+	}
+	return copies, nil
+}
+
+func (s *MongoCopyStore) GetCopyByID(ctx context.Context, id string) (*types.Copy, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var cp types.Copy
+	if err := s.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&cp); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &cp, nil
+}
+
+// RetireCopy takes a copy out of circulation. A rented copy stays with the
+// renter until it is released but can't be reserved again afterwards.
+func (s *MongoCopyStore) RetireCopy(ctx context.Context, id string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": oid, "retired": false}
+	update := bson.M{"$set": bson.M{"retired": true, "retiredAt": at}}
+	res, err := s.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		if _, err := s.GetCopyByID(ctx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReserveCopy atomically marks one available copy of the movie as rented.
+// An empty format reserves a copy of any format.
+func (s *MongoCopyStore) ReserveCopy(ctx context.Context, movieID string, format types.CopyFormat) (*types.Copy, error) {
+	oid, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"movieID": oid, "rented": false, "retired": false}
+	if format != "" {
+		filter["format"] = format
+	}
+	update := bson.M{"$set": bson.M{"rented": true}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var cp types.Copy
+	if err := s.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&cp); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNoCopiesAvailable
+		}
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (s *MongoCopyStore) ReleaseCopy(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"rented": false}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -1,16 +1,143 @@
 package db
 
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
 const (
 	MongoDBName = "mongodb"
 )
 
-type Pagination struct {
-	Page  int
-	Limit int
+var (
+	// ErrNotFound is returned by every store when the requested document
+	// does not exist, regardless of the backend.
+	ErrNotFound = errors.New("resource not found")
+	// ErrAlreadyRented is returned by RentStore.CheckRent when the user
+	// already holds an overlapping rent for the movie.
+	ErrAlreadyRented = errors.New("already rented")
+	// ErrInvalidTransition is returned by RentStore.UpdateRentState when the
+	// rent cannot move from its current state to the requested one, and by
+	// PaymentStore.UpdatePaymentStatus for payments in another status.
+	ErrInvalidTransition = errors.New("invalid rent state transition")
+	// ErrNoCopiesAvailable is returned by CopyStore.ReserveCopy when every
+	// copy of the movie is rented or retired.
+	ErrNoCopiesAvailable = errors.New("no copies available")
+	// ErrInvalidCursor is returned by the list methods when the pagination
+	// cursor is malformed or belongs to a differently sorted list.
+	ErrInvalidCursor = errors.New("invalid cursor")
+	// ErrInsufficientFunds is returned by WalletStore.AppendWalletEntry
+	// for debits larger than the balance of the wallet.
+	ErrInsufficientFunds = errors.New("insufficient funds")
+)
+
+// DuplicateError is returned by UserStore.InsertUser when another user
+// already has the email or username. Field names the clashing field.
+type DuplicateError struct {
+	Field string
+}
+
+func (e *DuplicateError) Error() string {
+	return e.Field + " already in use"
+}
+
+// DuplicateUsers lists users sharing a value that must be unique.
+type DuplicateUsers struct {
+	Field   string
+	Value   string
+	UserIDs []string
+}
+
+// DuplicateUsersError is returned at startup when users stored before the
+// unique indexes existed share an email or username. They have to be merged
+// or deleted before the indexes can be created.
+type DuplicateUsersError struct {
+	Duplicates []DuplicateUsers
+}
+
+func (e *DuplicateUsersError) Error() string {
+	var b strings.Builder
+	b.WriteString("duplicate users found:")
+	for _, d := range e.Duplicates {
+		fmt.Fprintf(&b, "\n\t%s %q: %s", d.Field, d.Value, strings.Join(d.UserIDs, ", "))
+	}
+	return b.String()
 }
 
 type Store struct {
-	User  UserStore
-	Movie MovieStore
-	Rent  RentStore
+	User         UserStore
+	Movie        MovieStore
+	Rent         RentStore
+	Copy         CopyStore
+	Rating       RatingStore
+	Review       ReviewStore
+	Session      SessionStore
+	ActionToken  ActionTokenStore
+	LoginAttempt LoginAttemptStore
+	Audit        AuditStore
+	TOTP         TOTPStore
+	APIKey       APIKeyStore
+	PriceRule    PriceRuleStore
+	Payment      PaymentStore
+	Wallet       WalletStore
+}
+
+func NewMongoStore(client *mongo.Client) *Store {
+	return &Store{
+		User:         NewUserStore(client),
+		Movie:        NewMovieStore(client),
+		Rent:         NewRentStore(client),
+		Copy:         NewCopyStore(client),
+		Rating:       NewRatingStore(client),
+		Review:       NewReviewStore(client),
+		Session:      NewSessionStore(client),
+		ActionToken:  NewActionTokenStore(client),
+		LoginAttempt: NewLoginAttemptStore(client),
+		Audit:        NewAuditStore(client),
+		TOTP:         NewTOTPStore(client),
+		APIKey:       NewAPIKeyStore(client),
+		PriceRule:    NewPriceRuleStore(client),
+		Payment:      NewPaymentStore(client),
+		Wallet:       NewWalletStore(client),
+	}
+}
+
+// EnsureMongoIndexes creates the indexes the Mongo stores rely on. It is
+// safe to call on every startup.
+func EnsureMongoIndexes(ctx context.Context, client *mongo.Client) error {
+	if err := NewUserStore(client).createIndexes(ctx); err != nil {
+		return err
+	}
+	if err := NewMovieStore(client).createIndexes(ctx); err != nil {
+		return err
+	}
+	if err := NewRatingStore(client).createIndexes(ctx); err != nil {
+		return err
+	}
+	if err := NewReviewStore(client).createIndexes(ctx); err != nil {
+		return err
+	}
+	if err := NewSessionStore(client).createIndexes(ctx); err != nil {
+		return err
+	}
+	if err := NewActionTokenStore(client).createIndexes(ctx); err != nil {
+		return err
+	}
+	if err := NewAuditStore(client).createIndexes(ctx); err != nil {
+		return err
+	}
+	if err := NewRentStore(client).createIndexes(ctx); err != nil {
+		return err
+	}
+	if err := NewPaymentStore(client).createIndexes(ctx); err != nil {
+		return err
+	}
+	if err := NewWalletStore(client).createIndexes(ctx); err != nil {
+		return err
+	}
+	return NewAPIKeyStore(client).createIndexes(ctx)
 }
@@ -20,7 +20,10 @@ func AddUser(store *db.Store, fName, lName string, admin bool) *types.User {
 	if err != nil {
 		log.Fatal(err)
 	}
-	user.IsAdmin = admin
+	user.EmailVerified = true
+	if admin {
+		user.Roles = []types.Role{types.RoleAdmin}
+	}
 	insertedUser, err := store.User.InsertUser(context.Background(), user)
 	if err != nil {
 		log.Fatal(err)
@@ -41,3 +44,12 @@ func AddMovie(store *db.Store, title string, genre []string, length int, year in
 	}
 	return insertedMovie
 }
+
+func AddCopy(store *db.Store, movie *types.Movie, format types.CopyFormat) *types.Copy {
+	cp := types.NewCopyFromParams(movie.ID, types.CreateCopyParams{Format: format})
+	insertedCopy, err := store.Copy.InsertCopy(context.Background(), cp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	return insertedCopy
+}
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	loginAttemptColl = "loginAttempts"
+)
+
+// LoginAttemptStore tracks failed logins per throttling key. Backed by a
+// shared database it throttles logins across every instance of the API.
+type LoginAttemptStore interface {
+	GetLoginAttempts(context.Context, string) (*types.LoginAttempts, error)
+	RecordLoginFailure(context.Context, string, time.Time, time.Duration) (*types.LoginAttempts, error)
+	LockLogin(context.Context, string, time.Time) error
+	ResetLoginAttempts(context.Context, string) error
+}
+
+type MongoLoginAttemptStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewLoginAttemptStore(client *mongo.Client) *MongoLoginAttemptStore {
+	return &MongoLoginAttemptStore{
+		client: client,
+		coll:   client.Database(MongoDBName).Collection(loginAttemptColl),
+	}
+}
+
+func (s *MongoLoginAttemptStore) GetLoginAttempts(ctx context.Context, key string) (*types.LoginAttempts, error) {
+	var attempts types.LoginAttempts
+	if err := s.coll.FindOne(ctx, bson.M{"_id": key}).Decode(&attempts); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+// RecordLoginFailure counts a failed login at the given time and returns
+// the updated attempts. Failures older than window are forgotten, so the
+// count starts again from one.
+func (s *MongoLoginAttemptStore) RecordLoginFailure(ctx context.Context, key string, at time.Time, window time.Duration) (*types.LoginAttempts, error) {
+	update := bson.A{bson.M{"$set": bson.M{
+		"failures": bson.M{"$cond": bson.A{
+			bson.M{"$gt": bson.A{"$lastFailure", at.Add(-window)}},
+			bson.M{"$add": bson.A{"$failures", 1}},
+			1,
+		}},
+		"lastFailure": at,
+		"lockedUntil": bson.M{"$ifNull": bson.A{"$lockedUntil", time.Time{}}},
+	}}}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var attempts types.LoginAttempts
+	if err := s.coll.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&attempts); err != nil {
+		return nil, err
+	}
+	return &attempts, nil
+}
+
+// LockLogin locks the key until the given time and clears its failures.
+func (s *MongoLoginAttemptStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	res, err := s.coll.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": bson.M{"lockedUntil": until, "failures": 0}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ResetLoginAttempts forgets the failures and the lock of the key. Resetting
+// a key without attempts is not an error.
+func (s *MongoLoginAttemptStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	_, err := s.coll.DeleteOne(ctx, bson.M{"_id": key})
+	return err
+}
@@ -0,0 +1,59 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ActionTokenStore struct {
+	mu     sync.Mutex
+	tokens map[primitive.ObjectID]types.ActionToken
+}
+
+func NewActionTokenStore() *ActionTokenStore {
+	return &ActionTokenStore{
+		tokens: map[primitive.ObjectID]types.ActionToken{},
+	}
+}
+
+func (s *ActionTokenStore) InsertActionToken(ctx context.Context, token *types.ActionToken) (*types.ActionToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	token.ID = primitive.NewObjectID()
+	s.tokens[token.ID] = *token
+	return token, nil
+}
+
+func (s *ActionTokenStore) UseActionToken(ctx context.Context, purpose types.TokenPurpose, hash string, at time.Time) (*types.ActionToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, token := range s.tokens {
+		if token.Hash != hash || token.Purpose != purpose || token.UsedAt != nil || !at.Before(token.ExpiresAt) {
+			continue
+		}
+		token.UsedAt = &at
+		s.tokens[id] = token
+		return &token, nil
+	}
+	return nil, db.ErrNotFound
+}
+
+func (s *ActionTokenStore) DeleteActionTokens(ctx context.Context, userID string, purpose types.TokenPurpose) error {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, token := range s.tokens {
+		if token.UserID == oid && token.Purpose == purpose {
+			delete(s.tokens, id)
+		}
+	}
+	return nil
+}
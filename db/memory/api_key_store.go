@@ -0,0 +1,115 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type APIKeyStore struct {
+	mu   sync.RWMutex
+	keys map[primitive.ObjectID]types.APIKey
+}
+
+func NewAPIKeyStore() *APIKeyStore {
+	return &APIKeyStore{
+		keys: map[primitive.ObjectID]types.APIKey{},
+	}
+}
+
+func (s *APIKeyStore) InsertAPIKey(ctx context.Context, key *types.APIKey) (*types.APIKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key.ID = primitive.NewObjectID()
+	stored := *key
+	stored.Scopes = slices.Clone(key.Scopes)
+	s.keys[key.ID] = stored
+	return key, nil
+}
+
+func (s *APIKeyStore) GetAPIKeyByID(ctx context.Context, id string) (*types.APIKey, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	key, ok := s.keys[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return &key, nil
+}
+
+func (s *APIKeyStore) GetAPIKeys(ctx context.Context, filter map[string]any, pag *db.Pagination) (*db.Page[*types.APIKey], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := []*types.APIKey{}
+	for _, key := range s.keys {
+		key := key
+		ok, err := matchAPIKey(key, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			keys = append(keys, &key)
+		}
+	}
+	return paginate(keys, pag, "", apiKeyOrder, db.APIKeyCursor)
+}
+
+// apiKeyOrder lists the newest keys first.
+var apiKeyOrder = listOrder[*types.APIKey]{
+	id:   func(key *types.APIKey) primitive.ObjectID { return key.ID },
+	desc: true,
+}
+
+func matchAPIKey(key types.APIKey, filter map[string]any) (bool, error) {
+	for field, value := range filter {
+		switch field {
+		case "userID":
+			if key.UserID != value {
+				return false, nil
+			}
+		default:
+			return false, fmt.Errorf("unsupported api key filter %q", field)
+		}
+	}
+	return true, nil
+}
+
+func (s *APIKeyStore) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	return s.update(id, func(key *types.APIKey) {
+		key.LastUsedAt = &at
+	})
+}
+
+func (s *APIKeyStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	return s.update(id, func(key *types.APIKey) {
+		if key.RevokedAt == nil {
+			key.RevokedAt = &at
+		}
+	})
+}
+
+func (s *APIKeyStore) update(id string, fn func(*types.APIKey)) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key, ok := s.keys[oid]
+	if !ok {
+		return db.ErrNotFound
+	}
+	fn(&key)
+	s.keys[oid] = key
+	return nil
+}
@@ -0,0 +1,69 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type AuditStore struct {
+	mu      sync.RWMutex
+	entries []types.AuditEntry
+}
+
+func NewAuditStore() *AuditStore {
+	return &AuditStore{}
+}
+
+func (s *AuditStore) InsertAuditEntry(ctx context.Context, entry *types.AuditEntry) (*types.AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry.ID = primitive.NewObjectID()
+	s.entries = append(s.entries, *entry)
+	return entry, nil
+}
+
+func (s *AuditStore) GetAuditEntries(ctx context.Context, filter map[string]any, pag *db.Pagination) (*db.Page[*types.AuditEntry], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := []*types.AuditEntry{}
+	for i := range s.entries {
+		entry := s.entries[i]
+		ok, err := matchAuditEntry(entry, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			entries = append(entries, &entry)
+		}
+	}
+	return paginate(entries, pag, "", auditOrder, db.AuditCursor)
+}
+
+// auditOrder lists the newest entries first.
+var auditOrder = listOrder[*types.AuditEntry]{
+	id:   func(entry *types.AuditEntry) primitive.ObjectID { return entry.ID },
+	desc: true,
+}
+
+func matchAuditEntry(entry types.AuditEntry, filter map[string]any) (bool, error) {
+	for key, value := range filter {
+		switch key {
+		case "action":
+			if entry.Action != value {
+				return false, nil
+			}
+		case "subject":
+			if entry.Subject != value {
+				return false, nil
+			}
+		default:
+			return false, fmt.Errorf("unsupported audit filter %q", key)
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,147 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CopyStore struct {
+	mu     sync.RWMutex
+	copies map[primitive.ObjectID]types.Copy
+	order  []primitive.ObjectID
+}
+
+func NewCopyStore() *CopyStore {
+	return &CopyStore{
+		copies: map[primitive.ObjectID]types.Copy{},
+	}
+}
+
+func (s *CopyStore) InsertCopy(ctx context.Context, cp *types.Copy) (*types.Copy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp.ID = primitive.NewObjectID()
+	s.copies[cp.ID] = *cp
+	s.order = append(s.order, cp.ID)
+	return cp, nil
+}
+
+func (s *CopyStore) GetCopies(ctx context.Context, movieID string, filter map[string]any) ([]*types.Copy, error) {
+	oid, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	copies := []*types.Copy{}
+	for _, id := range s.order {
+		cp := s.copies[id]
+		if cp.MovieID != oid {
+			continue
+		}
+		ok, err := matchCopy(cp, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			copies = append(copies, &cp)
+		}
+	}
+	return copies, nil
+}
+
+func matchCopy(cp types.Copy, filter map[string]any) (bool, error) {
+	for key, value := range filter {
+		switch key {
+		case "format":
+			if cp.Format != value {
+				return false, nil
+			}
+		case "rented":
+			if cp.Rented != value {
+				return false, nil
+			}
+		case "retired":
+			if cp.Retired != value {
+				return false, nil
+			}
+		default:
+			return false, fmt.Errorf("unsupported copy filter %q", key)
+		}
+	}
+	return true, nil
+}
+
+func (s *CopyStore) GetCopyByID(ctx context.Context, id string) (*types.Copy, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cp, ok := s.copies[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return &cp, nil
+}
+
+func (s *CopyStore) RetireCopy(ctx context.Context, id string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, ok := s.copies[oid]
+	if !ok {
+		return db.ErrNotFound
+	}
+	if !cp.Retired {
+		cp.Retired = true
+		cp.RetiredAt = &at
+		s.copies[oid] = cp
+	}
+	return nil
+}
+
+func (s *CopyStore) ReserveCopy(ctx context.Context, movieID string, format types.CopyFormat) (*types.Copy, error) {
+	oid, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, id := range s.order {
+		cp := s.copies[id]
+		if cp.MovieID != oid || cp.Rented || cp.Retired || (format != "" && cp.Format != format) {
+			continue
+		}
+		cp.Rented = true
+		s.copies[id] = cp
+		return &cp, nil
+	}
+	return nil, db.ErrNoCopiesAvailable
+}
+
+func (s *CopyStore) ReleaseCopy(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cp, ok := s.copies[oid]
+	if !ok {
+		return db.ErrNotFound
+	}
+	cp.Rented = false
+	s.copies[oid] = cp
+	return nil
+}
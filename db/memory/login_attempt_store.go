@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+// LoginAttemptStore only throttles the instance holding it; deployments
+// running several instances need one of the database backends.
+type LoginAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]types.LoginAttempts
+}
+
+func NewLoginAttemptStore() *LoginAttemptStore {
+	return &LoginAttemptStore{
+		attempts: map[string]types.LoginAttempts{},
+	}
+}
+
+func (s *LoginAttemptStore) GetLoginAttempts(ctx context.Context, key string) (*types.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts, ok := s.attempts[key]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return &attempts, nil
+}
+
+func (s *LoginAttemptStore) RecordLoginFailure(ctx context.Context, key string, at time.Time, window time.Duration) (*types.LoginAttempts, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts, ok := s.attempts[key]
+	if !ok {
+		attempts.Key = key
+	}
+	if attempts.LastFailure.After(at.Add(-window)) {
+		attempts.Failures++
+	} else {
+		attempts.Failures = 1
+	}
+	attempts.LastFailure = at
+	s.attempts[key] = attempts
+	return &attempts, nil
+}
+
+func (s *LoginAttemptStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempts, ok := s.attempts[key]
+	if !ok {
+		return db.ErrNotFound
+	}
+	attempts.LockedUntil = until
+	attempts.Failures = 0
+	s.attempts[key] = attempts
+	return nil
+}
+
+func (s *LoginAttemptStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
@@ -0,0 +1,126 @@
+// Package memory provides thread-safe in-memory implementations of the db
+// store interfaces. They mirror the behaviour of the Mongo stores and are
+// meant for tests and local development.
+package memory
+
+import (
+	"bytes"
+	"cmp"
+	"sort"
+	"strings"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func NewStore() *db.Store {
+	movies := NewMovieStore()
+	return &db.Store{
+		User:         NewUserStore(),
+		Movie:        movies,
+		Rent:         NewRentStore(),
+		Copy:         NewCopyStore(),
+		Rating:       NewRatingStore(movies),
+		Review:       NewReviewStore(),
+		Session:      NewSessionStore(),
+		ActionToken:  NewActionTokenStore(),
+		LoginAttempt: NewLoginAttemptStore(),
+		Audit:        NewAuditStore(),
+		TOTP:         NewTOTPStore(),
+		APIKey:       NewAPIKeyStore(),
+		PriceRule:    NewPriceRuleStore(),
+		Payment:      NewPaymentStore(),
+		Wallet:       NewWalletStore(),
+	}
+}
+
+// listOrder is the order of a keyset paginated list, matching the other
+// backends: by value, then id ascending, or by id alone when value is nil.
+type listOrder[T any] struct {
+	id    func(T) primitive.ObjectID
+	value func(T) any
+	desc  bool
+}
+
+// compare reports whether item sorts before (-1) or after (+1) the position
+// given by value and id.
+func (o listOrder[T]) compare(item T, value any, id primitive.ObjectID) int {
+	itemID := o.id(item)
+	if o.value != nil {
+		if c := compareValues(o.value(item), value); c != 0 {
+			if o.desc {
+				return -c
+			}
+			return c
+		}
+		return bytes.Compare(itemID[:], id[:])
+	}
+	c := bytes.Compare(itemID[:], id[:])
+	if o.desc {
+		return -c
+	}
+	return c
+}
+
+func (o listOrder[T]) valueOf(item T) any {
+	if o.value == nil {
+		return nil
+	}
+	return o.value(item)
+}
+
+// compareValues compares sort key values. Numbers are compared as floats
+// because cursors carry them decoded from JSON.
+func compareValues(a, b any) int {
+	if as, ok := a.(string); ok {
+		bs, _ := b.(string)
+		return strings.Compare(as, bs)
+	}
+	return cmp.Compare(toFloat(a), toFloat(b))
+}
+
+func toFloat(v any) float64 {
+	switch n := v.(type) {
+	case int:
+		return float64(n)
+	case float64:
+		return n
+	}
+	return 0
+}
+
+// paginate sorts items and returns the page following the cursor of pag
+// with the same keyset semantics as the other backends. A nil pag returns
+// every item.
+func paginate[T any](items []T, pag *db.Pagination, key string, order listOrder[T], cursorOf func(T) db.Cursor) (*db.Page[T], error) {
+	c, err := db.PageCursor(pag, key)
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return order.compare(items[i], order.valueOf(items[j]), order.id(items[j])) < 0
+	})
+	total := len(items)
+	if c != nil {
+		after := items[:0:0]
+		for _, item := range items {
+			if order.compare(item, c.Value, c.ID) > 0 {
+				after = append(after, item)
+			}
+		}
+		items = after
+	}
+	if n := db.FetchLimit(pag); n > 0 && n < len(items) {
+		items = items[:n]
+	}
+	return db.NewPage(items, total, pag, cursorOf), nil
+}
+
+func remove(ids []primitive.ObjectID, id primitive.ObjectID) []primitive.ObjectID {
+	for i, v := range ids {
+		if v == id {
+			return append(ids[:i], ids[i+1:]...)
+		}
+	}
+	return ids
+}
@@ -0,0 +1,14 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/db/storetest"
+)
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) *db.Store {
+		return NewStore()
+	})
+}
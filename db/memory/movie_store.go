@@ -0,0 +1,264 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sync"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type MovieStore struct {
+	mu     sync.RWMutex
+	movies map[primitive.ObjectID]types.Movie
+	order  []primitive.ObjectID
+}
+
+func NewMovieStore() *MovieStore {
+	return &MovieStore{
+		movies: map[primitive.ObjectID]types.Movie{},
+	}
+}
+
+func (s *MovieStore) InsertMovie(ctx context.Context, movie *types.Movie) (*types.Movie, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	movie.ID = primitive.NewObjectID()
+	s.movies[movie.ID] = copyMovie(*movie)
+	s.order = append(s.order, movie.ID)
+	return movie, nil
+}
+
+func (s *MovieStore) GetMovies(ctx context.Context, filter map[string]any, pag *db.Pagination) (*db.Page[*types.Movie], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	movies := []*types.Movie{}
+	for _, id := range s.order {
+		movie := s.movies[id]
+		if !matchMovie(movie, filter) {
+			continue
+		}
+		m := copyMovie(movie)
+		movies = append(movies, &m)
+	}
+	return paginate(movies, pag, "", movieOrder(types.MovieSearchParams{}), db.MovieCursor(types.MovieSearchParams{}))
+}
+
+func (s *MovieStore) SearchMovies(ctx context.Context, params types.MovieSearchParams, pag *db.Pagination) (*db.MovieSearchResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	facets := types.MovieFacets{Genre: map[string]int{}, Decade: map[int]int{}}
+	terms := params.Terms()
+	movies := []*types.Movie{}
+	for _, id := range s.order {
+		movie := s.movies[id]
+		if !matchSearch(movie, params, terms) {
+			continue
+		}
+		for _, genre := range movie.Genre {
+			facets.Genre[genre]++
+		}
+		facets.Decade[types.Decade(movie.Year)]++
+		m := copyMovie(movie)
+		movies = append(movies, &m)
+	}
+	page, err := paginate(movies, pag, params.Sort, movieOrder(params), db.MovieCursor(params))
+	if err != nil {
+		return nil, err
+	}
+	return &db.MovieSearchResult{Page: *page, Facets: facets}, nil
+}
+
+func movieOrder(params types.MovieSearchParams) listOrder[*types.Movie] {
+	key, desc := params.SortBy()
+	order := listOrder[*types.Movie]{
+		id:   func(movie *types.Movie) primitive.ObjectID { return movie.ID },
+		desc: desc,
+	}
+	switch key {
+	case types.SortTitle:
+		order.value = func(movie *types.Movie) any { return movie.Title }
+	case types.SortYear:
+		order.value = func(movie *types.Movie) any { return movie.Year }
+	case types.SortRating:
+		order.value = func(movie *types.Movie) any { return movie.RatingAvg }
+	case types.SortPopularity:
+		order.value = func(movie *types.Movie) any { return movie.RatingCount }
+	}
+	return order
+}
+
+func matchSearch(movie types.Movie, params types.MovieSearchParams, terms []string) bool {
+	if len(terms) > 0 {
+		words := types.SearchTerms(movie.Title)
+		for _, term := range terms {
+			if !slices.Contains(words, term) {
+				return false
+			}
+		}
+	}
+	if len(params.Genre) > 0 && !slices.ContainsFunc(params.Genre, func(g string) bool { return slices.Contains(movie.Genre, g) }) {
+		return false
+	}
+	for _, g := range params.GenreAll {
+		if !slices.Contains(movie.Genre, g) {
+			return false
+		}
+	}
+	switch {
+	case params.YearFrom > 0 && movie.Year < params.YearFrom,
+		params.YearTo > 0 && movie.Year > params.YearTo,
+		params.LengthMin > 0 && movie.Length < params.LengthMin,
+		params.LengthMax > 0 && movie.Length > params.LengthMax,
+		params.MinRating > 0 && movie.RatingAvg < params.MinRating:
+		return false
+	}
+	return true
+}
+
+func (s *MovieStore) GetMovieByID(ctx context.Context, id string) (*types.Movie, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	movie, ok := s.movies[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	m := copyMovie(movie)
+	return &m, nil
+}
+
+func (s *MovieStore) PutMovie(ctx context.Context, id string, params types.UpdateMovieParams) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	movie, ok := s.movies[oid]
+	if !ok {
+		return db.ErrNotFound
+	}
+	update := params.ToBSON()
+	if v, ok := update["title"]; ok {
+		movie.Title = v.(string)
+	}
+	if v, ok := update["genre"]; ok {
+		movie.Genre = append([]string(nil), v.([]string)...)
+	}
+	if v, ok := update["length"]; ok {
+		movie.Length = v.(int)
+	}
+	if v, ok := update["year"]; ok {
+		movie.Year = v.(int)
+	}
+	if v, ok := update["rentalHours"]; ok {
+		movie.RentalHours = slices.Clone(v.([]int))
+	}
+	s.movies[oid] = movie
+	return nil
+}
+
+func (s *MovieStore) DeleteMovie(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.movies[oid]; !ok {
+		return db.ErrNotFound
+	}
+	delete(s.movies, oid)
+	s.order = remove(s.order, oid)
+	return nil
+}
+
+func (s *MovieStore) UpdateRatingStats(ctx context.Context, id string, stats types.RatingStats) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	movie, ok := s.movies[oid]
+	if !ok {
+		return db.ErrNotFound
+	}
+	movie.Rating = int(math.Round(stats.Average))
+	movie.RatingAvg = stats.Average
+	movie.RatingCount = stats.Count
+	s.movies[oid] = movie
+	return nil
+}
+
+// matchMovie evaluates the equality filters accepted by MovieStore.GetMovies.
+// Like Mongo, a scalar genre matches any movie containing that genre.
+func matchMovie(movie types.Movie, filter map[string]any) bool {
+	for key, value := range filter {
+		switch key {
+		case "_id":
+			if movie.ID != value {
+				return false
+			}
+		case "title":
+			if movie.Title != value {
+				return false
+			}
+		case "genre":
+			if !matchGenre(movie.Genre, value) {
+				return false
+			}
+		case "length":
+			if movie.Length != value {
+				return false
+			}
+		case "year":
+			if movie.Year != value {
+				return false
+			}
+		case "rating":
+			if movie.Rating != value {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func matchGenre(genres []string, value any) bool {
+	switch v := value.(type) {
+	case string:
+		for _, g := range genres {
+			if g == v {
+				return true
+			}
+		}
+		return false
+	case []string:
+		if len(v) != len(genres) {
+			return false
+		}
+		for i := range v {
+			if v[i] != genres[i] {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func copyMovie(movie types.Movie) types.Movie {
+	movie.Genre = append([]string(nil), movie.Genre...)
+	movie.RentalHours = slices.Clone(movie.RentalHours)
+	return movie
+}
@@ -0,0 +1,94 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PaymentStore struct {
+	mu       sync.RWMutex
+	payments map[primitive.ObjectID]types.Payment
+	order    []primitive.ObjectID
+}
+
+func NewPaymentStore() *PaymentStore {
+	return &PaymentStore{
+		payments: map[primitive.ObjectID]types.Payment{},
+	}
+}
+
+func (s *PaymentStore) InsertPayment(ctx context.Context, payment *types.Payment) (*types.Payment, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payment.ID = primitive.NewObjectID()
+	s.payments[payment.ID] = *payment
+	s.order = append(s.order, payment.ID)
+	return payment, nil
+}
+
+func (s *PaymentStore) GetPaymentByID(ctx context.Context, id string) (*types.Payment, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	payment, ok := s.payments[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return &payment, nil
+}
+
+func (s *PaymentStore) GetPaymentByProviderID(ctx context.Context, provider, providerID string) (*types.Payment, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, payment := range s.payments {
+		if payment.Provider == provider && payment.ProviderID == providerID {
+			return &payment, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (s *PaymentStore) GetPaymentsByRent(ctx context.Context, rentID string) ([]*types.Payment, error) {
+	oid, err := primitive.ObjectIDFromHex(rentID)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	payments := []*types.Payment{}
+	for _, id := range s.order {
+		payment := s.payments[id]
+		if payment.RentID == oid {
+			payments = append(payments, &payment)
+		}
+	}
+	return payments, nil
+}
+
+func (s *PaymentStore) UpdatePaymentStatus(ctx context.Context, id string, from, to types.PaymentStatus, at time.Time) (*types.Payment, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	payment, ok := s.payments[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	if payment.Status != from {
+		return nil, db.ErrInvalidTransition
+	}
+	payment.Status = to
+	payment.UpdatedAt = at
+	s.payments[oid] = payment
+	return &payment, nil
+}
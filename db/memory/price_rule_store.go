@@ -0,0 +1,88 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PriceRuleStore struct {
+	mu    sync.RWMutex
+	rules map[primitive.ObjectID]types.PriceRule
+}
+
+func NewPriceRuleStore() *PriceRuleStore {
+	return &PriceRuleStore{
+		rules: map[primitive.ObjectID]types.PriceRule{},
+	}
+}
+
+func (s *PriceRuleStore) InsertPriceRule(ctx context.Context, rule *types.PriceRule) (*types.PriceRule, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rule.ID = primitive.NewObjectID()
+	s.rules[rule.ID] = *rule
+	return rule, nil
+}
+
+func (s *PriceRuleStore) GetPriceRules(ctx context.Context) ([]*types.PriceRule, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rules := []*types.PriceRule{}
+	for _, rule := range s.rules {
+		rule := rule
+		rules = append(rules, &rule)
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		return bytes.Compare(rules[i].ID[:], rules[j].ID[:]) < 0
+	})
+	return rules, nil
+}
+
+func (s *PriceRuleStore) GetPriceRuleByID(ctx context.Context, id string) (*types.PriceRule, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rule, ok := s.rules[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return &rule, nil
+}
+
+func (s *PriceRuleStore) ReplacePriceRule(ctx context.Context, id string, rule *types.PriceRule) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rules[oid]; !ok {
+		return db.ErrNotFound
+	}
+	rule.ID = oid
+	s.rules[oid] = *rule
+	return nil
+}
+
+func (s *PriceRuleStore) DeletePriceRule(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.rules[oid]; !ok {
+		return db.ErrNotFound
+	}
+	delete(s.rules, oid)
+	return nil
+}
@@ -0,0 +1,107 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ratingKey struct {
+	userID  primitive.ObjectID
+	movieID primitive.ObjectID
+}
+
+type RatingStore struct {
+	mu      sync.RWMutex
+	ratings map[ratingKey]types.Rating
+	movies  *MovieStore
+}
+
+// NewRatingStore returns a rating store keeping the rating stats of the
+// movies in movies.
+func NewRatingStore(movies *MovieStore) *RatingStore {
+	return &RatingStore{
+		ratings: map[ratingKey]types.Rating{},
+		movies:  movies,
+	}
+}
+
+func (s *RatingStore) UpsertRating(ctx context.Context, rating *types.Rating) (*types.Rating, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	key := ratingKey{userID: rating.UserID, movieID: rating.MovieID}
+	upserted, ok := s.ratings[key]
+	if !ok {
+		upserted = *rating
+		upserted.ID = primitive.NewObjectID()
+	}
+	upserted.Rating = rating.Rating
+	upserted.UpdatedAt = rating.UpdatedAt
+	s.ratings[key] = upserted
+	return &upserted, nil
+}
+
+func (s *RatingStore) GetRating(ctx context.Context, userID, movieID string) (*types.Rating, error) {
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	movieOID, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rating, ok := s.ratings[ratingKey{userID: userOID, movieID: movieOID}]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return &rating, nil
+}
+
+func (s *RatingStore) GetRatingStats(ctx context.Context, movieID string) (types.RatingStats, error) {
+	oid, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return types.RatingStats{}, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.stats(oid), nil
+}
+
+func (s *RatingStore) stats(movieID primitive.ObjectID) types.RatingStats {
+	var (
+		stats types.RatingStats
+		sum   int
+	)
+	for key, rating := range s.ratings {
+		if key.movieID != movieID {
+			continue
+		}
+		sum += rating.Rating
+		stats.Count++
+	}
+	if stats.Count > 0 {
+		stats.Average = float64(sum) / float64(stats.Count)
+	}
+	return stats
+}
+
+// RefreshMovieRating holds the ratings lock until the stats are stored, so
+// no rating changes in between.
+func (s *RatingStore) RefreshMovieRating(ctx context.Context, movieID string) (types.RatingStats, error) {
+	oid, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return types.RatingStats{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stats := s.stats(oid)
+	if err := s.movies.UpdateRatingStats(ctx, movieID, stats); err != nil {
+		return types.RatingStats{}, err
+	}
+	return stats, nil
+}
@@ -0,0 +1,251 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type RentStore struct {
+	mu    sync.RWMutex
+	rents map[primitive.ObjectID]types.Rent
+	order []primitive.ObjectID
+}
+
+func NewRentStore() *RentStore {
+	return &RentStore{
+		rents: map[primitive.ObjectID]types.Rent{},
+	}
+}
+
+func (s *RentStore) InsertRent(ctx context.Context, rent *types.Rent) (*types.Rent, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := s.checkRent(types.CheckRentParams{
+		UserID:  rent.UserID,
+		MovieID: rent.MovieID,
+		From:    rent.From,
+		To:      rent.To,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rent.State == "" {
+		rent.State = types.RentActive
+	}
+	rent.ID = primitive.NewObjectID()
+	s.rents[rent.ID] = *rent
+	s.order = append(s.order, rent.ID)
+	return rent, nil
+}
+
+func (s *RentStore) GetRents(ctx context.Context, filter map[string]any, pag *db.Pagination) (*db.Page[*types.Rent], error) {
+	return s.findRents(filter, nil, pag)
+}
+
+func (s *RentStore) GetRentsByUser(ctx context.Context, userID string, filter map[string]any, pag *db.Pagination) (*db.Page[*types.Rent], error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	return s.findRents(filter, &oid, pag)
+}
+
+var rentOrder = listOrder[*types.Rent]{id: func(rent *types.Rent) primitive.ObjectID { return rent.ID }}
+
+func (s *RentStore) findRents(filter map[string]any, userID *primitive.ObjectID, pag *db.Pagination) (*db.Page[*types.Rent], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rents := []*types.Rent{}
+	for _, id := range s.order {
+		rent := s.rents[id]
+		if userID != nil && rent.UserID != *userID {
+			continue
+		}
+		ok, err := matchRent(rent, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			rents = append(rents, &rent)
+		}
+	}
+	return paginate(rents, pag, "", rentOrder, db.RentCursor)
+}
+
+func matchRent(rent types.Rent, filter map[string]any) (bool, error) {
+	for key, value := range filter {
+		switch key {
+		case "state":
+			state, ok := value.(types.RentState)
+			if !ok {
+				return false, fmt.Errorf("invalid state filter %v", value)
+			}
+			if rent.State != state {
+				return false, nil
+			}
+		case "movieID":
+			if rent.MovieID != value {
+				return false, nil
+			}
+		case "userID":
+			if rent.UserID != value {
+				return false, nil
+			}
+		default:
+			return false, fmt.Errorf("unsupported rent filter %q", key)
+		}
+	}
+	return true, nil
+}
+
+func (s *RentStore) GetRentByID(ctx context.Context, id string) (*types.Rent, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rent, ok := s.rents[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return &rent, nil
+}
+
+func (s *RentStore) UpdateRentState(ctx context.Context, id string, state types.RentState, at time.Time) (*types.Rent, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rent, ok := s.rents[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	if !rent.State.CanTransitionTo(state) {
+		return nil, db.ErrInvalidTransition
+	}
+	rent.SetState(state, at)
+	s.rents[oid] = rent
+	return &rent, nil
+}
+
+func (s *RentStore) ExtendRent(ctx context.Context, id string, from, to time.Time, price int64) (*types.Rent, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rent, ok := s.rents[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	if rent.State != types.RentActive || !rent.To.Equal(from) {
+		return nil, db.ErrInvalidTransition
+	}
+	rent.To = to
+	rent.Extensions++
+	rent.Price += price
+	s.rents[oid] = rent
+	return &rent, nil
+}
+
+func (s *RentStore) GetRentsDueBefore(ctx context.Context, at time.Time) ([]*types.Rent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	rents := []*types.Rent{}
+	for _, id := range s.order {
+		rent := s.rents[id]
+		if rent.State.AccruesLateFees() && rent.To.Before(at) {
+			rents = append(rents, &rent)
+		}
+	}
+	sort.SliceStable(rents, func(i, j int) bool {
+		return rents[i].To.Before(rents[j].To)
+	})
+	return rents, nil
+}
+
+func (s *RentStore) AccrueLateFee(ctx context.Context, id string, fee int64) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rent, ok := s.rents[oid]
+	if !ok {
+		return db.ErrNotFound
+	}
+	if !rent.State.AccruesLateFees() {
+		return db.ErrInvalidTransition
+	}
+	rent.LateFee = max(rent.LateFee, fee)
+	s.rents[oid] = rent
+	return nil
+}
+
+func (s *RentStore) SettleLateFee(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rent, ok := s.rents[oid]
+	if !ok {
+		return db.ErrNotFound
+	}
+	if rent.State != types.RentReturned || rent.LateFeePaid {
+		return db.ErrInvalidTransition
+	}
+	rent.LateFeePaid = true
+	s.rents[oid] = rent
+	return nil
+}
+
+func (s *RentStore) GetLateFees(ctx context.Context, userID string) (int64, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var total int64
+	for _, rent := range s.rents {
+		if rent.UserID == oid && !rent.LateFeePaid {
+			total += rent.LateFee
+		}
+	}
+	return total, nil
+}
+
+// CheckRent reports a pending or active rent by the same user for the same
+// movie whose period overlaps params.From and params.To, or any overdue one,
+// like the other backends.
+func (s *RentStore) CheckRent(ctx context.Context, params types.CheckRentParams) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.checkRent(params)
+}
+
+func (s *RentStore) checkRent(params types.CheckRentParams) error {
+	for _, rent := range s.rents {
+		if rent.UserID != params.UserID || rent.MovieID != params.MovieID || !rent.State.IsOpen() {
+			continue
+		}
+		if rent.State == types.RentOverdue || rent.From.Before(params.To) && rent.To.After(params.From) {
+			return db.ErrAlreadyRented
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ReviewStore struct {
+	mu      sync.RWMutex
+	reviews map[primitive.ObjectID]types.Review
+	order   []primitive.ObjectID
+}
+
+func NewReviewStore() *ReviewStore {
+	return &ReviewStore{
+		reviews: map[primitive.ObjectID]types.Review{},
+	}
+}
+
+func (s *ReviewStore) InsertReview(ctx context.Context, review *types.Review) (*types.Review, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	review.ID = primitive.NewObjectID()
+	s.reviews[review.ID] = *review
+	s.order = append(s.order, review.ID)
+	return review, nil
+}
+
+func (s *ReviewStore) GetReviewByID(ctx context.Context, id string) (*types.Review, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	review, ok := s.reviews[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return &review, nil
+}
+
+func (s *ReviewStore) GetReviews(ctx context.Context, filter map[string]any, pag *db.Pagination) (*db.Page[*types.Review], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	reviews := []*types.Review{}
+	for _, id := range s.order {
+		review := s.reviews[id]
+		ok, err := matchReview(review, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			reviews = append(reviews, &review)
+		}
+	}
+	return paginate(reviews, pag, "", reviewOrder, db.ReviewCursor)
+}
+
+// reviewOrder lists the newest reviews first.
+var reviewOrder = listOrder[*types.Review]{
+	id:   func(review *types.Review) primitive.ObjectID { return review.ID },
+	desc: true,
+}
+
+func matchReview(review types.Review, filter map[string]any) (bool, error) {
+	for key, value := range filter {
+		switch key {
+		case "movieID":
+			if review.MovieID != value {
+				return false, nil
+			}
+		case "userID":
+			if review.UserID != value {
+				return false, nil
+			}
+		case "status":
+			if review.Status != value {
+				return false, nil
+			}
+		default:
+			return false, fmt.Errorf("unsupported review filter %q", key)
+		}
+	}
+	return true, nil
+}
+
+func (s *ReviewStore) UpdateReviewBody(ctx context.Context, id string, body string, at time.Time) error {
+	return s.update(id, func(review *types.Review) {
+		review.Body = body
+		review.Status = types.ReviewPending
+		review.UpdatedAt = at
+	})
+}
+
+func (s *ReviewStore) UpdateReviewStatus(ctx context.Context, id string, status types.ReviewStatus, at time.Time) error {
+	return s.update(id, func(review *types.Review) {
+		review.Status = status
+		review.UpdatedAt = at
+	})
+}
+
+func (s *ReviewStore) update(id string, fn func(*types.Review)) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	review, ok := s.reviews[oid]
+	if !ok {
+		return db.ErrNotFound
+	}
+	fn(&review)
+	s.reviews[oid] = review
+	return nil
+}
+
+func (s *ReviewStore) DeleteReview(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.reviews[oid]; !ok {
+		return db.ErrNotFound
+	}
+	delete(s.reviews, oid)
+	s.order = remove(s.order, oid)
+	return nil
+}
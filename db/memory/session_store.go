@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type SessionStore struct {
+	mu       sync.RWMutex
+	sessions map[primitive.ObjectID]types.Session
+}
+
+func NewSessionStore() *SessionStore {
+	return &SessionStore{
+		sessions: map[primitive.ObjectID]types.Session{},
+	}
+}
+
+func (s *SessionStore) InsertSession(ctx context.Context, session *types.Session) (*types.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session.ID = primitive.NewObjectID()
+	s.sessions[session.ID] = *session
+	return session, nil
+}
+
+func (s *SessionStore) GetSessionByID(ctx context.Context, id string) (*types.Session, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	session, ok := s.sessions[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return &session, nil
+}
+
+func (s *SessionStore) RotateSession(ctx context.Context, id string, oldHash string, newHash string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[oid]
+	if !ok || session.RefreshHash != oldHash || session.RevokedAt != nil {
+		return db.ErrNotFound
+	}
+	session.RefreshHash = newHash
+	s.sessions[oid] = session
+	return nil
+}
+
+func (s *SessionStore) RevokeSession(ctx context.Context, id string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	session, ok := s.sessions[oid]
+	if !ok {
+		return db.ErrNotFound
+	}
+	if session.RevokedAt == nil {
+		session.RevokedAt = &at
+		s.sessions[oid] = session
+	}
+	return nil
+}
+
+func (s *SessionStore) RevokeUserSessions(ctx context.Context, userID string, at time.Time) (int, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for id, session := range s.sessions {
+		if session.UserID != oid || !session.IsActive(at) {
+			continue
+		}
+		session.RevokedAt = &at
+		s.sessions[id] = session
+		n++
+	}
+	return n, nil
+}
@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type TOTPStore struct {
+	mu    sync.RWMutex
+	totps map[primitive.ObjectID]types.TOTP
+}
+
+func NewTOTPStore() *TOTPStore {
+	return &TOTPStore{
+		totps: map[primitive.ObjectID]types.TOTP{},
+	}
+}
+
+func (s *TOTPStore) StartTOTP(ctx context.Context, totp *types.TOTP) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if existing, ok := s.totps[totp.UserID]; ok && existing.Confirmed {
+		return db.ErrTOTPEnabled
+	}
+	s.totps[totp.UserID] = *totp
+	return nil
+}
+
+func (s *TOTPStore) GetTOTP(ctx context.Context, userID string) (*types.TOTP, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	totp, ok := s.totps[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	totp.RecoveryHashes = slices.Clone(totp.RecoveryHashes)
+	return &totp, nil
+}
+
+func (s *TOTPStore) ConfirmTOTP(ctx context.Context, userID string, step int64, recoveryHashes []string) error {
+	return s.update(userID, func(totp *types.TOTP) bool {
+		if totp.Confirmed {
+			return false
+		}
+		totp.Confirmed = true
+		totp.LastStep = step
+		totp.RecoveryHashes = slices.Clone(recoveryHashes)
+		return true
+	})
+}
+
+func (s *TOTPStore) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return s.update(userID, func(totp *types.TOTP) bool {
+		if !totp.Confirmed || totp.LastStep >= step {
+			return false
+		}
+		totp.LastStep = step
+		return true
+	})
+}
+
+func (s *TOTPStore) UseRecoveryCode(ctx context.Context, userID string, hash string) error {
+	return s.update(userID, func(totp *types.TOTP) bool {
+		i := slices.Index(totp.RecoveryHashes, hash)
+		if !totp.Confirmed || i < 0 {
+			return false
+		}
+		totp.RecoveryHashes = slices.Delete(slices.Clone(totp.RecoveryHashes), i, i+1)
+		return true
+	})
+}
+
+// update applies fn to the enrolment of the user, returning ErrNotFound
+// when there is none or fn reports it doesn't apply.
+func (s *TOTPStore) update(userID string, fn func(*types.TOTP) bool) error {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	totp, ok := s.totps[oid]
+	if !ok || !fn(&totp) {
+		return db.ErrNotFound
+	}
+	s.totps[oid] = totp
+	return nil
+}
+
+func (s *TOTPStore) DeleteTOTP(ctx context.Context, userID string) error {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.totps[oid]; !ok {
+		return db.ErrNotFound
+	}
+	delete(s.totps, oid)
+	return nil
+}
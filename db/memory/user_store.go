@@ -0,0 +1,223 @@
+package memory
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type UserStore struct {
+	mu    sync.RWMutex
+	users map[primitive.ObjectID]types.User
+	order []primitive.ObjectID
+}
+
+func NewUserStore() *UserStore {
+	return &UserStore{
+		users: map[primitive.ObjectID]types.User{},
+	}
+}
+
+func (s *UserStore) InsertUser(ctx context.Context, user *types.User) (*types.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, other := range s.users {
+		if other.Email == user.Email {
+			return nil, &db.DuplicateError{Field: "email"}
+		}
+		if other.Username == user.Username {
+			return nil, &db.DuplicateError{Field: "username"}
+		}
+		if user.Identity != nil && other.Identity != nil && *other.Identity == *user.Identity {
+			return nil, &db.DuplicateError{Field: "identity"}
+		}
+	}
+	user.ID = primitive.NewObjectID()
+	stored := *user
+	stored.Roles = slices.Clone(user.Roles)
+	if user.Identity != nil {
+		identity := *user.Identity
+		stored.Identity = &identity
+	}
+	s.users[user.ID] = stored
+	s.order = append(s.order, user.ID)
+	return user, nil
+}
+
+func (s *UserStore) GetUsers(ctx context.Context, pag *db.Pagination) (*db.Page[*types.User], error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	users := make([]*types.User, 0, len(s.order))
+	for _, id := range s.order {
+		user := s.users[id]
+		users = append(users, &user)
+	}
+	return paginate(users, pag, "", userOrder, db.UserCursor)
+}
+
+var userOrder = listOrder[*types.User]{id: func(user *types.User) primitive.ObjectID { return user.ID }}
+
+func (s *UserStore) GetUserByID(ctx context.Context, id string) (*types.User, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	user, ok := s.users[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	return &user, nil
+}
+
+func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (*types.User, error) {
+	email = types.NormalizeEmail(email)
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, id := range s.order {
+		if user := s.users[id]; user.Email == email {
+			return &user, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (s *UserStore) GetUserByIdentity(ctx context.Context, identity types.Identity) (*types.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, id := range s.order {
+		if user := s.users[id]; user.Identity != nil && *user.Identity == identity {
+			return &user, nil
+		}
+	}
+	return nil, db.ErrNotFound
+}
+
+func (s *UserStore) LinkIdentity(ctx context.Context, id string, identity types.Identity) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[oid]
+	if !ok || user.Identity != nil {
+		return db.ErrNotFound
+	}
+	for _, other := range s.users {
+		if other.Identity != nil && *other.Identity == identity {
+			return &db.DuplicateError{Field: "identity"}
+		}
+	}
+	user.Identity = &identity
+	s.users[oid] = user
+	return nil
+}
+
+func (s *UserStore) UpdateUserRoles(ctx context.Context, id string, roles []types.Role) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[oid]
+	if !ok {
+		return db.ErrNotFound
+	}
+	user.Roles = slices.Clone(roles)
+	s.users[oid] = user
+	return nil
+}
+
+func (s *UserStore) UpdateUserPassword(ctx context.Context, id string, encryptedPassword string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[oid]
+	if !ok {
+		return db.ErrNotFound
+	}
+	user.EncryptedPassword = encryptedPassword
+	s.users[oid] = user
+	return nil
+}
+
+func (s *UserStore) SetEmailVerified(ctx context.Context, id string, verified bool) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[oid]
+	if !ok {
+		return db.ErrNotFound
+	}
+	user.EmailVerified = verified
+	s.users[oid] = user
+	return nil
+}
+
+func (s *UserStore) UpdateUser(ctx context.Context, id string, params types.UpdateUserParams) (*types.User, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	user, ok := s.users[oid]
+	if !ok {
+		return nil, db.ErrNotFound
+	}
+	for otherID, other := range s.users {
+		if otherID == oid {
+			continue
+		}
+		if params.Email != "" && other.Email == params.Email {
+			return nil, &db.DuplicateError{Field: "email"}
+		}
+		if params.Username != "" && other.Username == params.Username {
+			return nil, &db.DuplicateError{Field: "username"}
+		}
+	}
+	if params.Username != "" {
+		user.Username = params.Username
+	}
+	if params.FirstName != "" {
+		user.FirstName = params.FirstName
+	}
+	if params.LastName != "" {
+		user.LastName = params.LastName
+	}
+	if params.Email != "" && params.Email != user.Email {
+		user.Email = params.Email
+		user.EmailVerified = false
+	}
+	s.users[oid] = user
+	user.Roles = slices.Clone(user.Roles)
+	return &user, nil
+}
+
+func (s *UserStore) DeleteUser(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.users[oid]; !ok {
+		return db.ErrNotFound
+	}
+	delete(s.users, oid)
+	s.order = remove(s.order, oid)
+	return nil
+}
@@ -0,0 +1,74 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type WalletStore struct {
+	mu      sync.RWMutex
+	entries map[primitive.ObjectID][]types.WalletEntry
+}
+
+func NewWalletStore() *WalletStore {
+	return &WalletStore{
+		entries: map[primitive.ObjectID][]types.WalletEntry{},
+	}
+}
+
+func (s *WalletStore) AppendWalletEntry(ctx context.Context, entry *types.WalletEntry) (*types.WalletEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	balance := s.balance(entry.UserID)
+	if entry.Amount < 0 && balance+entry.Amount < 0 {
+		return nil, db.ErrInsufficientFunds
+	}
+	entry.ID = primitive.NewObjectID()
+	entry.Seq = int64(len(s.entries[entry.UserID]) + 1)
+	entry.Balance = balance + entry.Amount
+	s.entries[entry.UserID] = append(s.entries[entry.UserID], *entry)
+	return entry, nil
+}
+
+func (s *WalletStore) balance(userID primitive.ObjectID) int64 {
+	entries := s.entries[userID]
+	if len(entries) == 0 {
+		return 0
+	}
+	return entries[len(entries)-1].Balance
+}
+
+func (s *WalletStore) GetWalletBalance(ctx context.Context, userID string) (int64, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.balance(oid), nil
+}
+
+func (s *WalletStore) GetWalletEntries(ctx context.Context, userID string, pag *db.Pagination) (*db.Page[*types.WalletEntry], error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	entries := []*types.WalletEntry{}
+	for i := range s.entries[oid] {
+		entry := s.entries[oid][i]
+		entries = append(entries, &entry)
+	}
+	return paginate(entries, pag, "", walletOrder, db.WalletEntryCursor)
+}
+
+// walletOrder lists the newest entries first.
+var walletOrder = listOrder[*types.WalletEntry]{
+	id:   func(entry *types.WalletEntry) primitive.ObjectID { return entry.ID },
+	desc: true,
+}
@@ -0,0 +1,74 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/db/storetest"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func TestMongoStore(t *testing.T) {
+	godotenv.Load("../.env")
+	url := os.Getenv("MONGO_DB_URL_TEST")
+	if url == "" {
+		t.Skip("MONGO_DB_URL_TEST not set")
+	}
+	client, err := mongo.Connect(context.TODO(), options.Client().ApplyURI(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	storetest.Run(t, func(t *testing.T) *db.Store {
+		t.Cleanup(func() {
+			if err := client.Database(db.MongoDBName).Drop(context.TODO()); err != nil {
+				t.Fatal(err)
+			}
+		})
+		if err := db.EnsureMongoIndexes(context.TODO(), client); err != nil {
+			t.Fatal(err)
+		}
+		return db.NewMongoStore(client)
+	})
+}
+
+func TestCheckDuplicateUsers(t *testing.T) {
+	godotenv.Load("../.env")
+	url := os.Getenv("MONGO_DB_URL_TEST")
+	if url == "" {
+		t.Skip("MONGO_DB_URL_TEST not set")
+	}
+	ctx := context.TODO()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(url))
+	if err != nil {
+		t.Fatal(err)
+	}
+	database := client.Database(db.MongoDBName)
+	t.Cleanup(func() {
+		if err := database.Drop(ctx); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// users stored before the unique indexes existed
+	_, err = database.Collection("users").InsertMany(ctx, []any{
+		bson.M{"username": "tomek", "email": "Tomek@test.com"},
+		bson.M{"username": "tomek2", "email": "tomek@test.com "},
+		bson.M{"username": "zuzia", "email": "zuzia@test.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.CheckDuplicateUsers(ctx, client)
+	var dupErr *db.DuplicateUsersError
+	if !errors.As(err, &dupErr) {
+		t.Fatalf("expected a DuplicateUsersError but got %v", err)
+	}
+	if len(dupErr.Duplicates) != 1 || dupErr.Duplicates[0].Value != "tomek@test.com" || len(dupErr.Duplicates[0].UserIDs) != 2 {
+		t.Fatalf("expected the two tomek users to be reported but got %+v", dupErr.Duplicates)
+	}
+}
@@ -2,6 +2,9 @@ package db
 
 import (
 	"context"
+	"errors"
+	"math"
+	"strings"
 
 	"github.com/tomekzakrzewski/go-movierental/types"
 	"go.mongodb.org/mongo-driver/bson"
@@ -16,11 +19,19 @@ const (
 
 type MovieStore interface {
 	InsertMovie(context.Context, *types.Movie) (*types.Movie, error)
-	GetMovies(context.Context, map[string]any, *Pagination) ([]*types.Movie, error)
+	GetMovies(context.Context, map[string]any, *Pagination) (*Page[*types.Movie], error)
+	SearchMovies(context.Context, types.MovieSearchParams, *Pagination) (*MovieSearchResult, error)
 	GetMovieByID(context.Context, string) (*types.Movie, error)
 	PutMovie(context.Context, string, types.UpdateMovieParams) error
 	DeleteMovie(context.Context, string) error
-	UpdateRating(context.Context, string, int) error
+	UpdateRatingStats(context.Context, string, types.RatingStats) error
+}
+
+// MovieSearchResult is a page of a movie search with the facet counts of
+// every matching movie.
+type MovieSearchResult struct {
+	Page[*types.Movie]
+	Facets types.MovieFacets
 }
 
 type MongoMovieStore struct {
@@ -35,6 +46,20 @@ func NewMovieStore(client *mongo.Client) *MongoMovieStore {
 	}
 }
 
+// createIndexes adds the title text index used by SearchMovies. Stemming is
+// turned off so title queries match whole words like the other backends.
+func (s *MongoMovieStore) createIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "title", Value: "text"}},
+			Options: options.Index().SetDefaultLanguage("none"),
+		},
+		{Keys: bson.D{{Key: "genre", Value: 1}}},
+		{Keys: bson.D{{Key: "year", Value: 1}}},
+	})
+	return err
+}
+
 func (s *MongoMovieStore) InsertMovie(ctx context.Context, movie *types.Movie) (*types.Movie, error) {
 	res, err := s.coll.InsertOne(ctx, movie)
 	if err != nil {
@@ -44,21 +69,136 @@ func (s *MongoMovieStore) InsertMovie(ctx context.Context, movie *types.Movie) (
 	return movie, nil
 }
 
-func (s *MongoMovieStore) GetMovies(ctx context.Context, filter map[string]any, pag *Pagination) ([]*types.Movie, error) {
-	opts := options.Find()
-	opts.SetSkip(int64(pag.Page) * int64(pag.Limit))
-	opts.SetLimit(int64(pag.Limit))
-	//res, err := s.coll.Find(ctx, bson.M{})
-	res, err := s.coll.Find(ctx, filter, opts)
+func (s *MongoMovieStore) GetMovies(ctx context.Context, filter map[string]any, pag *Pagination) (*Page[*types.Movie], error) {
+	m := bson.M{}
+	for key, value := range filter {
+		m[key] = value
+	}
+	return findPage(ctx, s.coll, findQuery{filter: m}, pag, func(movie *types.Movie) Cursor { return Cursor{ID: movie.ID} })
+}
+
+var movieSortFields = map[types.MovieSort]string{
+	types.SortTitle:      "title",
+	types.SortYear:       "year",
+	types.SortRating:     "ratingAvg",
+	types.SortPopularity: "ratingCount",
+}
+
+// SearchMovies runs the search and the facet counts in a single aggregation.
+// The total and the facets cover every matching movie, not only the page.
+func (s *MongoMovieStore) SearchMovies(ctx context.Context, params types.MovieSearchParams, pag *Pagination) (*MovieSearchResult, error) {
+	c, err := PageCursor(pag, params.Sort)
 	if err != nil {
 		return nil, err
 	}
-	var movies []*types.Movie
-	err = res.All(ctx, &movies)
+	key, desc := params.SortBy()
+	q := findQuery{sortKey: params.Sort, sortField: movieSortFields[key], desc: desc}
+	page := bson.A{}
+	if c != nil {
+		page = append(page, bson.M{"$match": q.after(c)})
+	}
+	page = append(page, bson.M{"$sort": q.sort()})
+	if n := FetchLimit(pag); n > 0 {
+		page = append(page, bson.M{"$limit": n})
+	}
+	pipeline := bson.A{
+		bson.M{"$match": movieSearchFilter(params)},
+		bson.M{"$facet": bson.M{
+			"movies": page,
+			"total":  bson.A{bson.M{"$count": "count"}},
+			"genre": bson.A{
+				bson.M{"$unwind": "$genre"},
+				bson.M{"$group": bson.M{"_id": "$genre", "count": bson.M{"$sum": 1}}},
+			},
+			"decade": bson.A{
+				bson.M{"$group": bson.M{
+					"_id":   bson.M{"$subtract": bson.A{"$year", bson.M{"$mod": bson.A{"$year", 10}}}},
+					"count": bson.M{"$sum": 1},
+				}},
+			},
+		}},
+	}
+	cur, err := s.coll.Aggregate(ctx, pipeline)
 	if err != nil {
 		return nil, err
 	}
-	return movies, nil
+	var res []struct {
+		Movies []*types.Movie `bson:"movies"`
+		Total  []struct {
+			Count int `bson:"count"`
+		} `bson:"total"`
+		Genre []struct {
+			Genre string `bson:"_id"`
+			Count int    `bson:"count"`
+		} `bson:"genre"`
+		Decade []struct {
+			Decade int `bson:"_id"`
+			Count  int `bson:"count"`
+		} `bson:"decade"`
+	}
+	if err := cur.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	var (
+		movies []*types.Movie
+		total  int
+		facets = types.MovieFacets{Genre: map[string]int{}, Decade: map[int]int{}}
+	)
+	if len(res) > 0 {
+		movies = res[0].Movies
+		if len(res[0].Total) > 0 {
+			total = res[0].Total[0].Count
+		}
+		for _, g := range res[0].Genre {
+			facets.Genre[g.Genre] = g.Count
+		}
+		for _, d := range res[0].Decade {
+			facets.Decade[d.Decade] = d.Count
+		}
+	}
+	return &MovieSearchResult{
+		Page:   *NewPage(movies, total, pag, MovieCursor(params)),
+		Facets: facets,
+	}, nil
+}
+
+func movieSearchFilter(params types.MovieSearchParams) bson.M {
+	filter := bson.M{}
+	if terms := params.Terms(); len(terms) > 0 {
+		// Quoting every term makes $text require all of them.
+		filter["$text"] = bson.M{"$search": `"` + strings.Join(terms, `" "`) + `"`}
+	}
+	genre := bson.M{}
+	if len(params.Genre) > 0 {
+		genre["$in"] = params.Genre
+	}
+	if len(params.GenreAll) > 0 {
+		genre["$all"] = params.GenreAll
+	}
+	if len(genre) > 0 {
+		filter["genre"] = genre
+	}
+	if r := numberRange(params.YearFrom, params.YearTo); len(r) > 0 {
+		filter["year"] = r
+	}
+	if r := numberRange(params.LengthMin, params.LengthMax); len(r) > 0 {
+		filter["length"] = r
+	}
+	if params.MinRating > 0 {
+		filter["ratingAvg"] = bson.M{"$gte": params.MinRating}
+	}
+	return filter
+}
+
+func numberRange(from, to int) bson.M {
+	r := bson.M{}
+	if from > 0 {
+		r["$gte"] = from
+	}
+	if to > 0 {
+		r["$lte"] = to
+	}
+	return r
 }
 
 func (s *MongoMovieStore) PutMovie(ctx context.Context, id string, params types.UpdateMovieParams) error {
@@ -68,10 +208,13 @@ func (s *MongoMovieStore) PutMovie(ctx context.Context, id string, params types.
 	}
 	filter := bson.M{"_id": oid}
 	update := bson.M{"$set": params.ToBSON()}
-	_, err = s.coll.UpdateOne(ctx, filter, update)
+	res, err := s.coll.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
 
@@ -81,10 +224,13 @@ func (s *MongoMovieStore) DeleteMovie(ctx context.Context, id string) error {
 		return err
 	}
 	filter := bson.M{"_id": oid}
-	_, err = s.coll.DeleteOne(ctx, filter)
+	res, err := s.coll.DeleteOne(ctx, filter)
 	if err != nil {
 		return err
 	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
 
@@ -97,21 +243,31 @@ func (s *MongoMovieStore) GetMovieByID(ctx context.Context, id string) (*types.M
 	var movie types.Movie
 	err = s.coll.FindOne(ctx, filter).Decode(&movie)
 	if err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 	return &movie, nil
 }
 
-func (s *MongoMovieStore) UpdateRating(ctx context.Context, id string, rating int) error {
+func (s *MongoMovieStore) UpdateRatingStats(ctx context.Context, id string, stats types.RatingStats) error {
 	oid, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return err
 	}
 	filter := bson.M{"_id": oid}
-	update := bson.M{"$set": bson.M{"rating": rating}}
-	_, err = s.coll.UpdateOne(ctx, filter, update)
+	update := bson.M{"$set": bson.M{
+		"rating":      int(math.Round(stats.Average)),
+		"ratingAvg":   stats.Average,
+		"ratingCount": stats.Count,
+	}}
+	res, err := s.coll.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return err
 	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
@@ -0,0 +1,217 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	DefaultLimit = 20
+	MaxLimit     = 100
+)
+
+// Pagination selects the page of a list that follows Cursor, the first page
+// when it is empty. A zero Limit returns every remaining item; the handlers
+// apply DefaultLimit and MaxLimit before reaching the stores.
+type Pagination struct {
+	Limit  int
+	Cursor string
+}
+
+// Clamp applies DefaultLimit to a missing limit and caps it at MaxLimit.
+func (p *Pagination) Clamp() {
+	if p.Limit <= 0 {
+		p.Limit = DefaultLimit
+	}
+	if p.Limit > MaxLimit {
+		p.Limit = MaxLimit
+	}
+}
+
+// Page is one page of a list. Total counts every item matching the filter,
+// not only the ones on this page.
+type Page[T any] struct {
+	Items      []T
+	Total      int
+	HasNext    bool
+	NextCursor string
+}
+
+// Cursor is the position of the last item of a page: its sort key, the
+// value of that key and its id, which breaks ties. Lists ordered by id alone
+// leave Key and Value empty. Clients only ever see it encoded.
+type Cursor struct {
+	ID    primitive.ObjectID `json:"id"`
+	Key   string             `json:"k,omitempty"`
+	Value any                `json:"v,omitempty"`
+}
+
+func (c Cursor) Encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor parses an encoded cursor and checks it was issued for a list
+// sorted by key.
+func DecodeCursor(s string, key string) (*Cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var c Cursor
+	if err := json.Unmarshal(b, &c); err != nil || c.ID.IsZero() || c.Key != key {
+		return nil, ErrInvalidCursor
+	}
+	if key != "" && c.Value == nil {
+		return nil, ErrInvalidCursor
+	}
+	return &c, nil
+}
+
+// PageCursor decodes the cursor of pag, returning nil for the first page.
+func PageCursor(pag *Pagination, key string) (*Cursor, error) {
+	if pag == nil || pag.Cursor == "" {
+		return nil, nil
+	}
+	return DecodeCursor(pag.Cursor, key)
+}
+
+// FetchLimit is the number of items a store should read for pag: one more
+// than the limit so NewPage can tell whether another page follows, or zero
+// for no limit.
+func FetchLimit(pag *Pagination) int {
+	if pag == nil || pag.Limit <= 0 {
+		return 0
+	}
+	return pag.Limit + 1
+}
+
+// NewPage builds a page from items read with FetchLimit.
+func NewPage[T any](items []T, total int, pag *Pagination, cursorOf func(T) Cursor) *Page[T] {
+	if items == nil {
+		items = []T{}
+	}
+	page := &Page[T]{Items: items, Total: total}
+	if pag != nil && pag.Limit > 0 && len(items) > pag.Limit {
+		page.Items = items[:pag.Limit]
+		page.HasNext = true
+		page.NextCursor = cursorOf(page.Items[pag.Limit-1]).Encode()
+	}
+	return page
+}
+
+func UserCursor(user *types.User) Cursor {
+	return Cursor{ID: user.ID}
+}
+
+func RentCursor(rent *types.Rent) Cursor {
+	return Cursor{ID: rent.ID}
+}
+
+func ReviewCursor(review *types.Review) Cursor {
+	return Cursor{ID: review.ID}
+}
+
+func AuditCursor(entry *types.AuditEntry) Cursor {
+	return Cursor{ID: entry.ID}
+}
+
+func APIKeyCursor(key *types.APIKey) Cursor {
+	return Cursor{ID: key.ID}
+}
+
+func WalletEntryCursor(entry *types.WalletEntry) Cursor {
+	return Cursor{ID: entry.ID}
+}
+
+// MovieCursor returns the cursor function of a movie search. The cursor of
+// a sorted search carries the value of the sort key.
+func MovieCursor(params types.MovieSearchParams) func(*types.Movie) Cursor {
+	key, _ := params.SortBy()
+	return func(movie *types.Movie) Cursor {
+		c := Cursor{ID: movie.ID, Key: params.Sort}
+		switch key {
+		case types.SortTitle:
+			c.Value = movie.Title
+		case types.SortYear:
+			c.Value = movie.Year
+		case types.SortRating:
+			c.Value = movie.RatingAvg
+		case types.SortPopularity:
+			c.Value = movie.RatingCount
+		}
+		return c
+	}
+}
+
+// findQuery describes a keyset paginated Mongo list. Documents are sorted
+// by sortField, then _id ascending, or by _id alone when sortField is empty.
+type findQuery struct {
+	filter    bson.M
+	sortKey   string
+	sortField string
+	desc      bool
+}
+
+// after selects the documents following the cursor.
+func (q findQuery) after(c *Cursor) bson.M {
+	op := "$gt"
+	if q.desc {
+		op = "$lt"
+	}
+	if q.sortField == "" {
+		return bson.M{"_id": bson.M{op: c.ID}}
+	}
+	return bson.M{"$or": bson.A{
+		bson.M{q.sortField: bson.M{op: c.Value}},
+		bson.M{q.sortField: c.Value, "_id": bson.M{"$gt": c.ID}},
+	}}
+}
+
+func (q findQuery) sort() bson.D {
+	dir := 1
+	if q.desc {
+		dir = -1
+	}
+	if q.sortField == "" {
+		return bson.D{{Key: "_id", Value: dir}}
+	}
+	return bson.D{{Key: q.sortField, Value: dir}, {Key: "_id", Value: 1}}
+}
+
+// findPage reads the page of q selected by pag and counts every document
+// matching its filter.
+func findPage[T any](ctx context.Context, coll *mongo.Collection, q findQuery, pag *Pagination, cursorOf func(T) Cursor) (*Page[T], error) {
+	c, err := PageCursor(pag, q.sortKey)
+	if err != nil {
+		return nil, err
+	}
+	total, err := coll.CountDocuments(ctx, q.filter)
+	if err != nil {
+		return nil, err
+	}
+	filter := q.filter
+	if c != nil {
+		filter = bson.M{"$and": bson.A{q.filter, q.after(c)}}
+	}
+	opts := options.Find().SetSort(q.sort())
+	if n := FetchLimit(pag); n > 0 {
+		opts.SetLimit(int64(n))
+	}
+	cur, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	var items []T
+	if err := cur.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return NewPage(items, int(total), pag, cursorOf), nil
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	paymentColl = "payments"
+)
+
+type PaymentStore interface {
+	InsertPayment(context.Context, *types.Payment) (*types.Payment, error)
+	GetPaymentByID(context.Context, string) (*types.Payment, error)
+	GetPaymentByProviderID(context.Context, string, string) (*types.Payment, error)
+	GetPaymentsByRent(context.Context, string) ([]*types.Payment, error)
+	UpdatePaymentStatus(context.Context, string, types.PaymentStatus, types.PaymentStatus, time.Time) (*types.Payment, error)
+}
+
+type MongoPaymentStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewPaymentStore(client *mongo.Client) *MongoPaymentStore {
+	return &MongoPaymentStore{
+		client: client,
+		coll:   client.Database(MongoDBName).Collection(paymentColl),
+	}
+}
+
+// createIndexes adds the indexes webhooks and the payments of a rent are
+// looked up by.
+func (s *MongoPaymentStore) createIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "provider", Value: 1}, {Key: "providerID", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "rentID", Value: 1}, {Key: "_id", Value: 1}}},
+	})
+	return err
+}
+
+func (s *MongoPaymentStore) InsertPayment(ctx context.Context, payment *types.Payment) (*types.Payment, error) {
+	res, err := s.coll.InsertOne(ctx, payment)
+	if err != nil {
+		return nil, err
+	}
+	payment.ID = res.InsertedID.(primitive.ObjectID)
+	return payment, nil
+}
+
+func (s *MongoPaymentStore) GetPaymentByID(ctx context.Context, id string) (*types.Payment, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.findOne(ctx, bson.M{"_id": oid})
+}
+
+func (s *MongoPaymentStore) GetPaymentByProviderID(ctx context.Context, provider, providerID string) (*types.Payment, error) {
+	return s.findOne(ctx, bson.M{"provider": provider, "providerID": providerID})
+}
+
+func (s *MongoPaymentStore) findOne(ctx context.Context, filter bson.M) (*types.Payment, error) {
+	var payment types.Payment
+	if err := s.coll.FindOne(ctx, filter).Decode(&payment); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &payment, nil
+}
+
+// GetPaymentsByRent returns the payments of a rent, oldest first.
+func (s *MongoPaymentStore) GetPaymentsByRent(ctx context.Context, rentID string) ([]*types.Payment, error) {
+	oid, err := primitive.ObjectIDFromHex(rentID)
+	if err != nil {
+		return nil, err
+	}
+	cur, err := s.coll.Find(ctx, bson.M{"rentID": oid}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	payments := []*types.Payment{}
+	if err := cur.All(ctx, &payments); err != nil {
+		return nil, err
+	}
+	return payments, nil
+}
+
+// UpdatePaymentStatus moves a payment from one status to another. It
+// returns ErrInvalidTransition when the payment is no longer in from, so
+// a webhook and a request racing on a payment can't both apply.
+func (s *MongoPaymentStore) UpdatePaymentStatus(ctx context.Context, id string, from, to types.PaymentStatus, at time.Time) (*types.Payment, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	update := bson.M{"$set": bson.M{"status": to, "updatedAt": at}}
+	var payment types.Payment
+	err = s.coll.FindOneAndUpdate(ctx, bson.M{"_id": oid, "status": from}, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&payment)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := s.GetPaymentByID(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidTransition
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
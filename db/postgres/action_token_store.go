@@ -0,0 +1,70 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const actionTokenColumns = `id, user_id, purpose, hash, created_at, expires_at, used_at`
+
+type ActionTokenStore struct {
+	db *sql.DB
+}
+
+func NewActionTokenStore(conn *sql.DB) *ActionTokenStore {
+	return &ActionTokenStore{
+		db: conn,
+	}
+}
+
+func scanActionToken(row scanner) (*types.ActionToken, error) {
+	var (
+		token      types.ActionToken
+		id, userID string
+		usedAt     sql.NullTime
+	)
+	err := row.Scan(&id, &userID, &token.Purpose, &token.Hash, &token.CreatedAt, &token.ExpiresAt, &usedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if token.ID, err = parseID(id); err != nil {
+		return nil, err
+	}
+	if token.UserID, err = parseID(userID); err != nil {
+		return nil, err
+	}
+	if usedAt.Valid {
+		token.UsedAt = &usedAt.Time
+	}
+	return &token, nil
+}
+
+func (s *ActionTokenStore) InsertActionToken(ctx context.Context, token *types.ActionToken) (*types.ActionToken, error) {
+	id := primitive.NewObjectID()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO action_tokens (`+actionTokenColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id.Hex(), token.UserID.Hex(), token.Purpose, token.Hash, token.CreatedAt, token.ExpiresAt, token.UsedAt)
+	if err != nil {
+		return nil, err
+	}
+	token.ID = id
+	return token, nil
+}
+
+func (s *ActionTokenStore) UseActionToken(ctx context.Context, purpose types.TokenPurpose, hash string, at time.Time) (*types.ActionToken, error) {
+	return scanActionToken(s.db.QueryRowContext(ctx, `UPDATE action_tokens SET used_at = $3
+		WHERE hash = $1 AND purpose = $2 AND used_at IS NULL AND expires_at > $3
+		RETURNING `+actionTokenColumns, hash, purpose, at))
+}
+
+func (s *ActionTokenStore) DeleteActionTokens(ctx context.Context, userID string, purpose types.TokenPurpose) error {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `DELETE FROM action_tokens WHERE user_id = $1 AND purpose = $2`, oid.Hex(), purpose)
+	return err
+}
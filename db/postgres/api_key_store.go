@@ -0,0 +1,133 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const apiKeyColumns = `id, name, user_id, scopes, hash, created_by, created_at, expires_at, last_used_at, revoked_at`
+
+type APIKeyStore struct {
+	db *sql.DB
+}
+
+func NewAPIKeyStore(conn *sql.DB) *APIKeyStore {
+	return &APIKeyStore{
+		db: conn,
+	}
+}
+
+func scanAPIKey(row scanner) (*types.APIKey, error) {
+	var (
+		key                            types.APIKey
+		id, userID, createdBy          string
+		scopes                         []string
+		expiresAt, lastUsedAt, revoked sql.NullTime
+	)
+	err := row.Scan(&id, &key.Name, &userID, pq.Array(&scopes), &key.Hash, &createdBy, &key.CreatedAt, &expiresAt, &lastUsedAt, &revoked)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if key.ID, err = parseID(id); err != nil {
+		return nil, err
+	}
+	if key.UserID, err = parseID(userID); err != nil {
+		return nil, err
+	}
+	if key.CreatedBy, err = parseID(createdBy); err != nil {
+		return nil, err
+	}
+	for _, scope := range scopes {
+		key.Scopes = append(key.Scopes, types.Permission(scope))
+	}
+	if expiresAt.Valid {
+		key.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		key.LastUsedAt = &lastUsedAt.Time
+	}
+	if revoked.Valid {
+		key.RevokedAt = &revoked.Time
+	}
+	return &key, nil
+}
+
+func (s *APIKeyStore) InsertAPIKey(ctx context.Context, key *types.APIKey) (*types.APIKey, error) {
+	id := primitive.NewObjectID()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO api_keys (`+apiKeyColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		id.Hex(), key.Name, key.UserID.Hex(), pq.Array(key.Scopes), key.Hash, key.CreatedBy.Hex(), key.CreatedAt, key.ExpiresAt, key.LastUsedAt, key.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	key.ID = id
+	return key, nil
+}
+
+func (s *APIKeyStore) GetAPIKeyByID(ctx context.Context, id string) (*types.APIKey, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return scanAPIKey(s.db.QueryRowContext(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id = $1`, oid.Hex()))
+}
+
+// GetAPIKeys returns the newest keys first.
+func (s *APIKeyStore) GetAPIKeys(ctx context.Context, filter map[string]any, pag *db.Pagination) (*db.Page[*types.APIKey], error) {
+	var (
+		conds []string
+		args  []any
+	)
+	for key, value := range filter {
+		switch key {
+		case "userID":
+			oid, ok := value.(primitive.ObjectID)
+			if !ok {
+				return nil, fmt.Errorf("invalid userID filter %v", value)
+			}
+			args = append(args, oid.Hex())
+			conds = append(conds, fmt.Sprintf("user_id = $%d", len(args)))
+		default:
+			return nil, fmt.Errorf("unsupported api key filter %q", key)
+		}
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+	q := pageQuery{table: "api_keys", columns: apiKeyColumns, where: where, args: args, desc: true}
+	return queryPage(ctx, s.db, q, pag, scanAPIKey, db.APIKeyCursor)
+}
+
+func (s *APIKeyStore) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE api_keys SET last_used_at = $2 WHERE id = $1`, oid.Hex(), at)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// RevokeAPIKey revokes the key. Revoking it again keeps the original
+// revocation time.
+func (s *APIKeyStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE api_keys SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1`, oid.Hex(), at)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
@@ -0,0 +1,83 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const auditColumns = `id, action, actor_id, subject, detail, created_at`
+
+type AuditStore struct {
+	db *sql.DB
+}
+
+func NewAuditStore(conn *sql.DB) *AuditStore {
+	return &AuditStore{
+		db: conn,
+	}
+}
+
+func scanAuditEntry(row scanner) (*types.AuditEntry, error) {
+	var (
+		entry   types.AuditEntry
+		id      string
+		actorID sql.NullString
+	)
+	err := row.Scan(&id, &entry.Action, &actorID, &entry.Subject, &entry.Detail, &entry.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if entry.ID, err = parseID(id); err != nil {
+		return nil, err
+	}
+	if actorID.Valid {
+		if entry.ActorID, err = parseID(actorID.String); err != nil {
+			return nil, err
+		}
+	}
+	return &entry, nil
+}
+
+func (s *AuditStore) InsertAuditEntry(ctx context.Context, entry *types.AuditEntry) (*types.AuditEntry, error) {
+	id := primitive.NewObjectID()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO audit_entries (`+auditColumns+`) VALUES ($1, $2, $3, $4, $5, $6)`,
+		id.Hex(), entry.Action, nullID(entry.ActorID), entry.Subject, entry.Detail, entry.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	entry.ID = id
+	return entry, nil
+}
+
+var auditFilterColumns = map[string]string{
+	"action":  "action",
+	"subject": "subject",
+}
+
+// GetAuditEntries returns the newest entries first.
+func (s *AuditStore) GetAuditEntries(ctx context.Context, filter map[string]any, pag *db.Pagination) (*db.Page[*types.AuditEntry], error) {
+	var (
+		conds []string
+		args  []any
+	)
+	for key, value := range filter {
+		column, ok := auditFilterColumns[key]
+		if !ok {
+			return nil, fmt.Errorf("unsupported audit filter %q", key)
+		}
+		args = append(args, value)
+		conds = append(conds, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+	q := pageQuery{table: "audit_entries", columns: auditColumns, where: where, args: args, desc: true}
+	return queryPage(ctx, s.db, q, pag, scanAuditEntry, db.AuditCursor)
+}
@@ -0,0 +1,149 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const copyColumns = `id, movie_id, format, rented, retired, created_at, retired_at`
+
+type CopyStore struct {
+	db *sql.DB
+}
+
+func NewCopyStore(conn *sql.DB) *CopyStore {
+	return &CopyStore{
+		db: conn,
+	}
+}
+
+func scanCopy(row scanner) (*types.Copy, error) {
+	var (
+		cp          types.Copy
+		id, movieID string
+		retiredAt   sql.NullTime
+	)
+	err := row.Scan(&id, &movieID, &cp.Format, &cp.Rented, &cp.Retired, &cp.CreatedAt, &retiredAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if cp.ID, err = parseID(id); err != nil {
+		return nil, err
+	}
+	if cp.MovieID, err = parseID(movieID); err != nil {
+		return nil, err
+	}
+	if retiredAt.Valid {
+		cp.RetiredAt = &retiredAt.Time
+	}
+	return &cp, nil
+}
+
+func (s *CopyStore) InsertCopy(ctx context.Context, cp *types.Copy) (*types.Copy, error) {
+	id := primitive.NewObjectID()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO copies (`+copyColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id.Hex(), cp.MovieID.Hex(), cp.Format, cp.Rented, cp.Retired, cp.CreatedAt, cp.RetiredAt)
+	if err != nil {
+		return nil, err
+	}
+	cp.ID = id
+	return cp, nil
+}
+
+func (s *CopyStore) GetCopies(ctx context.Context, movieID string, filter map[string]any) ([]*types.Copy, error) {
+	oid, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		conds = []string{"movie_id = $1"}
+		args  = []any{oid.Hex()}
+	)
+	for key, value := range filter {
+		switch key {
+		case "format", "rented", "retired":
+			args = append(args, value)
+			conds = append(conds, fmt.Sprintf("%s = $%d", key, len(args)))
+		default:
+			return nil, fmt.Errorf("unsupported copy filter %q", key)
+		}
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+copyColumns+` FROM copies WHERE `+strings.Join(conds, " AND ")+` ORDER BY seq`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	copies := []*types.Copy{}
+	for rows.Next() {
+		cp, err := scanCopy(rows)
+		if err != nil {
+			return nil, err
+		}
+		copies = append(copies, cp)
+	}
+	return copies, rows.Err()
+}
+
+func (s *CopyStore) GetCopyByID(ctx context.Context, id string) (*types.Copy, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return scanCopy(s.db.QueryRowContext(ctx, `SELECT `+copyColumns+` FROM copies WHERE id = $1`, oid.Hex()))
+}
+
+func (s *CopyStore) RetireCopy(ctx context.Context, id string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE copies
+		SET retired = TRUE, retired_at = COALESCE(retired_at, $2)
+		WHERE id = $1`, oid.Hex(), at)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+// ReserveCopy skips rows locked by concurrent reservations, so parallel
+// rents of the same movie each get a different copy.
+func (s *CopyStore) ReserveCopy(ctx context.Context, movieID string, format types.CopyFormat) (*types.Copy, error) {
+	oid, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return nil, err
+	}
+	cp, err := scanCopy(s.db.QueryRowContext(ctx, `UPDATE copies SET rented = TRUE
+		WHERE id = (
+			SELECT id FROM copies
+			WHERE movie_id = $1 AND NOT rented AND NOT retired AND ($2 = '' OR format = $2)
+			ORDER BY seq
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING `+copyColumns, oid.Hex(), format))
+	if errors.Is(err, db.ErrNotFound) {
+		return nil, db.ErrNoCopiesAvailable
+	}
+	return cp, err
+}
+
+func (s *CopyStore) ReleaseCopy(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE copies SET rented = FALSE WHERE id = $1`, oid.Hex())
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
@@ -0,0 +1,58 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+const loginAttemptColumns = `key, failures, last_failure, locked_until`
+
+type LoginAttemptStore struct {
+	db *sql.DB
+}
+
+func NewLoginAttemptStore(conn *sql.DB) *LoginAttemptStore {
+	return &LoginAttemptStore{
+		db: conn,
+	}
+}
+
+func scanLoginAttempts(row scanner) (*types.LoginAttempts, error) {
+	var (
+		attempts    types.LoginAttempts
+		lockedUntil sql.NullTime
+	)
+	if err := row.Scan(&attempts.Key, &attempts.Failures, &attempts.LastFailure, &lockedUntil); err != nil {
+		return nil, notFound(err)
+	}
+	attempts.LockedUntil = lockedUntil.Time
+	return &attempts, nil
+}
+
+func (s *LoginAttemptStore) GetLoginAttempts(ctx context.Context, key string) (*types.LoginAttempts, error) {
+	return scanLoginAttempts(s.db.QueryRowContext(ctx, `SELECT `+loginAttemptColumns+` FROM login_attempts WHERE key = $1`, key))
+}
+
+func (s *LoginAttemptStore) RecordLoginFailure(ctx context.Context, key string, at time.Time, window time.Duration) (*types.LoginAttempts, error) {
+	return scanLoginAttempts(s.db.QueryRowContext(ctx, `INSERT INTO login_attempts (key, failures, last_failure) VALUES ($1, 1, $2)
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN login_attempts.last_failure > $3 THEN login_attempts.failures + 1 ELSE 1 END,
+			last_failure = $2
+		RETURNING `+loginAttemptColumns, key, at, at.Add(-window)))
+}
+
+func (s *LoginAttemptStore) LockLogin(ctx context.Context, key string, until time.Time) error {
+	res, err := s.db.ExecContext(ctx, `UPDATE login_attempts SET locked_until = $2, failures = 0 WHERE key = $1`, key, until)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (s *LoginAttemptStore) ResetLoginAttempts(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM login_attempts WHERE key = $1`, key)
+	return err
+}
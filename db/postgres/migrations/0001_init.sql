@@ -0,0 +1,33 @@
+CREATE TABLE users (
+	id                 CHAR(24) PRIMARY KEY,
+	username           TEXT NOT NULL,
+	first_name         TEXT NOT NULL,
+	last_name          TEXT NOT NULL,
+	encrypted_password TEXT NOT NULL,
+	email              TEXT NOT NULL,
+	is_admin           BOOLEAN NOT NULL DEFAULT FALSE,
+	seq                BIGSERIAL
+);
+
+CREATE INDEX users_email_idx ON users (email);
+
+CREATE TABLE movies (
+	id     CHAR(24) PRIMARY KEY,
+	title  TEXT NOT NULL,
+	genre  TEXT[] NOT NULL DEFAULT '{}',
+	length INTEGER NOT NULL,
+	year   INTEGER NOT NULL,
+	rating INTEGER NOT NULL DEFAULT 0,
+	seq    BIGSERIAL
+);
+
+CREATE TABLE rents (
+	id       CHAR(24) PRIMARY KEY,
+	user_id  CHAR(24) NOT NULL,
+	movie_id CHAR(24) NOT NULL,
+	from_at  TIMESTAMPTZ NOT NULL,
+	to_at    TIMESTAMPTZ NOT NULL,
+	seq      BIGSERIAL
+);
+
+CREATE INDEX rents_user_movie_idx ON rents (user_id, movie_id, to_at);
@@ -0,0 +1,7 @@
+ALTER TABLE rents
+	ADD COLUMN state TEXT NOT NULL DEFAULT 'active'
+		CHECK (state IN ('active', 'returned', 'overdue', 'cancelled')),
+	ADD COLUMN returned_at TIMESTAMPTZ,
+	ADD COLUMN late BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX rents_state_idx ON rents (state);
@@ -0,0 +1,16 @@
+CREATE TABLE copies (
+	id         CHAR(24) PRIMARY KEY,
+	movie_id   CHAR(24) NOT NULL,
+	format     TEXT NOT NULL CHECK (format IN ('dvd', 'bluray', '4k', 'digital')),
+	rented     BOOLEAN NOT NULL DEFAULT FALSE,
+	retired    BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	retired_at TIMESTAMPTZ,
+	seq        BIGSERIAL
+);
+
+CREATE INDEX copies_movie_idx ON copies (movie_id, format) WHERE NOT rented AND NOT retired;
+
+ALTER TABLE rents
+	ADD COLUMN copy_id CHAR(24),
+	ADD COLUMN format TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,15 @@
+ALTER TABLE movies
+	ADD COLUMN rating_avg DOUBLE PRECISION NOT NULL DEFAULT 0,
+	ADD COLUMN rating_count INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE ratings (
+	id         CHAR(24) PRIMARY KEY,
+	user_id    CHAR(24) NOT NULL,
+	movie_id   CHAR(24) NOT NULL,
+	rating     INTEGER NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	UNIQUE (user_id, movie_id)
+);
+
+CREATE INDEX ratings_movie_idx ON ratings (movie_id);
@@ -0,0 +1,13 @@
+CREATE TABLE reviews (
+	id         CHAR(24) PRIMARY KEY,
+	user_id    CHAR(24) NOT NULL,
+	movie_id   CHAR(24) NOT NULL,
+	body       TEXT NOT NULL,
+	status     TEXT NOT NULL CHECK (status IN ('pending', 'approved', 'hidden')),
+	created_at TIMESTAMPTZ NOT NULL,
+	updated_at TIMESTAMPTZ NOT NULL,
+	seq        BIGSERIAL
+);
+
+CREATE INDEX reviews_movie_idx ON reviews (movie_id, status, seq);
+CREATE INDEX reviews_status_idx ON reviews (status, seq);
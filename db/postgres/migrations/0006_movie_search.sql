@@ -0,0 +1,3 @@
+CREATE INDEX movies_title_search_idx ON movies USING GIN (to_tsvector('simple', title));
+CREATE INDEX movies_genre_idx ON movies USING GIN (genre);
+CREATE INDEX movies_year_idx ON movies (year);
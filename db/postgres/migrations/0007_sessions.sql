@@ -0,0 +1,10 @@
+CREATE TABLE sessions (
+	id           CHAR(24) PRIMARY KEY,
+	user_id      CHAR(24) NOT NULL,
+	refresh_hash TEXT NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL,
+	expires_at   TIMESTAMPTZ NOT NULL,
+	revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX sessions_user_idx ON sessions (user_id);
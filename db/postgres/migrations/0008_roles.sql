@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN roles TEXT[] NOT NULL DEFAULT '{customer}';
+
+UPDATE users SET roles = '{admin}' WHERE is_admin;
+
+ALTER TABLE users DROP COLUMN is_admin;
@@ -0,0 +1,11 @@
+CREATE TABLE action_tokens (
+	id         CHAR(24) PRIMARY KEY,
+	user_id    CHAR(24) NOT NULL,
+	purpose    TEXT NOT NULL,
+	hash       TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL,
+	expires_at TIMESTAMPTZ NOT NULL,
+	used_at    TIMESTAMPTZ
+);
+
+CREATE INDEX action_tokens_user_idx ON action_tokens (user_id, purpose);
@@ -0,0 +1,7 @@
+-- CheckDuplicateUsers reports users this migration would fail on
+UPDATE users SET email = lower(btrim(email)) WHERE email <> lower(btrim(email));
+
+DROP INDEX users_email_idx;
+
+CREATE UNIQUE INDEX users_email_key ON users (email);
+CREATE UNIQUE INDEX users_username_key ON users (username);
@@ -0,0 +1,18 @@
+CREATE TABLE login_attempts (
+	key          TEXT PRIMARY KEY,
+	failures     INTEGER NOT NULL DEFAULT 0,
+	last_failure TIMESTAMPTZ NOT NULL,
+	locked_until TIMESTAMPTZ
+);
+
+CREATE TABLE audit_entries (
+	id         CHAR(24) PRIMARY KEY,
+	action     TEXT NOT NULL,
+	actor_id   CHAR(24),
+	subject    TEXT NOT NULL,
+	detail     TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX audit_entries_action_idx ON audit_entries (action, id);
+CREATE INDEX audit_entries_subject_idx ON audit_entries (subject, id);
@@ -0,0 +1,10 @@
+ALTER TABLE sessions ADD COLUMN two_factor BOOLEAN NOT NULL DEFAULT false;
+
+CREATE TABLE totp (
+	user_id         CHAR(24) PRIMARY KEY,
+	secret          TEXT NOT NULL,
+	confirmed       BOOLEAN NOT NULL,
+	recovery_hashes TEXT[] NOT NULL DEFAULT '{}',
+	last_step       BIGINT NOT NULL DEFAULT 0,
+	created_at      TIMESTAMPTZ NOT NULL
+);
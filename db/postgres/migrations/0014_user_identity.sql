@@ -0,0 +1,5 @@
+ALTER TABLE users
+	ADD COLUMN identity_issuer  TEXT,
+	ADD COLUMN identity_subject TEXT;
+
+CREATE UNIQUE INDEX users_identity_key ON users (identity_issuer, identity_subject);
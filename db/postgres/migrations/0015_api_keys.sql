@@ -0,0 +1,14 @@
+CREATE TABLE api_keys (
+	id           CHAR(24) PRIMARY KEY,
+	name         TEXT NOT NULL,
+	user_id      CHAR(24) NOT NULL,
+	scopes       TEXT[] NOT NULL DEFAULT '{}',
+	hash         TEXT NOT NULL,
+	created_by   CHAR(24) NOT NULL,
+	created_at   TIMESTAMPTZ NOT NULL,
+	expires_at   TIMESTAMPTZ,
+	last_used_at TIMESTAMPTZ,
+	revoked_at   TIMESTAMPTZ
+);
+
+CREATE INDEX api_keys_user_idx ON api_keys (user_id, id);
@@ -0,0 +1,3 @@
+ALTER TABLE movies ADD COLUMN rental_hours INTEGER[];
+
+ALTER TABLE rents ADD COLUMN extensions INTEGER NOT NULL DEFAULT 0;
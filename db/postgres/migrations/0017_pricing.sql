@@ -0,0 +1,13 @@
+CREATE TABLE price_rules (
+	id       CHAR(24) PRIMARY KEY,
+	kind     TEXT NOT NULL,
+	movie_id CHAR(24),
+	format   TEXT NOT NULL DEFAULT '',
+	amount   BIGINT NOT NULL DEFAULT 0,
+	percent  INTEGER NOT NULL DEFAULT 0,
+	max_age  INTEGER NOT NULL DEFAULT 0,
+	hours    INTEGER NOT NULL DEFAULT 0
+);
+
+ALTER TABLE rents ADD COLUMN price BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE rents ADD COLUMN currency TEXT NOT NULL DEFAULT '';
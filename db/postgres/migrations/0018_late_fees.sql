@@ -0,0 +1,3 @@
+ALTER TABLE rents ADD COLUMN late_fee BIGINT NOT NULL DEFAULT 0;
+
+CREATE INDEX rents_due_idx ON rents (to_at) WHERE state IN ('active', 'overdue');
@@ -0,0 +1,16 @@
+CREATE TABLE payments (
+	id          CHAR(24) PRIMARY KEY,
+	rent_id     CHAR(24) NOT NULL,
+	user_id     CHAR(24) NOT NULL,
+	purpose     TEXT NOT NULL,
+	provider    TEXT NOT NULL,
+	provider_id TEXT NOT NULL,
+	amount      BIGINT NOT NULL,
+	currency    TEXT NOT NULL,
+	status      TEXT NOT NULL,
+	created_at  TIMESTAMPTZ NOT NULL,
+	updated_at  TIMESTAMPTZ NOT NULL,
+	UNIQUE (provider, provider_id)
+);
+
+CREATE INDEX payments_rent_idx ON payments (rent_id, id);
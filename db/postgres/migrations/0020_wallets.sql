@@ -0,0 +1,18 @@
+CREATE TABLE wallet_entries (
+	id         CHAR(24) NOT NULL UNIQUE,
+	user_id    CHAR(24) NOT NULL,
+	seq        BIGINT NOT NULL,
+	kind       TEXT NOT NULL,
+	amount     BIGINT NOT NULL,
+	balance    BIGINT NOT NULL CHECK (balance >= 0),
+	currency   TEXT NOT NULL,
+	rent_id    CHAR(24),
+	actor_id   CHAR(24),
+	reason     TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMPTZ NOT NULL,
+	PRIMARY KEY (user_id, seq)
+);
+
+CREATE INDEX wallet_entries_user_idx ON wallet_entries (user_id, id);
+
+ALTER TABLE rents ADD COLUMN late_fee_paid BOOLEAN NOT NULL DEFAULT false;
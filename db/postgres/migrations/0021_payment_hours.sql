@@ -0,0 +1 @@
+ALTER TABLE payments ADD COLUMN hours INTEGER NOT NULL DEFAULT 0;
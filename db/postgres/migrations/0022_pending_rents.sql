@@ -0,0 +1,4 @@
+ALTER TABLE rents DROP CONSTRAINT rents_state_check;
+
+ALTER TABLE rents
+	ADD CONSTRAINT rents_state_check CHECK (state IN ('pending', 'active', 'returned', 'overdue', 'cancelled'));
@@ -0,0 +1,277 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const movieColumns = `id, title, genre, length, year, rating, rating_avg, rating_count, rental_hours`
+
+type MovieStore struct {
+	db *sql.DB
+}
+
+func NewMovieStore(conn *sql.DB) *MovieStore {
+	return &MovieStore{
+		db: conn,
+	}
+}
+
+func scanMovie(row scanner) (*types.Movie, error) {
+	var (
+		movie       types.Movie
+		id          string
+		rentalHours []int64
+	)
+	err := row.Scan(&id, &movie.Title, pq.Array(&movie.Genre), &movie.Length, &movie.Year, &movie.Rating, &movie.RatingAvg, &movie.RatingCount, pq.Array(&rentalHours))
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if movie.ID, err = parseID(id); err != nil {
+		return nil, err
+	}
+	for _, h := range rentalHours {
+		movie.RentalHours = append(movie.RentalHours, int(h))
+	}
+	return &movie, nil
+}
+
+func (s *MovieStore) InsertMovie(ctx context.Context, movie *types.Movie) (*types.Movie, error) {
+	id := primitive.NewObjectID()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO movies (`+movieColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		id.Hex(), movie.Title, pq.Array(movie.Genre), movie.Length, movie.Year, movie.Rating, movie.RatingAvg, movie.RatingCount, pq.Array(movie.RentalHours))
+	if err != nil {
+		return nil, err
+	}
+	movie.ID = id
+	return movie, nil
+}
+
+func (s *MovieStore) GetMovies(ctx context.Context, filter map[string]any, pag *db.Pagination) (*db.Page[*types.Movie], error) {
+	where, args, err := movieFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	q := pageQuery{table: "movies", columns: movieColumns, where: where, args: args}
+	return queryPage(ctx, s.db, q, pag, scanMovie, db.MovieCursor(types.MovieSearchParams{}))
+}
+
+// movieFilter translates the equality filters accepted by GetMovies into a
+// WHERE clause. Like Mongo, a scalar genre matches any movie containing it.
+func movieFilter(filter map[string]any) (string, []any, error) {
+	var (
+		conds []string
+		args  []any
+	)
+	for key, value := range filter {
+		n := len(args) + 1
+		switch key {
+		case "_id":
+			oid, ok := value.(primitive.ObjectID)
+			if !ok {
+				return "", nil, fmt.Errorf("invalid _id filter %v", value)
+			}
+			conds = append(conds, fmt.Sprintf("id = $%d", n))
+			value = oid.Hex()
+		case "title", "length", "year", "rating":
+			conds = append(conds, fmt.Sprintf("%s = $%d", key, n))
+		case "genre":
+			switch v := value.(type) {
+			case string:
+				conds = append(conds, fmt.Sprintf("$%d = ANY(genre)", n))
+			case []string:
+				conds = append(conds, fmt.Sprintf("genre = $%d", n))
+				value = pq.Array(v)
+			default:
+				return "", nil, fmt.Errorf("invalid genre filter %v", value)
+			}
+		default:
+			return "", nil, fmt.Errorf("unsupported movie filter %q", key)
+		}
+		args = append(args, value)
+	}
+	if len(conds) == 0 {
+		return "", nil, nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+var movieSortColumns = map[types.MovieSort]string{
+	types.SortTitle:      `title COLLATE "C"`,
+	types.SortYear:       "year",
+	types.SortRating:     "rating_avg",
+	types.SortPopularity: "rating_count",
+}
+
+// SearchMovies runs the page, the total and both facet counts as separate
+// queries over the same WHERE clause.
+func (s *MovieStore) SearchMovies(ctx context.Context, params types.MovieSearchParams, pag *db.Pagination) (*db.MovieSearchResult, error) {
+	where, args := movieSearchFilter(params)
+	key, desc := params.SortBy()
+	q := pageQuery{
+		table:      "movies",
+		columns:    movieColumns,
+		where:      where,
+		args:       args,
+		sortKey:    params.Sort,
+		sortColumn: movieSortColumns[key],
+		desc:       desc,
+	}
+	page, err := queryPage(ctx, s.db, q, pag, scanMovie, db.MovieCursor(params))
+	if err != nil {
+		return nil, err
+	}
+	facets := types.MovieFacets{Genre: map[string]int{}, Decade: map[int]int{}}
+	if err := facetCounts(ctx, s.db, `SELECT g, COUNT(*) FROM movies CROSS JOIN unnest(genre) AS g`+where+` GROUP BY g`, args, func(row scanner) error {
+		var (
+			genre string
+			count int
+		)
+		if err := row.Scan(&genre, &count); err != nil {
+			return err
+		}
+		facets.Genre[genre] = count
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if err := facetCounts(ctx, s.db, `SELECT year - year % 10, COUNT(*) FROM movies`+where+` GROUP BY 1`, args, func(row scanner) error {
+		var decade, count int
+		if err := row.Scan(&decade, &count); err != nil {
+			return err
+		}
+		facets.Decade[decade] = count
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return &db.MovieSearchResult{Page: *page, Facets: facets}, nil
+}
+
+func facetCounts(ctx context.Context, q queryer, query string, args []any, scan func(scanner) error) error {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// movieSearchFilter builds the WHERE clause of SearchMovies. Titles are
+// matched with the simple text search configuration so words are compared
+// without stemming, like the other backends.
+func movieSearchFilter(params types.MovieSearchParams) (string, []any) {
+	var (
+		conds []string
+		args  []any
+	)
+	add := func(cond string, value any) {
+		args = append(args, value)
+		conds = append(conds, fmt.Sprintf(cond, len(args)))
+	}
+	if terms := params.Terms(); len(terms) > 0 {
+		add(`to_tsvector('simple', title) @@ plainto_tsquery('simple', $%d)`, strings.Join(terms, " "))
+	}
+	if len(params.Genre) > 0 {
+		add("genre && $%d", pq.Array(params.Genre))
+	}
+	if len(params.GenreAll) > 0 {
+		add("genre @> $%d", pq.Array(params.GenreAll))
+	}
+	if params.YearFrom > 0 {
+		add("year >= $%d", params.YearFrom)
+	}
+	if params.YearTo > 0 {
+		add("year <= $%d", params.YearTo)
+	}
+	if params.LengthMin > 0 {
+		add("length >= $%d", params.LengthMin)
+	}
+	if params.LengthMax > 0 {
+		add("length <= $%d", params.LengthMax)
+	}
+	if params.MinRating > 0 {
+		add("rating_avg >= $%d", params.MinRating)
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func (s *MovieStore) GetMovieByID(ctx context.Context, id string) (*types.Movie, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return scanMovie(s.db.QueryRowContext(ctx, `SELECT `+movieColumns+` FROM movies WHERE id = $1`, oid.Hex()))
+}
+
+func (s *MovieStore) PutMovie(ctx context.Context, id string, params types.UpdateMovieParams) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	var (
+		sets []string
+		args = []any{oid.Hex()}
+	)
+	for key, value := range params.ToBSON() {
+		column := key
+		switch key {
+		case "genre":
+			value = pq.Array(value)
+		case "rentalHours":
+			column, value = "rental_hours", pq.Array(value)
+		}
+		args = append(args, value)
+		sets = append(sets, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	query := `UPDATE movies SET ` + strings.Join(sets, ", ") + ` WHERE id = $1`
+	if len(sets) == 0 {
+		query = `UPDATE movies SET id = id WHERE id = $1`
+	}
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (s *MovieStore) DeleteMovie(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `DELETE FROM movies WHERE id = $1`, oid.Hex())
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (s *MovieStore) UpdateRatingStats(ctx context.Context, id string, stats types.RatingStats) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE movies SET rating = $2, rating_avg = $3, rating_count = $4 WHERE id = $1`,
+		oid.Hex(), int(math.Round(stats.Average)), stats.Average, stats.Count)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
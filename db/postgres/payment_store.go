@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const paymentColumns = `id, rent_id, user_id, purpose, provider, provider_id, amount, hours, currency, status, created_at, updated_at`
+
+type PaymentStore struct {
+	db *sql.DB
+}
+
+func NewPaymentStore(conn *sql.DB) *PaymentStore {
+	return &PaymentStore{
+		db: conn,
+	}
+}
+
+func scanPayment(row scanner) (*types.Payment, error) {
+	var (
+		payment            types.Payment
+		id, rentID, userID string
+	)
+	err := row.Scan(&id, &rentID, &userID, &payment.Purpose, &payment.Provider, &payment.ProviderID, &payment.Amount, &payment.Hours, &payment.Currency, &payment.Status, &payment.CreatedAt, &payment.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if payment.ID, err = parseID(id); err != nil {
+		return nil, err
+	}
+	if payment.RentID, err = parseID(rentID); err != nil {
+		return nil, err
+	}
+	if payment.UserID, err = parseID(userID); err != nil {
+		return nil, err
+	}
+	return &payment, nil
+}
+
+func (s *PaymentStore) InsertPayment(ctx context.Context, payment *types.Payment) (*types.Payment, error) {
+	id := primitive.NewObjectID()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO payments (`+paymentColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		id.Hex(), payment.RentID.Hex(), payment.UserID.Hex(), payment.Purpose, payment.Provider, payment.ProviderID,
+		payment.Amount, payment.Hours, payment.Currency, payment.Status, payment.CreatedAt, payment.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	payment.ID = id
+	return payment, nil
+}
+
+func (s *PaymentStore) GetPaymentByID(ctx context.Context, id string) (*types.Payment, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return scanPayment(s.db.QueryRowContext(ctx, `SELECT `+paymentColumns+` FROM payments WHERE id = $1`, oid.Hex()))
+}
+
+func (s *PaymentStore) GetPaymentByProviderID(ctx context.Context, provider, providerID string) (*types.Payment, error) {
+	return scanPayment(s.db.QueryRowContext(ctx, `SELECT `+paymentColumns+` FROM payments WHERE provider = $1 AND provider_id = $2`, provider, providerID))
+}
+
+// GetPaymentsByRent returns the payments of a rent, oldest first.
+func (s *PaymentStore) GetPaymentsByRent(ctx context.Context, rentID string) ([]*types.Payment, error) {
+	oid, err := primitive.ObjectIDFromHex(rentID)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `SELECT `+paymentColumns+` FROM payments WHERE rent_id = $1 ORDER BY id`, oid.Hex())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	payments := []*types.Payment{}
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, payment)
+	}
+	return payments, rows.Err()
+}
+
+func (s *PaymentStore) UpdatePaymentStatus(ctx context.Context, id string, from, to types.PaymentStatus, at time.Time) (*types.Payment, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	payment, err := scanPayment(s.db.QueryRowContext(ctx, `UPDATE payments SET status = $3, updated_at = $4
+		WHERE id = $1 AND status = $2 RETURNING `+paymentColumns, oid.Hex(), from, to, at))
+	if err == db.ErrNotFound {
+		if _, err := s.GetPaymentByID(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, db.ErrInvalidTransition
+	}
+	return payment, err
+}
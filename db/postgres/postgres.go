@@ -0,0 +1,235 @@
+// Package postgres implements the db store interfaces on top of PostgreSQL.
+// Documents keep their Mongo ObjectIDs, stored as 24 character hex strings,
+// so the types package stays backend agnostic.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strings"
+
+	_ "github.com/lib/pq"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+func Open(ctx context.Context, url string) (*sql.DB, error) {
+	conn, err := sql.Open("postgres", url)
+	if err != nil {
+		return nil, err
+	}
+	if err := conn.PingContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func NewStore(conn *sql.DB) *db.Store {
+	return &db.Store{
+		User:         NewUserStore(conn),
+		Movie:        NewMovieStore(conn),
+		Rent:         NewRentStore(conn),
+		Copy:         NewCopyStore(conn),
+		Rating:       NewRatingStore(conn),
+		Review:       NewReviewStore(conn),
+		Session:      NewSessionStore(conn),
+		ActionToken:  NewActionTokenStore(conn),
+		LoginAttempt: NewLoginAttemptStore(conn),
+		Audit:        NewAuditStore(conn),
+		TOTP:         NewTOTPStore(conn),
+		APIKey:       NewAPIKeyStore(conn),
+		PriceRule:    NewPriceRuleStore(conn),
+		Payment:      NewPaymentStore(conn),
+		Wallet:       NewWalletStore(conn),
+	}
+}
+
+// Migrate applies every embedded migration that has not been recorded in
+// the schema_migrations table yet. Each migration runs in its own
+// transaction.
+func Migrate(ctx context.Context, conn *sql.DB) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version    TEXT PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	)`)
+	if err != nil {
+		return err
+	}
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		version := strings.TrimSuffix(strings.TrimPrefix(name, "migrations/"), ".sql")
+		if err := migrate(ctx, conn, name, version); err != nil {
+			return fmt.Errorf("migration %s: %w", version, err)
+		}
+	}
+	return nil
+}
+
+func migrate(ctx context.Context, conn *sql.DB, name, version string) error {
+	query, err := migrations.ReadFile(name)
+	if err != nil {
+		return err
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// serialise concurrent instances starting at the same time
+	if _, err := tx.ExecContext(ctx, `LOCK TABLE schema_migrations IN EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+	var applied bool
+	err = tx.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`, version).Scan(&applied)
+	if err != nil || applied {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, string(query)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx.
+type queryer interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+type scanner interface {
+	Scan(...any) error
+}
+
+func parseID(id string) (primitive.ObjectID, error) {
+	return primitive.ObjectIDFromHex(strings.TrimSpace(id))
+}
+
+// nullID stores a zero ObjectID as NULL.
+func nullID(id primitive.ObjectID) sql.NullString {
+	if id.IsZero() {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: id.Hex(), Valid: true}
+}
+
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return db.ErrNotFound
+	}
+	return err
+}
+
+func expectAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return db.ErrNotFound
+	}
+	return nil
+}
+
+// pageQuery describes a keyset paginated list. Rows are ordered by
+// sortColumn, then id ascending, or by id alone when sortColumn is empty.
+type pageQuery struct {
+	table      string
+	columns    string
+	where      string
+	args       []any
+	sortKey    string
+	sortColumn string
+	desc       bool
+}
+
+// keyset extends the WHERE clause with the position after the cursor and
+// renders the ORDER BY and LIMIT of the page.
+func (q pageQuery) keyset(c *db.Cursor, pag *db.Pagination) (string, []any) {
+	var (
+		where   = q.where
+		args    = append([]any(nil), q.args...)
+		op, dir = ">", "ASC"
+		cond    string
+		order   string
+	)
+	if q.desc {
+		op, dir = "<", "DESC"
+	}
+	if q.sortColumn == "" {
+		order = " ORDER BY id " + dir
+		if c != nil {
+			args = append(args, c.ID.Hex())
+			cond = fmt.Sprintf("id %s $%d", op, len(args))
+		}
+	} else {
+		order = fmt.Sprintf(" ORDER BY %s %s, id", q.sortColumn, dir)
+		if c != nil {
+			args = append(args, c.Value, c.ID.Hex())
+			cond = fmt.Sprintf("(%[1]s %[2]s $%[3]d OR (%[1]s = $%[3]d AND id > $%[4]d))", q.sortColumn, op, len(args)-1, len(args))
+		}
+	}
+	if cond != "" {
+		if where == "" {
+			where = " WHERE " + cond
+		} else {
+			where += " AND " + cond
+		}
+	}
+	if n := db.FetchLimit(pag); n > 0 {
+		order += fmt.Sprintf(" LIMIT %d", n)
+	}
+	return where + order, args
+}
+
+// queryPage reads the page of q selected by pag and counts every row
+// matching its WHERE clause.
+func queryPage[T any](ctx context.Context, conn queryer, q pageQuery, pag *db.Pagination, scan func(scanner) (T, error), cursorOf func(T) db.Cursor) (*db.Page[T], error) {
+	c, err := db.PageCursor(pag, q.sortKey)
+	if err != nil {
+		return nil, err
+	}
+	var total int
+	if err := conn.QueryRowContext(ctx, `SELECT COUNT(*) FROM `+q.table+q.where, q.args...).Scan(&total); err != nil {
+		return nil, err
+	}
+	query, args := q.keyset(c, pag)
+	rows, err := conn.QueryContext(ctx, `SELECT `+q.columns+` FROM `+q.table+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	items, err := scanAll(rows, scan)
+	if err != nil {
+		return nil, err
+	}
+	return db.NewPage(items, total, pag, cursorOf), nil
+}
+
+func scanAll[T any](rows *sql.Rows, scan func(scanner) (T, error)) ([]T, error) {
+	defer rows.Close()
+	items := []T{}
+	for rows.Next() {
+		item, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
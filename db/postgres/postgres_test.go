@@ -0,0 +1,75 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/joho/godotenv"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/db/storetest"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func setup(t *testing.T) *db.Store {
+	godotenv.Load("../../.env")
+	url := os.Getenv("POSTGRES_DB_URL_TEST")
+	if url == "" {
+		t.Skip("POSTGRES_DB_URL_TEST not set")
+	}
+	ctx := context.Background()
+	conn, err := Open(ctx, url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Migrate(ctx, conn); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if _, err := conn.Exec(`TRUNCATE users, movies, rents, copies, ratings, reviews, sessions, action_tokens, login_attempts, audit_entries, totp, api_keys, price_rules, payments, wallet_entries`); err != nil {
+			t.Fatal(err)
+		}
+		conn.Close()
+	})
+	return NewStore(conn)
+}
+
+func TestStore(t *testing.T) {
+	storetest.Run(t, setup)
+}
+
+func TestInsertRentConcurrent(t *testing.T) {
+	var (
+		store  = setup(t)
+		params = types.CreateRentParams{
+			UserID:  primitive.NewObjectID(),
+			MovieID: primitive.NewObjectID(),
+		}
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		inserted int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := store.Rent.InsertRent(context.Background(), types.NewRentFromParams(params))
+			if err != nil && !errors.Is(err, db.ErrAlreadyRented) {
+				t.Error(err)
+				return
+			}
+			if err == nil {
+				mu.Lock()
+				inserted++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if inserted != 1 {
+		t.Fatalf("expected exactly 1 concurrent rent to succeed but got %d", inserted)
+	}
+}
@@ -0,0 +1,108 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const priceRuleColumns = `id, kind, movie_id, format, amount, percent, max_age, hours`
+
+type PriceRuleStore struct {
+	db *sql.DB
+}
+
+func NewPriceRuleStore(conn *sql.DB) *PriceRuleStore {
+	return &PriceRuleStore{
+		db: conn,
+	}
+}
+
+func scanPriceRule(row scanner) (*types.PriceRule, error) {
+	var (
+		rule    types.PriceRule
+		id      string
+		movieID sql.NullString
+	)
+	err := row.Scan(&id, &rule.Kind, &movieID, &rule.Format, &rule.Amount, &rule.Percent, &rule.MaxAge, &rule.Hours)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if rule.ID, err = parseID(id); err != nil {
+		return nil, err
+	}
+	if movieID.Valid {
+		if rule.MovieID, err = parseID(movieID.String); err != nil {
+			return nil, err
+		}
+	}
+	return &rule, nil
+}
+
+func (s *PriceRuleStore) InsertPriceRule(ctx context.Context, rule *types.PriceRule) (*types.PriceRule, error) {
+	id := primitive.NewObjectID()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO price_rules (`+priceRuleColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		id.Hex(), rule.Kind, nullID(rule.MovieID), rule.Format, rule.Amount, rule.Percent, rule.MaxAge, rule.Hours)
+	if err != nil {
+		return nil, err
+	}
+	rule.ID = id
+	return rule, nil
+}
+
+// GetPriceRules returns every rule, oldest first.
+func (s *PriceRuleStore) GetPriceRules(ctx context.Context) ([]*types.PriceRule, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+priceRuleColumns+` FROM price_rules ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []*types.PriceRule{}
+	for rows.Next() {
+		rule, err := scanPriceRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, rows.Err()
+}
+
+func (s *PriceRuleStore) GetPriceRuleByID(ctx context.Context, id string) (*types.PriceRule, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return scanPriceRule(s.db.QueryRowContext(ctx, `SELECT `+priceRuleColumns+` FROM price_rules WHERE id = $1`, oid.Hex()))
+}
+
+func (s *PriceRuleStore) ReplacePriceRule(ctx context.Context, id string, rule *types.PriceRule) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE price_rules SET kind = $2, movie_id = $3, format = $4, amount = $5, percent = $6, max_age = $7, hours = $8 WHERE id = $1`,
+		oid.Hex(), rule.Kind, nullID(rule.MovieID), rule.Format, rule.Amount, rule.Percent, rule.MaxAge, rule.Hours)
+	if err != nil {
+		return err
+	}
+	if err := expectAffected(res); err != nil {
+		return err
+	}
+	rule.ID = oid
+	return nil
+}
+
+func (s *PriceRuleStore) DeletePriceRule(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `DELETE FROM price_rules WHERE id = $1`, oid.Hex())
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
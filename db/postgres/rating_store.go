@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"math"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const ratingColumns = `id, user_id, movie_id, rating, created_at, updated_at`
+
+type RatingStore struct {
+	db *sql.DB
+}
+
+func NewRatingStore(conn *sql.DB) *RatingStore {
+	return &RatingStore{
+		db: conn,
+	}
+}
+
+func scanRating(row scanner) (*types.Rating, error) {
+	var (
+		rating              types.Rating
+		id, userID, movieID string
+	)
+	err := row.Scan(&id, &userID, &movieID, &rating.Rating, &rating.CreatedAt, &rating.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if rating.ID, err = parseID(id); err != nil {
+		return nil, err
+	}
+	if rating.UserID, err = parseID(userID); err != nil {
+		return nil, err
+	}
+	if rating.MovieID, err = parseID(movieID); err != nil {
+		return nil, err
+	}
+	return &rating, nil
+}
+
+func (s *RatingStore) UpsertRating(ctx context.Context, rating *types.Rating) (*types.Rating, error) {
+	return scanRating(s.db.QueryRowContext(ctx, `INSERT INTO ratings (`+ratingColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id, movie_id) DO UPDATE
+		SET rating = EXCLUDED.rating, updated_at = EXCLUDED.updated_at
+		RETURNING `+ratingColumns,
+		primitive.NewObjectID().Hex(), rating.UserID.Hex(), rating.MovieID.Hex(), rating.Rating, rating.CreatedAt, rating.UpdatedAt))
+}
+
+func (s *RatingStore) GetRating(ctx context.Context, userID, movieID string) (*types.Rating, error) {
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	movieOID, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRating(s.db.QueryRowContext(ctx, `SELECT `+ratingColumns+` FROM ratings WHERE user_id = $1 AND movie_id = $2`,
+		userOID.Hex(), movieOID.Hex()))
+}
+
+func (s *RatingStore) GetRatingStats(ctx context.Context, movieID string) (types.RatingStats, error) {
+	var stats types.RatingStats
+	oid, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return stats, err
+	}
+	err = s.db.QueryRowContext(ctx, `SELECT COALESCE(AVG(rating), 0), COUNT(*) FROM ratings WHERE movie_id = $1`,
+		oid.Hex()).Scan(&stats.Average, &stats.Count)
+	return stats, err
+}
+
+// RefreshMovieRating locks the movie row before reading the ratings, so
+// the stats of a later refresh always include the ratings of an earlier one.
+func (s *RatingStore) RefreshMovieRating(ctx context.Context, movieID string) (types.RatingStats, error) {
+	var stats types.RatingStats
+	oid, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return stats, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return stats, err
+	}
+	defer tx.Rollback()
+	var id string
+	if err := tx.QueryRowContext(ctx, `SELECT id FROM movies WHERE id = $1 FOR UPDATE`, oid.Hex()).Scan(&id); err != nil {
+		return stats, notFound(err)
+	}
+	err = tx.QueryRowContext(ctx, `SELECT COALESCE(AVG(rating), 0), COUNT(*) FROM ratings WHERE movie_id = $1`,
+		oid.Hex()).Scan(&stats.Average, &stats.Count)
+	if err != nil {
+		return stats, err
+	}
+	_, err = tx.ExecContext(ctx, `UPDATE movies SET rating = $2, rating_avg = $3, rating_count = $4 WHERE id = $1`,
+		oid.Hex(), int(math.Round(stats.Average)), stats.Average, stats.Count)
+	if err != nil {
+		return stats, err
+	}
+	return stats, tx.Commit()
+}
@@ -0,0 +1,318 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const rentColumns = `id, user_id, movie_id, copy_id, format, from_at, to_at, state, returned_at, late, extensions, price, currency, late_fee, late_fee_paid`
+
+type RentStore struct {
+	db *sql.DB
+}
+
+func NewRentStore(conn *sql.DB) *RentStore {
+	return &RentStore{
+		db: conn,
+	}
+}
+
+func scanRent(row scanner) (*types.Rent, error) {
+	var (
+		rent                types.Rent
+		id, userID, movieID string
+		copyID              sql.NullString
+		returnedAt          sql.NullTime
+	)
+	err := row.Scan(&id, &userID, &movieID, &copyID, &rent.Format, &rent.From, &rent.To, &rent.State, &returnedAt, &rent.Late, &rent.Extensions, &rent.Price, &rent.Currency, &rent.LateFee, &rent.LateFeePaid)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if rent.ID, err = parseID(id); err != nil {
+		return nil, err
+	}
+	if rent.UserID, err = parseID(userID); err != nil {
+		return nil, err
+	}
+	if rent.MovieID, err = parseID(movieID); err != nil {
+		return nil, err
+	}
+	if copyID.Valid {
+		if rent.CopyID, err = parseID(copyID.String); err != nil {
+			return nil, err
+		}
+	}
+	if returnedAt.Valid {
+		rent.ReturnedAt = &returnedAt.Time
+	}
+	return &rent, nil
+}
+
+// InsertRent checks for an overlapping rent and inserts the new one in a
+// single transaction. A transaction scoped advisory lock on the user and
+// movie pair serialises concurrent inserts, so two overlapping rents can
+// never both succeed.
+func (s *RentStore) InsertRent(ctx context.Context, rent *types.Rent) (*types.Rent, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock(hashtext($1))`, rent.UserID.Hex()+rent.MovieID.Hex())
+	if err != nil {
+		return nil, err
+	}
+	err = checkRent(ctx, tx, types.CheckRentParams{
+		UserID:  rent.UserID,
+		MovieID: rent.MovieID,
+		From:    rent.From,
+		To:      rent.To,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rent.State == "" {
+		rent.State = types.RentActive
+	}
+	id := primitive.NewObjectID()
+	_, err = tx.ExecContext(ctx, `INSERT INTO rents (`+rentColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		id.Hex(), rent.UserID.Hex(), rent.MovieID.Hex(), nullID(rent.CopyID), rent.Format, rent.From, rent.To, rent.State, rent.ReturnedAt, rent.Late, rent.Extensions, rent.Price, rent.Currency, rent.LateFee, rent.LateFeePaid)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	rent.ID = id
+	return rent, nil
+}
+
+func (s *RentStore) GetRents(ctx context.Context, filter map[string]any, pag *db.Pagination) (*db.Page[*types.Rent], error) {
+	return s.findRents(ctx, filter, pag)
+}
+
+func (s *RentStore) GetRentsByUser(ctx context.Context, userID string, filter map[string]any, pag *db.Pagination) (*db.Page[*types.Rent], error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	merged := map[string]any{"userID": oid}
+	for key, value := range filter {
+		merged[key] = value
+	}
+	return s.findRents(ctx, merged, pag)
+}
+
+func (s *RentStore) findRents(ctx context.Context, filter map[string]any, pag *db.Pagination) (*db.Page[*types.Rent], error) {
+	where, args, err := rentFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	q := pageQuery{table: "rents", columns: rentColumns, where: where, args: args}
+	return queryPage(ctx, s.db, q, pag, scanRent, db.RentCursor)
+}
+
+var rentIDColumns = map[string]string{
+	"userID":  "user_id",
+	"movieID": "movie_id",
+}
+
+// rentFilter translates the filters accepted by GetRents into a WHERE
+// clause.
+func rentFilter(filter map[string]any) (string, []any, error) {
+	var (
+		conds []string
+		args  []any
+	)
+	for key, value := range filter {
+		switch key {
+		case "state":
+			state, ok := value.(types.RentState)
+			if !ok {
+				return "", nil, fmt.Errorf("invalid state filter %v", value)
+			}
+			args = append(args, state)
+			conds = append(conds, fmt.Sprintf("state = $%d", len(args)))
+		case "userID", "movieID":
+			oid, ok := value.(primitive.ObjectID)
+			if !ok {
+				return "", nil, fmt.Errorf("invalid %s filter %v", key, value)
+			}
+			args = append(args, oid.Hex())
+			conds = append(conds, fmt.Sprintf("%s = $%d", rentIDColumns[key], len(args)))
+		default:
+			return "", nil, fmt.Errorf("unsupported rent filter %q", key)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil, nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+func (s *RentStore) GetRentByID(ctx context.Context, id string) (*types.Rent, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return scanRent(s.db.QueryRowContext(ctx, `SELECT `+rentColumns+` FROM rents WHERE id = $1`, oid.Hex()))
+}
+
+// UpdateRentState locks the rent row for the duration of the transition.
+func (s *RentStore) UpdateRentState(ctx context.Context, id string, state types.RentState, at time.Time) (*types.Rent, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rent, err := scanRent(tx.QueryRowContext(ctx, `SELECT `+rentColumns+` FROM rents WHERE id = $1 FOR UPDATE`, oid.Hex()))
+	if err != nil {
+		return nil, err
+	}
+	if !rent.State.CanTransitionTo(state) {
+		return nil, db.ErrInvalidTransition
+	}
+	rent.SetState(state, at)
+	_, err = tx.ExecContext(ctx, `UPDATE rents SET state = $2, returned_at = $3, late = $4 WHERE id = $1`,
+		oid.Hex(), rent.State, rent.ReturnedAt, rent.Late)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return rent, nil
+}
+
+// ExtendRent locks the rent row like UpdateRentState.
+func (s *RentStore) ExtendRent(ctx context.Context, id string, from, to time.Time, price int64) (*types.Rent, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	rent, err := scanRent(tx.QueryRowContext(ctx, `SELECT `+rentColumns+` FROM rents WHERE id = $1 FOR UPDATE`, oid.Hex()))
+	if err != nil {
+		return nil, err
+	}
+	if rent.State != types.RentActive || !rent.To.Equal(from) {
+		return nil, db.ErrInvalidTransition
+	}
+	rent.To = to
+	rent.Extensions++
+	rent.Price += price
+	_, err = tx.ExecContext(ctx, `UPDATE rents SET to_at = $2, extensions = $3, price = $4 WHERE id = $1`, oid.Hex(), rent.To, rent.Extensions, rent.Price)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return rent, nil
+}
+
+// GetRentsDueBefore returns the open rents that had to be returned before
+// at, the earliest due first.
+func (s *RentStore) GetRentsDueBefore(ctx context.Context, at time.Time) ([]*types.Rent, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+rentColumns+` FROM rents
+		WHERE state IN ($1, $2) AND to_at < $3 ORDER BY to_at, id`, types.RentActive, types.RentOverdue, at)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rents := []*types.Rent{}
+	for rows.Next() {
+		rent, err := scanRent(rows)
+		if err != nil {
+			return nil, err
+		}
+		rents = append(rents, rent)
+	}
+	return rents, rows.Err()
+}
+
+// AccrueLateFee raises the late fee of an open rent, the fees of returned
+// rents are final.
+func (s *RentStore) AccrueLateFee(ctx context.Context, id string, fee int64) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE rents SET late_fee = GREATEST(late_fee, $2) WHERE id = $1 AND state IN ($3, $4)`,
+		oid.Hex(), fee, types.RentActive, types.RentOverdue)
+	if err != nil {
+		return err
+	}
+	if err := expectAffected(res); err != nil {
+		if _, err := s.GetRentByID(ctx, id); err != nil {
+			return err
+		}
+		return db.ErrInvalidTransition
+	}
+	return nil
+}
+
+// SettleLateFee marks the final late fee of a returned rent as paid.
+func (s *RentStore) SettleLateFee(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE rents SET late_fee_paid = true WHERE id = $1 AND state = $2 AND NOT late_fee_paid`,
+		oid.Hex(), types.RentReturned)
+	if err != nil {
+		return err
+	}
+	if err := expectAffected(res); err != nil {
+		if _, err := s.GetRentByID(ctx, id); err != nil {
+			return err
+		}
+		return db.ErrInvalidTransition
+	}
+	return nil
+}
+
+func (s *RentStore) GetLateFees(ctx context.Context, userID string) (int64, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	err = s.db.QueryRowContext(ctx, `SELECT COALESCE(SUM(late_fee), 0) FROM rents WHERE user_id = $1 AND NOT late_fee_paid`, oid.Hex()).Scan(&total)
+	return total, err
+}
+
+func (s *RentStore) CheckRent(ctx context.Context, params types.CheckRentParams) error {
+	return checkRent(ctx, s.db, params)
+}
+
+func checkRent(ctx context.Context, q queryer, params types.CheckRentParams) error {
+	var overlaps bool
+	err := q.QueryRowContext(ctx, `SELECT EXISTS (
+		SELECT 1 FROM rents
+		WHERE user_id = $1 AND movie_id = $2 AND (state = 'overdue'
+			OR state IN ('pending', 'active') AND from_at < $4 AND to_at > $3)
+	)`, params.UserID.Hex(), params.MovieID.Hex(), params.From, params.To).Scan(&overlaps)
+	if err != nil {
+		return err
+	}
+	if overlaps {
+		return db.ErrAlreadyRented
+	}
+	return nil
+}
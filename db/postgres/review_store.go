@@ -0,0 +1,128 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const reviewColumns = `id, user_id, movie_id, body, status, created_at, updated_at`
+
+type ReviewStore struct {
+	db *sql.DB
+}
+
+func NewReviewStore(conn *sql.DB) *ReviewStore {
+	return &ReviewStore{
+		db: conn,
+	}
+}
+
+func scanReview(row scanner) (*types.Review, error) {
+	var (
+		review              types.Review
+		id, userID, movieID string
+	)
+	err := row.Scan(&id, &userID, &movieID, &review.Body, &review.Status, &review.CreatedAt, &review.UpdatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if review.ID, err = parseID(id); err != nil {
+		return nil, err
+	}
+	if review.UserID, err = parseID(userID); err != nil {
+		return nil, err
+	}
+	if review.MovieID, err = parseID(movieID); err != nil {
+		return nil, err
+	}
+	return &review, nil
+}
+
+func (s *ReviewStore) InsertReview(ctx context.Context, review *types.Review) (*types.Review, error) {
+	id := primitive.NewObjectID()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO reviews (`+reviewColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id.Hex(), review.UserID.Hex(), review.MovieID.Hex(), review.Body, review.Status, review.CreatedAt, review.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	review.ID = id
+	return review, nil
+}
+
+func (s *ReviewStore) GetReviewByID(ctx context.Context, id string) (*types.Review, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return scanReview(s.db.QueryRowContext(ctx, `SELECT `+reviewColumns+` FROM reviews WHERE id = $1`, oid.Hex()))
+}
+
+var reviewFilterColumns = map[string]string{
+	"movieID": "movie_id",
+	"userID":  "user_id",
+	"status":  "status",
+}
+
+// GetReviews returns the newest reviews first.
+func (s *ReviewStore) GetReviews(ctx context.Context, filter map[string]any, pag *db.Pagination) (*db.Page[*types.Review], error) {
+	var (
+		conds []string
+		args  []any
+	)
+	for key, value := range filter {
+		column, ok := reviewFilterColumns[key]
+		if !ok {
+			return nil, fmt.Errorf("unsupported review filter %q", key)
+		}
+		if oid, ok := value.(primitive.ObjectID); ok {
+			value = oid.Hex()
+		}
+		args = append(args, value)
+		conds = append(conds, fmt.Sprintf("%s = $%d", column, len(args)))
+	}
+	where := ""
+	if len(conds) > 0 {
+		where = " WHERE " + strings.Join(conds, " AND ")
+	}
+	q := pageQuery{table: "reviews", columns: reviewColumns, where: where, args: args, desc: true}
+	return queryPage(ctx, s.db, q, pag, scanReview, db.ReviewCursor)
+}
+
+func (s *ReviewStore) UpdateReviewBody(ctx context.Context, id string, body string, at time.Time) error {
+	return s.update(ctx, `UPDATE reviews SET body = $2, status = $3, updated_at = $4 WHERE id = $1`, id, body, types.ReviewPending, at)
+}
+
+func (s *ReviewStore) UpdateReviewStatus(ctx context.Context, id string, status types.ReviewStatus, at time.Time) error {
+	return s.update(ctx, `UPDATE reviews SET status = $2, updated_at = $3 WHERE id = $1`, id, status, at)
+}
+
+func (s *ReviewStore) update(ctx context.Context, query string, id string, args ...any) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, query, append([]any{oid.Hex()}, args...)...)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (s *ReviewStore) DeleteReview(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `DELETE FROM reviews WHERE id = $1`, oid.Hex())
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
@@ -0,0 +1,102 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const sessionColumns = `id, user_id, refresh_hash, two_factor, created_at, expires_at, revoked_at`
+
+type SessionStore struct {
+	db *sql.DB
+}
+
+func NewSessionStore(conn *sql.DB) *SessionStore {
+	return &SessionStore{
+		db: conn,
+	}
+}
+
+func scanSession(row scanner) (*types.Session, error) {
+	var (
+		session    types.Session
+		id, userID string
+		revokedAt  sql.NullTime
+	)
+	err := row.Scan(&id, &userID, &session.RefreshHash, &session.TwoFactor, &session.CreatedAt, &session.ExpiresAt, &revokedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if session.ID, err = parseID(id); err != nil {
+		return nil, err
+	}
+	if session.UserID, err = parseID(userID); err != nil {
+		return nil, err
+	}
+	if revokedAt.Valid {
+		session.RevokedAt = &revokedAt.Time
+	}
+	return &session, nil
+}
+
+func (s *SessionStore) InsertSession(ctx context.Context, session *types.Session) (*types.Session, error) {
+	id := primitive.NewObjectID()
+	_, err := s.db.ExecContext(ctx, `INSERT INTO sessions (`+sessionColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		id.Hex(), session.UserID.Hex(), session.RefreshHash, session.TwoFactor, session.CreatedAt, session.ExpiresAt, session.RevokedAt)
+	if err != nil {
+		return nil, err
+	}
+	session.ID = id
+	return session, nil
+}
+
+func (s *SessionStore) GetSessionByID(ctx context.Context, id string) (*types.Session, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return scanSession(s.db.QueryRowContext(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE id = $1`, oid.Hex()))
+}
+
+func (s *SessionStore) RotateSession(ctx context.Context, id string, oldHash string, newHash string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE sessions SET refresh_hash = $3
+		WHERE id = $1 AND refresh_hash = $2 AND revoked_at IS NULL`, oid.Hex(), oldHash, newHash)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (s *SessionStore) RevokeSession(ctx context.Context, id string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE sessions SET revoked_at = COALESCE(revoked_at, $2) WHERE id = $1`, oid.Hex(), at)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (s *SessionStore) RevokeUserSessions(ctx context.Context, userID string, at time.Time) (int, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE sessions SET revoked_at = $2
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > $2`, oid.Hex(), at)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/lib/pq"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const totpColumns = `user_id, secret, confirmed, recovery_hashes, last_step, created_at`
+
+type TOTPStore struct {
+	db *sql.DB
+}
+
+func NewTOTPStore(conn *sql.DB) *TOTPStore {
+	return &TOTPStore{
+		db: conn,
+	}
+}
+
+func scanTOTP(row scanner) (*types.TOTP, error) {
+	var (
+		totp   types.TOTP
+		userID string
+	)
+	err := row.Scan(&userID, &totp.Secret, &totp.Confirmed, pq.Array(&totp.RecoveryHashes), &totp.LastStep, &totp.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if totp.UserID, err = parseID(userID); err != nil {
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// StartTOTP upserts the enrolment unless the stored one is confirmed.
+func (s *TOTPStore) StartTOTP(ctx context.Context, totp *types.TOTP) error {
+	res, err := s.db.ExecContext(ctx, `INSERT INTO totp (`+totpColumns+`) VALUES ($1, $2, false, '{}', 0, $3)
+		ON CONFLICT (user_id) DO UPDATE SET secret = EXCLUDED.secret, created_at = EXCLUDED.created_at
+		WHERE NOT totp.confirmed`, totp.UserID.Hex(), totp.Secret, totp.CreatedAt)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return db.ErrTOTPEnabled
+	}
+	return nil
+}
+
+func (s *TOTPStore) GetTOTP(ctx context.Context, userID string) (*types.TOTP, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanTOTP(s.db.QueryRowContext(ctx, `SELECT `+totpColumns+` FROM totp WHERE user_id = $1`, oid.Hex()))
+}
+
+func (s *TOTPStore) ConfirmTOTP(ctx context.Context, userID string, step int64, recoveryHashes []string) error {
+	return s.update(ctx, `UPDATE totp SET confirmed = true, last_step = $2, recovery_hashes = $3
+		WHERE user_id = $1 AND NOT confirmed`, userID, step, pq.Array(recoveryHashes))
+}
+
+func (s *TOTPStore) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return s.update(ctx, `UPDATE totp SET last_step = $2 WHERE user_id = $1 AND confirmed AND last_step < $2`, userID, step)
+}
+
+func (s *TOTPStore) UseRecoveryCode(ctx context.Context, userID string, hash string) error {
+	return s.update(ctx, `UPDATE totp SET recovery_hashes = array_remove(recovery_hashes, $2)
+		WHERE user_id = $1 AND confirmed AND $2 = ANY(recovery_hashes)`, userID, hash)
+}
+
+func (s *TOTPStore) update(ctx context.Context, query string, userID string, args ...any) error {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, query, append([]any{oid.Hex()}, args...)...)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (s *TOTPStore) DeleteTOTP(ctx context.Context, userID string) error {
+	return s.update(ctx, `DELETE FROM totp WHERE user_id = $1`, userID)
+}
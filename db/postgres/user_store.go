@@ -0,0 +1,237 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const userColumns = `id, username, first_name, last_name, encrypted_password, email, roles, email_verified, identity_issuer, identity_subject`
+
+type UserStore struct {
+	db *sql.DB
+}
+
+func NewUserStore(conn *sql.DB) *UserStore {
+	return &UserStore{
+		db: conn,
+	}
+}
+
+func scanUser(row scanner) (*types.User, error) {
+	var (
+		user            types.User
+		id              string
+		roles           []string
+		issuer, subject sql.NullString
+	)
+	err := row.Scan(&id, &user.Username, &user.FirstName, &user.LastName, &user.EncryptedPassword, &user.Email, pq.Array(&roles), &user.EmailVerified, &issuer, &subject)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if issuer.Valid {
+		user.Identity = &types.Identity{Issuer: issuer.String, Subject: subject.String}
+	}
+	if user.ID, err = parseID(id); err != nil {
+		return nil, err
+	}
+	for _, role := range roles {
+		user.Roles = append(user.Roles, types.Role(role))
+	}
+	return &user, nil
+}
+
+func (s *UserStore) InsertUser(ctx context.Context, user *types.User) (*types.User, error) {
+	id := primitive.NewObjectID()
+	var issuer, subject sql.NullString
+	if user.Identity != nil {
+		issuer = sql.NullString{String: user.Identity.Issuer, Valid: true}
+		subject = sql.NullString{String: user.Identity.Subject, Valid: true}
+	}
+	_, err := s.db.ExecContext(ctx, `INSERT INTO users (`+userColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`,
+		id.Hex(), user.Username, user.FirstName, user.LastName, user.EncryptedPassword, user.Email, pq.Array(user.Roles), user.EmailVerified, issuer, subject)
+	if err != nil {
+		return nil, duplicateUserError(err)
+	}
+	user.ID = id
+	return user, nil
+}
+
+// duplicateUserError tells which unique index a write clashed with.
+func duplicateUserError(err error) error {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) || pqErr.Code != "23505" {
+		return err
+	}
+	switch pqErr.Constraint {
+	case "users_username_key":
+		return &db.DuplicateError{Field: "username"}
+	case "users_identity_key":
+		return &db.DuplicateError{Field: "identity"}
+	}
+	return &db.DuplicateError{Field: "email"}
+}
+
+// CheckDuplicateUsers reports users that share an email, ignoring case, or
+// a username. Run it before Migrate, the migration adding the unique
+// indexes would fail on them.
+func CheckDuplicateUsers(ctx context.Context, conn *sql.DB) error {
+	var exists bool
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass('users') IS NOT NULL`).Scan(&exists); err != nil || !exists {
+		return err
+	}
+	var dupErr db.DuplicateUsersError
+	for _, field := range []struct{ name, expr string }{
+		{"email", "lower(btrim(email))"},
+		{"username", "username"},
+	} {
+		rows, err := conn.QueryContext(ctx, `SELECT `+field.expr+`, array_agg(id ORDER BY seq) FROM users
+			GROUP BY 1 HAVING COUNT(*) > 1 ORDER BY 1`)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			d := db.DuplicateUsers{Field: field.name}
+			if err := rows.Scan(&d.Value, pq.Array(&d.UserIDs)); err != nil {
+				rows.Close()
+				return err
+			}
+			for i, id := range d.UserIDs {
+				d.UserIDs[i] = strings.TrimSpace(id)
+			}
+			dupErr.Duplicates = append(dupErr.Duplicates, d)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	if len(dupErr.Duplicates) > 0 {
+		return &dupErr
+	}
+	return nil
+}
+
+func (s *UserStore) GetUsers(ctx context.Context, pag *db.Pagination) (*db.Page[*types.User], error) {
+	q := pageQuery{table: "users", columns: userColumns}
+	return queryPage(ctx, s.db, q, pag, scanUser, db.UserCursor)
+}
+
+func (s *UserStore) GetUserByID(ctx context.Context, id string) (*types.User, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	return scanUser(s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE id = $1`, oid.Hex()))
+}
+
+func (s *UserStore) GetUserByEmail(ctx context.Context, email string) (*types.User, error) {
+	return scanUser(s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE email = $1`, types.NormalizeEmail(email)))
+}
+
+func (s *UserStore) GetUserByIdentity(ctx context.Context, identity types.Identity) (*types.User, error) {
+	return scanUser(s.db.QueryRowContext(ctx, `SELECT `+userColumns+` FROM users WHERE identity_issuer = $1 AND identity_subject = $2`,
+		identity.Issuer, identity.Subject))
+}
+
+func (s *UserStore) LinkIdentity(ctx context.Context, id string, identity types.Identity) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET identity_issuer = $2, identity_subject = $3
+		WHERE id = $1 AND identity_issuer IS NULL`, oid.Hex(), identity.Issuer, identity.Subject)
+	if err != nil {
+		return duplicateUserError(err)
+	}
+	return expectAffected(res)
+}
+
+func (s *UserStore) UpdateUserRoles(ctx context.Context, id string, roles []types.Role) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET roles = $2 WHERE id = $1`, oid.Hex(), pq.Array(roles))
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (s *UserStore) UpdateUserPassword(ctx context.Context, id string, encryptedPassword string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET encrypted_password = $2 WHERE id = $1`, oid.Hex(), encryptedPassword)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (s *UserStore) SetEmailVerified(ctx context.Context, id string, verified bool) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `UPDATE users SET email_verified = $2 WHERE id = $1`, oid.Hex(), verified)
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
+
+func (s *UserStore) UpdateUser(ctx context.Context, id string, params types.UpdateUserParams) (*types.User, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var (
+		sets []string
+		args = []any{oid.Hex()}
+	)
+	for _, field := range []struct{ column, value string }{
+		{"username", params.Username},
+		{"first_name", params.FirstName},
+		{"last_name", params.LastName},
+	} {
+		if field.value != "" {
+			args = append(args, field.value)
+			sets = append(sets, fmt.Sprintf("%s = $%d", field.column, len(args)))
+		}
+	}
+	if params.Email != "" {
+		args = append(args, params.Email)
+		// the right hand sides see the old email
+		sets = append(sets, fmt.Sprintf("email_verified = email_verified AND email = $%[1]d, email = $%[1]d", len(args)))
+	}
+	if len(sets) == 0 {
+		return s.GetUserByID(ctx, id)
+	}
+	user, err := scanUser(s.db.QueryRowContext(ctx, `UPDATE users SET `+strings.Join(sets, ", ")+` WHERE id = $1 RETURNING `+userColumns, args...))
+	if err != nil {
+		return nil, duplicateUserError(err)
+	}
+	return user, nil
+}
+
+func (s *UserStore) DeleteUser(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, oid.Hex())
+	if err != nil {
+		return err
+	}
+	return expectAffected(res)
+}
@@ -0,0 +1,112 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const walletColumns = `id, user_id, seq, kind, amount, balance, currency, rent_id, actor_id, reason, created_at`
+
+type WalletStore struct {
+	db *sql.DB
+}
+
+func NewWalletStore(conn *sql.DB) *WalletStore {
+	return &WalletStore{
+		db: conn,
+	}
+}
+
+func scanWalletEntry(row scanner) (*types.WalletEntry, error) {
+	var (
+		entry           types.WalletEntry
+		id, userID      string
+		rentID, actorID sql.NullString
+	)
+	err := row.Scan(&id, &userID, &entry.Seq, &entry.Kind, &entry.Amount, &entry.Balance, &entry.Currency, &rentID, &actorID, &entry.Reason, &entry.CreatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if entry.ID, err = parseID(id); err != nil {
+		return nil, err
+	}
+	if entry.UserID, err = parseID(userID); err != nil {
+		return nil, err
+	}
+	if rentID.Valid {
+		if entry.RentID, err = parseID(rentID.String); err != nil {
+			return nil, err
+		}
+	}
+	if actorID.Valid {
+		if entry.ActorID, err = parseID(actorID.String); err != nil {
+			return nil, err
+		}
+	}
+	return &entry, nil
+}
+
+// AppendWalletEntry relies on the primary key of (user_id, seq) like the
+// mongo store relies on its unique index: of two entries racing for the
+// same number only one is inserted and the other is retried on the new
+// balance.
+func (s *WalletStore) AppendWalletEntry(ctx context.Context, entry *types.WalletEntry) (*types.WalletEntry, error) {
+	for {
+		seq, balance, err := s.latest(ctx, entry.UserID.Hex())
+		if err != nil {
+			return nil, err
+		}
+		if entry.Amount < 0 && balance+entry.Amount < 0 {
+			return nil, db.ErrInsufficientFunds
+		}
+		id := primitive.NewObjectID()
+		_, err = s.db.ExecContext(ctx, `INSERT INTO wallet_entries (`+walletColumns+`) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`,
+			id.Hex(), entry.UserID.Hex(), seq+1, entry.Kind, entry.Amount, balance+entry.Amount, entry.Currency,
+			nullID(entry.RentID), nullID(entry.ActorID), entry.Reason, entry.CreatedAt)
+		var pqErr *pq.Error
+		if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry.ID = id
+		entry.Seq = seq + 1
+		entry.Balance = balance + entry.Amount
+		return entry, nil
+	}
+}
+
+func (s *WalletStore) latest(ctx context.Context, userID string) (int64, int64, error) {
+	var seq, balance int64
+	err := s.db.QueryRowContext(ctx, `SELECT seq, balance FROM wallet_entries WHERE user_id = $1 ORDER BY seq DESC LIMIT 1`, userID).Scan(&seq, &balance)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, nil
+	}
+	return seq, balance, err
+}
+
+func (s *WalletStore) GetWalletBalance(ctx context.Context, userID string) (int64, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+	_, balance, err := s.latest(ctx, oid.Hex())
+	return balance, err
+}
+
+// GetWalletEntries returns the statement of a wallet, newest entries first.
+func (s *WalletStore) GetWalletEntries(ctx context.Context, userID string, pag *db.Pagination) (*db.Page[*types.WalletEntry], error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	q := pageQuery{table: "wallet_entries", columns: walletColumns, where: " WHERE user_id = $1", args: []any{oid.Hex()}, desc: true}
+	return queryPage(ctx, s.db, q, pag, scanWalletEntry, db.WalletEntryCursor)
+}
@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	priceRuleColl = "priceRules"
+)
+
+// PriceRuleStore holds the price list. It is small and read whole to price
+// a rent, so it isn't paginated.
+type PriceRuleStore interface {
+	InsertPriceRule(context.Context, *types.PriceRule) (*types.PriceRule, error)
+	GetPriceRules(context.Context) ([]*types.PriceRule, error)
+	GetPriceRuleByID(context.Context, string) (*types.PriceRule, error)
+	ReplacePriceRule(context.Context, string, *types.PriceRule) error
+	DeletePriceRule(context.Context, string) error
+}
+
+type MongoPriceRuleStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewPriceRuleStore(client *mongo.Client) *MongoPriceRuleStore {
+	return &MongoPriceRuleStore{
+		client: client,
+		coll:   client.Database(MongoDBName).Collection(priceRuleColl),
+	}
+}
+
+func (s *MongoPriceRuleStore) InsertPriceRule(ctx context.Context, rule *types.PriceRule) (*types.PriceRule, error) {
+	res, err := s.coll.InsertOne(ctx, rule)
+	if err != nil {
+		return nil, err
+	}
+	rule.ID = res.InsertedID.(primitive.ObjectID)
+	return rule, nil
+}
+
+// GetPriceRules returns every rule, oldest first.
+func (s *MongoPriceRuleStore) GetPriceRules(ctx context.Context) ([]*types.PriceRule, error) {
+	cur, err := s.coll.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "_id", Value: 1}}))
+	if err != nil {
+		return nil, err
+	}
+	rules := []*types.PriceRule{}
+	if err := cur.All(ctx, &rules); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+func (s *MongoPriceRuleStore) GetPriceRuleByID(ctx context.Context, id string) (*types.PriceRule, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var rule types.PriceRule
+	if err := s.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&rule); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// ReplacePriceRule replaces every field of the rule, keeping its id.
+func (s *MongoPriceRuleStore) ReplacePriceRule(ctx context.Context, id string, rule *types.PriceRule) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	replacement := *rule
+	replacement.ID = oid
+	res, err := s.coll.ReplaceOne(ctx, bson.M{"_id": oid}, &replacement)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	rule.ID = oid
+	return nil
+}
+
+func (s *MongoPriceRuleStore) DeletePriceRule(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.coll.DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
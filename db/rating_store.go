@@ -0,0 +1,157 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	ratingColl = "ratings"
+)
+
+type RatingStore interface {
+	UpsertRating(context.Context, *types.Rating) (*types.Rating, error)
+	GetRating(context.Context, string, string) (*types.Rating, error)
+	GetRatingStats(context.Context, string) (types.RatingStats, error)
+	// RefreshMovieRating recomputes the rating stats of a movie and stores
+	// them on the movie. Refreshes running at the same time never store
+	// stats older than the ones they replace.
+	RefreshMovieRating(context.Context, string) (types.RatingStats, error)
+}
+
+type MongoRatingStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewRatingStore(client *mongo.Client) *MongoRatingStore {
+	return &MongoRatingStore{
+		client: client,
+		coll:   client.Database(MongoDBName).Collection(ratingColl),
+	}
+}
+
+// createIndexes adds the unique user and movie index that keeps concurrent
+// upserts from creating two ratings for the same pair.
+func (s *MongoRatingStore) createIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "userID", Value: 1}, {Key: "movieID", Value: 1}},
+		Options: options.Index().SetUnique(true),
+	})
+	return err
+}
+
+// UpsertRating inserts the rating or replaces the value of the user's
+// existing rating for the movie, keeping its id and creation time.
+func (s *MongoRatingStore) UpsertRating(ctx context.Context, rating *types.Rating) (*types.Rating, error) {
+	filter := bson.M{"userID": rating.UserID, "movieID": rating.MovieID}
+	update := bson.M{
+		"$set":         bson.M{"rating": rating.Rating, "updatedAt": rating.UpdatedAt},
+		"$setOnInsert": bson.M{"createdAt": rating.CreatedAt},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+	var upserted types.Rating
+	if err := s.coll.FindOneAndUpdate(ctx, filter, update, opts).Decode(&upserted); err != nil {
+		return nil, err
+	}
+	return &upserted, nil
+}
+
+func (s *MongoRatingStore) GetRating(ctx context.Context, userID, movieID string) (*types.Rating, error) {
+	userOID, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	movieOID, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return nil, err
+	}
+	var rating types.Rating
+	if err := s.coll.FindOne(ctx, bson.M{"userID": userOID, "movieID": movieOID}).Decode(&rating); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &rating, nil
+}
+
+func (s *MongoRatingStore) GetRatingStats(ctx context.Context, movieID string) (types.RatingStats, error) {
+	oid, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return types.RatingStats{}, err
+	}
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{"movieID": oid}}},
+		{{Key: "$group", Value: bson.M{
+			"_id":     nil,
+			"average": bson.M{"$avg": "$rating"},
+			"count":   bson.M{"$sum": 1},
+		}}},
+	}
+	res, err := s.coll.Aggregate(ctx, pipeline)
+	if err != nil {
+		return types.RatingStats{}, err
+	}
+	var stats []types.RatingStats
+	if err := res.All(ctx, &stats); err != nil {
+		return types.RatingStats{}, err
+	}
+	if len(stats) == 0 {
+		return types.RatingStats{}, nil
+	}
+	return stats[0], nil
+}
+
+// RefreshMovieRating stores the stats with a compare and swap on a version
+// of the movie's rating, retrying when another refresh stored its stats
+// after this one read the version, since those may be newer.
+func (s *MongoRatingStore) RefreshMovieRating(ctx context.Context, movieID string) (types.RatingStats, error) {
+	oid, err := primitive.ObjectIDFromHex(movieID)
+	if err != nil {
+		return types.RatingStats{}, err
+	}
+	movies := s.client.Database(MongoDBName).Collection(movieColl)
+	for {
+		var current struct {
+			Version int64 `bson:"ratingVersion"`
+		}
+		opts := options.FindOne().SetProjection(bson.M{"ratingVersion": 1})
+		if err := movies.FindOne(ctx, bson.M{"_id": oid}, opts).Decode(&current); err != nil {
+			if errors.Is(err, mongo.ErrNoDocuments) {
+				return types.RatingStats{}, ErrNotFound
+			}
+			return types.RatingStats{}, err
+		}
+		stats, err := s.GetRatingStats(ctx, movieID)
+		if err != nil {
+			return types.RatingStats{}, err
+		}
+		var version any = current.Version
+		if current.Version == 0 {
+			// movies rated before versions were kept have none
+			version = bson.M{"$in": bson.A{0, nil}}
+		}
+		res, err := movies.UpdateOne(ctx, bson.M{"_id": oid, "ratingVersion": version}, bson.M{
+			"$set": bson.M{
+				"rating":      int(math.Round(stats.Average)),
+				"ratingAvg":   stats.Average,
+				"ratingCount": stats.Count,
+			},
+			"$inc": bson.M{"ratingVersion": 1},
+		})
+		if err != nil {
+			return types.RatingStats{}, err
+		}
+		if res.MatchedCount == 1 {
+			return stats, nil
+		}
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -9,6 +10,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -17,9 +19,16 @@ const (
 
 type RentStore interface {
 	InsertRent(context.Context, *types.Rent) (*types.Rent, error)
-	GetRents(context.Context) ([]*types.Rent, error)
+	GetRents(context.Context, map[string]any, *Pagination) (*Page[*types.Rent], error)
+	GetRentByID(context.Context, string) (*types.Rent, error)
 	CheckRent(context.Context, types.CheckRentParams) error
-	GetRentsByUser(context.Context, string) ([]*types.Rent, error)
+	GetRentsByUser(context.Context, string, map[string]any, *Pagination) (*Page[*types.Rent], error)
+	UpdateRentState(context.Context, string, types.RentState, time.Time) (*types.Rent, error)
+	ExtendRent(context.Context, string, time.Time, time.Time, int64) (*types.Rent, error)
+	GetRentsDueBefore(context.Context, time.Time) ([]*types.Rent, error)
+	AccrueLateFee(context.Context, string, int64) error
+	SettleLateFee(context.Context, string) error
+	GetLateFees(context.Context, string) (int64, error)
 }
 
 type MongoRentStore struct {
@@ -34,24 +43,74 @@ func NewRentStore(client *mongo.Client) *MongoRentStore {
 	}
 }
 
-func (s *MongoRentStore) GetRentsByUser(ctx context.Context, userID string) ([]*types.Rent, error) {
-	oid, err := primitive.ObjectIDFromHex(userID)
+// rentFilter builds a query from the filters accepted by GetRents. Rents
+// stored before states were introduced have no state field and count as
+// active.
+func rentFilter(filter map[string]any) (bson.M, error) {
+	m := bson.M{}
+	for key, value := range filter {
+		switch key {
+		case "state":
+			state, ok := value.(types.RentState)
+			if !ok {
+				return nil, fmt.Errorf("invalid state filter %v", value)
+			}
+			if state == types.RentActive {
+				m["state"] = bson.M{"$in": bson.A{state, nil}}
+			} else {
+				m["state"] = state
+			}
+		case "movieID", "userID":
+			m[key] = value
+		default:
+			return nil, fmt.Errorf("unsupported rent filter %q", key)
+		}
+	}
+	return m, nil
+}
+
+func (s *MongoRentStore) findRents(ctx context.Context, filter bson.M, pag *Pagination) (*Page[*types.Rent], error) {
+	page, err := findPage(ctx, s.coll, findQuery{filter: filter}, pag, RentCursor)
 	if err != nil {
 		return nil, err
 	}
-	res, err := s.coll.Find(ctx, bson.M{"userID": oid})
+	for _, rent := range page.Items {
+		if rent.State == "" {
+			rent.State = types.RentActive
+		}
+	}
+	return page, nil
+}
+
+func (s *MongoRentStore) GetRentsByUser(ctx context.Context, userID string, filter map[string]any, pag *Pagination) (*Page[*types.Rent], error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
 	if err != nil {
 		return nil, err
 	}
-	var rents []*types.Rent
-	err = res.All(ctx, &rents)
+	m, err := rentFilter(filter)
 	if err != nil {
 		return nil, err
 	}
-	return rents, nil
+	m["userID"] = oid
+	return s.findRents(ctx, m, pag)
 }
 
+// InsertRent rejects rents overlapping an existing one. The check and the
+// insert are separate operations, so unlike the postgres store two
+// concurrent requests can still both succeed.
 func (s *MongoRentStore) InsertRent(ctx context.Context, rent *types.Rent) (*types.Rent, error) {
+	err := s.CheckRent(ctx, types.CheckRentParams{
+		UserID:  rent.UserID,
+		MovieID: rent.MovieID,
+		From:    rent.From,
+		To:      rent.To,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rent.State == "" {
+		rent.State = types.RentActive
+	}
 	res, err := s.coll.InsertOne(ctx, rent)
 	if err != nil {
 		return nil, err
@@ -61,46 +120,212 @@ func (s *MongoRentStore) InsertRent(ctx context.Context, rent *types.Rent) (*typ
 
 	return rent, err
 }
-func (s *MongoRentStore) GetRents(ctx context.Context) ([]*types.Rent, error) {
-	res, err := s.coll.Find(ctx, bson.M{})
+
+func (s *MongoRentStore) GetRents(ctx context.Context, filter map[string]any, pag *Pagination) (*Page[*types.Rent], error) {
+	m, err := rentFilter(filter)
+	if err != nil {
+		return nil, err
+	}
+	return s.findRents(ctx, m, pag)
+}
+
+func (s *MongoRentStore) GetRentByID(ctx context.Context, id string) (*types.Rent, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var rent types.Rent
+	if err := s.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&rent); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if rent.State == "" {
+		rent.State = types.RentActive
+	}
+	return &rent, nil
+}
+
+// UpdateRentState only applies the update if the rent is still in the state
+// it was read in, so concurrent transitions cannot both succeed.
+func (s *MongoRentStore) UpdateRentState(ctx context.Context, id string, state types.RentState, at time.Time) (*types.Rent, error) {
+	rent, err := s.GetRentByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !rent.State.CanTransitionTo(state) {
+		return nil, ErrInvalidTransition
+	}
+	filter := bson.M{"_id": rent.ID, "state": rent.State}
+	if rent.State == types.RentActive {
+		filter["state"] = bson.M{"$in": bson.A{rent.State, nil}}
+	}
+	rent.SetState(state, at)
+	update := bson.M{"$set": bson.M{
+		"state":      rent.State,
+		"returnedAt": rent.ReturnedAt,
+		"late":       rent.Late,
+	}}
+	res, err := s.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return nil, err
+	}
+	if res.MatchedCount == 0 {
+		return nil, ErrInvalidTransition
+	}
+	return rent, nil
+}
+
+// ExtendRent moves the end of an active rent from from to to, counts the
+// extension and adds its price. It returns ErrInvalidTransition when the
+// rent is no longer active or its end moved since it was read, so
+// concurrent extensions can't both apply.
+func (s *MongoRentStore) ExtendRent(ctx context.Context, id string, from, to time.Time, price int64) (*types.Rent, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	filter := bson.M{"_id": oid, "state": bson.M{"$in": bson.A{types.RentActive, nil}}, "to": from}
+	update := bson.M{"$set": bson.M{"to": to}, "$inc": bson.M{"extensions": 1, "price": price}}
+	var rent types.Rent
+	err = s.coll.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&rent)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		if _, err := s.GetRentByID(ctx, id); err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidTransition
+	}
 	if err != nil {
 		return nil, err
 	}
-	var rents []*types.Rent
-	err = res.All(ctx, &rents)
+	return &rent, nil
+}
+
+// createIndexes adds the index the overdue sweep looks due rents up with.
+func (s *MongoRentStore) createIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "state", Value: 1}, {Key: "to", Value: 1}},
+	})
+	return err
+}
+
+// GetRentsDueBefore returns the open rents that had to be returned before
+// at, the earliest due first.
+func (s *MongoRentStore) GetRentsDueBefore(ctx context.Context, at time.Time) ([]*types.Rent, error) {
+	filter := bson.M{
+		"state": bson.M{"$in": bson.A{types.RentActive, types.RentOverdue, nil}},
+		"to":    bson.M{"$lt": at},
+	}
+	cur, err := s.coll.Find(ctx, filter, options.Find().SetSort(bson.D{{Key: "to", Value: 1}, {Key: "_id", Value: 1}}))
 	if err != nil {
 		return nil, err
 	}
+	rents := []*types.Rent{}
+	if err := cur.All(ctx, &rents); err != nil {
+		return nil, err
+	}
+	for _, rent := range rents {
+		if rent.State == "" {
+			rent.State = types.RentActive
+		}
+	}
 	return rents, nil
 }
 
+// AccrueLateFee raises the late fee of an open rent to fee, a lower fee
+// leaves it unchanged. Fees of returned rents are final, so it returns
+// ErrInvalidTransition for them.
+func (s *MongoRentStore) AccrueLateFee(ctx context.Context, id string, fee int64) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": oid, "state": bson.M{"$in": bson.A{types.RentActive, types.RentOverdue, nil}}}
+	res, err := s.coll.UpdateOne(ctx, filter, bson.M{"$max": bson.M{"lateFee": fee}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		if _, err := s.GetRentByID(ctx, id); err != nil {
+			return err
+		}
+		return ErrInvalidTransition
+	}
+	return nil
+}
+
+// SettleLateFee marks the final late fee of a returned rent as paid. It
+// returns ErrInvalidTransition for rents that weren't returned or whose
+// fee is already paid.
+func (s *MongoRentStore) SettleLateFee(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": oid, "state": types.RentReturned, "lateFeePaid": bson.M{"$ne": true}}
+	res, err := s.coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"lateFeePaid": true}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		if _, err := s.GetRentByID(ctx, id); err != nil {
+			return err
+		}
+		return ErrInvalidTransition
+	}
+	return nil
+}
+
+// GetLateFees sums the unpaid late fees of every rent of the user.
+func (s *MongoRentStore) GetLateFees(ctx context.Context, userID string) (int64, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+	cur, err := s.coll.Aggregate(ctx, bson.A{
+		bson.M{"$match": bson.M{"userID": oid, "lateFee": bson.M{"$gt": 0}, "lateFeePaid": bson.M{"$ne": true}}},
+		bson.M{"$group": bson.M{"_id": nil, "total": bson.M{"$sum": "$lateFee"}}},
+	})
+	if err != nil {
+		return 0, err
+	}
+	var res []struct {
+		Total int64 `bson:"total"`
+	}
+	if err := cur.All(ctx, &res); err != nil {
+		return 0, err
+	}
+	if len(res) == 0 {
+		return 0, nil
+	}
+	return res[0].Total, nil
+}
+
+// CheckRent reports a pending or active rent by the same user for the same
+// movie whose period overlaps the params, or any overdue one, which holds
+// the movie until it is returned. Returned and cancelled rents are ignored
+// since they no longer hold the movie.
 func (s *MongoRentStore) CheckRent(ctx context.Context, params types.CheckRentParams) error {
 	filter := bson.D{
-		{"movieID", params.MovieID},
-		{"userID", params.UserID},
-		{"$or", bson.A{
-			bson.D{
-				{Key: "to", Value: bson.D{
-					{Key: "$gt", Value: params.From},
-					{Key: "$lt", Value: params.To},
-				}},
-			},
+		{Key: "movieID", Value: params.MovieID},
+		{Key: "userID", Value: params.UserID},
+		{Key: "$or", Value: bson.A{
 			bson.D{
-				{Key: "to", Value: bson.D{
-					{Key: "$gt", Value: params.From.Add(-24 * time.Hour)},
-					{Key: "$lt", Value: params.To},
-				}},
+				{Key: "state", Value: bson.M{"$in": bson.A{types.RentPending, types.RentActive}}},
+				{Key: "from", Value: bson.M{"$lt": params.To}},
+				{Key: "to", Value: bson.M{"$gt": params.From}},
 			},
+			bson.D{{Key: "state", Value: types.RentOverdue}},
 		}},
 	}
 
 	res, err := s.coll.CountDocuments(ctx, filter)
-	fmt.Println(res)
 	if err != nil {
 		return err
 	}
 	if res > 0 {
-		return fmt.Errorf("already rented")
+		return ErrAlreadyRented
 	}
 	return nil
 }
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	reviewColl = "reviews"
+)
+
+type ReviewStore interface {
+	InsertReview(context.Context, *types.Review) (*types.Review, error)
+	GetReviewByID(context.Context, string) (*types.Review, error)
+	GetReviews(context.Context, map[string]any, *Pagination) (*Page[*types.Review], error)
+	UpdateReviewBody(context.Context, string, string, time.Time) error
+	UpdateReviewStatus(context.Context, string, types.ReviewStatus, time.Time) error
+	DeleteReview(context.Context, string) error
+}
+
+type MongoReviewStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewReviewStore(client *mongo.Client) *MongoReviewStore {
+	return &MongoReviewStore{
+		client: client,
+		coll:   client.Database(MongoDBName).Collection(reviewColl),
+	}
+}
+
+// createIndexes adds the indexes backing the per-movie listing and the
+// moderation queue.
+func (s *MongoReviewStore) createIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "movieID", Value: 1}, {Key: "status", Value: 1}, {Key: "_id", Value: -1}}},
+		{Keys: bson.D{{Key: "status", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	return err
+}
+
+func (s *MongoReviewStore) InsertReview(ctx context.Context, review *types.Review) (*types.Review, error) {
+	res, err := s.coll.InsertOne(ctx, review)
+	if err != nil {
+		return nil, err
+	}
+	review.ID = res.InsertedID.(primitive.ObjectID)
+	return review, nil
+}
+
+func (s *MongoReviewStore) GetReviewByID(ctx context.Context, id string) (*types.Review, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var review types.Review
+	if err := s.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&review); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &review, nil
+}
+
+// GetReviews returns the newest reviews first. The filter accepts
+// "movieID", "userID" and "status".
+func (s *MongoReviewStore) GetReviews(ctx context.Context, filter map[string]any, pag *Pagination) (*Page[*types.Review], error) {
+	m := bson.M{}
+	for key, value := range filter {
+		switch key {
+		case "movieID", "userID", "status":
+			m[key] = value
+		default:
+			return nil, fmt.Errorf("unsupported review filter %q", key)
+		}
+	}
+	return findPage(ctx, s.coll, findQuery{filter: m, desc: true}, pag, ReviewCursor)
+}
+
+// UpdateReviewBody replaces the text of a review and sends it back to the
+// moderation queue.
+func (s *MongoReviewStore) UpdateReviewBody(ctx context.Context, id string, body string, at time.Time) error {
+	return s.update(ctx, id, bson.M{"body": body, "status": types.ReviewPending, "updatedAt": at})
+}
+
+func (s *MongoReviewStore) UpdateReviewStatus(ctx context.Context, id string, status types.ReviewStatus, at time.Time) error {
+	return s.update(ctx, id, bson.M{"status": status, "updatedAt": at})
+}
+
+func (s *MongoReviewStore) update(ctx context.Context, id string, set bson.M) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *MongoReviewStore) DeleteReview(ctx context.Context, id string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.coll.DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	sessionColl = "sessions"
+)
+
+type SessionStore interface {
+	InsertSession(context.Context, *types.Session) (*types.Session, error)
+	GetSessionByID(context.Context, string) (*types.Session, error)
+	RotateSession(context.Context, string, string, string) error
+	RevokeSession(context.Context, string, time.Time) error
+	RevokeUserSessions(context.Context, string, time.Time) (int, error)
+}
+
+type MongoSessionStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewSessionStore(client *mongo.Client) *MongoSessionStore {
+	return &MongoSessionStore{
+		client: client,
+		coll:   client.Database(MongoDBName).Collection(sessionColl),
+	}
+}
+
+// createIndexes adds the user index used to revoke every session of a user
+// and lets Mongo delete sessions once they expire.
+func (s *MongoSessionStore) createIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{Keys: bson.D{{Key: "userID", Value: 1}}},
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		},
+	})
+	return err
+}
+
+func (s *MongoSessionStore) InsertSession(ctx context.Context, session *types.Session) (*types.Session, error) {
+	res, err := s.coll.InsertOne(ctx, session)
+	if err != nil {
+		return nil, err
+	}
+	session.ID = res.InsertedID.(primitive.ObjectID)
+	return session, nil
+}
+
+func (s *MongoSessionStore) GetSessionByID(ctx context.Context, id string) (*types.Session, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	var session types.Session
+	if err := s.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&session); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &session, nil
+}
+
+// RotateSession replaces the refresh token hash of an unrevoked session
+// only if it still holds oldHash, so a refresh token can be used once even
+// by concurrent requests. Otherwise it returns ErrNotFound.
+func (s *MongoSessionStore) RotateSession(ctx context.Context, id string, oldHash string, newHash string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": oid, "refreshHash": oldHash, "revokedAt": nil}
+	res, err := s.coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"refreshHash": newHash}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeSession revokes the session. Revoking it again keeps the original
+// revocation time.
+func (s *MongoSessionStore) RevokeSession(ctx context.Context, id string, at time.Time) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.A{
+		bson.M{"$set": bson.M{"revokedAt": bson.M{"$ifNull": bson.A{"$revokedAt", at}}}},
+	})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RevokeUserSessions revokes every active session of the user and returns
+// how many were revoked.
+func (s *MongoSessionStore) RevokeUserSessions(ctx context.Context, userID string, at time.Time) (int, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+	filter := bson.M{"userID": oid, "revokedAt": nil, "expiresAt": bson.M{"$gt": at}}
+	res, err := s.coll.UpdateMany(ctx, filter, bson.M{"$set": bson.M{"revokedAt": at}})
+	if err != nil {
+		return 0, err
+	}
+	return int(res.ModifiedCount), nil
+}
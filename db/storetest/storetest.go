@@ -0,0 +1,1981 @@
+// Package storetest holds a conformance suite for the db store interfaces.
+// Every backend runs the same suite so their behaviour cannot drift apart.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Run executes the whole suite. newStore must return an empty store for
+// every call; backends that share state between calls should reset it with
+// t.Cleanup.
+func Run(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	t.Run("Movie", func(t *testing.T) { testMovieStore(t, newStore) })
+	t.Run("User", func(t *testing.T) { testUserStore(t, newStore) })
+	t.Run("Rent", func(t *testing.T) { testRentStore(t, newStore) })
+	t.Run("Copy", func(t *testing.T) { testCopyStore(t, newStore) })
+	t.Run("Rating", func(t *testing.T) { testRatingStore(t, newStore) })
+	t.Run("Review", func(t *testing.T) { testReviewStore(t, newStore) })
+	t.Run("Session", func(t *testing.T) { testSessionStore(t, newStore) })
+	t.Run("ActionToken", func(t *testing.T) { testActionTokenStore(t, newStore) })
+	t.Run("LoginAttempt", func(t *testing.T) { testLoginAttemptStore(t, newStore) })
+	t.Run("Audit", func(t *testing.T) { testAuditStore(t, newStore) })
+	t.Run("TOTP", func(t *testing.T) { testTOTPStore(t, newStore) })
+	t.Run("APIKey", func(t *testing.T) { testAPIKeyStore(t, newStore) })
+	t.Run("PriceRule", func(t *testing.T) { testPriceRuleStore(t, newStore) })
+	t.Run("Payment", func(t *testing.T) { testPaymentStore(t, newStore) })
+	t.Run("Wallet", func(t *testing.T) { testWalletStore(t, newStore) })
+}
+
+func insertMovie(t *testing.T, store *db.Store, title string, genre []string, year int) *types.Movie {
+	t.Helper()
+	movie, err := store.Movie.InsertMovie(context.Background(), &types.Movie{
+		Title:  title,
+		Genre:  genre,
+		Length: 120,
+		Year:   year,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return movie
+}
+
+func insertUser(t *testing.T, store *db.Store, email string) *types.User {
+	t.Helper()
+	user, err := store.User.InsertUser(context.Background(), &types.User{
+		Username:  email,
+		FirstName: "first",
+		LastName:  "last",
+		Email:     email,
+		Roles:     []types.Role{types.RoleCustomer},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func expectNotFound(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, db.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound but got %v", err)
+	}
+}
+
+func testMovieStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+	missingID := primitive.NewObjectID().Hex()
+
+	t.Run("InsertAndGet", func(t *testing.T) {
+		store := newStore(t)
+		movie := insertMovie(t, store, "The Matrix", []string{"Action", "Sci-Fi"}, 1999)
+		if movie.ID.IsZero() {
+			t.Fatal("expected movie id to be set")
+		}
+		got, err := store.Movie.GetMovieByID(ctx, movie.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != movie.ID || got.Title != movie.Title || got.Year != movie.Year || len(got.Genre) != 2 {
+			t.Fatalf("expected %+v but got %+v", movie, got)
+		}
+	})
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		store := newStore(t)
+		_, err := store.Movie.GetMovieByID(ctx, missingID)
+		expectNotFound(t, err)
+		if _, err := store.Movie.GetMovieByID(ctx, "invalid"); err == nil {
+			t.Fatal("expected an error for an invalid id")
+		}
+	})
+
+	t.Run("GetMoviesFilter", func(t *testing.T) {
+		store := newStore(t)
+		matrix := insertMovie(t, store, "The Matrix", []string{"Action", "Sci-Fi"}, 1999)
+		insertMovie(t, store, "Titanic", []string{"Drama", "Romance"}, 1997)
+		if err := store.Movie.UpdateRatingStats(ctx, matrix.ID.Hex(), types.RatingStats{Average: 8, Count: 1}); err != nil {
+			t.Fatal(err)
+		}
+		page, err := store.Movie.GetMovies(ctx, map[string]any{"rating": 8}, &db.Pagination{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if movies := page.Items; len(movies) != 1 || movies[0].ID != matrix.ID {
+			t.Fatalf("expected only %s but got %d movies", matrix.Title, len(movies))
+		}
+		page, err = store.Movie.GetMovies(ctx, map[string]any{"genre": "Drama"}, &db.Pagination{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if movies := page.Items; len(movies) != 1 || movies[0].Title != "Titanic" {
+			t.Fatalf("expected only Titanic but got %d movies", len(movies))
+		}
+	})
+
+	t.Run("GetMoviesPagination", func(t *testing.T) {
+		store := newStore(t)
+		for _, title := range []string{"A", "B", "C", "D", "E"} {
+			insertMovie(t, store, title, []string{"Drama"}, 2000)
+		}
+		var (
+			titles []string
+			pag    = &db.Pagination{Limit: 2}
+		)
+		for pages := 1; ; pages++ {
+			page, err := store.Movie.GetMovies(ctx, map[string]any{}, pag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != 5 {
+				t.Fatalf("expected a total of 5 on every page but got %d", page.Total)
+			}
+			for _, movie := range page.Items {
+				titles = append(titles, movie.Title)
+			}
+			if !page.HasNext {
+				if pages != 3 || len(page.Items) != 1 || page.NextCursor != "" {
+					t.Fatalf("expected a last third page with 1 movie but got page %d with %d movies", pages, len(page.Items))
+				}
+				break
+			}
+			if len(page.Items) != 2 || page.NextCursor == "" {
+				t.Fatalf("expected a full page with a next cursor but got %d movies", len(page.Items))
+			}
+			pag = &db.Pagination{Limit: 2, Cursor: page.NextCursor}
+		}
+		if strings.Join(titles, "") != "ABCDE" {
+			t.Fatalf("expected to walk through ABCDE but got %v", titles)
+		}
+		page, err := store.Movie.GetMovies(ctx, map[string]any{}, &db.Pagination{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 5 || page.HasNext {
+			t.Fatalf("expected a zero limit to return all 5 movies but got %d", len(page.Items))
+		}
+		_, err = store.Movie.GetMovies(ctx, map[string]any{}, &db.Pagination{Limit: 2, Cursor: "garbage"})
+		if !errors.Is(err, db.ErrInvalidCursor) {
+			t.Fatalf("expected ErrInvalidCursor but got %v", err)
+		}
+	})
+
+	t.Run("SearchMovies", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			matrix   = insertMovie(t, store, "The Matrix", []string{"Action", "Sci-Fi"}, 1999)
+			reloaded = insertMovie(t, store, "The Matrix Reloaded", []string{"Action", "Sci-Fi"}, 2003)
+			titanic  = insertMovie(t, store, "Titanic", []string{"Drama", "Romance"}, 1997)
+		)
+		insertMovie(t, store, "Heat", []string{"Action", "Drama"}, 1995)
+		for id, stats := range map[string]types.RatingStats{
+			matrix.ID.Hex():   {Average: 9, Count: 3},
+			reloaded.ID.Hex(): {Average: 6.5, Count: 1},
+			titanic.ID.Hex():  {Average: 7.5, Count: 5},
+		} {
+			if err := store.Movie.UpdateRatingStats(ctx, id, stats); err != nil {
+				t.Fatal(err)
+			}
+		}
+		search := func(t *testing.T, params types.MovieSearchParams, pag *db.Pagination) *db.MovieSearchResult {
+			t.Helper()
+			res, err := store.Movie.SearchMovies(ctx, params, pag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return res
+		}
+		titles := func(movies []*types.Movie) []string {
+			titles := []string{}
+			for _, movie := range movies {
+				titles = append(titles, movie.Title)
+			}
+			return titles
+		}
+		expect := func(t *testing.T, res *db.MovieSearchResult, want ...string) {
+			t.Helper()
+			got := titles(res.Items)
+			if len(got) != len(want) {
+				t.Fatalf("expected %v but got %v", want, got)
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Fatalf("expected %v but got %v", want, got)
+				}
+			}
+		}
+
+		res := search(t, types.MovieSearchParams{}, nil)
+		expect(t, res, "The Matrix", "The Matrix Reloaded", "Titanic", "Heat")
+		if res.Total != 4 {
+			t.Fatalf("expected a total of 4 but got %d", res.Total)
+		}
+		if res.Facets.Genre["Action"] != 3 || res.Facets.Genre["Romance"] != 1 {
+			t.Fatalf("unexpected genre facets %v", res.Facets.Genre)
+		}
+		if res.Facets.Decade[1990] != 3 || res.Facets.Decade[2000] != 1 {
+			t.Fatalf("unexpected decade facets %v", res.Facets.Decade)
+		}
+
+		expect(t, search(t, types.MovieSearchParams{Query: "matrix"}, nil), "The Matrix", "The Matrix Reloaded")
+		expect(t, search(t, types.MovieSearchParams{Query: "RELOADED matrix"}, nil), "The Matrix Reloaded")
+		expect(t, search(t, types.MovieSearchParams{Query: "mat"}, nil))
+		expect(t, search(t, types.MovieSearchParams{Genre: []string{"Romance", "Sci-Fi"}}, nil), "The Matrix", "The Matrix Reloaded", "Titanic")
+		expect(t, search(t, types.MovieSearchParams{GenreAll: []string{"Action", "Drama"}}, nil), "Heat")
+		expect(t, search(t, types.MovieSearchParams{YearFrom: 1997, YearTo: 1999}, nil), "The Matrix", "Titanic")
+		expect(t, search(t, types.MovieSearchParams{MinRating: 7.5}, nil), "The Matrix", "Titanic")
+		expect(t, search(t, types.MovieSearchParams{Sort: "title"}, nil), "Heat", "The Matrix", "The Matrix Reloaded", "Titanic")
+		expect(t, search(t, types.MovieSearchParams{Sort: "-year"}, nil), "The Matrix Reloaded", "The Matrix", "Titanic", "Heat")
+		expect(t, search(t, types.MovieSearchParams{Sort: "-rating"}, nil), "The Matrix", "Titanic", "The Matrix Reloaded", "Heat")
+		expect(t, search(t, types.MovieSearchParams{Sort: "-popularity"}, nil), "Titanic", "The Matrix", "The Matrix Reloaded", "Heat")
+
+		params := types.MovieSearchParams{Genre: []string{"Action"}, Sort: "-rating"}
+		res = search(t, params, &db.Pagination{Limit: 2})
+		expect(t, res, "The Matrix", "The Matrix Reloaded")
+		if !res.HasNext {
+			t.Fatal("expected another page")
+		}
+		res = search(t, params, &db.Pagination{Limit: 2, Cursor: res.NextCursor})
+		expect(t, res, "Heat")
+		if res.HasNext || res.Total != 3 || res.Facets.Genre["Action"] != 3 || res.Facets.Genre["Sci-Fi"] != 2 || res.Facets.Genre["Romance"] != 0 {
+			t.Fatalf("expected facets over all 3 matches but got total %d and %v", res.Total, res.Facets.Genre)
+		}
+		params.Sort = "title"
+		res = search(t, params, &db.Pagination{Limit: 1})
+		expect(t, res, "Heat")
+		res = search(t, params, &db.Pagination{Limit: 1, Cursor: res.NextCursor})
+		expect(t, res, "The Matrix")
+		_, err := store.Movie.SearchMovies(ctx, types.MovieSearchParams{Sort: "year"}, &db.Pagination{Limit: 1, Cursor: res.NextCursor})
+		if !errors.Is(err, db.ErrInvalidCursor) {
+			t.Fatalf("expected ErrInvalidCursor for a cursor of another sort but got %v", err)
+		}
+	})
+
+	t.Run("PutMovie", func(t *testing.T) {
+		store := newStore(t)
+		movie := insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+		params := types.UpdateMovieParams{Title: "The Matrix Reloaded", Year: 2003}
+		if err := store.Movie.PutMovie(ctx, movie.ID.Hex(), params); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Movie.GetMovieByID(ctx, movie.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Title != params.Title || got.Year != params.Year || got.Length != movie.Length {
+			t.Fatalf("unexpected movie after update %+v", got)
+		}
+		expectNotFound(t, store.Movie.PutMovie(ctx, missingID, params))
+	})
+
+	t.Run("UpdateRatingStats", func(t *testing.T) {
+		store := newStore(t)
+		movie := insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+		stats := types.RatingStats{Average: 7.5, Count: 4}
+		if err := store.Movie.UpdateRatingStats(ctx, movie.ID.Hex(), stats); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Movie.GetMovieByID(ctx, movie.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Rating != 8 || got.RatingAvg != stats.Average || got.RatingCount != stats.Count {
+			t.Fatalf("expected rating 8 (7.5 from 4 ratings) but got %d (%v from %d ratings)", got.Rating, got.RatingAvg, got.RatingCount)
+		}
+		expectNotFound(t, store.Movie.UpdateRatingStats(ctx, missingID, stats))
+	})
+
+	t.Run("DeleteMovie", func(t *testing.T) {
+		store := newStore(t)
+		movie := insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+		if err := store.Movie.DeleteMovie(ctx, movie.ID.Hex()); err != nil {
+			t.Fatal(err)
+		}
+		_, err := store.Movie.GetMovieByID(ctx, movie.ID.Hex())
+		expectNotFound(t, err)
+		expectNotFound(t, store.Movie.DeleteMovie(ctx, movie.ID.Hex()))
+	})
+}
+
+func testUserStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+	missingID := primitive.NewObjectID().Hex()
+
+	t.Run("InsertAndGet", func(t *testing.T) {
+		store := newStore(t)
+		user := insertUser(t, store, "tomek@test.com")
+		if user.ID.IsZero() {
+			t.Fatal("expected user id to be set")
+		}
+		got, err := store.User.GetUserByID(ctx, user.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != user.ID || got.Email != user.Email {
+			t.Fatalf("expected %+v but got %+v", user, got)
+		}
+		got, err = store.User.GetUserByEmail(ctx, user.Email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != user.ID {
+			t.Fatalf("expected user %s but got %s", user.ID, got.ID)
+		}
+	})
+
+	t.Run("GetNotFound", func(t *testing.T) {
+		store := newStore(t)
+		_, err := store.User.GetUserByID(ctx, missingID)
+		expectNotFound(t, err)
+		_, err = store.User.GetUserByEmail(ctx, "nobody@test.com")
+		expectNotFound(t, err)
+	})
+
+	t.Run("GetUsers", func(t *testing.T) {
+		store := newStore(t)
+		insertUser(t, store, "a@test.com")
+		insertUser(t, store, "b@test.com")
+		insertUser(t, store, "c@test.com")
+		page, err := store.User.GetUsers(ctx, &db.Pagination{Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 2 || page.Total != 3 || !page.HasNext {
+			t.Fatalf("expected 2 of 3 users but got %d of %d", len(page.Items), page.Total)
+		}
+		page, err = store.User.GetUsers(ctx, &db.Pagination{Limit: 2, Cursor: page.NextCursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 1 || page.Items[0].Email != "c@test.com" || page.HasNext {
+			t.Fatalf("expected only the last user on the second page but got %d users", len(page.Items))
+		}
+	})
+
+	t.Run("Duplicates", func(t *testing.T) {
+		store := newStore(t)
+		user := insertUser(t, store, "tomek@test.com")
+		for field, dup := range map[string]*types.User{
+			"email":    {Username: "other", Email: user.Email},
+			"username": {Username: user.Username, Email: "other@test.com"},
+		} {
+			_, err := store.User.InsertUser(ctx, dup)
+			var dupErr *db.DuplicateError
+			if !errors.As(err, &dupErr) || dupErr.Field != field {
+				t.Fatalf("expected a duplicate %s error but got %v", field, err)
+			}
+		}
+		got, err := store.User.GetUserByEmail(ctx, " Tomek@Test.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != user.ID {
+			t.Fatalf("expected user %s but got %s", user.ID, got.ID)
+		}
+	})
+
+	t.Run("Identity", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			identity = types.Identity{Issuer: "https://sso.test", Subject: "42"}
+			user     = insertUser(t, store, "tomek@test.com")
+			other    = insertUser(t, store, "other@test.com")
+		)
+		_, err := store.User.GetUserByIdentity(ctx, identity)
+		expectNotFound(t, err)
+		if err := store.User.LinkIdentity(ctx, user.ID.Hex(), identity); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.User.GetUserByIdentity(ctx, identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != user.ID || got.Identity == nil || *got.Identity != identity {
+			t.Fatalf("unexpected user %+v", got)
+		}
+		expectNotFound(t, store.User.LinkIdentity(ctx, user.ID.Hex(), types.Identity{Issuer: "https://sso.test", Subject: "43"}))
+		var dupErr *db.DuplicateError
+		if err := store.User.LinkIdentity(ctx, other.ID.Hex(), identity); !errors.As(err, &dupErr) || dupErr.Field != "identity" {
+			t.Fatalf("expected a duplicate identity error but got %v", err)
+		}
+		_, err = store.User.InsertUser(ctx, &types.User{Username: "new", Email: "new@test.com", Identity: &identity})
+		if !errors.As(err, &dupErr) || dupErr.Field != "identity" {
+			t.Fatalf("expected a duplicate identity error but got %v", err)
+		}
+		if got, err := store.User.GetUserByID(ctx, other.ID.Hex()); err != nil || got.Identity != nil {
+			t.Fatalf("expected other users to stay unlinked but got %+v, %v", got, err)
+		}
+	})
+
+	t.Run("UpdateUser", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			user  = insertUser(t, store, "tomek@test.com")
+			other = insertUser(t, store, "other@test.com")
+		)
+		if err := store.User.SetEmailVerified(ctx, user.ID.Hex(), true); err != nil {
+			t.Fatal(err)
+		}
+		updated, err := store.User.UpdateUser(ctx, user.ID.Hex(), types.UpdateUserParams{FirstName: "tom", Email: user.Email})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.FirstName != "tom" || updated.LastName != user.LastName || !updated.EmailVerified {
+			t.Fatalf("expected only the first name to change but got %+v", updated)
+		}
+		updated, err = store.User.UpdateUser(ctx, user.ID.Hex(), types.UpdateUserParams{Email: "new@test.com"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if updated.Email != "new@test.com" || updated.EmailVerified || updated.FirstName != "tom" {
+			t.Fatalf("expected a new unverified email but got %+v", updated)
+		}
+		// values are stored as they are, not read as field paths or variables
+		updated, err = store.User.UpdateUser(ctx, user.ID.Hex(), types.UpdateUserParams{Username: "$$REMOVE", FirstName: "$encryptedPassword", LastName: "$lastName"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.User.GetUserByID(ctx, user.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, u := range []*types.User{updated, got} {
+			if u.Username != "$$REMOVE" || u.FirstName != "$encryptedPassword" || u.LastName != "$lastName" {
+				t.Fatalf("expected the names to be stored literally but got %+v", u)
+			}
+		}
+		_, err = store.User.UpdateUser(ctx, user.ID.Hex(), types.UpdateUserParams{Email: other.Email})
+		var dupErr *db.DuplicateError
+		if !errors.As(err, &dupErr) || dupErr.Field != "email" {
+			t.Fatalf("expected a duplicate email error but got %v", err)
+		}
+		_, err = store.User.UpdateUser(ctx, missingID, types.UpdateUserParams{FirstName: "tom"})
+		expectNotFound(t, err)
+	})
+
+	t.Run("UpdateUserRoles", func(t *testing.T) {
+		store := newStore(t)
+		user := insertUser(t, store, "tomek@test.com")
+		roles := []types.Role{types.RoleClerk, types.RoleCatalogManager}
+		if err := store.User.UpdateUserRoles(ctx, user.ID.Hex(), roles); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.User.GetUserByID(ctx, user.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(got.Roles, roles) {
+			t.Fatalf("expected roles %v but got %v", roles, got.Roles)
+		}
+		expectNotFound(t, store.User.UpdateUserRoles(ctx, missingID, roles))
+	})
+
+	t.Run("UpdateUserPassword", func(t *testing.T) {
+		store := newStore(t)
+		user := insertUser(t, store, "tomek@test.com")
+		if err := store.User.UpdateUserPassword(ctx, user.ID.Hex(), "encrypted"); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.User.GetUserByID(ctx, user.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.EncryptedPassword != "encrypted" {
+			t.Fatalf("expected the updated password but got %q", got.EncryptedPassword)
+		}
+		expectNotFound(t, store.User.UpdateUserPassword(ctx, missingID, "encrypted"))
+	})
+
+	t.Run("SetEmailVerified", func(t *testing.T) {
+		store := newStore(t)
+		user := insertUser(t, store, "tomek@test.com")
+		if err := store.User.SetEmailVerified(ctx, user.ID.Hex(), true); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.User.GetUserByID(ctx, user.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.EmailVerified {
+			t.Fatal("expected the user to be verified")
+		}
+		expectNotFound(t, store.User.SetEmailVerified(ctx, missingID, true))
+	})
+
+	t.Run("DeleteUser", func(t *testing.T) {
+		store := newStore(t)
+		user := insertUser(t, store, "tomek@test.com")
+		if err := store.User.DeleteUser(ctx, user.ID.Hex()); err != nil {
+			t.Fatal(err)
+		}
+		_, err := store.User.GetUserByID(ctx, user.ID.Hex())
+		expectNotFound(t, err)
+		expectNotFound(t, store.User.DeleteUser(ctx, user.ID.Hex()))
+	})
+}
+
+func testRentStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+
+	t.Run("InsertAndList", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			matrix  = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			titanic = insertMovie(t, store, "Titanic", []string{"Drama"}, 1997)
+			tomek   = insertUser(t, store, "tomek@test.com")
+			zuzia   = insertUser(t, store, "zuzia@test.com")
+		)
+		for _, params := range []types.CreateRentParams{
+			{UserID: tomek.ID, MovieID: matrix.ID},
+			{UserID: tomek.ID, MovieID: titanic.ID},
+			{UserID: zuzia.ID, MovieID: matrix.ID},
+		} {
+			rent := types.NewRentFromParams(params)
+			if _, err := store.Rent.InsertRent(ctx, rent); err != nil {
+				t.Fatal(err)
+			}
+			if rent.ID.IsZero() {
+				t.Fatal("expected rent id to be set")
+			}
+		}
+		page, err := store.Rent.GetRents(ctx, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 3 || page.Total != 3 {
+			t.Fatalf("expected 3 rents but got %d", len(page.Items))
+		}
+		page, err = store.Rent.GetRentsByUser(ctx, tomek.ID.Hex(), nil, &db.Pagination{Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 1 || page.Total != 2 || !page.HasNext {
+			t.Fatalf("expected 1 of 2 rents for %s but got %d of %d", tomek.Email, len(page.Items), page.Total)
+		}
+		rents := page.Items
+		page, err = store.Rent.GetRentsByUser(ctx, tomek.ID.Hex(), nil, &db.Pagination{Limit: 1, Cursor: page.NextCursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 1 || page.HasNext || page.Items[0].ID == rents[0].ID {
+			t.Fatalf("expected the other rent of %s on the second page", tomek.Email)
+		}
+		for _, rent := range append(rents, page.Items...) {
+			if rent.UserID != tomek.ID {
+				t.Fatalf("unexpected rent %+v", rent)
+			}
+		}
+	})
+
+	t.Run("InsertRentOverlap", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			movie  = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			user   = insertUser(t, store, "tomek@test.com")
+			params = types.CreateRentParams{UserID: user.ID, MovieID: movie.ID}
+		)
+		if _, err := store.Rent.InsertRent(ctx, types.NewRentFromParams(params)); err != nil {
+			t.Fatal(err)
+		}
+		_, err := store.Rent.InsertRent(ctx, types.NewRentFromParams(params))
+		if !errors.Is(err, db.ErrAlreadyRented) {
+			t.Fatalf("expected ErrAlreadyRented but got %v", err)
+		}
+		page, err := store.Rent.GetRentsByUser(ctx, user.ID.Hex(), nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 1 {
+			t.Fatalf("expected 1 rent but got %d", len(page.Items))
+		}
+	})
+
+	t.Run("CheckRent", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			matrix  = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			titanic = insertMovie(t, store, "Titanic", []string{"Drama"}, 1997)
+			tomek   = insertUser(t, store, "tomek@test.com")
+			zuzia   = insertUser(t, store, "zuzia@test.com")
+			now     = time.Now()
+		)
+		_, err := store.Rent.InsertRent(ctx, &types.Rent{
+			UserID:  tomek.ID,
+			MovieID: matrix.ID,
+			From:    now,
+			To:      now.Add(24 * time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.Rent.InsertRent(ctx, &types.Rent{
+			UserID:  zuzia.ID,
+			MovieID: matrix.ID,
+			From:    now.Add(-72 * time.Hour),
+			To:      now.Add(-48 * time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		check := func(user *types.User, movie *types.Movie) error {
+			return store.Rent.CheckRent(ctx, types.CheckRentParams{
+				UserID:  user.ID,
+				MovieID: movie.ID,
+				From:    now.Add(time.Minute),
+				To:      now.Add(25 * time.Hour),
+			})
+		}
+		if err := check(tomek, matrix); !errors.Is(err, db.ErrAlreadyRented) {
+			t.Fatalf("expected ErrAlreadyRented but got %v", err)
+		}
+		if err := check(tomek, titanic); err != nil {
+			t.Fatalf("expected a different movie to be free but got %v", err)
+		}
+		if err := check(zuzia, matrix); err != nil {
+			t.Fatalf("expected an expired rent not to block but got %v", err)
+		}
+		// a week long rent blocks the whole week, not only its last day
+		_, err = store.Rent.InsertRent(ctx, &types.Rent{
+			UserID:  zuzia.ID,
+			MovieID: titanic.ID,
+			From:    now,
+			To:      now.Add(7 * 24 * time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := check(zuzia, titanic); !errors.Is(err, db.ErrAlreadyRented) {
+			t.Fatalf("expected ErrAlreadyRented but got %v", err)
+		}
+		// an overdue rent holds the movie until it comes back, however long
+		// ago it was due
+		overdue, err := store.Rent.InsertRent(ctx, &types.Rent{
+			UserID:  tomek.ID,
+			MovieID: titanic.ID,
+			From:    now.Add(-72 * time.Hour),
+			To:      now.Add(-48 * time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Rent.UpdateRentState(ctx, overdue.ID.Hex(), types.RentOverdue, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := check(tomek, titanic); !errors.Is(err, db.ErrAlreadyRented) {
+			t.Fatalf("expected an overdue rent to block but got %v", err)
+		}
+	})
+
+	t.Run("PendingRent", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			movie = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			user  = insertUser(t, store, "tomek@test.com")
+			now   = time.Now()
+		)
+		rent, err := store.Rent.InsertRent(ctx, &types.Rent{
+			UserID:  user.ID,
+			MovieID: movie.ID,
+			From:    now,
+			To:      now.Add(24 * time.Hour),
+			State:   types.RentPending,
+			Price:   399,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		check := func() error {
+			return store.Rent.CheckRent(ctx, types.CheckRentParams{
+				UserID:  user.ID,
+				MovieID: movie.ID,
+				From:    now.Add(time.Minute),
+				To:      now.Add(25 * time.Hour),
+			})
+		}
+		// a rent waiting for its payment holds the movie like an active one
+		if err := check(); !errors.Is(err, db.ErrAlreadyRented) {
+			t.Fatalf("expected ErrAlreadyRented but got %v", err)
+		}
+		got, err := store.Rent.GetRentByID(ctx, rent.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.State != types.RentPending {
+			t.Fatalf("expected a pending rent but got %s", got.State)
+		}
+		active, err := store.Rent.UpdateRentState(ctx, rent.ID.Hex(), types.RentActive, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if active.State != types.RentActive {
+			t.Fatalf("expected the paid rent to be active but got %s", active.State)
+		}
+		if err := check(); !errors.Is(err, db.ErrAlreadyRented) {
+			t.Fatalf("expected ErrAlreadyRented but got %v", err)
+		}
+	})
+
+	t.Run("ExtendRent", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			movie = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			user  = insertUser(t, store, "tomek@test.com")
+		)
+		inserted, err := store.Rent.InsertRent(ctx, types.NewRentFromParams(types.CreateRentParams{UserID: user.ID, MovieID: movie.ID, Price: 399, Currency: "USD"}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		// read the rent back so its end has the precision of the backend
+		rent, err := store.Rent.GetRentByID(ctx, inserted.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		to := rent.To.Add(48 * time.Hour)
+		if rent.Price != 399 || rent.Currency != "USD" {
+			t.Fatalf("expected the price to be stored but got %d %s", rent.Price, rent.Currency)
+		}
+		extended, err := store.Rent.ExtendRent(ctx, rent.ID.Hex(), rent.To, to, 250)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if extended.To.Sub(to).Abs() > time.Millisecond || extended.Extensions != 1 || extended.Price != 649 {
+			t.Fatalf("expected the rent to end at %s after 1 extension costing 649 but got %+v", to, extended)
+		}
+		// the end moved, so extending from the old one is a lost race
+		_, err = store.Rent.ExtendRent(ctx, rent.ID.Hex(), rent.To, to.Add(24*time.Hour), 250)
+		if !errors.Is(err, db.ErrInvalidTransition) {
+			t.Fatalf("expected ErrInvalidTransition but got %v", err)
+		}
+		got, err := store.Rent.GetRentByID(ctx, rent.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Extensions != 1 || got.Price != 649 {
+			t.Fatalf("expected 1 stored extension costing 649 but got %d, %d", got.Extensions, got.Price)
+		}
+		if _, err := store.Rent.UpdateRentState(ctx, rent.ID.Hex(), types.RentReturned, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		_, err = store.Rent.ExtendRent(ctx, rent.ID.Hex(), got.To, got.To.Add(24*time.Hour), 250)
+		if !errors.Is(err, db.ErrInvalidTransition) {
+			t.Fatalf("expected a returned rent not to extend but got %v", err)
+		}
+		_, err = store.Rent.ExtendRent(ctx, primitive.NewObjectID().Hex(), got.To, got.To.Add(24*time.Hour), 250)
+		expectNotFound(t, err)
+	})
+
+	t.Run("UpdateRentState", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			movie = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			user  = insertUser(t, store, "tomek@test.com")
+			now   = time.Now()
+		)
+		rent, err := store.Rent.InsertRent(ctx, &types.Rent{
+			UserID:  user.ID,
+			MovieID: movie.ID,
+			From:    now.Add(-48 * time.Hour),
+			To:      now.Add(-24 * time.Hour),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rent.State != types.RentActive {
+			t.Fatalf("expected a new rent to be active but got %s", rent.State)
+		}
+		if _, err := store.Rent.UpdateRentState(ctx, rent.ID.Hex(), types.RentOverdue, now); err != nil {
+			t.Fatal(err)
+		}
+		returned, err := store.Rent.UpdateRentState(ctx, rent.ID.Hex(), types.RentReturned, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if returned.State != types.RentReturned || returned.ReturnedAt == nil || !returned.Late {
+			t.Fatalf("expected a late return but got %+v", returned)
+		}
+		got, err := store.Rent.GetRentByID(ctx, rent.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.State != types.RentReturned || got.ReturnedAt == nil || !got.Late {
+			t.Fatalf("expected the return to be stored but got %+v", got)
+		}
+		_, err = store.Rent.UpdateRentState(ctx, rent.ID.Hex(), types.RentCancelled, now)
+		if !errors.Is(err, db.ErrInvalidTransition) {
+			t.Fatalf("expected ErrInvalidTransition but got %v", err)
+		}
+		_, err = store.Rent.UpdateRentState(ctx, primitive.NewObjectID().Hex(), types.RentReturned, now)
+		expectNotFound(t, err)
+		_, err = store.Rent.GetRentByID(ctx, primitive.NewObjectID().Hex())
+		expectNotFound(t, err)
+	})
+
+	t.Run("LateFees", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			matrix  = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			titanic = insertMovie(t, store, "Titanic", []string{"Drama"}, 1997)
+			dune    = insertMovie(t, store, "Dune", []string{"Sci-Fi"}, 2021)
+			tomek   = insertUser(t, store, "tomek@test.com")
+			zuzia   = insertUser(t, store, "zuzia@test.com")
+			now     = time.Now()
+		)
+		insert := func(user *types.User, movie *types.Movie, to time.Time) *types.Rent {
+			t.Helper()
+			rent, err := store.Rent.InsertRent(ctx, &types.Rent{UserID: user.ID, MovieID: movie.ID, From: to.Add(-24 * time.Hour), To: to})
+			if err != nil {
+				t.Fatal(err)
+			}
+			return rent
+		}
+		var (
+			late     = insert(tomek, matrix, now.Add(-48*time.Hour))
+			later    = insert(zuzia, matrix, now.Add(-time.Hour))
+			returned = insert(tomek, titanic, now.Add(-72*time.Hour))
+			_        = insert(tomek, dune, now.Add(time.Hour))
+		)
+		if _, err := store.Rent.UpdateRentState(ctx, returned.ID.Hex(), types.RentReturned, now); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Rent.UpdateRentState(ctx, later.ID.Hex(), types.RentOverdue, now); err != nil {
+			t.Fatal(err)
+		}
+		due, err := store.Rent.GetRentsDueBefore(ctx, now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != 2 || due[0].ID != late.ID || due[1].ID != later.ID {
+			t.Fatalf("expected the two open rents past due, earliest first, but got %+v", due)
+		}
+
+		if err := store.Rent.AccrueLateFee(ctx, late.ID.Hex(), 200); err != nil {
+			t.Fatal(err)
+		}
+		// fees only grow
+		if err := store.Rent.AccrueLateFee(ctx, late.ID.Hex(), 100); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Rent.AccrueLateFee(ctx, later.ID.Hex(), 100); err != nil {
+			t.Fatal(err)
+		}
+		err = store.Rent.AccrueLateFee(ctx, returned.ID.Hex(), 300)
+		if !errors.Is(err, db.ErrInvalidTransition) {
+			t.Fatalf("expected a returned rent's fee to be final but got %v", err)
+		}
+		expectNotFound(t, store.Rent.AccrueLateFee(ctx, primitive.NewObjectID().Hex(), 100))
+		got, err := store.Rent.GetRentByID(ctx, late.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.LateFee != 200 {
+			t.Fatalf("expected a late fee of 200 but got %d", got.LateFee)
+		}
+		for user, want := range map[*types.User]int64{tomek: 200, zuzia: 100} {
+			total, err := store.Rent.GetLateFees(ctx, user.ID.Hex())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total != want {
+				t.Fatalf("expected %s to owe %d but got %d", user.Email, want, total)
+			}
+		}
+		// paid fees are no longer owed, only fees of returned rents are paid
+		err = store.Rent.SettleLateFee(ctx, late.ID.Hex())
+		if !errors.Is(err, db.ErrInvalidTransition) {
+			t.Fatalf("expected an open rent's fee not to be settled but got %v", err)
+		}
+		if _, err := store.Rent.UpdateRentState(ctx, late.ID.Hex(), types.RentReturned, now); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Rent.SettleLateFee(ctx, late.ID.Hex()); err != nil {
+			t.Fatal(err)
+		}
+		err = store.Rent.SettleLateFee(ctx, late.ID.Hex())
+		if !errors.Is(err, db.ErrInvalidTransition) {
+			t.Fatalf("expected a paid fee not to be settled twice but got %v", err)
+		}
+		expectNotFound(t, store.Rent.SettleLateFee(ctx, primitive.NewObjectID().Hex()))
+		if total, err := store.Rent.GetLateFees(ctx, tomek.ID.Hex()); err != nil || total != 0 {
+			t.Fatalf("expected tomek to owe nothing but got %d, %v", total, err)
+		}
+	})
+
+	t.Run("FilterByState", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			matrix  = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			titanic = insertMovie(t, store, "Titanic", []string{"Drama"}, 1997)
+			user    = insertUser(t, store, "tomek@test.com")
+		)
+		returned, err := store.Rent.InsertRent(ctx, types.NewRentFromParams(types.CreateRentParams{UserID: user.ID, MovieID: matrix.ID}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Rent.UpdateRentState(ctx, returned.ID.Hex(), types.RentReturned, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := store.Rent.InsertRent(ctx, types.NewRentFromParams(types.CreateRentParams{UserID: user.ID, MovieID: titanic.ID})); err != nil {
+			t.Fatal(err)
+		}
+		page, err := store.Rent.GetRentsByUser(ctx, user.ID.Hex(), map[string]any{"state": types.RentActive}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if active := page.Items; len(active) != 1 || active[0].MovieID != titanic.ID {
+			t.Fatalf("expected only the Titanic rent to be active but got %d rents", len(active))
+		}
+		page, err = store.Rent.GetRents(ctx, map[string]any{"state": types.RentReturned}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rents := page.Items; len(rents) != 1 || rents[0].ID != returned.ID {
+			t.Fatalf("expected only the returned rent but got %d rents", len(rents))
+		}
+		// a returned rent no longer blocks renting the movie again
+		if _, err := store.Rent.InsertRent(ctx, types.NewRentFromParams(types.CreateRentParams{UserID: user.ID, MovieID: matrix.ID})); err != nil {
+			t.Fatalf("expected to rent a returned movie again but got %v", err)
+		}
+	})
+}
+
+func insertCopy(t *testing.T, store *db.Store, movie *types.Movie, format types.CopyFormat) *types.Copy {
+	t.Helper()
+	cp, err := store.Copy.InsertCopy(context.Background(), types.NewCopyFromParams(movie.ID, types.CreateCopyParams{Format: format}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cp
+}
+
+func testCopyStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+	missingID := primitive.NewObjectID().Hex()
+
+	t.Run("InsertAndList", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			matrix  = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			titanic = insertMovie(t, store, "Titanic", []string{"Drama"}, 1997)
+			dvd     = insertCopy(t, store, matrix, types.FormatDVD)
+		)
+		insertCopy(t, store, matrix, types.FormatBluRay)
+		insertCopy(t, store, titanic, types.FormatDVD)
+		if dvd.ID.IsZero() {
+			t.Fatal("expected copy id to be set")
+		}
+		got, err := store.Copy.GetCopyByID(ctx, dvd.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.MovieID != matrix.ID || got.Format != types.FormatDVD || got.Rented || got.Retired {
+			t.Fatalf("unexpected copy %+v", got)
+		}
+		copies, err := store.Copy.GetCopies(ctx, matrix.ID.Hex(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(copies) != 2 {
+			t.Fatalf("expected 2 copies but got %d", len(copies))
+		}
+		copies, err = store.Copy.GetCopies(ctx, matrix.ID.Hex(), map[string]any{"format": types.FormatBluRay})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(copies) != 1 || copies[0].Format != types.FormatBluRay {
+			t.Fatalf("expected 1 bluray copy but got %d", len(copies))
+		}
+		_, err = store.Copy.GetCopyByID(ctx, missingID)
+		expectNotFound(t, err)
+	})
+
+	t.Run("ReserveAndRelease", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			movie  = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			dvd    = insertCopy(t, store, movie, types.FormatDVD)
+			bluRay = insertCopy(t, store, movie, types.FormatBluRay)
+		)
+		cp, err := store.Copy.ReserveCopy(ctx, movie.ID.Hex(), types.FormatBluRay)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cp.ID != bluRay.ID || !cp.Rented {
+			t.Fatalf("expected the bluray copy to be reserved but got %+v", cp)
+		}
+		if _, err := store.Copy.ReserveCopy(ctx, movie.ID.Hex(), types.FormatBluRay); !errors.Is(err, db.ErrNoCopiesAvailable) {
+			t.Fatalf("expected ErrNoCopiesAvailable but got %v", err)
+		}
+		cp, err = store.Copy.ReserveCopy(ctx, movie.ID.Hex(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cp.ID != dvd.ID {
+			t.Fatalf("expected the dvd copy to be reserved but got %s", cp.ID)
+		}
+		if _, err := store.Copy.ReserveCopy(ctx, movie.ID.Hex(), ""); !errors.Is(err, db.ErrNoCopiesAvailable) {
+			t.Fatalf("expected ErrNoCopiesAvailable but got %v", err)
+		}
+		rented, err := store.Copy.GetCopies(ctx, movie.ID.Hex(), map[string]any{"rented": true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rented) != 2 {
+			t.Fatalf("expected 2 rented copies but got %d", len(rented))
+		}
+		if err := store.Copy.ReleaseCopy(ctx, dvd.ID.Hex()); err != nil {
+			t.Fatal(err)
+		}
+		cp, err = store.Copy.ReserveCopy(ctx, movie.ID.Hex(), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cp.ID != dvd.ID {
+			t.Fatalf("expected the released dvd copy to be reserved but got %s", cp.ID)
+		}
+		expectNotFound(t, store.Copy.ReleaseCopy(ctx, missingID))
+	})
+
+	t.Run("RetireCopy", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			movie = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			cp    = insertCopy(t, store, movie, types.FormatDVD)
+		)
+		if err := store.Copy.RetireCopy(ctx, cp.ID.Hex(), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Copy.RetireCopy(ctx, cp.ID.Hex(), time.Now()); err != nil {
+			t.Fatalf("expected retiring twice to succeed but got %v", err)
+		}
+		got, err := store.Copy.GetCopyByID(ctx, cp.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Retired || got.RetiredAt == nil {
+			t.Fatalf("expected a retired copy but got %+v", got)
+		}
+		if _, err := store.Copy.ReserveCopy(ctx, movie.ID.Hex(), ""); !errors.Is(err, db.ErrNoCopiesAvailable) {
+			t.Fatalf("expected ErrNoCopiesAvailable but got %v", err)
+		}
+		expectNotFound(t, store.Copy.RetireCopy(ctx, missingID, time.Now()))
+	})
+}
+
+func testRatingStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+
+	rate := func(t *testing.T, store *db.Store, user *types.User, movie *types.Movie, value int) *types.Rating {
+		t.Helper()
+		rating, err := store.Rating.UpsertRating(ctx, types.NewRatingFromParams(user.ID, movie.ID, types.UpdateMovieRating{Rating: value}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rating
+	}
+
+	t.Run("UpsertRating", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			movie = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			user  = insertUser(t, store, "tomek@test.com")
+			first = rate(t, store, user, movie, 4)
+		)
+		if first.ID.IsZero() || first.Rating != 4 {
+			t.Fatalf("unexpected rating %+v", first)
+		}
+		second := rate(t, store, user, movie, 9)
+		if second.ID != first.ID || second.Rating != 9 {
+			t.Fatalf("expected rating %s to be updated to 9 but got %+v", first.ID, second)
+		}
+		got, err := store.Rating.GetRating(ctx, user.ID.Hex(), movie.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.ID != first.ID || got.Rating != 9 || got.UserID != user.ID || got.MovieID != movie.ID {
+			t.Fatalf("unexpected rating %+v", got)
+		}
+		_, err = store.Rating.GetRating(ctx, primitive.NewObjectID().Hex(), movie.ID.Hex())
+		expectNotFound(t, err)
+	})
+
+	t.Run("GetRatingStats", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			matrix  = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			titanic = insertMovie(t, store, "Titanic", []string{"Drama"}, 1997)
+			tomek   = insertUser(t, store, "tomek@test.com")
+			zuzia   = insertUser(t, store, "zuzia@test.com")
+		)
+		stats, err := store.Rating.GetRatingStats(ctx, matrix.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Count != 0 || stats.Average != 0 {
+			t.Fatalf("expected empty stats but got %+v", stats)
+		}
+		rate(t, store, tomek, matrix, 10)
+		rate(t, store, tomek, matrix, 6)
+		rate(t, store, zuzia, matrix, 9)
+		rate(t, store, zuzia, titanic, 1)
+		stats, err = store.Rating.GetRatingStats(ctx, matrix.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stats.Count != 2 || stats.Average != 7.5 {
+			t.Fatalf("expected an average of 7.5 from 2 ratings but got %+v", stats)
+		}
+	})
+
+	t.Run("RefreshMovieRating", func(t *testing.T) {
+		store := newStore(t)
+		movie := insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+		// users rating at the same time all end up in the stats
+		var (
+			wg   sync.WaitGroup
+			errs = make(chan error, 8)
+		)
+		for i := 1; i <= 8; i++ {
+			user := insertUser(t, store, fmt.Sprintf("user%d@test.com", i))
+			value := i
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := store.Rating.UpsertRating(ctx, types.NewRatingFromParams(user.ID, movie.ID, types.UpdateMovieRating{Rating: value})); err != nil {
+					errs <- err
+					return
+				}
+				if _, err := store.Rating.RefreshMovieRating(ctx, movie.ID.Hex()); err != nil {
+					errs <- err
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Fatal(err)
+		}
+		got, err := store.Movie.GetMovieByID(ctx, movie.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.RatingCount != 8 || got.RatingAvg != 4.5 || got.Rating != 5 {
+			t.Fatalf("expected an average of 4.5 from 8 ratings but got %d, %v from %d", got.Rating, got.RatingAvg, got.RatingCount)
+		}
+		_, err = store.Rating.RefreshMovieRating(ctx, primitive.NewObjectID().Hex())
+		expectNotFound(t, err)
+	})
+}
+
+func testReviewStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+	missingID := primitive.NewObjectID().Hex()
+
+	review := func(t *testing.T, store *db.Store, user *types.User, movie *types.Movie, body string) *types.Review {
+		t.Helper()
+		review, err := store.Review.InsertReview(ctx, types.NewReviewFromParams(user.ID, movie.ID, types.ReviewParams{Body: body}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return review
+	}
+
+	t.Run("InsertAndGet", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			movie    = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			user     = insertUser(t, store, "tomek@test.com")
+			inserted = review(t, store, user, movie, "great movie")
+		)
+		if inserted.ID.IsZero() {
+			t.Fatal("expecting review id to be set")
+		}
+		got, err := store.Review.GetReviewByID(ctx, inserted.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Body != "great movie" || got.Status != types.ReviewPending || got.UserID != user.ID || got.MovieID != movie.ID {
+			t.Fatalf("unexpected review %+v", got)
+		}
+		_, err = store.Review.GetReviewByID(ctx, missingID)
+		expectNotFound(t, err)
+	})
+
+	t.Run("GetReviews", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			matrix  = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			titanic = insertMovie(t, store, "Titanic", []string{"Drama"}, 1997)
+			user    = insertUser(t, store, "tomek@test.com")
+			first   = review(t, store, user, matrix, "first")
+			second  = review(t, store, user, matrix, "second")
+			third   = review(t, store, user, matrix, "third")
+		)
+		review(t, store, user, titanic, "other movie")
+		if err := store.Review.UpdateReviewStatus(ctx, first.ID.Hex(), types.ReviewApproved, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Review.UpdateReviewStatus(ctx, third.ID.Hex(), types.ReviewApproved, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		page, err := store.Review.GetReviews(ctx, map[string]any{"movieID": matrix.ID}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reviews := page.Items; len(reviews) != 3 || reviews[0].ID != third.ID || reviews[2].ID != first.ID {
+			t.Fatalf("expected 3 reviews newest first but got %+v", reviews)
+		}
+		approved := map[string]any{"movieID": matrix.ID, "status": types.ReviewApproved}
+		page, err = store.Review.GetReviews(ctx, approved, &db.Pagination{Limit: 1})
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err = store.Review.GetReviews(ctx, approved, &db.Pagination{Limit: 1, Cursor: page.NextCursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reviews := page.Items; len(reviews) != 1 || reviews[0].ID != first.ID || page.HasNext || page.Total != 2 {
+			t.Fatalf("expected the second approved review page to hold %s but got %+v", first.ID, reviews)
+		}
+		page, err = store.Review.GetReviews(ctx, map[string]any{"status": types.ReviewPending}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reviews := page.Items; len(reviews) != 2 || reviews[1].ID != second.ID {
+			t.Fatalf("expected 2 pending reviews but got %+v", reviews)
+		}
+		if _, err := store.Review.GetReviews(ctx, map[string]any{"body": "first"}, nil); err == nil {
+			t.Fatal("expected an error for an unsupported filter")
+		}
+	})
+
+	t.Run("UpdateReview", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			movie    = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			user     = insertUser(t, store, "tomek@test.com")
+			inserted = review(t, store, user, movie, "great movie")
+		)
+		if err := store.Review.UpdateReviewStatus(ctx, inserted.ID.Hex(), types.ReviewApproved, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Review.UpdateReviewBody(ctx, inserted.ID.Hex(), "changed my mind", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Review.GetReviewByID(ctx, inserted.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Body != "changed my mind" || got.Status != types.ReviewPending {
+			t.Fatalf("expected an edited review back in the queue but got %+v", got)
+		}
+		expectNotFound(t, store.Review.UpdateReviewBody(ctx, missingID, "body", time.Now()))
+		expectNotFound(t, store.Review.UpdateReviewStatus(ctx, missingID, types.ReviewHidden, time.Now()))
+	})
+
+	t.Run("DeleteReview", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			movie    = insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+			user     = insertUser(t, store, "tomek@test.com")
+			inserted = review(t, store, user, movie, "great movie")
+		)
+		if err := store.Review.DeleteReview(ctx, inserted.ID.Hex()); err != nil {
+			t.Fatal(err)
+		}
+		_, err := store.Review.GetReviewByID(ctx, inserted.ID.Hex())
+		expectNotFound(t, err)
+		expectNotFound(t, store.Review.DeleteReview(ctx, inserted.ID.Hex()))
+	})
+}
+
+func testSessionStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+	missingID := primitive.NewObjectID().Hex()
+
+	session := func(t *testing.T, store *db.Store, user *types.User, hash string, ttl time.Duration) *types.Session {
+		t.Helper()
+		session, err := store.Session.InsertSession(ctx, types.NewSession(user.ID, hash, ttl))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return session
+	}
+
+	t.Run("InsertAndGet", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			user     = insertUser(t, store, "tomek@test.com")
+			inserted = session(t, store, user, "hash", time.Hour)
+		)
+		if inserted.ID.IsZero() {
+			t.Fatal("expecting session id to be set")
+		}
+		got, err := store.Session.GetSessionByID(ctx, inserted.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.UserID != user.ID || got.RefreshHash != "hash" || got.TwoFactor || !got.IsActive(time.Now()) {
+			t.Fatalf("unexpected session %+v", got)
+		}
+		_, err = store.Session.GetSessionByID(ctx, missingID)
+		expectNotFound(t, err)
+
+		twoFactor := types.NewSession(user.ID, "other", time.Hour)
+		twoFactor.TwoFactor = true
+		if _, err := store.Session.InsertSession(ctx, twoFactor); err != nil {
+			t.Fatal(err)
+		}
+		if got, err = store.Session.GetSessionByID(ctx, twoFactor.ID.Hex()); err != nil || !got.TwoFactor {
+			t.Fatalf("expected a two-factor session but got %+v, %v", got, err)
+		}
+	})
+
+	t.Run("Rotate", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			user  = insertUser(t, store, "tomek@test.com")
+			sess  = session(t, store, user, "first", time.Hour)
+			strID = sess.ID.Hex()
+		)
+		if err := store.Session.RotateSession(ctx, strID, "first", "second"); err != nil {
+			t.Fatal(err)
+		}
+		expectNotFound(t, store.Session.RotateSession(ctx, strID, "first", "third"))
+		got, err := store.Session.GetSessionByID(ctx, strID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.RefreshHash != "second" {
+			t.Fatalf("expected the rotated hash but got %q", got.RefreshHash)
+		}
+		if err := store.Session.RevokeSession(ctx, strID, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		expectNotFound(t, store.Session.RotateSession(ctx, strID, "second", "third"))
+		expectNotFound(t, store.Session.RotateSession(ctx, missingID, "second", "third"))
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			user  = insertUser(t, store, "tomek@test.com")
+			sess  = session(t, store, user, "hash", time.Hour)
+			first = time.Now().Add(-time.Minute).Truncate(time.Second)
+		)
+		if err := store.Session.RevokeSession(ctx, sess.ID.Hex(), first); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Session.RevokeSession(ctx, sess.ID.Hex(), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Session.GetSessionByID(ctx, sess.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.IsActive(time.Now()) || got.RevokedAt == nil || !got.RevokedAt.Equal(first) {
+			t.Fatalf("expected the session revoked at %v but got %+v", first, got)
+		}
+		expectNotFound(t, store.Session.RevokeSession(ctx, missingID, time.Now()))
+	})
+
+	t.Run("RevokeUserSessions", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			user    = insertUser(t, store, "tomek@test.com")
+			other   = insertUser(t, store, "other@test.com")
+			revoked = session(t, store, user, "revoked", time.Hour)
+			expired = session(t, store, user, "expired", -time.Hour)
+			kept    = session(t, store, other, "kept", time.Hour)
+		)
+		session(t, store, user, "first", time.Hour)
+		session(t, store, user, "second", time.Hour)
+		if err := store.Session.RevokeSession(ctx, revoked.ID.Hex(), time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		n, err := store.Session.RevokeUserSessions(ctx, user.ID.Hex(), time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 2 {
+			t.Fatalf("expected 2 revoked sessions but got %d", n)
+		}
+		got, err := store.Session.GetSessionByID(ctx, expired.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.RevokedAt != nil {
+			t.Fatal("expected the expired session to be left alone")
+		}
+		got, err = store.Session.GetSessionByID(ctx, kept.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.IsActive(time.Now()) {
+			t.Fatal("expected sessions of other users to stay active")
+		}
+	})
+}
+
+func testActionTokenStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+
+	token := func(t *testing.T, store *db.Store, user *types.User, hash string, ttl time.Duration) *types.ActionToken {
+		t.Helper()
+		token, err := store.ActionToken.InsertActionToken(ctx, types.NewActionToken(user.ID, types.TokenPasswordReset, hash, ttl))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	t.Run("UseOnce", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			user     = insertUser(t, store, "tomek@test.com")
+			inserted = token(t, store, user, "hash", time.Hour)
+		)
+		if inserted.ID.IsZero() {
+			t.Fatal("expecting token id to be set")
+		}
+		used, err := store.ActionToken.UseActionToken(ctx, types.TokenPasswordReset, "hash", time.Now())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if used.ID != inserted.ID || used.UserID != user.ID || used.UsedAt == nil {
+			t.Fatalf("unexpected token %+v", used)
+		}
+		_, err = store.ActionToken.UseActionToken(ctx, types.TokenPasswordReset, "hash", time.Now())
+		expectNotFound(t, err)
+	})
+
+	t.Run("UseExpiredOrWrongPurpose", func(t *testing.T) {
+		store := newStore(t)
+		user := insertUser(t, store, "tomek@test.com")
+		token(t, store, user, "expired", -time.Minute)
+		token(t, store, user, "valid", time.Hour)
+		_, err := store.ActionToken.UseActionToken(ctx, types.TokenPasswordReset, "expired", time.Now())
+		expectNotFound(t, err)
+		_, err = store.ActionToken.UseActionToken(ctx, "other", "valid", time.Now())
+		expectNotFound(t, err)
+		_, err = store.ActionToken.UseActionToken(ctx, types.TokenPasswordReset, "missing", time.Now())
+		expectNotFound(t, err)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			user  = insertUser(t, store, "tomek@test.com")
+			other = insertUser(t, store, "other@test.com")
+		)
+		token(t, store, user, "first", time.Hour)
+		token(t, store, other, "second", time.Hour)
+		if err := store.ActionToken.DeleteActionTokens(ctx, user.ID.Hex(), types.TokenPasswordReset); err != nil {
+			t.Fatal(err)
+		}
+		_, err := store.ActionToken.UseActionToken(ctx, types.TokenPasswordReset, "first", time.Now())
+		expectNotFound(t, err)
+		if _, err := store.ActionToken.UseActionToken(ctx, types.TokenPasswordReset, "second", time.Now()); err != nil {
+			t.Fatalf("expected tokens of other users to be kept but got %v", err)
+		}
+	})
+}
+
+func testLoginAttemptStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Millisecond)
+
+	record := func(t *testing.T, store *db.Store, key string, at time.Time) *types.LoginAttempts {
+		t.Helper()
+		attempts, err := store.LoginAttempt.RecordLoginFailure(ctx, key, at, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return attempts
+	}
+
+	t.Run("RecordFailures", func(t *testing.T) {
+		store := newStore(t)
+		_, err := store.LoginAttempt.GetLoginAttempts(ctx, "account:tomek@test.com")
+		expectNotFound(t, err)
+		record(t, store, "account:tomek@test.com", now.Add(-2*time.Hour))
+		if got := record(t, store, "account:tomek@test.com", now.Add(-time.Minute)); got.Failures != 1 {
+			t.Fatalf("expected failures outside the window to be forgotten but got %d", got.Failures)
+		}
+		got := record(t, store, "account:tomek@test.com", now)
+		if got.Key != "account:tomek@test.com" || got.Failures != 2 || !got.LastFailure.Equal(now) {
+			t.Fatalf("unexpected attempts %+v", got)
+		}
+		if got.IsLocked(now) {
+			t.Fatal("expected key not to be locked")
+		}
+		if other := record(t, store, "ip:10.0.0.1", now); other.Failures != 1 {
+			t.Fatalf("expected keys to be counted apart but got %d failures", other.Failures)
+		}
+	})
+
+	t.Run("Lock", func(t *testing.T) {
+		store := newStore(t)
+		expectNotFound(t, store.LoginAttempt.LockLogin(ctx, "account:tomek@test.com", now.Add(time.Hour)))
+		record(t, store, "account:tomek@test.com", now)
+		record(t, store, "account:tomek@test.com", now)
+		if err := store.LoginAttempt.LockLogin(ctx, "account:tomek@test.com", now.Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.LoginAttempt.GetLoginAttempts(ctx, "account:tomek@test.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Failures != 0 || !got.IsLocked(now) || got.IsLocked(now.Add(time.Hour)) {
+			t.Fatalf("unexpected attempts %+v", got)
+		}
+		if got := record(t, store, "account:tomek@test.com", now); got.Failures != 1 || !got.IsLocked(now) {
+			t.Fatalf("expected failures to keep the lock but got %+v", got)
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		store := newStore(t)
+		record(t, store, "account:tomek@test.com", now)
+		if err := store.LoginAttempt.ResetLoginAttempts(ctx, "account:tomek@test.com"); err != nil {
+			t.Fatal(err)
+		}
+		_, err := store.LoginAttempt.GetLoginAttempts(ctx, "account:tomek@test.com")
+		expectNotFound(t, err)
+		if err := store.LoginAttempt.ResetLoginAttempts(ctx, "account:tomek@test.com"); err != nil {
+			t.Fatalf("expected resetting a missing key to succeed but got %v", err)
+		}
+	})
+}
+
+func testAuditStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+
+	t.Run("InsertAndList", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			actor   = primitive.NewObjectID()
+			entries = []*types.AuditEntry{
+				types.NewAuditEntry(types.AuditLoginLocked, primitive.NilObjectID, "account:tomek@test.com", "locked"),
+				types.NewAuditEntry(types.AuditLoginLocked, primitive.NilObjectID, "ip:10.0.0.1", "locked"),
+				types.NewAuditEntry(types.AuditLoginUnlocked, actor, "account:tomek@test.com", ""),
+			}
+		)
+		for _, entry := range entries {
+			if _, err := store.Audit.InsertAuditEntry(ctx, entry); err != nil {
+				t.Fatal(err)
+			}
+			if entry.ID.IsZero() {
+				t.Fatal("expected audit entry id to be set")
+			}
+		}
+		page, err := store.Audit.GetAuditEntries(ctx, map[string]any{}, &db.Pagination{Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 3 || len(page.Items) != 2 || !page.HasNext {
+			t.Fatalf("unexpected page %+v", page)
+		}
+		if page.Items[0].ID != entries[2].ID || page.Items[0].ActorID != actor {
+			t.Fatalf("expected the newest entry first but got %+v", page.Items[0])
+		}
+		if !page.Items[1].ActorID.IsZero() {
+			t.Fatalf("expected system entries without actor but got %s", page.Items[1].ActorID)
+		}
+		page, err = store.Audit.GetAuditEntries(ctx, map[string]any{}, &db.Pagination{Limit: 2, Cursor: page.NextCursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Items) != 1 || page.Items[0].ID != entries[0].ID || page.HasNext {
+			t.Fatalf("unexpected second page %+v", page)
+		}
+		page, err = store.Audit.GetAuditEntries(ctx, map[string]any{
+			"action":  types.AuditLoginLocked,
+			"subject": "account:tomek@test.com",
+		}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 1 || page.Items[0].ID != entries[0].ID {
+			t.Fatalf("unexpected filtered page %+v", page)
+		}
+	})
+}
+
+func testTOTPStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+
+	start := func(t *testing.T, store *db.Store, user *types.User, secret string) {
+		t.Helper()
+		if err := store.TOTP.StartTOTP(ctx, types.NewTOTP(user.ID, secret)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("Enrol", func(t *testing.T) {
+		store := newStore(t)
+		user := insertUser(t, store, "tomek@test.com")
+		_, err := store.TOTP.GetTOTP(ctx, user.ID.Hex())
+		expectNotFound(t, err)
+		expectNotFound(t, store.TOTP.UseTOTPStep(ctx, user.ID.Hex(), 1))
+
+		start(t, store, user, "first")
+		start(t, store, user, "second")
+		got, err := store.TOTP.GetTOTP(ctx, user.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.UserID != user.ID || got.Secret != "second" || got.Confirmed {
+			t.Fatalf("expected the pending enrolment to be replaced but got %+v", got)
+		}
+		expectNotFound(t, store.TOTP.UseTOTPStep(ctx, user.ID.Hex(), 1))
+
+		if err := store.TOTP.ConfirmTOTP(ctx, user.ID.Hex(), 10, []string{"a", "b"}); err != nil {
+			t.Fatal(err)
+		}
+		expectNotFound(t, store.TOTP.ConfirmTOTP(ctx, user.ID.Hex(), 11, nil))
+		if err := store.TOTP.StartTOTP(ctx, types.NewTOTP(user.ID, "third")); !errors.Is(err, db.ErrTOTPEnabled) {
+			t.Fatalf("expected ErrTOTPEnabled but got %v", err)
+		}
+		got, err = store.TOTP.GetTOTP(ctx, user.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Confirmed || got.Secret != "second" || got.LastStep != 10 || !slices.Equal(got.RecoveryHashes, []string{"a", "b"}) {
+			t.Fatalf("unexpected enrolment %+v", got)
+		}
+	})
+
+	t.Run("UseOnce", func(t *testing.T) {
+		store := newStore(t)
+		user := insertUser(t, store, "tomek@test.com")
+		start(t, store, user, "secret")
+		if err := store.TOTP.ConfirmTOTP(ctx, user.ID.Hex(), 10, []string{"a", "b"}); err != nil {
+			t.Fatal(err)
+		}
+		expectNotFound(t, store.TOTP.UseTOTPStep(ctx, user.ID.Hex(), 10))
+		if err := store.TOTP.UseTOTPStep(ctx, user.ID.Hex(), 11); err != nil {
+			t.Fatal(err)
+		}
+		expectNotFound(t, store.TOTP.UseTOTPStep(ctx, user.ID.Hex(), 11))
+
+		if err := store.TOTP.UseRecoveryCode(ctx, user.ID.Hex(), "a"); err != nil {
+			t.Fatal(err)
+		}
+		expectNotFound(t, store.TOTP.UseRecoveryCode(ctx, user.ID.Hex(), "a"))
+		expectNotFound(t, store.TOTP.UseRecoveryCode(ctx, user.ID.Hex(), "c"))
+		got, err := store.TOTP.GetTOTP(ctx, user.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.LastStep != 11 || !slices.Equal(got.RecoveryHashes, []string{"b"}) {
+			t.Fatalf("unexpected enrolment %+v", got)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := newStore(t)
+		user := insertUser(t, store, "tomek@test.com")
+		expectNotFound(t, store.TOTP.DeleteTOTP(ctx, user.ID.Hex()))
+		start(t, store, user, "secret")
+		if err := store.TOTP.DeleteTOTP(ctx, user.ID.Hex()); err != nil {
+			t.Fatal(err)
+		}
+		_, err := store.TOTP.GetTOTP(ctx, user.ID.Hex())
+		expectNotFound(t, err)
+	})
+}
+
+func testAPIKeyStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+	missingID := primitive.NewObjectID().Hex()
+
+	insertKey := func(t *testing.T, store *db.Store, user *types.User, name string, expiresAt *time.Time) *types.APIKey {
+		t.Helper()
+		params := types.CreateAPIKeyParams{Name: name, Scopes: []types.Permission{types.PermReadUsers}, ExpiresAt: expiresAt}
+		key, err := store.APIKey.InsertAPIKey(ctx, types.NewAPIKeyFromParams(params, user.ID, user.ID, "hash-"+name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key.ID.IsZero() {
+			t.Fatal("expected api key id to be set")
+		}
+		return key
+	}
+
+	t.Run("InsertAndGet", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			user    = insertUser(t, store, "tomek@test.com")
+			expires = time.Now().Add(time.Hour).Truncate(time.Second)
+			key     = insertKey(t, store, user, "kiosk", &expires)
+		)
+		got, err := store.APIKey.GetAPIKeyByID(ctx, key.ID.Hex())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "kiosk" || got.UserID != user.ID || got.Hash != "hash-kiosk" || len(got.Scopes) != 1 || got.Scopes[0] != types.PermReadUsers {
+			t.Fatalf("unexpected api key %+v", got)
+		}
+		if got.ExpiresAt == nil || !got.ExpiresAt.Equal(expires) || got.LastUsedAt != nil || !got.IsActive(time.Now()) {
+			t.Fatalf("unexpected api key times %+v", got)
+		}
+		if got.IsActive(expires) {
+			t.Fatal("expected the key to expire")
+		}
+		_, err = store.APIKey.GetAPIKeyByID(ctx, missingID)
+		expectNotFound(t, err)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			tomek = insertUser(t, store, "tomek@test.com")
+			anna  = insertUser(t, store, "anna@test.com")
+			keys  = []*types.APIKey{
+				insertKey(t, store, tomek, "kiosk", nil),
+				insertKey(t, store, anna, "reports", nil),
+				insertKey(t, store, tomek, "backup", nil),
+			}
+		)
+		page, err := store.APIKey.GetAPIKeys(ctx, map[string]any{}, &db.Pagination{Limit: 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 3 || len(page.Items) != 2 || !page.HasNext || page.Items[0].ID != keys[2].ID {
+			t.Fatalf("expected the newest keys first but got %+v", page)
+		}
+		page, err = store.APIKey.GetAPIKeys(ctx, map[string]any{"userID": tomek.ID}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if page.Total != 2 || page.Items[0].ID != keys[2].ID || page.Items[1].ID != keys[0].ID {
+			t.Fatalf("unexpected keys of the user %+v", page)
+		}
+	})
+
+	t.Run("TouchAndRevoke", func(t *testing.T) {
+		store := newStore(t)
+		var (
+			user  = insertUser(t, store, "tomek@test.com")
+			key   = insertKey(t, store, user, "kiosk", nil)
+			strID = key.ID.Hex()
+			used  = time.Now().Add(-time.Minute).Truncate(time.Second)
+		)
+		if err := store.APIKey.TouchAPIKey(ctx, strID, used); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.APIKey.RevokeAPIKey(ctx, strID, used); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.APIKey.RevokeAPIKey(ctx, strID, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.APIKey.GetAPIKeyByID(ctx, strID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.LastUsedAt == nil || !got.LastUsedAt.Equal(used) {
+			t.Fatalf("expected the key to be used at %s but got %v", used, got.LastUsedAt)
+		}
+		if got.RevokedAt == nil || !got.RevokedAt.Equal(used) || got.IsActive(time.Now()) {
+			t.Fatalf("expected the first revocation to be kept but got %v", got.RevokedAt)
+		}
+		expectNotFound(t, store.APIKey.TouchAPIKey(ctx, missingID, used))
+		expectNotFound(t, store.APIKey.RevokeAPIKey(ctx, missingID, used))
+	})
+}
+
+func testPriceRuleStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	ctx := context.Background()
+	store := newStore(t)
+	movie := insertMovie(t, store, "The Matrix", []string{"Action"}, 1999)
+	base, err := store.PriceRule.InsertPriceRule(ctx, &types.PriceRule{Kind: types.PriceBase, MovieID: movie.ID, Format: types.FormatDVD, Amount: 399})
+	if err != nil {
+		t.Fatal(err)
+	}
+	weekend, err := store.PriceRule.InsertPriceRule(ctx, &types.PriceRule{Kind: types.PriceWeekend, Percent: 120})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if base.ID.IsZero() || weekend.ID.IsZero() {
+		t.Fatal("expected price rule ids to be set")
+	}
+	rules, err := store.PriceRule.GetPriceRules(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 2 || *rules[0] != *base || *rules[1] != *weekend {
+		t.Fatalf("expected both rules oldest first but got %+v", rules)
+	}
+
+	replaced := &types.PriceRule{Kind: types.PriceDuration, Hours: 48, Percent: 180}
+	if err := store.PriceRule.ReplacePriceRule(ctx, base.ID.Hex(), replaced); err != nil {
+		t.Fatal(err)
+	}
+	got, err := store.PriceRule.GetPriceRuleByID(ctx, base.ID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.ID != base.ID || *got != *replaced {
+		t.Fatalf("expected the rule to be replaced but got %+v", got)
+	}
+	missingID := primitive.NewObjectID().Hex()
+	expectNotFound(t, store.PriceRule.ReplacePriceRule(ctx, missingID, replaced))
+
+	if err := store.PriceRule.DeletePriceRule(ctx, weekend.ID.Hex()); err != nil {
+		t.Fatal(err)
+	}
+	expectNotFound(t, store.PriceRule.DeletePriceRule(ctx, weekend.ID.Hex()))
+	_, err = store.PriceRule.GetPriceRuleByID(ctx, weekend.ID.Hex())
+	expectNotFound(t, err)
+}
+
+func testPaymentStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	var (
+		ctx    = context.Background()
+		store  = newStore(t)
+		rentID = primitive.NewObjectID()
+		userID = primitive.NewObjectID()
+		now    = time.Now()
+	)
+	var inserted []*types.Payment
+	for _, providerID := range []string{"pay_1", "pay_2"} {
+		payment, err := store.Payment.InsertPayment(ctx, &types.Payment{
+			RentID:     rentID,
+			UserID:     userID,
+			Purpose:    types.PaymentForExtension,
+			Provider:   "fake",
+			ProviderID: providerID,
+			Amount:     399,
+			Hours:      24,
+			Currency:   "USD",
+			Status:     types.PaymentAuthorized,
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if payment.ID.IsZero() {
+			t.Fatal("expected payment id to be set")
+		}
+		inserted = append(inserted, payment)
+	}
+
+	got, err := store.Payment.GetPaymentByProviderID(ctx, "fake", "pay_2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != inserted[1].ID || got.RentID != rentID || got.UserID != userID || got.Amount != 399 || got.Hours != 24 || got.Status != types.PaymentAuthorized {
+		t.Fatalf("expected the second payment but got %+v", got)
+	}
+	_, err = store.Payment.GetPaymentByProviderID(ctx, "other", "pay_2")
+	expectNotFound(t, err)
+
+	payments, err := store.Payment.GetPaymentsByRent(ctx, rentID.Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(payments) != 2 || payments[0].ID != inserted[0].ID || payments[1].ID != inserted[1].ID {
+		t.Fatalf("expected both payments oldest first but got %+v", payments)
+	}
+
+	updated, err := store.Payment.UpdatePaymentStatus(ctx, inserted[0].ID.Hex(), types.PaymentAuthorized, types.PaymentCaptured, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status != types.PaymentCaptured {
+		t.Fatalf("expected a captured payment but got %s", updated.Status)
+	}
+	_, err = store.Payment.UpdatePaymentStatus(ctx, inserted[0].ID.Hex(), types.PaymentAuthorized, types.PaymentFailed, now)
+	if !errors.Is(err, db.ErrInvalidTransition) {
+		t.Fatalf("expected ErrInvalidTransition but got %v", err)
+	}
+	_, err = store.Payment.UpdatePaymentStatus(ctx, primitive.NewObjectID().Hex(), types.PaymentAuthorized, types.PaymentFailed, now)
+	expectNotFound(t, err)
+}
+
+func testWalletStore(t *testing.T, newStore func(t *testing.T) *db.Store) {
+	var (
+		ctx    = context.Background()
+		store  = newStore(t)
+		tomek  = insertUser(t, store, "tomek@test.com")
+		zuzia  = insertUser(t, store, "zuzia@test.com")
+		rentID = primitive.NewObjectID()
+	)
+	appendEntry := func(user *types.User, kind types.WalletEntryKind, amount int64) (*types.WalletEntry, error) {
+		t.Helper()
+		return store.Wallet.AppendWalletEntry(ctx, &types.WalletEntry{
+			UserID:    user.ID,
+			Kind:      kind,
+			Amount:    amount,
+			Currency:  "USD",
+			RentID:    rentID,
+			CreatedAt: time.Now(),
+		})
+	}
+
+	balance, err := store.Wallet.GetWalletBalance(ctx, tomek.ID.Hex())
+	if err != nil || balance != 0 {
+		t.Fatalf("expected an empty wallet but got %d, %v", balance, err)
+	}
+	if _, err := appendEntry(tomek, types.WalletRentalCharge, -1); !errors.Is(err, db.ErrInsufficientFunds) {
+		t.Fatalf("expected ErrInsufficientFunds but got %v", err)
+	}
+	for _, amount := range []int64{1000, -399, -601} {
+		kind := types.WalletTopUp
+		if amount < 0 {
+			kind = types.WalletRentalCharge
+		}
+		if _, err := appendEntry(tomek, kind, amount); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := appendEntry(tomek, types.WalletRentalCharge, -1); !errors.Is(err, db.ErrInsufficientFunds) {
+		t.Fatalf("expected ErrInsufficientFunds but got %v", err)
+	}
+	refund, err := appendEntry(tomek, types.WalletRefund, 399)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.ID.IsZero() || refund.Seq != 4 || refund.Balance != 399 {
+		t.Fatalf("expected the fourth entry with a balance of 399 but got %+v", refund)
+	}
+	if _, err := appendEntry(zuzia, types.WalletAdjustment, 50); err != nil {
+		t.Fatal(err)
+	}
+	balance, err = store.Wallet.GetWalletBalance(ctx, tomek.ID.Hex())
+	if err != nil || balance != 399 {
+		t.Fatalf("expected a balance of 399 but got %d, %v", balance, err)
+	}
+
+	page, err := store.Wallet.GetWalletEntries(ctx, tomek.ID.Hex(), &db.Pagination{Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 4 || !page.HasNext || len(page.Items) != 3 || page.Items[0].ID != refund.ID || page.Items[2].Seq != 2 {
+		t.Fatalf("expected the newest 3 of 4 entries but got %+v", page)
+	}
+	if page.Items[0].RentID != rentID || page.Items[0].Kind != types.WalletRefund || page.Items[1].Balance != 0 {
+		t.Fatalf("expected the entries to round trip but got %+v", page.Items)
+	}
+	page, err = store.Wallet.GetWalletEntries(ctx, tomek.ID.Hex(), &db.Pagination{Limit: 3, Cursor: page.NextCursor})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.HasNext || page.Items[0].Seq != 1 || page.Items[0].Amount != 1000 {
+		t.Fatalf("expected the first entry on the last page but got %+v", page)
+	}
+}
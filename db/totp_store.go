@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	totpColl = "totp"
+)
+
+// ErrTOTPEnabled is returned by TOTPStore.StartTOTP when the user already
+// confirmed an enrolment.
+var ErrTOTPEnabled = errors.New("two-factor authentication already enabled")
+
+// TOTPStore holds one enrolment per user. Every method taking a code step
+// or recovery code succeeds once per value so they can't be replayed.
+type TOTPStore interface {
+	StartTOTP(context.Context, *types.TOTP) error
+	GetTOTP(context.Context, string) (*types.TOTP, error)
+	ConfirmTOTP(context.Context, string, int64, []string) error
+	UseTOTPStep(context.Context, string, int64) error
+	UseRecoveryCode(context.Context, string, string) error
+	DeleteTOTP(context.Context, string) error
+}
+
+type MongoTOTPStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewTOTPStore(client *mongo.Client) *MongoTOTPStore {
+	return &MongoTOTPStore{
+		client: client,
+		coll:   client.Database(MongoDBName).Collection(totpColl),
+	}
+}
+
+// StartTOTP stores a new unconfirmed enrolment, replacing an unconfirmed
+// one. A confirmed enrolment has to be deleted first.
+func (s *MongoTOTPStore) StartTOTP(ctx context.Context, totp *types.TOTP) error {
+	filter := bson.M{"_id": totp.UserID, "confirmed": false}
+	_, err := s.coll.ReplaceOne(ctx, filter, totp, options.Replace().SetUpsert(true))
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrTOTPEnabled
+	}
+	return err
+}
+
+func (s *MongoTOTPStore) GetTOTP(ctx context.Context, userID string) (*types.TOTP, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	var totp types.TOTP
+	if err := s.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&totp); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &totp, nil
+}
+
+// ConfirmTOTP enables an unconfirmed enrolment with the step of its first
+// code and the hashes of its recovery codes.
+func (s *MongoTOTPStore) ConfirmTOTP(ctx context.Context, userID string, step int64, recoveryHashes []string) error {
+	return s.update(ctx, userID, bson.M{"confirmed": false}, bson.M{"$set": bson.M{
+		"confirmed":      true,
+		"lastStep":       step,
+		"recoveryHashes": recoveryHashes,
+	}})
+}
+
+// UseTOTPStep records a code of a confirmed enrolment, returning ErrNotFound
+// unless it is newer than the last accepted one.
+func (s *MongoTOTPStore) UseTOTPStep(ctx context.Context, userID string, step int64) error {
+	return s.update(ctx, userID, bson.M{"confirmed": true, "lastStep": bson.M{"$lt": step}}, bson.M{"$set": bson.M{"lastStep": step}})
+}
+
+// UseRecoveryCode removes a recovery code of a confirmed enrolment,
+// returning ErrNotFound when it has no such code.
+func (s *MongoTOTPStore) UseRecoveryCode(ctx context.Context, userID string, hash string) error {
+	return s.update(ctx, userID, bson.M{"confirmed": true, "recoveryHashes": hash}, bson.M{"$pull": bson.M{"recoveryHashes": hash}})
+}
+
+func (s *MongoTOTPStore) update(ctx context.Context, userID string, filter bson.M, update bson.M) error {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	filter["_id"] = oid
+	res, err := s.coll.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *MongoTOTPStore) DeleteTOTP(ctx context.Context, userID string) error {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return err
+	}
+	res, err := s.coll.DeleteOne(ctx, bson.M{"_id": oid})
+	if err != nil {
+		return err
+	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
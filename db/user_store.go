@@ -2,11 +2,15 @@ package db
 
 import (
 	"context"
+	"errors"
+	"sort"
+	"strings"
 
 	"github.com/tomekzakrzewski/go-movierental/types"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 const (
@@ -15,10 +19,16 @@ const (
 
 type UserStore interface {
 	InsertUser(context.Context, *types.User) (*types.User, error)
-	GetUsers(context.Context) ([]*types.User, error)
+	GetUsers(context.Context, *Pagination) (*Page[*types.User], error)
 	GetUserByID(context.Context, string) (*types.User, error)
 	GetUserByEmail(context.Context, string) (*types.User, error)
 	DeleteUser(context.Context, string) error
+	UpdateUserRoles(context.Context, string, []types.Role) error
+	UpdateUserPassword(context.Context, string, string) error
+	SetEmailVerified(context.Context, string, bool) error
+	UpdateUser(context.Context, string, types.UpdateUserParams) (*types.User, error)
+	GetUserByIdentity(context.Context, types.Identity) (*types.User, error)
+	LinkIdentity(context.Context, string, types.Identity) error
 }
 
 type MongoUserStore struct {
@@ -33,27 +43,103 @@ func NewUserStore(client *mongo.Client) *MongoUserStore {
 	}
 }
 
+// createIndexes adds the unique email, username and identity indexes.
+// Emails are stored normalised, so the email index is case insensitive.
+func (s *MongoUserStore) createIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetName(userEmailIndex).SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetName(userUsernameIndex).SetUnique(true),
+		},
+		{
+			Keys: bson.D{{Key: "identity.issuer", Value: 1}, {Key: "identity.subject", Value: 1}},
+			Options: options.Index().SetName(userIdentityIndex).SetUnique(true).
+				SetPartialFilterExpression(bson.M{"identity": bson.M{"$exists": true}}),
+		},
+	})
+	return err
+}
+
+const (
+	userEmailIndex    = "email_unique"
+	userUsernameIndex = "username_unique"
+	userIdentityIndex = "identity_unique"
+)
+
+// duplicateUserError tells which unique index a write clashed with.
+func duplicateUserError(err error) error {
+	if !mongo.IsDuplicateKeyError(err) {
+		return err
+	}
+	if strings.Contains(err.Error(), "index: "+userUsernameIndex) {
+		return &DuplicateError{Field: "username"}
+	}
+	if strings.Contains(err.Error(), "index: "+userIdentityIndex) {
+		return &DuplicateError{Field: "identity"}
+	}
+	return &DuplicateError{Field: "email"}
+}
+
+// CheckDuplicateUsers reports users that share an email, ignoring case, or
+// a username. It runs before the unique indexes are created, which would
+// fail on them.
+func CheckDuplicateUsers(ctx context.Context, client *mongo.Client) error {
+	coll := client.Database(MongoDBName).Collection(userColl)
+	var dupErr DuplicateUsersError
+	for field, key := range map[string]any{
+		"email":    bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}},
+		"username": "$username",
+	} {
+		cur, err := coll.Aggregate(ctx, bson.A{
+			bson.M{"$sort": bson.M{"_id": 1}},
+			bson.M{"$group": bson.M{"_id": key, "ids": bson.M{"$push": "$_id"}}},
+			bson.M{"$match": bson.M{"ids.1": bson.M{"$exists": true}}},
+			bson.M{"$sort": bson.M{"_id": 1}},
+		})
+		if err != nil {
+			return err
+		}
+		var groups []struct {
+			Value string               `bson:"_id"`
+			IDs   []primitive.ObjectID `bson:"ids"`
+		}
+		if err := cur.All(ctx, &groups); err != nil {
+			return err
+		}
+		for _, g := range groups {
+			d := DuplicateUsers{Field: field, Value: g.Value}
+			for _, id := range g.IDs {
+				d.UserIDs = append(d.UserIDs, id.Hex())
+			}
+			dupErr.Duplicates = append(dupErr.Duplicates, d)
+		}
+	}
+	if len(dupErr.Duplicates) > 0 {
+		sort.SliceStable(dupErr.Duplicates, func(i, j int) bool {
+			return dupErr.Duplicates[i].Field < dupErr.Duplicates[j].Field
+		})
+		return &dupErr
+	}
+	return nil
+}
+
 func (s *MongoUserStore) InsertUser(ctx context.Context, user *types.User) (*types.User, error) {
 	res, err := s.coll.InsertOne(ctx, user)
 	if err != nil {
-		return nil, err
+		return nil, duplicateUserError(err)
 	}
 	user.ID = res.InsertedID.(primitive.ObjectID)
 	return user, nil
 }
 
-func (s *MongoUserStore) GetUsers(ctx context.Context) ([]*types.User, error) {
-	res, err := s.coll.Find(ctx, bson.M{})
-	if err != nil {
-		return nil, err
-	}
-	var users []*types.User
-	err = res.All(ctx, &users)
-	if err != nil {
-		return nil, err
-	}
-	return users, nil
+func (s *MongoUserStore) GetUsers(ctx context.Context, pag *Pagination) (*Page[*types.User], error) {
+	return findPage(ctx, s.coll, findQuery{filter: bson.M{}}, pag, UserCursor)
 }
+
 func (s *MongoUserStore) GetUserByID(ctx context.Context, id string) (*types.User, error) {
 	oid, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -61,27 +147,185 @@ func (s *MongoUserStore) GetUserByID(ctx context.Context, id string) (*types.Use
 	}
 	var user types.User
 	if err := s.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 	return &user, nil
 }
+
 func (s *MongoUserStore) GetUserByEmail(ctx context.Context, email string) (*types.User, error) {
 	var user types.User
-	if err := s.coll.FindOne(ctx, bson.M{"email": email}).Decode(&user); err != nil {
+	if err := s.coll.FindOne(ctx, bson.M{"email": types.NormalizeEmail(email)}).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
 		return nil, err
 	}
 	return &user, nil
 }
 
+func (s *MongoUserStore) GetUserByIdentity(ctx context.Context, identity types.Identity) (*types.User, error) {
+	filter := bson.M{"identity.issuer": identity.Issuer, "identity.subject": identity.Subject}
+	var user types.User
+	if err := s.coll.FindOne(ctx, filter).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// LinkIdentity links a user without an identity to one. It returns
+// ErrNotFound when the user is missing or already linked and a
+// DuplicateError when another user has the identity.
+func (s *MongoUserStore) LinkIdentity(ctx context.Context, id string, identity types.Identity) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	filter := bson.M{"_id": oid, "identity": bson.M{"$exists": false}}
+	res, err := s.coll.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"identity": identity}})
+	if err != nil {
+		return duplicateUserError(err)
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// MigrateUsers brings users stored by older versions up to date. Users
+// stored before roles existed get the admin role if they had the isAdmin
+// flag and the customer role otherwise, and users stored before email
+// verification existed are treated as verified. Emails are normalised, run
+// CheckDuplicateUsers first. It is safe to call on every startup.
+func MigrateUsers(ctx context.Context, client *mongo.Client) error {
+	coll := client.Database(MongoDBName).Collection(userColl)
+	_, err := coll.UpdateMany(ctx, bson.M{"roles": bson.M{"$exists": false}}, bson.A{
+		bson.M{"$set": bson.M{"roles": bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{"$isAdmin", true}},
+			bson.A{types.RoleAdmin},
+			bson.A{types.RoleCustomer},
+		}}}},
+		bson.M{"$unset": "isAdmin"},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = coll.UpdateMany(ctx, bson.M{"emailVerified": bson.M{"$exists": false}}, bson.M{"$set": bson.M{"emailVerified": true}})
+	if err != nil {
+		return err
+	}
+	normalized := bson.M{"$toLower": bson.M{"$trim": bson.M{"input": "$email"}}}
+	_, err = coll.UpdateMany(ctx,
+		bson.M{"$expr": bson.M{"$ne": bson.A{"$email", normalized}}},
+		bson.A{bson.M{"$set": bson.M{"email": normalized}}},
+	)
+	return err
+}
+
+func (s *MongoUserStore) UpdateUserRoles(ctx context.Context, id string, roles []types.Role) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"roles": roles}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *MongoUserStore) UpdateUserPassword(ctx context.Context, id string, encryptedPassword string) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"encryptedPassword": encryptedPassword}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (s *MongoUserStore) SetEmailVerified(ctx context.Context, id string, verified bool) error {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+	res, err := s.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"emailVerified": verified}})
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// UpdateUser sets the non empty profile fields of params and returns the
+// updated user. A new email is stored unverified.
+func (s *MongoUserStore) UpdateUser(ctx context.Context, id string, params types.UpdateUserParams) (*types.User, error) {
+	oid, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, err
+	}
+	set := bson.M{}
+	for field, value := range map[string]string{
+		"username":  params.Username,
+		"firstName": params.FirstName,
+		"lastName":  params.LastName,
+	} {
+		if value != "" {
+			set[field] = literal(value)
+		}
+	}
+	if params.Email != "" {
+		set["email"] = literal(params.Email)
+		set["emailVerified"] = bson.M{"$and": bson.A{"$emailVerified", bson.M{"$eq": bson.A{"$email", literal(params.Email)}}}}
+	}
+	if len(set) == 0 {
+		return s.GetUserByID(ctx, id)
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var user types.User
+	// a pipeline update so emailVerified can compare with the old email
+	if err := s.coll.FindOneAndUpdate(ctx, bson.M{"_id": oid}, bson.A{bson.M{"$set": set}}, opts).Decode(&user); err != nil {
+		if errors.Is(err, mongo.ErrNoDocuments) {
+			return nil, ErrNotFound
+		}
+		return nil, duplicateUserError(err)
+	}
+	return &user, nil
+}
+
+// literal keeps a pipeline update from reading a value starting with $ as a
+// field path or variable.
+func literal(value string) bson.M {
+	return bson.M{"$literal": value}
+}
+
 func (s *MongoUserStore) DeleteUser(ctx context.Context, id string) error {
 	oid, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
 		return err
 	}
 	filter := bson.M{"_id": oid}
-	_, err = s.coll.DeleteOne(ctx, filter)
+	res, err := s.coll.DeleteOne(ctx, filter)
 	if err != nil {
 		return err
 	}
+	if res.DeletedCount == 0 {
+		return ErrNotFound
+	}
 	return nil
 }
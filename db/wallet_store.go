@@ -0,0 +1,108 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	walletColl = "walletEntries"
+)
+
+// WalletStore keeps the append-only ledgers of the users' wallets. Entries
+// are never changed once appended, the balance of a wallet is the balance
+// of its latest entry.
+type WalletStore interface {
+	AppendWalletEntry(context.Context, *types.WalletEntry) (*types.WalletEntry, error)
+	GetWalletBalance(context.Context, string) (int64, error)
+	GetWalletEntries(context.Context, string, *Pagination) (*Page[*types.WalletEntry], error)
+}
+
+type MongoWalletStore struct {
+	client *mongo.Client
+	coll   *mongo.Collection
+}
+
+func NewWalletStore(client *mongo.Client) *MongoWalletStore {
+	return &MongoWalletStore{
+		client: client,
+		coll:   client.Database(MongoDBName).Collection(walletColl),
+	}
+}
+
+// createIndexes adds the unique index that serializes the entries of a
+// wallet and the one backing statements.
+func (s *MongoWalletStore) createIndexes(ctx context.Context) error {
+	_, err := s.coll.Indexes().CreateMany(ctx, []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "userID", Value: 1}, {Key: "seq", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		},
+		{Keys: bson.D{{Key: "userID", Value: 1}, {Key: "_id", Value: -1}}},
+	})
+	return err
+}
+
+// AppendWalletEntry numbers the entry after the latest one of its wallet
+// and sets its balance. A debit that would take the balance below zero
+// returns ErrInsufficientFunds. Two entries racing for the same number
+// can't both be inserted, the loser reads the new balance and tries again,
+// so concurrent debits can't overdraw the wallet.
+func (s *MongoWalletStore) AppendWalletEntry(ctx context.Context, entry *types.WalletEntry) (*types.WalletEntry, error) {
+	for {
+		seq, balance, err := s.latest(ctx, entry.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if entry.Amount < 0 && balance+entry.Amount < 0 {
+			return nil, ErrInsufficientFunds
+		}
+		entry.Seq = seq + 1
+		entry.Balance = balance + entry.Amount
+		res, err := s.coll.InsertOne(ctx, entry)
+		if mongo.IsDuplicateKeyError(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		entry.ID = res.InsertedID.(primitive.ObjectID)
+		return entry, nil
+	}
+}
+
+// latest returns the number and balance of the latest entry of a wallet,
+// zeros for a wallet without entries.
+func (s *MongoWalletStore) latest(ctx context.Context, userID primitive.ObjectID) (int64, int64, error) {
+	var entry types.WalletEntry
+	opts := options.FindOne().SetSort(bson.D{{Key: "seq", Value: -1}})
+	err := s.coll.FindOne(ctx, bson.M{"userID": userID}, opts).Decode(&entry)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, 0, nil
+	}
+	return entry.Seq, entry.Balance, err
+}
+
+func (s *MongoWalletStore) GetWalletBalance(ctx context.Context, userID string) (int64, error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return 0, err
+	}
+	_, balance, err := s.latest(ctx, oid)
+	return balance, err
+}
+
+// GetWalletEntries returns the statement of a wallet, newest entries first.
+func (s *MongoWalletStore) GetWalletEntries(ctx context.Context, userID string, pag *Pagination) (*Page[*types.WalletEntry], error) {
+	oid, err := primitive.ObjectIDFromHex(userID)
+	if err != nil {
+		return nil, err
+	}
+	return findPage(ctx, s.coll, findQuery{filter: bson.M{"userID": oid}, desc: true}, pag, WalletEntryCursor)
+}
@@ -16,9 +16,942 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/.well-known/jwks.json": {
+            "get": {
+                "description": "Handle publishing the public keys access tokens are signed with as a JWK set. Tokens name their key in the kid header, keys being rotated out stay listed until their tokens expire",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Get the token verification keys",
+                "responses": {}
+            }
+        },
+        "/admin/payments/:id/refund": {
+            "post": {
+                "description": "Handle refunding a captured payment in full through the payment provider, payments made from a wallet are credited back to it",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Refund a payment",
+                "responses": {}
+            }
+        },
+        "/admin/rents/:id/late-fee/settle": {
+            "post": {
+                "description": "Handle marking the late fee of a returned rent as settled, when the renter paid it at the counter or staff waive it. It no longer counts towards what the renter owes",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Settle a late fee",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "rent id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/admin/users/:id/wallet": {
+            "get": {
+                "description": "Handle getting the balance of a user's prepaid wallet, in minor units",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get a user's wallet",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/admin/users/:id/wallet/credit": {
+            "post": {
+                "description": "Handle adding an amount to a user's wallet with a reason, as an adjustment or, with kind top_up, as money the user paid in\nLate fees the user still owes are then paid from the wallet as far as its balance covers them",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Credit a user's wallet",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/admin/users/:id/wallet/debit": {
+            "post": {
+                "description": "Handle taking an amount from a user's wallet with a reason. The balance can't go below zero",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Debit a user's wallet",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/admin/users/:id/wallet/statement": {
+            "get": {
+                "description": "Handle listing the entries of a user's wallet, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get a user's wallet statement",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size, 20 by default and at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "nextCursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api-keys": {
+            "get": {
+                "description": "Handle listing API keys, newest first, optionally of a single user. Revoked and expired keys are listed too",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get API keys",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user id",
+                        "name": "userID",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size, 20 by default and at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "nextCursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            },
+            "post": {
+                "description": "Handle creating a named API key of a user, the signed in admin when userID is empty. The key is sent in the Api-Key header instead of a token and can only use its scopes, each of which the user and the admin need to hold. The key is only shown in this response",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Create an API key",
+                "parameters": [
+                    {
+                        "description": "name, user, scopes and optional expiry",
+                        "name": "params",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.CreateAPIKeyParams"
+                        }
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/api-keys/:id/revoke": {
+            "post": {
+                "description": "Handle revoking an API key, it stops being accepted right away",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Revoke an API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "api key id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/audit": {
+            "get": {
+                "description": "Handle listing audit entries, newest first, optionally filtered by action and subject",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get the audit log",
+                "parameters": [
+                    {
+                        "enum": [
+                            "login.locked",
+                            "login.unlocked",
+                            "totp.reset",
+                            "identity.linked",
+                            "apikey.created",
+                            "apikey.revoked"
+                        ],
+                        "type": "string",
+                        "description": "audit action",
+                        "name": "action",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "audit subject, such as account:\u003cemail\u003e, ip:\u003caddress\u003e or user:\u003cid\u003e",
+                        "name": "subject",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size, 20 by default and at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "nextCursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
         "/auth": {
             "post": {
-                "description": "Handle authenticating user",
+                "description": "Handle authenticating user. Users with two-factor authentication get a 202 with a challenge to complete at /auth/2fa instead of the tokens. Failed logins are throttled per account and per client IP, too many of them answer 429 with a Retry-After header",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Authenticate user",
+                "responses": {}
+            }
+        },
+        "/auth/2fa": {
+            "post": {
+                "description": "Handle exchanging the challenge of /auth and a code of the authenticator app, or a recovery code, for the tokens. A challenge can be used once, a wrong code requires logging in again",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Complete a two-factor login",
+                "parameters": [
+                    {
+                        "description": "challenge and code",
+                        "name": "params",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.TwoFactorParams"
+                        }
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/auth/logout": {
+            "post": {
+                "description": "Handle revoking the session of a refresh token, its access tokens stop being accepted",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Log out",
+                "responses": {}
+            }
+        },
+        "/auth/oidc/callback": {
+            "get": {
+                "description": "Handle the redirect back from the OIDC provider. The code is exchanged for the identity of the user, who is linked to an account with the same verified email or created on the first login. Responds like /auth, with a 202 challenge for users with two-factor authentication. With OIDC_TRUST_MFA=true a multi-factor login at the provider counts as a two-factor login instead",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Complete an OIDC login",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "authorization code",
+                        "name": "code",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "state of the login",
+                        "name": "state",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/auth/oidc/login": {
+            "get": {
+                "description": "Handle redirecting to the OIDC provider. The state, nonce and PKCE verifier of the login are kept in a short lived cookie until the callback",
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Start an OIDC login",
+                "responses": {}
+            }
+        },
+        "/auth/password/forgot": {
+            "post": {
+                "description": "Handle mailing a password reset token. The response is the same whether the email is registered or not",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Forgot password",
+                "parameters": [
+                    {
+                        "description": "email",
+                        "name": "params",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.ForgotPasswordParams"
+                        }
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/auth/password/reset": {
+            "post": {
+                "description": "Handle setting a new password with a password reset token. The token can be used once and every session of the user is revoked",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Reset password",
+                "parameters": [
+                    {
+                        "description": "token and new password",
+                        "name": "params",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.ResetPasswordParams"
+                        }
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/auth/refresh": {
+            "post": {
+                "description": "Handle exchanging a refresh token for a new access token and refresh token. Every refresh token can be used once, using it again revokes the session",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Refresh tokens",
+                "responses": {}
+            }
+        },
+        "/auth/verify": {
+            "post": {
+                "description": "Handle confirming the email of a user with a mailed verification token",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Verify email",
+                "parameters": [
+                    {
+                        "description": "verification token",
+                        "name": "params",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.VerifyEmailParams"
+                        }
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/auth/verify/resend": {
+            "post": {
+                "description": "Handle mailing a new verification token, earlier tokens stop working. The response is the same whether the email is registered or not",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "authentication"
+                ],
+                "summary": "Resend verification",
+                "parameters": [
+                    {
+                        "description": "email",
+                        "name": "params",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/api.ResendVerificationParams"
+                        }
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/copies/:id/retire": {
+            "post": {
+                "description": "Handle taking a copy out of circulation",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Retire a copy",
+                "responses": {}
+            }
+        },
+        "/me": {
+            "get": {
+                "description": "Handle getting the signed in user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Get me",
+                "responses": {}
+            },
+            "patch": {
+                "description": "Handle updating the profile of the signed in user, empty fields are kept. Changing the email requires the current password and the new email has to be verified again",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Update me",
+                "parameters": [
+                    {
+                        "description": "changed fields",
+                        "name": "params",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.UpdateUserParams"
+                        }
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/me/2fa": {
+            "post": {
+                "description": "Handle generating an authenticator secret for the signed in user. It guards logins once confirmed, starting again replaces an unconfirmed secret",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Start two-factor enrolment",
+                "responses": {}
+            },
+            "delete": {
+                "description": "Handle removing the authenticator of the signed in user, which takes the password and a current code. Every session is revoked and the response carries new tokens",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Disable two-factor authentication",
+                "parameters": [
+                    {
+                        "description": "password and authenticator code",
+                        "name": "params",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.DisableTOTPParams"
+                        }
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/me/2fa/confirm": {
+            "post": {
+                "description": "Handle enabling two-factor authentication with a first code of the authenticator app. The response lists recovery codes, they are not shown again. Log in again to get a session allowed on the admin routes",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Confirm two-factor enrolment",
+                "parameters": [
+                    {
+                        "description": "authenticator code",
+                        "name": "params",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.TOTPCodeParams"
+                        }
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/me/balance": {
+            "get": {
+                "description": "Handle getting the late fees the signed in user owes, in minor units. Renting is blocked while they are above the configured limit",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Get my balance",
+                "responses": {}
+            }
+        },
+        "/me/password": {
+            "put": {
+                "description": "Handle changing the password of the signed in user. Every session is revoked and the response carries new tokens",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Change my password",
+                "parameters": [
+                    {
+                        "description": "current and new password",
+                        "name": "params",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.ChangePasswordParams"
+                        }
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/me/wallet": {
+            "get": {
+                "description": "Handle getting the balance of the signed in user's prepaid wallet, in minor units",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Get my wallet",
+                "responses": {}
+            }
+        },
+        "/me/wallet/statement": {
+            "get": {
+                "description": "Handle listing the entries of the signed in user's wallet, newest first. Every entry carries the balance right after it",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Get my wallet statement",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "page size, 20 by default and at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "nextCursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/movies": {
+            "get": {
+                "description": "Handle searching movies with facet counts per genre and decade over all matches",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Search movies",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "words that must all appear in the title",
+                        "name": "q",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "any of these genres",
+                        "name": "genre",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "all of these genres",
+                        "name": "genreAll",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "earliest year",
+                        "name": "yearFrom",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "latest year",
+                        "name": "yearTo",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "minimum length in minutes",
+                        "name": "lengthMin",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "maximum length in minutes",
+                        "name": "lengthMax",
+                        "in": "query"
+                    },
+                    {
+                        "type": "number",
+                        "description": "minimum average rating",
+                        "name": "minRating",
+                        "in": "query"
+                    },
+                    {
+                        "enum": [
+                            "title",
+                            "-title",
+                            "year",
+                            "-year",
+                            "rating",
+                            "-rating",
+                            "popularity",
+                            "-popularity"
+                        ],
+                        "type": "string",
+                        "description": "sort key, prefix with - for descending",
+                        "name": "sort",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size, 20 by default and at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "nextCursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            },
+            "post": {
+                "description": "Handle posting movie to database",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Add movie",
+                "responses": {}
+            }
+        },
+        "/movies/:id": {
+            "get": {
+                "description": "Handle getting movie by id",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Get movie by ID",
+                "responses": {}
+            },
+            "put": {
+                "description": "Handle updating movie",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Update movie",
+                "responses": {}
+            },
+            "delete": {
+                "description": "Handle deleting movie",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Delete movie",
+                "responses": {}
+            }
+        },
+        "/movies/:id/copies": {
+            "get": {
+                "description": "Handle listing the copies of a movie",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get copies of a movie",
+                "parameters": [
+                    {
+                        "enum": [
+                            "dvd",
+                            "bluray",
+                            "4k",
+                            "digital"
+                        ],
+                        "type": "string",
+                        "description": "copy format",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "only rented or available copies",
+                        "name": "rented",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "only retired or active copies",
+                        "name": "retired",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            },
+            "post": {
+                "description": "Handle adding one or more copies of a movie in a given format",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Add copies of a movie",
+                "responses": {}
+            }
+        },
+        "/movies/:id/quote": {
+            "get": {
+                "description": "Handle pricing a rent of the movie starting now, per format with copies available or for the format asked for",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Quote the price of a rent",
+                "parameters": [
+                    {
+                        "enum": [
+                            "dvd",
+                            "bluray",
+                            "4k",
+                            "digital"
+                        ],
+                        "type": "string",
+                        "description": "copy format",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "rental duration in hours, the first one of each format by default",
+                        "name": "hours",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/movies/:id/rate": {
+            "put": {
+                "description": "Handle rating a movie, rating again replaces the user's previous rating",
                 "consumes": [
                     "application/json"
                 ],
@@ -26,26 +959,92 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "authentication"
+                    "user"
                 ],
-                "summary": "Authenticate user",
+                "summary": "Rate a movie",
                 "responses": {}
             }
         },
-        "/movies": {
+        "/movies/:id/rating": {
+            "get": {
+                "description": "Handle getting the current user's rating of a movie",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Get own movie rating",
+                "responses": {}
+            }
+        },
+        "/movies/:id/rent": {
+            "post": {
+                "description": "Handle renting movie, reserves one available copy. Users have to verify their email first and can't owe more late fees than the configured limit\nThe rental duration is picked from the durations of the movie or the copy format, the first one by default. The rent records its price from the price list\nPaid rents are charged to the payment method and stay pending until the payment is captured. A declined payment cancels the rent with 402, a capture the provider confirms later returns the pending rent with 202",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Rent a movie",
+                "parameters": [
+                    {
+                        "enum": [
+                            "dvd",
+                            "bluray",
+                            "4k",
+                            "digital"
+                        ],
+                        "type": "string",
+                        "description": "copy format",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "rental duration in hours",
+                        "name": "hours",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "payment method token of the provider, or wallet to pay from the renter's wallet",
+                        "name": "paymentMethod",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/movies/:id/reviews": {
             "get": {
-                "description": "Handle getting all movies from database",
+                "description": "Handle listing the approved reviews of a movie, newest first",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "user"
                 ],
-                "summary": "Get all movies",
+                "summary": "Get reviews of a movie",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "page size, 20 by default and at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "nextCursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
                 "responses": {}
             },
             "post": {
-                "description": "Handle posting movie to database",
+                "description": "Handle posting a text review, it is listed after an admin approves it",
                 "consumes": [
                     "application/json"
                 ],
@@ -53,96 +1052,354 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "admin"
+                    "user"
                 ],
-                "summary": "Add movie",
+                "summary": "Review a movie",
                 "responses": {}
             }
         },
-        "/movies/:id": {
-            "get": {
-                "description": "Handle getting movie by id",
+        "/movies/rented": {
+            "post": {
+                "description": "Handle getting movies rented by user, optionally filtered by state",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "user"
                 ],
-                "summary": "Get movie by ID",
+                "summary": "Get movies rented by user",
+                "parameters": [
+                    {
+                        "enum": [
+                            "pending",
+                            "active",
+                            "returned",
+                            "overdue",
+                            "cancelled"
+                        ],
+                        "type": "string",
+                        "description": "rent state",
+                        "name": "state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size, 20 by default and at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "nextCursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/payments/webhook": {
+            "post": {
+                "description": "Handle a signed webhook of the payment provider. A captured rent payment activates its pending rent, a failed one cancels it and releases its copy\nA captured extension payment that was pending extends its rent, or is refunded when the rent can't be extended anymore\nRedelivered webhooks are acknowledged without changing anything",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "payments"
+                ],
+                "summary": "Receive a payment webhook",
+                "responses": {}
+            }
+        },
+        "/price-rules": {
+            "get": {
+                "description": "Handle listing the whole price list, oldest rule first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get price rules",
                 "responses": {}
             },
+            "post": {
+                "description": "Handle adding a base price, new release premium, duration multiplier or weekend rate to the price list. Amounts are in minor units of the rental currency",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Add a price rule",
+                "parameters": [
+                    {
+                        "description": "price rule",
+                        "name": "params",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.PriceRuleParams"
+                        }
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/price-rules/:id": {
             "put": {
-                "description": "Handle updating movie",
+                "description": "Handle replacing every field of a price rule",
+                "consumes": [
+                    "application/json"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "admin"
                 ],
-                "summary": "Update movie",
+                "summary": "Replace a price rule",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "price rule id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "price rule",
+                        "name": "params",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.PriceRuleParams"
+                        }
+                    }
+                ],
                 "responses": {}
             },
             "delete": {
-                "description": "Handle deleting movie",
+                "description": "Handle removing a rule from the price list, rents already made keep their price",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "admin"
                 ],
-                "summary": "Delete movie",
+                "summary": "Delete a price rule",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "price rule id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
                 "responses": {}
             }
         },
-        "/movies/:id/rate": {
-            "put": {
-                "description": "Handle updating movie rating",
+        "/rents": {
+            "get": {
+                "description": "Handle getting all rents made by users, optionally filtered by state",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "user"
+                    "admin"
+                ],
+                "summary": "Get all rents(user id, movie id, from, to)",
+                "parameters": [
+                    {
+                        "enum": [
+                            "pending",
+                            "active",
+                            "returned",
+                            "overdue",
+                            "cancelled"
+                        ],
+                        "type": "string",
+                        "description": "rent state",
+                        "name": "state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size, 20 by default and at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "nextCursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
                 ],
-                "summary": "Update movie movie rating",
                 "responses": {}
             }
         },
-        "/movies/:id/rent": {
+        "/rents/:id/extend": {
             "post": {
-                "description": "Handle renting movie",
+                "description": "Handle extending an active rent by one of the rental durations of its movie or format, the first one by default. Its price is added to the rent\nA rent can only be extended while another copy of its format is available, so renters waiting for one aren't held up\nPaid extensions are charged to the payment method first, a declined payment returns 402. A payment the provider confirms later returns 202 and the rent is extended once the capture webhook arrives",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "user"
                 ],
-                "summary": "Rent a movie",
+                "summary": "Extend a rent",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "extension in hours",
+                        "name": "hours",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "payment method token of the provider, or wallet to pay from the renter's wallet",
+                        "name": "paymentMethod",
+                        "in": "query"
+                    }
+                ],
                 "responses": {}
             }
         },
-        "/movies/rented": {
+        "/rents/:id/payments": {
+            "get": {
+                "description": "Handle getting the payments of a rent oldest first, users can only see the payments of their own rents unless they can manage rents",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Get the payments of a rent",
+                "responses": {}
+            }
+        },
+        "/rents/:id/return": {
             "post": {
-                "description": "Handle getting movies rented by user",
+                "description": "Handle returning a rent, users can only return their own rents unless they can manage rents. A late return settles the late fee of the rent, which is paid from the renter's wallet when its balance covers it",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "user"
                 ],
-                "summary": "Get movies rented by user",
+                "summary": "Return a rented movie",
                 "responses": {}
             }
         },
-        "/rents": {
+        "/reviews": {
             "get": {
-                "description": "Handle getting all rents made by users",
+                "description": "Handle listing reviews by status, pending reviews by default",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "admin"
                 ],
-                "summary": "Get all rents(user id, movie id, from, to)",
+                "summary": "Get the moderation queue",
+                "parameters": [
+                    {
+                        "enum": [
+                            "pending",
+                            "approved",
+                            "hidden"
+                        ],
+                        "type": "string",
+                        "description": "review status",
+                        "name": "status",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "page size, 20 by default and at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "nextCursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/reviews/:id": {
+            "put": {
+                "description": "Handle editing the text of the user's own review, it goes back to the moderation queue",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Edit a review",
+                "responses": {}
+            },
+            "delete": {
+                "description": "Handle deleting the user's own review",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "user"
+                ],
+                "summary": "Delete a review",
+                "responses": {}
+            }
+        },
+        "/reviews/:id/approve": {
+            "post": {
+                "description": "Handle approving a review so it is listed on the movie",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Approve a review",
+                "responses": {}
+            }
+        },
+        "/reviews/:id/hide": {
+            "post": {
+                "description": "Handle hiding a review from the movie listing",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Hide a review",
+                "responses": {}
+            }
+        },
+        "/roles": {
+            "get": {
+                "description": "Handle listing every role with its permissions",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Get roles",
                 "responses": {}
             }
         },
@@ -156,10 +1413,24 @@ const docTemplate = `{
                     "admin"
                 ],
                 "summary": "Get users",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "page size, 20 by default and at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "nextCursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
                 "responses": {}
             },
             "post": {
-                "description": "Handle posting user",
+                "description": "Handle posting user, the user has to verify their email with the mailed token before renting. Emails are case insensitive, a taken email or username is a 409 naming the field",
                 "produces": [
                     "application/json"
                 ],
@@ -172,12 +1443,12 @@ const docTemplate = `{
         },
         "/users/:id": {
             "get": {
-                "description": "Handle getting user by id",
+                "description": "Handle getting user by id, users can only get themselves unless they can read users",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "admin"
+                    "user"
                 ],
                 "summary": "Get user by id",
                 "responses": {}
@@ -193,6 +1464,335 @@ const docTemplate = `{
                 "summary": "Delete user by id",
                 "responses": {}
             }
+        },
+        "/users/:id/2fa/reset": {
+            "post": {
+                "description": "Handle removing the authenticator of a user who lost it and their recovery codes, revoking every session of the user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Reset two-factor authentication",
+                "responses": {}
+            }
+        },
+        "/users/:id/roles": {
+            "put": {
+                "description": "Handle replacing the roles of a user, admins can't take the permission to assign roles away from themselves",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Set user roles",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "roles",
+                        "name": "roles",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.UpdateRolesParams"
+                        }
+                    }
+                ],
+                "responses": {}
+            }
+        },
+        "/users/:id/sessions/revoke": {
+            "post": {
+                "description": "Handle revoking every session of a user, logging them out everywhere",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Revoke user sessions",
+                "responses": {}
+            }
+        },
+        "/users/:id/unlock": {
+            "post": {
+                "description": "Handle lifting the login lockout and the failed login count of a user",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Unlock user login",
+                "responses": {}
+            }
+        },
+        "/users/:id/verify": {
+            "post": {
+                "description": "Handle marking the email of a user as verified without a token",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Verify user email",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "user id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {}
+            }
+        }
+    },
+    "definitions": {
+        "api.ForgotPasswordParams": {
+            "type": "object",
+            "properties": {
+                "email": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.ResendVerificationParams": {
+            "type": "object",
+            "properties": {
+                "email": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.TwoFactorParams": {
+            "type": "object",
+            "properties": {
+                "challenge": {
+                    "type": "string"
+                },
+                "code": {
+                    "type": "string"
+                },
+                "recoveryCode": {
+                    "type": "string"
+                }
+            }
+        },
+        "api.VerifyEmailParams": {
+            "type": "object",
+            "properties": {
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.ChangePasswordParams": {
+            "type": "object",
+            "properties": {
+                "currentPassword": {
+                    "type": "string"
+                },
+                "password": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.CopyFormat": {
+            "type": "string",
+            "enum": [
+                "dvd",
+                "bluray",
+                "4k",
+                "digital"
+            ],
+            "x-enum-varnames": [
+                "FormatDVD",
+                "FormatBluRay",
+                "Format4K",
+                "FormatDigital"
+            ]
+        },
+        "types.CreateAPIKeyParams": {
+            "type": "object",
+            "properties": {
+                "expiresAt": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/types.Permission"
+                    }
+                },
+                "userID": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.DisableTOTPParams": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "password": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.Permission": {
+            "type": "string",
+            "enum": [
+                "movies:rent",
+                "movies:review",
+                "catalog:manage",
+                "rents:manage",
+                "reviews:moderate",
+                "users:read",
+                "users:manage",
+                "roles:assign",
+                "audit:read",
+                "apikeys:manage",
+                "pricing:manage",
+                "wallets:manage"
+            ],
+            "x-enum-varnames": [
+                "PermRentMovies",
+                "PermReviewMovies",
+                "PermManageCatalog",
+                "PermManageRents",
+                "PermModerateReviews",
+                "PermReadUsers",
+                "PermManageUsers",
+                "PermAssignRoles",
+                "PermReadAudit",
+                "PermManageAPIKeys",
+                "PermManagePricing",
+                "PermManageWallets"
+            ]
+        },
+        "types.PriceRuleKind": {
+            "type": "string",
+            "enum": [
+                "base",
+                "new_release",
+                "duration",
+                "weekend"
+            ],
+            "x-enum-varnames": [
+                "PriceBase",
+                "PriceNewRelease",
+                "PriceDuration",
+                "PriceWeekend"
+            ]
+        },
+        "types.PriceRuleParams": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "integer"
+                },
+                "format": {
+                    "$ref": "#/definitions/types.CopyFormat"
+                },
+                "hours": {
+                    "type": "integer"
+                },
+                "kind": {
+                    "$ref": "#/definitions/types.PriceRuleKind"
+                },
+                "maxAge": {
+                    "type": "integer"
+                },
+                "movieID": {
+                    "type": "string"
+                },
+                "percent": {
+                    "type": "integer"
+                }
+            }
+        },
+        "types.ResetPasswordParams": {
+            "type": "object",
+            "properties": {
+                "password": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.Role": {
+            "type": "string",
+            "enum": [
+                "customer",
+                "clerk",
+                "catalog_manager",
+                "admin"
+            ],
+            "x-enum-varnames": [
+                "RoleCustomer",
+                "RoleClerk",
+                "RoleCatalogManager",
+                "RoleAdmin"
+            ]
+        },
+        "types.TOTPCodeParams": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                }
+            }
+        },
+        "types.UpdateRolesParams": {
+            "type": "object",
+            "properties": {
+                "roles": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/types.Role"
+                    }
+                }
+            }
+        },
+        "types.UpdateUserParams": {
+            "type": "object",
+            "properties": {
+                "currentPassword": {
+                    "type": "string"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "firstName": {
+                    "type": "string"
+                },
+                "lastName": {
+                    "type": "string"
+                },
+                "username": {
+                    "type": "string"
+                }
+            }
         }
     }
 }`
@@ -0,0 +1,269 @@
+// Package keys holds the asymmetric keys access tokens are signed with. One
+// key signs new tokens while retired keys keep verifying the tokens they
+// signed until those expire, so signing keys can be rotated without logging
+// anyone out. The public keys are published as a JWK set for other services
+// to verify tokens with.
+package keys
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const minRSABits = 2048
+
+// ErrNoSigningKey is returned by FromEnv when JWT_SIGNING_KEY is not set.
+var ErrNoSigningKey = errors.New("JWT_SIGNING_KEY is not set")
+
+// Key is a public key identified by the thumbprint of RFC 7638.
+type Key struct {
+	ID     string
+	Method jwt.SigningMethod
+	Public crypto.PublicKey
+}
+
+func newKey(pub crypto.PublicKey) (*Key, error) {
+	key := &Key{Public: pub}
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if pub.N.BitLen() < minRSABits {
+			return nil, fmt.Errorf("RSA keys need at least %d bits", minRSABits)
+		}
+		key.Method = jwt.SigningMethodRS256
+	case ed25519.PublicKey:
+		key.Method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T, use RSA or Ed25519", pub)
+	}
+	key.ID = thumbprint(key.JWK())
+	return key, nil
+}
+
+// thumbprint hashes the required members of the key in lexical order.
+func thumbprint(jwk JWK) string {
+	var members string
+	switch jwk.Kty {
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":%q,"n":%q}`, jwk.E, jwk.Kty, jwk.N)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q}`, jwk.Crv, jwk.Kty, jwk.X)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// JWK is a public key in the JSON Web Key format.
+type JWK struct {
+	Crv string `json:"crv,omitempty"`
+	E   string `json:"e,omitempty"`
+	Kty string `json:"kty"`
+	N   string `json:"n,omitempty"`
+	X   string `json:"x,omitempty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+}
+
+func (k *Key) JWK() JWK {
+	jwk := JWK{Kid: k.ID, Use: "sig", Alg: k.Method.Alg()}
+	switch pub := k.Public.(type) {
+	case *rsa.PublicKey:
+		jwk.Kty = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.Kty = "OKP"
+		jwk.Crv = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+	}
+	return jwk
+}
+
+// JWKS is a JSON Web Key Set.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// Set is the signing key and the retired keys still accepted.
+type Set struct {
+	signer  crypto.Signer
+	signing *Key
+	keys    map[string]*Key
+	methods []string
+}
+
+// NewSet signs with signer and also verifies tokens signed by the retired
+// public keys.
+func NewSet(signer crypto.Signer, retired ...crypto.PublicKey) (*Set, error) {
+	signing, err := newKey(signer.Public())
+	if err != nil {
+		return nil, err
+	}
+	s := &Set{
+		signer:  signer,
+		signing: signing,
+		keys:    map[string]*Key{},
+	}
+	for _, pub := range append([]crypto.PublicKey{signer.Public()}, retired...) {
+		key, err := newKey(pub)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := s.keys[key.ID]; ok {
+			continue
+		}
+		s.keys[key.ID] = key
+		if alg := key.Method.Alg(); !slices.Contains(s.methods, alg) {
+			s.methods = append(s.methods, alg)
+		}
+	}
+	return s, nil
+}
+
+// Generate returns a set with a new Ed25519 key. Tokens it signs stop being
+// accepted once the process exits.
+func Generate() (*Set, error) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return NewSet(priv)
+}
+
+// FromEnv loads the PEM private key in the file named by JWT_SIGNING_KEY
+// and the retired public keys in the comma separated files of
+// JWT_VERIFICATION_KEYS.
+func FromEnv() (*Set, error) {
+	path := os.Getenv("JWT_SIGNING_KEY")
+	if path == "" {
+		return nil, ErrNoSigningKey
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ParsePrivateKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var retired []crypto.PublicKey
+	for _, path := range strings.Split(os.Getenv("JWT_VERIFICATION_KEYS"), ",") {
+		if path = strings.TrimSpace(path); path == "" {
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := ParsePublicKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		retired = append(retired, pub)
+	}
+	return NewSet(signer, retired...)
+}
+
+// ParsePrivateKey reads a PKCS #8 or PKCS #1 PEM private key.
+func ParsePrivateKey(b []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	var (
+		key any
+		err error
+	)
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q", block.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return signer, nil
+}
+
+// ParsePublicKey reads a PKIX or PKCS #1 PEM public key. The public half of
+// a private key is accepted too.
+func ParsePublicKey(b []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	signer, err := ParsePrivateKey(b)
+	if err != nil {
+		return nil, err
+	}
+	return signer.Public(), nil
+}
+
+// Sign signs the claims with the signing key, naming it in the kid header.
+func (s *Set) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.signing.Method, claims)
+	token.Header["kid"] = s.signing.ID
+	return token.SignedString(s.signer)
+}
+
+// Keyfunc finds the verification key of a token by its kid header. Tokens
+// have to be signed with the algorithm of the key.
+func (s *Set) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := s.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	}
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("key %q does not sign with %s", kid, token.Method.Alg())
+	}
+	return key.Public, nil
+}
+
+// Methods are the signing algorithms of the keys of the set.
+func (s *Set) Methods() []string {
+	return s.methods
+}
+
+// JWKS returns the public keys, the signing key first.
+func (s *Set) JWKS() JWKS {
+	ids := make([]string, 0, len(s.keys))
+	for id := range s.keys {
+		if id != s.signing.ID {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	set := JWKS{Keys: []JWK{s.signing.JWK()}}
+	for _, id := range ids {
+		set.Keys = append(set.Keys, s.keys[id].JWK())
+	}
+	return set
+}
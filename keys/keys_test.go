@@ -0,0 +1,110 @@
+package keys
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// RFC 8037, appendix A.3
+func TestThumbprint(t *testing.T) {
+	x, _ := base64.RawURLEncoding.DecodeString("11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo")
+	key, err := newKey(ed25519.PublicKey(x))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.ID != "kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k" {
+		t.Fatalf("unexpected thumbprint %s", key.ID)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := NewSet(rsaKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := jwt.RegisteredClaims{Subject: "user", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute))}
+	token, err := old.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	rotated, err := NewSet(edKey, rsaKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	verify := func(set *Set, token string) error {
+		_, err := jwt.ParseWithClaims(token, &jwt.RegisteredClaims{}, set.Keyfunc, jwt.WithValidMethods(set.Methods()))
+		return err
+	}
+	if err := verify(rotated, token); err != nil {
+		t.Fatalf("expected the retired key to verify its token: %v", err)
+	}
+	newToken, err := rotated.Sign(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(rotated, newToken); err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(old, newToken); err == nil {
+		t.Fatal("expected the old set to reject a token of an unknown key")
+	}
+	done, _ := NewSet(edKey)
+	if err := verify(done, token); err == nil {
+		t.Fatal("expected the token of a dropped key to be rejected")
+	}
+
+	jwks := rotated.JWKS()
+	if len(jwks.Keys) != 2 || jwks.Keys[0].Kty != "OKP" || jwks.Keys[0].Alg != "EdDSA" || jwks.Keys[1].Kty != "RSA" || jwks.Keys[1].Alg != "RS256" {
+		t.Fatalf("unexpected key set %+v", jwks)
+	}
+
+	// a token naming the key of another algorithm is rejected
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	forged.Header["kid"] = old.signing.ID
+	forgedStr, _ := forged.SignedString(x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey))
+	if err := verify(rotated, forgedStr); err == nil {
+		t.Fatal("expected a token signed with the wrong algorithm to be rejected")
+	}
+}
+
+func TestParse(t *testing.T) {
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	der, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	signer, err := ParsePrivateKey(privPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ = x509.MarshalPKIXPublicKey(edKey.Public())
+	pub, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !edKey.Public().(ed25519.PublicKey).Equal(pub) || !edKey.Equal(signer) {
+		t.Fatal("expected the parsed keys to match")
+	}
+	if pub, err = ParsePublicKey(privPEM); err != nil || !edKey.Public().(ed25519.PublicKey).Equal(pub) {
+		t.Fatalf("expected the public half of the private key, got %v", err)
+	}
+
+	small, _ := rsa.GenerateKey(rand.Reader, 1024)
+	if _, err := NewSet(small); err == nil {
+		t.Fatal("expected a 1024 bit RSA key to be rejected")
+	}
+}
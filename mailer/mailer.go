@@ -0,0 +1,122 @@
+// Package mailer sends the emails of the API, such as password reset
+// links. SMTPMailer delivers them and LogMailer writes them to a file or
+// stdout for local development and tests.
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(context.Context, Message) error
+}
+
+// FromEnv builds the mailer selected by MAIL_DRIVER: "log" (the default)
+// writes to MAIL_LOG_FILE or stdout, "smtp" sends through SMTP_ADDR as
+// MAIL_FROM.
+func FromEnv() (Mailer, error) {
+	switch driver := os.Getenv("MAIL_DRIVER"); driver {
+	case "", "log":
+		path := os.Getenv("MAIL_LOG_FILE")
+		if path == "" {
+			return NewLogMailer(os.Stdout), nil
+		}
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			return nil, err
+		}
+		return NewLogMailer(f), nil
+	case "smtp":
+		return NewSMTPMailer(os.Getenv("SMTP_ADDR"), os.Getenv("MAIL_FROM"), os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD")), nil
+	default:
+		return nil, fmt.Errorf("unknown MAIL_DRIVER %q", driver)
+	}
+}
+
+var errHeaderInjection = errors.New("mail header contains a line break")
+
+// format renders msg as a plain text email. Line breaks in the headers are
+// rejected so user input can't add headers of its own.
+func format(from string, msg Message) ([]byte, error) {
+	for _, header := range []string{from, msg.To, msg.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, errHeaderInjection
+		}
+	}
+	var b strings.Builder
+	if from != "" {
+		fmt.Fprintf(&b, "From: %s\r\n", from)
+	}
+	fmt.Fprintf(&b, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", msg.Subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+	b.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String()), nil
+}
+
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer sends mail through the server at addr (host:port). PLAIN
+// authentication is used when username is set.
+func NewSMTPMailer(addr, from, username, password string) *SMTPMailer {
+	m := &SMTPMailer{
+		addr: addr,
+		from: from,
+	}
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+// Send delivers msg. net/smtp does not take a context, so ctx is ignored.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	b, err := format(m.from, msg)
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(m.addr, m.auth, m.from, []string{msg.To}, b)
+}
+
+type LogMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewLogMailer(w io.Writer) *LogMailer {
+	return &LogMailer{
+		w: w,
+	}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	b, err := format("", msg)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err = fmt.Fprintf(m.w, "%s\r\n", b)
+	return err
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestLogMailer(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf)
+	err := m.Send(context.Background(), Message{
+		To:      "tomek@test.com",
+		Subject: "Reset your password",
+		Body:    "first line\nsecond line",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := buf.String()
+	for _, want := range []string{"To: tomek@test.com\r\n", "Subject: Reset your password\r\n", "\r\n\r\nfirst line\r\nsecond line\r\n"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("expected %q in %q", want, got)
+		}
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	var buf bytes.Buffer
+	m := NewLogMailer(&buf)
+	err := m.Send(context.Background(), Message{
+		To:      "tomek@test.com\r\nBcc: everyone@test.com",
+		Subject: "hi",
+	})
+	if !errors.Is(err, errHeaderInjection) {
+		t.Fatalf("expected errHeaderInjection but got %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Fatal("expected nothing to be written")
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log"
 	"os"
 
@@ -10,7 +12,13 @@ import (
 	"github.com/joho/godotenv"
 	"github.com/tomekzakrzewski/go-movierental/api"
 	"github.com/tomekzakrzewski/go-movierental/db"
+	"github.com/tomekzakrzewski/go-movierental/db/postgres"
 	_ "github.com/tomekzakrzewski/go-movierental/docs"
+	"github.com/tomekzakrzewski/go-movierental/keys"
+	"github.com/tomekzakrzewski/go-movierental/mailer"
+	"github.com/tomekzakrzewski/go-movierental/oidc"
+	"github.com/tomekzakrzewski/go-movierental/payment"
+	"github.com/tomekzakrzewski/go-movierental/types"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -24,60 +32,227 @@ var config = fiber.Config{
 // @description	API for movie rental
 // @termsOfService	http://swagger.io/terms/
 func main() {
-	mongoEndpoint := os.Getenv("MONGO_DB_URL")
-	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(mongoEndpoint))
+	// the client IP throttles logins, behind a reverse proxy it has to be
+	// read from the header the proxy sets
+	config.ProxyHeader = os.Getenv("PROXY_HEADER")
+	store, err := newStore(context.Background())
 	if err != nil {
 		log.Fatal(err)
 	}
+	mail, err := mailer.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	loginPolicy, err := api.LoginPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rentalPolicy, err := api.RentalPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	payments, err := payment.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	tokenKeys, err := keys.FromEnv()
+	if errors.Is(err, keys.ErrNoSigningKey) {
+		log.Println("JWT_SIGNING_KEY is not set, signing tokens with a temporary key")
+		tokenKeys, err = keys.Generate()
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+	api.SetTokenKeys(tokenKeys)
 
 	var (
-		store = &db.Store{
-			User:  db.NewUserStore(client),
-			Movie: db.NewMovieStore(client),
-			Rent:  db.NewRentStore(client),
-		}
-		rentStore    = db.NewRentStore(client)
-		userStore    = db.NewUserStore(client)
-		movieHandler = api.NewMovieHandler(store)
-		userHandler  = api.NewUserHandler(userStore)
-		rentHandler  = api.NewRentHandler(rentStore)
-		authHandler  = api.NewAuthHandler(userStore)
-		app          = fiber.New(config)
-		auth         = app.Group("/api")
-		apiv1        = app.Group("/api/v1", api.JWTAuthentication(userStore))
-		admin        = apiv1.Group("/admin", api.AdminAuth)
+		movieHandler    = api.NewMovieHandler(store, rentalPolicy, payments)
+		userHandler     = api.NewUserHandler(store, mail)
+		rentHandler     = api.NewRentHandler(store, rentalPolicy, payments)
+		copyHandler     = api.NewCopyHandler(store)
+		reviewHandler   = api.NewReviewHandler(store)
+		roleHandler     = api.NewRoleHandler(store.User)
+		authHandler     = api.NewAuthHandler(store, api.NewLoginThrottle(store, loginPolicy))
+		auditHandler    = api.NewAuditHandler(store.Audit)
+		passwordHandler = api.NewPasswordHandler(store, mail)
+		verifyHandler   = api.NewVerificationHandler(store, mail)
+		totpHandler     = api.NewTwoFactorHandler(store, os.Getenv("TOTP_ISSUER"))
+		apiKeyHandler   = api.NewAPIKeyHandler(store)
+		priceHandler    = api.NewPriceHandler(store, rentalPolicy)
+		paymentHandler  = api.NewPaymentHandler(store, payments)
+		walletHandler   = api.NewWalletHandler(store, rentalPolicy)
+		app             = fiber.New(config)
+		auth            = app.Group("/api")
+		apiv1           = app.Group("/api/v1", api.JWTAuthentication(store))
+		admin           = apiv1.Group("/admin", api.AdminAuth)
+
+		canRent          = api.RequirePermission(types.PermRentMovies)
+		canReview        = api.RequirePermission(types.PermReviewMovies)
+		canManageCatalog = api.RequirePermission(types.PermManageCatalog)
+		canManageRents   = api.RequirePermission(types.PermManageRents)
+		canModerate      = api.RequirePermission(types.PermModerateReviews)
+		canReadUsers     = api.RequirePermission(types.PermReadUsers)
+		canManageUsers   = api.RequirePermission(types.PermManageUsers)
+		canAssignRoles   = api.RequirePermission(types.PermAssignRoles)
+		canReadAudit     = api.RequirePermission(types.PermReadAudit)
+		canManageAPIKeys = api.RequirePermission(types.PermManageAPIKeys)
+		canManagePricing = api.RequirePermission(types.PermManagePricing)
+		canManageWallets = api.RequirePermission(types.PermManageWallets)
 	)
 
 	//swagger
 	app.Get("/swagger/*", swagger.HandlerDefault)
 
+	// the public keys of access tokens, for other services to verify them
+	app.Get("/.well-known/jwks.json", api.HandleGetJWKS)
+
 	// auth handler
 	auth.Post("/auth", authHandler.HandleAuthenticate)
+	auth.Post("/auth/2fa", authHandler.HandleTwoFactor)
+	auth.Post("/auth/refresh", authHandler.HandleRefresh)
+	auth.Post("/auth/logout", authHandler.HandleLogout)
+	auth.Post("/auth/password/forgot", passwordHandler.HandleForgotPassword)
+	auth.Post("/auth/password/reset", passwordHandler.HandleResetPassword)
+	auth.Post("/auth/verify", verifyHandler.HandleVerifyEmail)
+	auth.Post("/auth/verify/resend", verifyHandler.HandleResendVerification)
+
+	// single sign-on is enabled by configuring a provider
+	if oidcConfig, ok := oidc.ConfigFromEnv(); ok {
+		provider, err := oidc.NewProvider(context.Background(), oidcConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+		oidcHandler := api.NewOIDCHandler(store, provider, authHandler)
+		auth.Get("/auth/oidc/login", oidcHandler.HandleLogin)
+		auth.Get("/auth/oidc/callback", oidcHandler.HandleCallback)
+	}
 
 	// movie handlers
 	apiv1.Get("/movies/:id", movieHandler.HandleGetMovieByID)
-	apiv1.Put("/movies/:id/rate", movieHandler.HandleUpdateMovieRating)
-	apiv1.Post("/movies/:id/rent", movieHandler.HandleRentMovie)
-	apiv1.Post("/movies/rented", movieHandler.HandleGetRentedMovies)
+	apiv1.Put("/movies/:id/rate", canReview, movieHandler.HandleUpdateMovieRating)
+	apiv1.Get("/movies/:id/rating", movieHandler.HandleGetMovieRating)
+	apiv1.Post("/movies/:id/rent", canRent, movieHandler.HandleRentMovie)
+	apiv1.Get("/movies/:id/quote", priceHandler.HandleGetQuote)
+	apiv1.Post("/movies/rented", canRent, movieHandler.HandleGetRentedMovies)
 	apiv1.Get("/movies", movieHandler.HandleGetMovies)
 
-	admin.Post("/movies", movieHandler.HandlePostMovie)
-	admin.Put("/movies/:id", movieHandler.HandleUpdateMovie)
-	admin.Delete("/movies/:id", movieHandler.HandleDeleteMovie)
+	admin.Post("/movies", canManageCatalog, movieHandler.HandlePostMovie)
+	admin.Put("/movies/:id", canManageCatalog, movieHandler.HandleUpdateMovie)
+	admin.Delete("/movies/:id", canManageCatalog, movieHandler.HandleDeleteMovie)
+
+	// copy handlers
+	admin.Post("/movies/:id/copies", canManageCatalog, copyHandler.HandlePostCopies)
+	admin.Get("/movies/:id/copies", canManageCatalog, copyHandler.HandleGetCopies)
+	admin.Post("/copies/:id/retire", canManageCatalog, copyHandler.HandleRetireCopy)
+
+	// review handlers
+	apiv1.Post("/movies/:id/reviews", canReview, reviewHandler.HandlePostReview)
+	apiv1.Get("/movies/:id/reviews", reviewHandler.HandleGetMovieReviews)
+	apiv1.Put("/reviews/:id", canReview, reviewHandler.HandlePutReview)
+	apiv1.Delete("/reviews/:id", canReview, reviewHandler.HandleDeleteReview)
+
+	admin.Get("/reviews", canModerate, reviewHandler.HandleGetReviews)
+	admin.Post("/reviews/:id/approve", canModerate, reviewHandler.HandleApproveReview)
+	admin.Post("/reviews/:id/hide", canModerate, reviewHandler.HandleHideReview)
 
 	// user handlers
 	apiv1.Get("/users/:id", userHandler.HandleGetUser)
 	apiv1.Post("/users", userHandler.HandlePostUser)
+	apiv1.Get("/me", userHandler.HandleGetMe)
+	apiv1.Patch("/me", userHandler.HandlePatchMe)
+	apiv1.Put("/me/password", userHandler.HandlePutMyPassword)
+	apiv1.Get("/me/balance", rentHandler.HandleGetBalance)
+	apiv1.Post("/me/2fa", totpHandler.HandleStartTOTP)
+	apiv1.Post("/me/2fa/confirm", totpHandler.HandleConfirmTOTP)
+	apiv1.Delete("/me/2fa", totpHandler.HandleDeleteTOTP)
+
+	admin.Get("/users", canReadUsers, userHandler.HandleGetUsers)
+	admin.Delete("/users/:id", canManageUsers, userHandler.HandleDeleteUser)
+	admin.Post("/users/:id/sessions/revoke", canManageUsers, authHandler.HandleRevokeUserSessions)
+	admin.Post("/users/:id/verify", canManageUsers, verifyHandler.HandleAdminVerify)
+	admin.Post("/users/:id/unlock", canManageUsers, authHandler.HandleUnlockUser)
+	admin.Post("/users/:id/2fa/reset", canManageUsers, totpHandler.HandleResetTOTP)
+
+	// role handlers
+	admin.Get("/roles", canAssignRoles, roleHandler.HandleGetRoles)
+	admin.Put("/users/:id/roles", canAssignRoles, roleHandler.HandlePutUserRoles)
+
+	// audit handlers
+	admin.Get("/audit", canReadAudit, auditHandler.HandleGetAudit)
 
-	admin.Get("/users", userHandler.HandleGetUsers)
-	admin.Delete("/users/:id", userHandler.HandleDeleteUser)
+	// api key handlers
+	admin.Post("/api-keys", canManageAPIKeys, apiKeyHandler.HandlePostAPIKey)
+	admin.Get("/api-keys", canManageAPIKeys, apiKeyHandler.HandleGetAPIKeys)
+	admin.Post("/api-keys/:id/revoke", canManageAPIKeys, apiKeyHandler.HandleRevokeAPIKey)
+
+	// price handlers
+	admin.Get("/price-rules", canManagePricing, priceHandler.HandleGetPriceRules)
+	admin.Post("/price-rules", canManagePricing, priceHandler.HandlePostPriceRule)
+	admin.Put("/price-rules/:id", canManagePricing, priceHandler.HandlePutPriceRule)
+	admin.Delete("/price-rules/:id", canManagePricing, priceHandler.HandleDeletePriceRule)
 
 	//rent handlers
-	admin.Get("/rents", rentHandler.HandleGetRents)
+	apiv1.Post("/rents/:id/return", canRent, rentHandler.HandleReturnRent)
+	apiv1.Post("/rents/:id/extend", canRent, rentHandler.HandleExtendRent)
+	apiv1.Get("/rents/:id/payments", paymentHandler.HandleGetRentPayments)
+
+	admin.Get("/rents", canManageRents, rentHandler.HandleGetRents)
+	admin.Post("/rents/:id/late-fee/settle", canManageRents, rentHandler.HandleSettleLateFee)
+
+	// payment handlers, webhooks are authenticated by the provider's signature
+	auth.Post("/payments/webhook", paymentHandler.HandleWebhook)
+	admin.Post("/payments/:id/refund", canManageRents, paymentHandler.HandleRefundPayment)
+
+	// wallet handlers
+	apiv1.Get("/me/wallet", walletHandler.HandleGetMyWallet)
+	apiv1.Get("/me/wallet/statement", walletHandler.HandleGetMyStatement)
+	admin.Get("/users/:id/wallet", canManageWallets, walletHandler.HandleGetWallet)
+	admin.Get("/users/:id/wallet/statement", canManageWallets, walletHandler.HandleGetStatement)
+	admin.Post("/users/:id/wallet/credit", canManageWallets, walletHandler.HandleCredit)
+	admin.Post("/users/:id/wallet/debit", canManageWallets, walletHandler.HandleDebit)
+
+	// overdue rents are marked and their late fees accrued in the background
+	go api.NewOverdueSweeper(store, rentalPolicy.LateFees).Run(context.Background())
 
 	app.Listen(os.Getenv("LISTEN_ADDR"))
 }
 
+// newStore builds the store backend selected by DB_DRIVER: "mongo" (the
+// default) or "postgres".
+func newStore(ctx context.Context) (*db.Store, error) {
+	switch driver := os.Getenv("DB_DRIVER"); driver {
+	case "", "mongo":
+		client, err := mongo.Connect(ctx, options.Client().ApplyURI(os.Getenv("MONGO_DB_URL")))
+		if err != nil {
+			return nil, err
+		}
+		if err := db.CheckDuplicateUsers(ctx, client); err != nil {
+			return nil, err
+		}
+		if err := db.MigrateUsers(ctx, client); err != nil {
+			return nil, err
+		}
+		if err := db.EnsureMongoIndexes(ctx, client); err != nil {
+			return nil, err
+		}
+		return db.NewMongoStore(client), nil
+	case "postgres":
+		conn, err := postgres.Open(ctx, os.Getenv("POSTGRES_DB_URL"))
+		if err != nil {
+			return nil, err
+		}
+		if err := postgres.CheckDuplicateUsers(ctx, conn); err != nil {
+			return nil, err
+		}
+		if err := postgres.Migrate(ctx, conn); err != nil {
+			return nil, err
+		}
+		return postgres.NewStore(conn), nil
+	default:
+		return nil, fmt.Errorf("unknown DB_DRIVER %q", driver)
+	}
+}
+
 func init() {
 	if err := godotenv.Load(); err != nil {
 		log.Fatal(err)
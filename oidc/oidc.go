@@ -0,0 +1,370 @@
+// Package oidc is a minimal OpenID Connect relying party implementing the
+// authorization code flow with PKCE. It discovers the provider, builds the
+// authorization URL, exchanges the code and verifies the ID token against
+// the keys the provider publishes.
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+type Config struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// TrustMFA lets a login the provider reports as multi-factor count as
+	// a local two-factor login, skipping the authenticator app.
+	TrustMFA bool
+	// HTTPClient talks to the provider, http.DefaultClient when nil.
+	HTTPClient *http.Client
+}
+
+// ConfigFromEnv reads OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET,
+// OIDC_REDIRECT_URL and OIDC_TRUST_MFA, which is off unless set to true. It
+// reports false when OIDC_ISSUER is not set. The client secret is optional
+// for public clients.
+func ConfigFromEnv() (Config, bool) {
+	config := Config{
+		Issuer:       os.Getenv("OIDC_ISSUER"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+		TrustMFA:     os.Getenv("OIDC_TRUST_MFA") == "true",
+	}
+	return config, config.Issuer != ""
+}
+
+// metadata is the part of the discovery document the flow needs.
+type metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type Provider struct {
+	config   Config
+	client   *http.Client
+	metadata metadata
+
+	mu   sync.RWMutex
+	keys map[string]crypto.PublicKey
+}
+
+// NewProvider discovers the provider at the issuer URL.
+func NewProvider(ctx context.Context, config Config) (*Provider, error) {
+	p := &Provider{
+		config: config,
+		client: config.HTTPClient,
+	}
+	if p.client == nil {
+		p.client = http.DefaultClient
+	}
+	discovery := strings.TrimSuffix(config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, discovery, &p.metadata); err != nil {
+		return nil, fmt.Errorf("oidc discovery: %w", err)
+	}
+	if p.metadata.Issuer != config.Issuer {
+		return nil, fmt.Errorf("oidc discovery: issuer %q does not match %q", p.metadata.Issuer, config.Issuer)
+	}
+	return p, nil
+}
+
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// TrustsMFA reports whether multi-factor logins at the provider count as
+// two-factor logins, see Config.TrustMFA.
+func (p *Provider) TrustsMFA() bool {
+	return p.config.TrustMFA
+}
+
+// Flow holds the secrets of one login between the redirect to the
+// provider and the callback. Only State leaves the client unhashed.
+type Flow struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+func NewFlow() (Flow, error) {
+	var (
+		flow Flow
+		err  error
+	)
+	for _, s := range []*string{&flow.State, &flow.Nonce, &flow.Verifier} {
+		if *s, err = randomString(); err != nil {
+			return Flow{}, err
+		}
+	}
+	return flow, nil
+}
+
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Challenge is the S256 PKCE code challenge of the verifier.
+func (f Flow) Challenge() string {
+	sum := sha256.Sum256([]byte(f.Verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthURL is where the user is sent to log in.
+func (p *Provider) AuthURL(flow Flow) string {
+	q := url.Values{}
+	q.Set("response_type", "code")
+	q.Set("client_id", p.config.ClientID)
+	q.Set("redirect_uri", p.config.RedirectURL)
+	q.Set("scope", "openid email profile")
+	q.Set("state", flow.State)
+	q.Set("nonce", flow.Nonce)
+	q.Set("code_challenge", flow.Challenge())
+	q.Set("code_challenge_method", "S256")
+	sep := "?"
+	if strings.Contains(p.metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.metadata.AuthorizationEndpoint + sep + q.Encode()
+}
+
+// Claims are the verified claims of an ID token.
+type Claims struct {
+	Subject           string   `json:"sub"`
+	Email             string   `json:"email"`
+	EmailVerified     bool     `json:"email_verified"`
+	Name              string   `json:"name"`
+	GivenName         string   `json:"given_name"`
+	FamilyName        string   `json:"family_name"`
+	PreferredUsername string   `json:"preferred_username"`
+	AMR               []string `json:"amr"`
+	Nonce             string   `json:"nonce"`
+}
+
+// MultiFactor reports whether the provider says the user logged in with
+// more than one factor.
+func (c *Claims) MultiFactor() bool {
+	return slices.Contains(c.AMR, "mfa")
+}
+
+var ErrInvalidToken = errors.New("oidc: invalid id token")
+
+// Exchange trades the authorization code for tokens and returns the
+// verified claims of the ID token.
+func (p *Provider) Exchange(ctx context.Context, code string, flow Flow) (*Claims, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("client_id", p.config.ClientID)
+	form.Set("code_verifier", flow.Verifier)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := p.do(req, &token); err != nil {
+		return nil, fmt.Errorf("oidc token exchange: %w", err)
+	}
+	return p.Verify(ctx, token.IDToken, flow.Nonce)
+}
+
+// Verify checks the signature, issuer, audience, expiry and nonce of an ID
+// token and returns its claims.
+func (p *Provider) Verify(ctx context.Context, idToken string, nonce string) (*Claims, error) {
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		return p.key(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(p.config.Issuer),
+		jwt.WithAudience(p.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
+	if err != nil || !token.Valid {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	// decode the payload again to read the claims into their types
+	parts := strings.Split(idToken, ".")
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Subject == "" || claims.Nonce != nonce {
+		return nil, fmt.Errorf("%w: nonce or subject mismatch", ErrInvalidToken)
+	}
+	return &claims, nil
+}
+
+// key returns the signing key with the id, fetching the key set again when
+// it is unknown in case the provider rotated its keys.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.RLock()
+	key, ok := p.keys[kid]
+	p.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	p.keys = keys
+	p.mu.Unlock()
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	// a provider with a single key may leave kid out of its tokens
+	if kid == "" && len(keys) == 1 {
+		for _, key := range keys {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown signing key %q", kid)
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.metadata.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("oidc keys: %w", err)
+	}
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			// keys of unsupported types can't have signed our tokens
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return p.do(req, v)
+}
+
+func (p *Provider) do(req *http.Request, v any) error {
+	ctx, cancel := context.WithTimeout(req.Context(), 10*time.Second)
+	defer cancel()
+	resp, err := p.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, v)
+}
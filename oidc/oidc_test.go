@@ -0,0 +1,72 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tomekzakrzewski/go-movierental/oidc"
+	"github.com/tomekzakrzewski/go-movierental/oidc/oidctest"
+)
+
+func TestExchange(t *testing.T) {
+	iss := oidctest.NewIssuer("movies")
+	defer iss.Close()
+	ctx := context.Background()
+	provider, err := oidc.NewProvider(ctx, oidc.Config{
+		Issuer:      iss.URL,
+		ClientID:    "movies",
+		RedirectURL: "http://localhost/callback",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss.SetClaims(oidctest.Claims{Subject: "staff-1", Email: "jane@corp.com", EmailVerified: true, AMR: []string{"pwd", "mfa"}})
+
+	login := func(flow oidc.Flow) string {
+		t.Helper()
+		redirect, err := iss.Authorize(provider.AuthURL(flow))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if redirect.Query().Get("state") != flow.State {
+			t.Fatalf("expected state %s, got %s", flow.State, redirect.Query().Get("state"))
+		}
+		return redirect.Query().Get("code")
+	}
+
+	flow, err := oidc.NewFlow()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := login(flow)
+	claims, err := provider.Exchange(ctx, code, flow)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "staff-1" || claims.Email != "jane@corp.com" || !claims.EmailVerified || !claims.MultiFactor() {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+	if _, err := provider.Exchange(ctx, code, flow); err == nil {
+		t.Fatal("expected a used code to be rejected")
+	}
+
+	other, _ := oidc.NewFlow()
+	code = login(flow)
+	if _, err := provider.Exchange(ctx, code, oidc.Flow{State: flow.State, Nonce: flow.Nonce, Verifier: other.Verifier}); err == nil {
+		t.Fatal("expected a wrong code verifier to be rejected")
+	}
+
+	code = login(flow)
+	if _, err := provider.Exchange(ctx, code, oidc.Flow{State: flow.State, Nonce: other.Nonce, Verifier: flow.Verifier}); !errors.Is(err, oidc.ErrInvalidToken) {
+		t.Fatalf("expected a nonce mismatch to be rejected, got %v", err)
+	}
+}
+
+func TestNewProviderIssuerMismatch(t *testing.T) {
+	iss := oidctest.NewIssuer("movies")
+	defer iss.Close()
+	if _, err := oidc.NewProvider(context.Background(), oidc.Config{Issuer: iss.URL + "/", ClientID: "movies"}); err == nil {
+		t.Fatal("expected an issuer mismatch to fail discovery")
+	}
+}
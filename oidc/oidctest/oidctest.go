@@ -0,0 +1,209 @@
+// Package oidctest runs a local OpenID Connect issuer for tests. It
+// implements discovery, the authorization endpoint, the token endpoint with
+// PKCE and the key set, and signs ID tokens for whatever claims the test
+// sets for the next login.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "test-key"
+
+// Claims are the user claims of the next ID token.
+type Claims struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	GivenName         string
+	FamilyName        string
+	PreferredUsername string
+	AMR               []string
+}
+
+type grant struct {
+	clientID    string
+	redirectURI string
+	nonce       string
+	challenge   string
+	claims      Claims
+}
+
+type Issuer struct {
+	*httptest.Server
+	ClientID string
+
+	key *rsa.PrivateKey
+
+	mu     sync.Mutex
+	next   Claims
+	grants map[string]grant
+}
+
+// NewIssuer starts an issuer for the client id. Close it when done.
+func NewIssuer(clientID string) *Issuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	iss := &Issuer{
+		ClientID: clientID,
+		key:      key,
+		grants:   map[string]grant{},
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", iss.handleDiscovery)
+	mux.HandleFunc("/authorize", iss.handleAuthorize)
+	mux.HandleFunc("/token", iss.handleToken)
+	mux.HandleFunc("/jwks", iss.handleKeys)
+	iss.Server = httptest.NewServer(mux)
+	return iss
+}
+
+// SetClaims sets the user the issuer logs in on the next authorization.
+func (iss *Issuer) SetClaims(claims Claims) {
+	iss.mu.Lock()
+	defer iss.mu.Unlock()
+	iss.next = claims
+}
+
+// Authorize follows an authorization URL like a browser would and returns
+// the redirect back to the client, carrying the code and state.
+func (iss *Issuer) Authorize(authURL string) (*url.URL, error) {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp.Location()
+}
+
+func (iss *Issuer) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                iss.URL,
+		"authorization_endpoint":                iss.URL + "/authorize",
+		"token_endpoint":                        iss.URL + "/token",
+		"jwks_uri":                              iss.URL + "/jwks",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (iss *Issuer) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil || q.Get("client_id") != iss.ClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+	code := randomString()
+	iss.mu.Lock()
+	iss.grants[code] = grant{
+		clientID:    q.Get("client_id"),
+		redirectURI: q.Get("redirect_uri"),
+		nonce:       q.Get("nonce"),
+		challenge:   q.Get("code_challenge"),
+		claims:      iss.next,
+	}
+	iss.mu.Unlock()
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", q.Get("state"))
+	redirect.RawQuery = params.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (iss *Issuer) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	code := r.PostForm.Get("code")
+	iss.mu.Lock()
+	g, ok := iss.grants[code]
+	// codes are single use, even when the exchange fails
+	delete(iss.grants, code)
+	iss.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || g.clientID != r.PostForm.Get("client_id") || g.redirectURI != r.PostForm.Get("redirect_uri") ||
+		g.challenge != base64.RawURLEncoding.EncodeToString(sum[:]) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":                iss.URL,
+		"aud":                g.clientID,
+		"sub":                g.claims.Subject,
+		"iat":                now.Unix(),
+		"exp":                now.Add(5 * time.Minute).Unix(),
+		"nonce":              g.nonce,
+		"email":              g.claims.Email,
+		"email_verified":     g.claims.EmailVerified,
+		"given_name":         g.claims.GivenName,
+		"family_name":        g.claims.FamilyName,
+		"preferred_username": g.claims.PreferredUsername,
+	}
+	if len(g.claims.AMR) > 0 {
+		claims["amr"] = g.claims.AMR
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(iss.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (iss *Issuer) handleKeys(w http.ResponseWriter, r *http.Request) {
+	pub := iss.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
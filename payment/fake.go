@@ -0,0 +1,174 @@
+package payment
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+// Payment methods the fake provider treats specially, every other method is
+// authorized and captured right away.
+const (
+	// FakeDeclined is refused on authorization.
+	FakeDeclined = "fake_declined"
+	// FakeCaptureDeclined is authorized but refused on capture.
+	FakeCaptureDeclined = "fake_capture_declined"
+	// FakeAsync is captured pending, until Settle sends its webhook.
+	FakeAsync = "fake_async"
+	// FakeCaptureUnavailable is authorized but its capture fails with
+	// ErrFakeUnavailable, like a provider that times out.
+	FakeCaptureUnavailable = "fake_capture_unavailable"
+)
+
+// ErrFakeUnavailable is the error of captures of FakeCaptureUnavailable.
+var ErrFakeUnavailable = errors.New("fake provider unavailable")
+
+// FakeSignatureHeader carries the hex HMAC-SHA256 of a fake webhook body.
+const FakeSignatureHeader = "Fake-Signature"
+
+type fakePayment struct {
+	charge Charge
+	status types.PaymentStatus
+}
+
+// Fake is a deterministic in-process provider. Payment ids count up from
+// fake_000001 in the order of authorization and the outcome only depends on
+// the payment method.
+type Fake struct {
+	mu       sync.Mutex
+	secret   []byte
+	payments map[string]*fakePayment
+	next     int
+}
+
+func NewFake(secret string) *Fake {
+	return &Fake{
+		secret:   []byte(secret),
+		payments: map[string]*fakePayment{},
+	}
+}
+
+func (f *Fake) Name() string {
+	return "fake"
+}
+
+func (f *Fake) Authorize(ctx context.Context, charge Charge) (string, error) {
+	if charge.Method == FakeDeclined {
+		return "", ErrDeclined
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.next++
+	id := fmt.Sprintf("fake_%06d", f.next)
+	f.payments[id] = &fakePayment{charge: charge, status: types.PaymentAuthorized}
+	return id, nil
+}
+
+func (f *Fake) Capture(ctx context.Context, id string) (types.PaymentStatus, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[id]
+	if !ok || p.status != types.PaymentAuthorized {
+		return "", ErrUnknownPayment
+	}
+	switch p.charge.Method {
+	case FakeCaptureDeclined:
+		p.status = types.PaymentFailed
+		return "", ErrDeclined
+	case FakeAsync:
+		p.status = types.PaymentPending
+	case FakeCaptureUnavailable:
+		return "", ErrFakeUnavailable
+	default:
+		p.status = types.PaymentCaptured
+	}
+	return p.status, nil
+}
+
+func (f *Fake) Void(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[id]
+	if !ok || p.status != types.PaymentAuthorized {
+		return ErrUnknownPayment
+	}
+	p.status = types.PaymentFailed
+	return nil
+}
+
+func (f *Fake) Refund(ctx context.Context, id string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	p, ok := f.payments[id]
+	if !ok || p.status != types.PaymentCaptured {
+		return ErrUnknownPayment
+	}
+	p.status = types.PaymentRefunded
+	return nil
+}
+
+// Status returns the status of a payment as the fake provider sees it.
+func (f *Fake) Status(id string) types.PaymentStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if p, ok := f.payments[id]; ok {
+		return p.status
+	}
+	return ""
+}
+
+// Settle completes a pending capture, successfully when captured is true,
+// and returns the signed webhook the provider sends about it.
+func (f *Fake) Settle(id string, captured bool) (http.Header, []byte, error) {
+	f.mu.Lock()
+	p, ok := f.payments[id]
+	if !ok || p.status != types.PaymentPending {
+		f.mu.Unlock()
+		return nil, nil, ErrUnknownPayment
+	}
+	event := Event{Type: EventCaptured, PaymentID: id}
+	p.status = types.PaymentCaptured
+	if !captured {
+		event.Type = EventFailed
+		p.status = types.PaymentFailed
+	}
+	f.mu.Unlock()
+	return f.Webhook(event)
+}
+
+// Webhook returns the body and headers of a signed webhook of the event.
+func (f *Fake) Webhook(event Event) (http.Header, []byte, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return nil, nil, err
+	}
+	header := http.Header{}
+	header.Set(FakeSignatureHeader, hex.EncodeToString(f.mac(body)))
+	return header, body, nil
+}
+
+func (f *Fake) VerifyWebhook(header http.Header, body []byte) (Event, error) {
+	signature, err := hex.DecodeString(header.Get(FakeSignatureHeader))
+	if err != nil || !hmac.Equal(signature, f.mac(body)) {
+		return Event{}, ErrInvalidSignature
+	}
+	var event Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		return Event{}, err
+	}
+	return event, nil
+}
+
+func (f *Fake) mac(body []byte) []byte {
+	mac := hmac.New(sha256.New, f.secret)
+	mac.Write(body)
+	return mac.Sum(nil)
+}
@@ -0,0 +1,90 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+func TestFake(t *testing.T) {
+	ctx := context.Background()
+	f := NewFake("secret")
+	charge := Charge{Amount: 399, Currency: "USD", Reference: "rent"}
+
+	id, err := f.Authorize(ctx, charge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "fake_000001" {
+		t.Fatalf("expected the first payment to be fake_000001 but got %s", id)
+	}
+	if err := f.Refund(ctx, id); !errors.Is(err, ErrUnknownPayment) {
+		t.Fatalf("expected an uncaptured payment not to refund but got %v", err)
+	}
+	status, err := f.Capture(ctx, id)
+	if err != nil || status != types.PaymentCaptured {
+		t.Fatalf("expected a captured payment but got %s, %v", status, err)
+	}
+	if err := f.Refund(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if f.Status(id) != types.PaymentRefunded {
+		t.Fatalf("expected a refunded payment but got %s", f.Status(id))
+	}
+
+	charge.Method = FakeDeclined
+	if _, err := f.Authorize(ctx, charge); !errors.Is(err, ErrDeclined) {
+		t.Fatalf("expected ErrDeclined but got %v", err)
+	}
+	charge.Method = FakeCaptureDeclined
+	id, err = f.Authorize(ctx, charge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Capture(ctx, id); !errors.Is(err, ErrDeclined) {
+		t.Fatalf("expected the capture to be declined but got %v", err)
+	}
+
+	charge.Method = FakeCaptureUnavailable
+	id, err = f.Authorize(ctx, charge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Capture(ctx, id); !errors.Is(err, ErrFakeUnavailable) {
+		t.Fatalf("expected the capture to fail but got %v", err)
+	}
+	if err := f.Void(ctx, id); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Void(ctx, id); !errors.Is(err, ErrUnknownPayment) {
+		t.Fatalf("expected a voided payment not to void again but got %v", err)
+	}
+
+	charge.Method = FakeAsync
+	id, err = f.Authorize(ctx, charge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, err := f.Capture(ctx, id); err != nil || status != types.PaymentPending {
+		t.Fatalf("expected a pending capture but got %s, %v", status, err)
+	}
+	header, body, err := f.Settle(id, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, err := f.VerifyWebhook(header, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Type != EventCaptured || event.PaymentID != id {
+		t.Fatalf("unexpected event %+v", event)
+	}
+	if _, err := NewFake("other").VerifyWebhook(header, body); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected ErrInvalidSignature but got %v", err)
+	}
+	if _, err := f.VerifyWebhook(header, append(body, ' ')); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("expected a changed body to fail verification but got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+// Package payment abstracts the providers rents are paid through. A
+// provider authorizes an amount on the renter's payment method, captures
+// it once the rent is made and can refund it later. Providers that settle
+// captures asynchronously confirm them through signed webhooks. Fake
+// implements every step in process for development and tests, a real
+// provider only needs an adapter implementing Provider.
+package payment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+)
+
+var (
+	// ErrDeclined is returned when the provider refuses to authorize or
+	// capture a payment.
+	ErrDeclined = errors.New("payment declined")
+	// ErrInvalidSignature is returned by VerifyWebhook for webhooks the
+	// provider didn't sign.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrUnknownPayment is returned for payments the provider has no
+	// record of or that are in the wrong status for the operation.
+	ErrUnknownPayment = errors.New("unknown payment")
+	// ErrNoProvider is returned by FromEnv when PAYMENT_PROVIDER is not set.
+	ErrNoProvider = errors.New("PAYMENT_PROVIDER is not set")
+	// ErrNoWebhookSecret is returned by FromEnv when PAYMENT_WEBHOOK_SECRET
+	// is not set.
+	ErrNoWebhookSecret = errors.New("PAYMENT_WEBHOOK_SECRET is not set")
+)
+
+// Charge is an amount to authorize, in minor units of Currency. Method is
+// the provider's token of the renter's payment method and Reference is
+// passed on to the provider to find the payment by, the rent id.
+type Charge struct {
+	Amount    int64
+	Currency  string
+	Method    string
+	Reference string
+}
+
+type EventType string
+
+const (
+	EventCaptured EventType = "payment.captured"
+	EventFailed   EventType = "payment.failed"
+	EventRefunded EventType = "payment.refunded"
+)
+
+// Event is a verified webhook about the payment with PaymentID, the id the
+// provider returned from Authorize.
+type Event struct {
+	Type      EventType `json:"type"`
+	PaymentID string    `json:"paymentID"`
+}
+
+type Provider interface {
+	// Name identifies the provider on the payments made through it.
+	Name() string
+	// Authorize reserves the charge on the payment method and returns the
+	// provider's id of the payment.
+	Authorize(context.Context, Charge) (string, error)
+	// Capture collects an authorized payment. It returns
+	// types.PaymentCaptured, or types.PaymentPending when the provider
+	// confirms the capture later with an EventCaptured or EventFailed
+	// webhook.
+	Capture(context.Context, string) (types.PaymentStatus, error)
+	// Void releases an authorized payment that won't be captured.
+	Void(context.Context, string) error
+	// Refund returns a captured payment in full.
+	Refund(context.Context, string) error
+	// VerifyWebhook checks the signature of a webhook request and decodes
+	// its event.
+	VerifyWebhook(http.Header, []byte) (Event, error)
+}
+
+// FromEnv builds the provider selected by PAYMENT_PROVIDER, signing its
+// webhooks with PAYMENT_WEBHOOK_SECRET. Both have to be set: only "fake"
+// exists so far and it approves every payment method, so it's never picked
+// by default, and webhooks signed with an empty secret could be forged by
+// anyone.
+func FromEnv() (Provider, error) {
+	provider := os.Getenv("PAYMENT_PROVIDER")
+	if provider == "" {
+		return nil, ErrNoProvider
+	}
+	secret := os.Getenv("PAYMENT_WEBHOOK_SECRET")
+	if secret == "" {
+		return nil, ErrNoWebhookSecret
+	}
+	switch provider {
+	case "fake":
+		return NewFake(secret), nil
+	default:
+		return nil, fmt.Errorf("unknown PAYMENT_PROVIDER %q", provider)
+	}
+}
@@ -0,0 +1,34 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFromEnv(t *testing.T) {
+	for _, tt := range []struct {
+		provider, secret string
+		err              error
+	}{
+		{"", "secret", ErrNoProvider},
+		{"fake", "", ErrNoWebhookSecret},
+		{"", "", ErrNoProvider},
+	} {
+		t.Setenv("PAYMENT_PROVIDER", tt.provider)
+		t.Setenv("PAYMENT_WEBHOOK_SECRET", tt.secret)
+		if _, err := FromEnv(); !errors.Is(err, tt.err) {
+			t.Fatalf("PAYMENT_PROVIDER=%q PAYMENT_WEBHOOK_SECRET=%q: expected %v but got %v", tt.provider, tt.secret, tt.err, err)
+		}
+	}
+
+	t.Setenv("PAYMENT_WEBHOOK_SECRET", "secret")
+	t.Setenv("PAYMENT_PROVIDER", "other")
+	if _, err := FromEnv(); err == nil {
+		t.Fatal("expected an unknown provider to fail")
+	}
+	t.Setenv("PAYMENT_PROVIDER", "fake")
+	provider, err := FromEnv()
+	if err != nil || provider.Name() != "fake" {
+		t.Fatalf("expected the fake provider when named but got %v, %v", provider, err)
+	}
+}
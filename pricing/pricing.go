@@ -0,0 +1,127 @@
+// Package pricing computes the price of a rent from the price rules. A rent
+// starts at its base price, which at most one new release premium, one
+// duration multiplier and one weekend rate then scale in that order.
+package pricing
+
+import (
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Request describes the rent to price.
+type Request struct {
+	Movie  *types.Movie
+	Format types.CopyFormat
+	Hours  int
+	// Start is when the rent starts, its weekday decides the weekend rate.
+	Start time.Time
+}
+
+// Adjustment is a rule that scaled the price, Amount is the price after it.
+type Adjustment struct {
+	RuleID  primitive.ObjectID  `json:"ruleID"`
+	Kind    types.PriceRuleKind `json:"kind"`
+	Percent int                 `json:"percent"`
+	Amount  int64               `json:"amount"`
+}
+
+// Quote is the price of a rent in the minor units of Currency.
+type Quote struct {
+	MovieID     primitive.ObjectID `json:"movieID"`
+	Format      types.CopyFormat   `json:"format,omitempty"`
+	Hours       int                `json:"hours"`
+	Base        int64              `json:"base"`
+	BaseRuleID  primitive.ObjectID `json:"baseRuleID,omitempty"`
+	Adjustments []Adjustment       `json:"adjustments"`
+	Amount      int64              `json:"amount"`
+	Currency    string             `json:"currency"`
+}
+
+// Price prices the rent. The most specific base price applies: one for the
+// movie and format, then the movie, then the format, then the catch-all.
+// Without any the rent is free. Of the other kinds, rules for the format
+// win over the catch-all ones, and among new release premiums the one with
+// the smallest MaxAge. Ties go to the rule listed first.
+func Price(rules []*types.PriceRule, req Request, currency string) Quote {
+	q := Quote{
+		MovieID:     req.Movie.ID,
+		Format:      req.Format,
+		Hours:       req.Hours,
+		Adjustments: []Adjustment{},
+		Currency:    currency,
+	}
+	if base := best(rules, req, types.PriceBase); base != nil {
+		q.Base = base.Amount
+		q.BaseRuleID = base.ID
+	}
+	q.Amount = q.Base
+	for _, kind := range []types.PriceRuleKind{types.PriceNewRelease, types.PriceDuration, types.PriceWeekend} {
+		rule := best(rules, req, kind)
+		if rule == nil {
+			continue
+		}
+		q.Amount = scale(q.Amount, rule.Percent)
+		q.Adjustments = append(q.Adjustments, Adjustment{
+			RuleID:  rule.ID,
+			Kind:    rule.Kind,
+			Percent: rule.Percent,
+			Amount:  q.Amount,
+		})
+	}
+	return q
+}
+
+// best returns the most specific rule of the kind that applies to the rent.
+func best(rules []*types.PriceRule, req Request, kind types.PriceRuleKind) *types.PriceRule {
+	var (
+		found *types.PriceRule
+		score int
+	)
+	for _, rule := range rules {
+		if rule.Kind != kind || !applies(rule, req) {
+			continue
+		}
+		s := specificity(rule)
+		if found == nil || s > score || s == score && kind == types.PriceNewRelease && rule.MaxAge < found.MaxAge {
+			found, score = rule, s
+		}
+	}
+	return found
+}
+
+func applies(rule *types.PriceRule, req Request) bool {
+	if rule.Format != "" && rule.Format != req.Format {
+		return false
+	}
+	if !rule.MovieID.IsZero() && rule.MovieID != req.Movie.ID {
+		return false
+	}
+	switch rule.Kind {
+	case types.PriceNewRelease:
+		return req.Start.Year()-req.Movie.Year <= rule.MaxAge
+	case types.PriceDuration:
+		return rule.Hours == req.Hours
+	case types.PriceWeekend:
+		day := req.Start.Weekday()
+		return day == time.Saturday || day == time.Sunday
+	}
+	return true
+}
+
+func specificity(rule *types.PriceRule) int {
+	s := 0
+	if !rule.MovieID.IsZero() {
+		s += 2
+	}
+	if rule.Format != "" {
+		s++
+	}
+	return s
+}
+
+// scale multiplies amount by percent, rounding half up to a minor unit.
+func scale(amount int64, percent int) int64 {
+	return (amount*int64(percent) + 50) / 100
+}
@@ -0,0 +1,100 @@
+package pricing
+
+import (
+	"testing"
+	"time"
+
+	"github.com/tomekzakrzewski/go-movierental/types"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestPrice(t *testing.T) {
+	var (
+		matrix  = &types.Movie{ID: primitive.NewObjectID(), Title: "The Matrix", Year: 1999}
+		dune    = &types.Movie{ID: primitive.NewObjectID(), Title: "Dune: Part Two", Year: 2024}
+		monday  = time.Date(2024, time.June, 3, 18, 0, 0, 0, time.UTC)
+		sunday  = time.Date(2024, time.June, 9, 18, 0, 0, 0, time.UTC)
+		ruleFor = func(rule types.PriceRule) *types.PriceRule {
+			rule.ID = primitive.NewObjectID()
+			return &rule
+		}
+		rules = []*types.PriceRule{
+			ruleFor(types.PriceRule{Kind: types.PriceBase, Amount: 399}),
+			ruleFor(types.PriceRule{Kind: types.PriceBase, Format: types.Format4K, Amount: 599}),
+			ruleFor(types.PriceRule{Kind: types.PriceBase, MovieID: matrix.ID, Amount: 199}),
+			ruleFor(types.PriceRule{Kind: types.PriceNewRelease, MaxAge: 2, Percent: 125}),
+			ruleFor(types.PriceRule{Kind: types.PriceNewRelease, MaxAge: 0, Percent: 150}),
+			ruleFor(types.PriceRule{Kind: types.PriceDuration, Hours: 48, Percent: 180}),
+			ruleFor(types.PriceRule{Kind: types.PriceWeekend, Percent: 110}),
+			ruleFor(types.PriceRule{Kind: types.PriceWeekend, Format: types.FormatDigital, Percent: 100}),
+		}
+	)
+	tests := []struct {
+		name  string
+		req   Request
+		base  int64
+		kinds []types.PriceRuleKind
+		want  int64
+	}{
+		{
+			name: "catch-all base",
+			req:  Request{Movie: dune, Format: types.FormatDVD, Hours: 24, Start: time.Date(2027, time.June, 7, 0, 0, 0, 0, time.UTC)},
+			base: 399,
+			want: 399,
+		},
+		{
+			name: "format base",
+			req:  Request{Movie: dune, Format: types.Format4K, Hours: 24, Start: time.Date(2027, time.June, 7, 0, 0, 0, 0, time.UTC)},
+			base: 599,
+			want: 599,
+		},
+		{
+			name: "movie base beats format base",
+			req:  Request{Movie: matrix, Format: types.Format4K, Hours: 24, Start: monday},
+			base: 199,
+			want: 199,
+		},
+		{
+			name:  "newest release premium",
+			req:   Request{Movie: dune, Format: types.FormatDVD, Hours: 24, Start: monday},
+			base:  399,
+			kinds: []types.PriceRuleKind{types.PriceNewRelease},
+			want:  599,
+		},
+		{
+			name:  "every adjustment",
+			req:   Request{Movie: dune, Format: types.FormatDVD, Hours: 48, Start: sunday},
+			base:  399,
+			kinds: []types.PriceRuleKind{types.PriceNewRelease, types.PriceDuration, types.PriceWeekend},
+			// 399 * 1.5 = 598.5 -> 599, * 1.8 = 1078.2 -> 1078, * 1.1 = 1185.8 -> 1186
+			want: 1186,
+		},
+		{
+			name:  "format weekend rate",
+			req:   Request{Movie: matrix, Format: types.FormatDigital, Hours: 24, Start: sunday},
+			base:  199,
+			kinds: []types.PriceRuleKind{types.PriceWeekend},
+			want:  199,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := Price(rules, tt.req, "USD")
+			if q.Base != tt.base || q.Amount != tt.want || q.Currency != "USD" {
+				t.Fatalf("expected %d from a base of %d but got %+v", tt.want, tt.base, q)
+			}
+			if len(q.Adjustments) != len(tt.kinds) {
+				t.Fatalf("expected adjustments %v but got %+v", tt.kinds, q.Adjustments)
+			}
+			for i, kind := range tt.kinds {
+				if q.Adjustments[i].Kind != kind {
+					t.Fatalf("expected adjustments %v but got %+v", tt.kinds, q.Adjustments)
+				}
+			}
+		})
+	}
+
+	if q := Price(nil, Request{Movie: matrix, Hours: 24, Start: sunday}, "USD"); q.Amount != 0 {
+		t.Fatalf("expected rents to be free without a price list but got %d", q.Amount)
+	}
+}
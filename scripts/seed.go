@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -10,6 +11,8 @@ import (
 	"github.com/tomekzakrzewski/go-movierental/api"
 	"github.com/tomekzakrzewski/go-movierental/db"
 	"github.com/tomekzakrzewski/go-movierental/db/fixtures"
+	"github.com/tomekzakrzewski/go-movierental/keys"
+	"github.com/tomekzakrzewski/go-movierental/types"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -31,24 +34,44 @@ func main() {
 	if err := client.Database(mongoDBName).Drop(ctx); err != nil {
 		log.Fatal(err)
 	}
-	store := &db.Store{
-		User:  db.NewUserStore(client),
-		Movie: db.NewMovieStore(client),
-		Rent:  db.NewRentStore(client),
+	if err := db.EnsureMongoIndexes(ctx, client); err != nil {
+		log.Fatal(err)
+	}
+	store := db.NewMongoStore(client)
+	// without the signing key of the API only the refresh tokens work
+	if tokenKeys, err := keys.FromEnv(); err == nil {
+		api.SetTokenKeys(tokenKeys)
+	} else if errors.Is(err, keys.ErrNoSigningKey) {
+		fmt.Println("JWT_SIGNING_KEY is not set, use the refresh tokens to get access tokens")
+	} else {
+		log.Fatal(err)
 	}
 
-	user := fixtures.AddUser(store, "tomek", "zak", false)
-	fmt.Println("tomek ->", api.CreateTokenFromUser(user))
-	user = fixtures.AddUser(store, "zuzia", "poz", false)
-	fmt.Println("zuzia ->", api.CreateTokenFromUser(user))
-	user = fixtures.AddUser(store, "admin", "admin", true)
-	fmt.Println("admin ->", api.CreateTokenFromUser(user))
-
-	fixtures.AddMovie(store, "The Matrix", []string{"Action", "Sci-Fi"}, 120, 1999)
-	fixtures.AddMovie(store, "Titanic", []string{"Drama", "Romance"}, 194, 1999)
-	fixtures.AddMovie(store, "Star Wars: The Force Awakens", []string{"Action", "Sci-Fi"}, 136, 2015)
-	fixtures.AddMovie(store, "The Godfather", []string{"Drama"}, 175, 1972)
-	fixtures.AddMovie(store, "The Shawshank Redemption", []string{"Drama"}, 142, 1994)
-	fixtures.AddMovie(store, "Schindler's List", []string{"Biography", "Drama", "History"}, 195, 1993)
+	for _, user := range []*types.User{
+		fixtures.AddUser(store, "tomek", "zak", false),
+		fixtures.AddUser(store, "zuzia", "poz", false),
+		fixtures.AddUser(store, "admin", "admin", true),
+	} {
+		// seeded sessions skip the second factor so the admin token works
+		// on the admin routes during development
+		token, refreshToken, err := api.IssueTokens(ctx, store.Session, user, true)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(user.FirstName, "->", token, "refresh:", refreshToken)
+	}
 
+	movies := []*types.Movie{
+		fixtures.AddMovie(store, "The Matrix", []string{"Action", "Sci-Fi"}, 120, 1999),
+		fixtures.AddMovie(store, "Titanic", []string{"Drama", "Romance"}, 194, 1999),
+		fixtures.AddMovie(store, "Star Wars: The Force Awakens", []string{"Action", "Sci-Fi"}, 136, 2015),
+		fixtures.AddMovie(store, "The Godfather", []string{"Drama"}, 175, 1972),
+		fixtures.AddMovie(store, "The Shawshank Redemption", []string{"Drama"}, 142, 1994),
+		fixtures.AddMovie(store, "Schindler's List", []string{"Biography", "Drama", "History"}, 195, 1993),
+	}
+	for _, movie := range movies {
+		fixtures.AddCopy(store, movie, types.FormatDVD)
+		fixtures.AddCopy(store, movie, types.FormatDVD)
+		fixtures.AddCopy(store, movie, types.FormatBluRay)
+	}
 }
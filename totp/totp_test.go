@@ -0,0 +1,61 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 secret of the RFC 6238 test vectors.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// the RFC lists eight digit codes, six digit codes are their suffix
+	for unix, want := range map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1234567890: "005924",
+		2000000000: "279037",
+	} {
+		got, err := Code(rfcSecret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("%d: expected code %s but got %s", unix, want, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := Step(now)
+	for _, s := range []int64{step - 1, step, step + 1} {
+		code, _ := Code(rfcSecret, s)
+		got, ok := Validate(rfcSecret, code, now)
+		if !ok || got != s {
+			t.Fatalf("expected the code of step %d to match it but got %d, %v", s, got, ok)
+		}
+	}
+	old, _ := Code(rfcSecret, step-2)
+	if _, ok := Validate(rfcSecret, old, now); ok {
+		t.Fatal("expected a code two steps old to be refused")
+	}
+	if _, ok := Validate(rfcSecret, "08 1804", now); !ok {
+		t.Fatal("expected spaces in the code to be ignored")
+	}
+	if _, ok := Validate("not base32!", "081804", now); ok {
+		t.Fatal("expected an invalid secret to refuse every code")
+	}
+}
+
+func TestURI(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := URI("Movie Rental", "tomek@test.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/Movie%20Rental:tomek@test.com?") || !strings.Contains(uri, "secret="+secret) {
+		t.Fatalf("unexpected uri %s", uri)
+	}
+}
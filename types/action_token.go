@@ -0,0 +1,39 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TokenPurpose is the single action an ActionToken authorises.
+type TokenPurpose string
+
+const (
+	TokenPasswordReset     TokenPurpose = "password_reset"
+	TokenEmailVerification TokenPurpose = "email_verification"
+	TokenTwoFactor         TokenPurpose = "two_factor"
+)
+
+// ActionToken is a single use token mailed to a user. Only the hash of the
+// secret is stored.
+type ActionToken struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userID" json:"userID"`
+	Purpose   TokenPurpose       `bson:"purpose" json:"purpose"`
+	Hash      string             `bson:"hash" json:"-"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt time.Time          `bson:"expiresAt" json:"expiresAt"`
+	UsedAt    *time.Time         `bson:"usedAt" json:"usedAt,omitempty"`
+}
+
+func NewActionToken(userID primitive.ObjectID, purpose TokenPurpose, hash string, ttl time.Duration) *ActionToken {
+	now := time.Now()
+	return &ActionToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		Hash:      hash,
+		CreatedAt: now,
+		ExpiresAt: now.Add(ttl),
+	}
+}
@@ -0,0 +1,70 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// APIKey lets a program act as a user without logging in. Only the hash of
+// its secret is stored, the key itself is shown once when it is created.
+// The key can only use its scopes, and only while the user holds them.
+type APIKey struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Name       string             `bson:"name" json:"name"`
+	UserID     primitive.ObjectID `bson:"userID" json:"userID"`
+	Scopes     []Permission       `bson:"scopes" json:"scopes"`
+	Hash       string             `bson:"hash" json:"-"`
+	CreatedBy  primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt  *time.Time         `bson:"expiresAt" json:"expiresAt,omitempty"`
+	LastUsedAt *time.Time         `bson:"lastUsedAt" json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time         `bson:"revokedAt" json:"revokedAt,omitempty"`
+}
+
+// IsActive reports whether the key is neither revoked nor expired. Keys
+// without an expiry never expire.
+func (k *APIKey) IsActive(now time.Time) bool {
+	return k.RevokedAt == nil && (k.ExpiresAt == nil || now.Before(*k.ExpiresAt))
+}
+
+// CreateAPIKeyParams creates a key of the user with UserID, the admin
+// creating it when empty.
+type CreateAPIKeyParams struct {
+	Name      string       `json:"name"`
+	UserID    string       `json:"userID"`
+	Scopes    []Permission `json:"scopes"`
+	ExpiresAt *time.Time   `json:"expiresAt"`
+}
+
+func (p CreateAPIKeyParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if p.Name == "" {
+		errors["name"] = "name is required"
+	}
+	if len(p.Scopes) == 0 {
+		errors["scopes"] = "at least one scope is required"
+	}
+	for _, scope := range p.Scopes {
+		if !scope.IsValid() {
+			errors["scopes"] = fmt.Sprintf("invalid scope: %s", scope)
+		}
+	}
+	if p.ExpiresAt != nil && !p.ExpiresAt.After(time.Now()) {
+		errors["expiresAt"] = "expiry must be in the future"
+	}
+	return errors
+}
+
+func NewAPIKeyFromParams(params CreateAPIKeyParams, userID, createdBy primitive.ObjectID, hash string) *APIKey {
+	return &APIKey{
+		Name:      params.Name,
+		UserID:    userID,
+		Scopes:    params.Scopes,
+		Hash:      hash,
+		CreatedBy: createdBy,
+		CreatedAt: time.Now(),
+		ExpiresAt: params.ExpiresAt,
+	}
+}
@@ -0,0 +1,43 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// AuditAction names a security relevant event recorded in the audit log.
+type AuditAction string
+
+const (
+	AuditLoginLocked   AuditAction = "login.locked"
+	AuditLoginUnlocked AuditAction = "login.unlocked"
+	AuditTOTPReset     AuditAction = "totp.reset"
+	AuditIdentityLink  AuditAction = "identity.linked"
+	AuditAPIKeyCreate  AuditAction = "apikey.created"
+	AuditAPIKeyRevoke  AuditAction = "apikey.revoked"
+	AuditWalletAdjust  AuditAction = "wallet.adjusted"
+	AuditLateFeeSettle AuditAction = "latefee.settled"
+)
+
+// AuditEntry records an event for administrators. ActorID is the user who
+// caused it and is zero for events raised by the system itself. Subject is
+// what the event applies to, such as a login throttling key.
+type AuditEntry struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Action    AuditAction        `bson:"action" json:"action"`
+	ActorID   primitive.ObjectID `bson:"actorID,omitempty" json:"actorID,omitempty"`
+	Subject   string             `bson:"subject" json:"subject"`
+	Detail    string             `bson:"detail" json:"detail,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+func NewAuditEntry(action AuditAction, actorID primitive.ObjectID, subject, detail string) *AuditEntry {
+	return &AuditEntry{
+		Action:    action,
+		ActorID:   actorID,
+		Subject:   subject,
+		Detail:    detail,
+		CreatedAt: time.Now(),
+	}
+}
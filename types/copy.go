@@ -0,0 +1,63 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type CopyFormat string
+
+const (
+	FormatDVD     CopyFormat = "dvd"
+	FormatBluRay  CopyFormat = "bluray"
+	Format4K      CopyFormat = "4k"
+	FormatDigital CopyFormat = "digital"
+)
+
+func (f CopyFormat) IsValid() bool {
+	switch f {
+	case FormatDVD, FormatBluRay, Format4K, FormatDigital:
+		return true
+	}
+	return false
+}
+
+// Copy is a single rentable unit of a movie. A copy is held by at most one
+// open rent at a time and retired copies can't be rented anymore.
+type Copy struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	MovieID   primitive.ObjectID `bson:"movieID" json:"movieID"`
+	Format    CopyFormat         `bson:"format" json:"format"`
+	Rented    bool               `bson:"rented" json:"rented"`
+	Retired   bool               `bson:"retired" json:"retired"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	RetiredAt *time.Time         `bson:"retiredAt,omitempty" json:"retiredAt,omitempty"`
+}
+
+type CreateCopyParams struct {
+	Format CopyFormat `json:"format"`
+	Count  int        `json:"count"`
+}
+
+const maxCopiesPerRequest = 100
+
+func (p CreateCopyParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if !p.Format.IsValid() {
+		errors["format"] = fmt.Sprintf("invalid format %q, expected one of dvd, bluray, 4k, digital", p.Format)
+	}
+	if p.Count < 0 || p.Count > maxCopiesPerRequest {
+		errors["count"] = fmt.Sprintf("count should be between 1 and %d", maxCopiesPerRequest)
+	}
+	return errors
+}
+
+func NewCopyFromParams(movieID primitive.ObjectID, params CreateCopyParams) *Copy {
+	return &Copy{
+		MovieID:   movieID,
+		Format:    params.Format,
+		CreatedAt: time.Now(),
+	}
+}
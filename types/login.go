@@ -0,0 +1,28 @@
+package types
+
+import "time"
+
+// LoginAttempts counts the recent failed logins of a throttling key, either
+// an account or a client IP. Failures start again from zero once a key is
+// locked.
+type LoginAttempts struct {
+	Key         string    `bson:"_id" json:"key"`
+	Failures    int       `bson:"failures" json:"failures"`
+	LastFailure time.Time `bson:"lastFailure" json:"lastFailure"`
+	LockedUntil time.Time `bson:"lockedUntil" json:"lockedUntil"`
+}
+
+func (a *LoginAttempts) IsLocked(now time.Time) bool {
+	return now.Before(a.LockedUntil)
+}
+
+// AccountLoginKey is the throttling key of the account with the email,
+// whether or not such an account exists.
+func AccountLoginKey(email string) string {
+	return "account:" + NormalizeEmail(email)
+}
+
+// IPLoginKey is the throttling key of a client IP.
+func IPLoginKey(ip string) string {
+	return "ip:" + ip
+}
@@ -18,28 +18,37 @@ const (
 	minYear      = 1888
 )
 
+// Movie.Rating is the average user rating rounded to the nearest integer,
+// RatingAvg and RatingCount hold the exact aggregate.
 type Movie struct {
-	ID     primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	Title  string             `bson:"title" json:"title"`
-	Genre  []string           `bson:"genre" json:"genre"`
-	Length int                `bson:"length" json:"length"`
-	Year   int                `bson:"year" json:"year"`
-	Rating int                `bson:"rating" json:"rating"`
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Title       string             `bson:"title" json:"title"`
+	Genre       []string           `bson:"genre" json:"genre"`
+	Length      int                `bson:"length" json:"length"`
+	Year        int                `bson:"year" json:"year"`
+	Rating      int                `bson:"rating" json:"rating"`
+	RatingAvg   float64            `bson:"ratingAvg" json:"ratingAvg"`
+	RatingCount int                `bson:"ratingCount" json:"ratingCount"`
+	// RentalHours are the rental durations renters pick from, overriding
+	// the durations of the copy formats when set.
+	RentalHours []int `bson:"rentalHours,omitempty" json:"rentalHours,omitempty"`
 }
 
 type CreateMovieParams struct {
-	Title  string   `json:"title"`
-	Genre  []string `json:"genre"`
-	Length int      `json:"length"`
-	Year   int      `json:"year"`
+	Title       string   `json:"title"`
+	Genre       []string `json:"genre"`
+	Length      int      `json:"length"`
+	Year        int      `json:"year"`
+	RentalHours []int    `json:"rentalHours"`
 }
 
 func NewMovieFromParams(params CreateMovieParams) *Movie {
 	return &Movie{
-		Title:  params.Title,
-		Genre:  params.Genre,
-		Length: params.Length,
-		Year:   params.Year,
+		Title:       params.Title,
+		Genre:       params.Genre,
+		Length:      params.Length,
+		Year:        params.Year,
+		RentalHours: params.RentalHours,
 	}
 }
 
@@ -48,11 +57,11 @@ type UpdateMovieRating struct {
 }
 
 type UpdateMovieParams struct {
-	Title  string   `json:"title"`
-	Genre  []string `json:"genre"`
-	Length int      `json:"length"`
-	Year   int      `json:"year"`
-	Rating int      `json:"rating"`
+	Title       string   `json:"title"`
+	Genre       []string `json:"genre"`
+	Length      int      `json:"length"`
+	Year        int      `json:"year"`
+	RentalHours []int    `json:"rentalHours"`
 }
 
 func Validate(params CreateMovieParams) map[string]string {
@@ -69,9 +78,23 @@ func Validate(params CreateMovieParams) map[string]string {
 	if len(params.Genre) < minGenreLen {
 		errors["genre"] = fmt.Sprintf("movie should have at least %d genre", minGenreLen)
 	}
+	if !ValidRentalHours(params.RentalHours) {
+		errors["rentalHours"] = fmt.Sprintf("rental hours should be between 1 and %d", MaxRentalHours)
+	}
 	return errors
 }
 
+// ValidRentalHours reports whether every duration is between one hour and
+// MaxRentalHours.
+func ValidRentalHours(hours []int) bool {
+	for _, h := range hours {
+		if h < 1 || h > MaxRentalHours {
+			return false
+		}
+	}
+	return true
+}
+
 func (p UpdateMovieParams) ToBSON() bson.M {
 	m := bson.M{}
 	if len(p.Genre) >= minGenreLen {
@@ -86,8 +109,8 @@ func (p UpdateMovieParams) ToBSON() bson.M {
 	if p.Year >= minYear && p.Year <= time.Now().Year()+1 {
 		m["year"] = p.Year
 	}
-	if p.Rating > minRating && p.Rating <= maxRating {
-		m["rating"] = p.Rating
+	if len(p.RentalHours) > 0 && ValidRentalHours(p.RentalHours) {
+		m["rentalHours"] = p.RentalHours
 	}
 	return m
 }
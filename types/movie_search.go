@@ -0,0 +1,91 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+type MovieSort string
+
+// Popularity is measured by the number of user ratings.
+const (
+	SortTitle      MovieSort = "title"
+	SortYear       MovieSort = "year"
+	SortRating     MovieSort = "rating"
+	SortPopularity MovieSort = "popularity"
+)
+
+func (s MovieSort) IsValid() bool {
+	switch s {
+	case SortTitle, SortYear, SortRating, SortPopularity:
+		return true
+	}
+	return false
+}
+
+// MovieSearchParams describes a movie search. Zero values leave a criterion
+// out. Genre matches movies having any of the listed genres and GenreAll
+// those having every one of them. Sort names a MovieSort, prefixed with "-"
+// for descending order; without it movies keep their insertion order.
+type MovieSearchParams struct {
+	Query     string   `query:"q"`
+	Genre     []string `query:"genre"`
+	GenreAll  []string `query:"genreAll"`
+	YearFrom  int      `query:"yearFrom"`
+	YearTo    int      `query:"yearTo"`
+	LengthMin int      `query:"lengthMin"`
+	LengthMax int      `query:"lengthMax"`
+	MinRating float64  `query:"minRating"`
+	Sort      string   `query:"sort"`
+}
+
+func (p MovieSearchParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if p.YearFrom > 0 && p.YearTo > 0 && p.YearFrom > p.YearTo {
+		errors["year"] = "yearFrom should not be after yearTo"
+	}
+	if p.LengthMin < 0 || p.LengthMax < 0 || (p.LengthMax > 0 && p.LengthMin > p.LengthMax) {
+		errors["length"] = "lengthMin and lengthMax should be positive and lengthMin should not exceed lengthMax"
+	}
+	if p.MinRating < minRating || p.MinRating > maxRating {
+		errors["minRating"] = fmt.Sprintf("minRating should be between %d and %d", minRating, maxRating)
+	}
+	if sort, _ := p.SortBy(); sort != "" && !sort.IsValid() {
+		errors["sort"] = fmt.Sprintf("invalid sort: %s", p.Sort)
+	}
+	return errors
+}
+
+// SortBy splits Sort into the sort key and whether it is descending.
+func (p MovieSearchParams) SortBy() (MovieSort, bool) {
+	if strings.HasPrefix(p.Sort, "-") {
+		return MovieSort(p.Sort[1:]), true
+	}
+	return MovieSort(p.Sort), false
+}
+
+// Terms returns the lower cased words of the title query, a title matches
+// when it contains all of them.
+func (p MovieSearchParams) Terms() []string {
+	return SearchTerms(p.Query)
+}
+
+// SearchTerms splits text into lower cased words on anything that is not a
+// letter or a digit.
+func SearchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Decade returns the first year of the decade year falls in.
+func Decade(year int) int {
+	return year - year%10
+}
+
+// MovieFacets counts the movies matching a search per genre and per decade.
+type MovieFacets struct {
+	Genre  map[string]int `json:"genre"`
+	Decade map[int]int    `json:"decade"`
+}
@@ -0,0 +1,49 @@
+package types
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentAuthorized PaymentStatus = "authorized"
+	// PaymentPending is a capture the provider accepted but confirms later
+	// through a webhook.
+	PaymentPending  PaymentStatus = "pending"
+	PaymentCaptured PaymentStatus = "captured"
+	PaymentFailed   PaymentStatus = "failed"
+	PaymentRefunded PaymentStatus = "refunded"
+)
+
+// PaymentPurpose says what a payment of a rent paid for.
+type PaymentPurpose string
+
+const (
+	PaymentForRent      PaymentPurpose = "rent"
+	PaymentForExtension PaymentPurpose = "extension"
+)
+
+// Payment is a charge of a rent made through a payment provider. ProviderID
+// is the id the provider knows the payment by. Hours is how long an
+// extension payment extends its rent once it is captured.
+type Payment struct {
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	RentID     primitive.ObjectID `bson:"rentID" json:"rentID"`
+	UserID     primitive.ObjectID `bson:"userID" json:"userID"`
+	Purpose    PaymentPurpose     `bson:"purpose" json:"purpose"`
+	Provider   string             `bson:"provider" json:"provider"`
+	ProviderID string             `bson:"providerID" json:"providerID"`
+	Amount     int64              `bson:"amount" json:"amount"`
+	Hours      int                `bson:"hours,omitempty" json:"hours,omitempty"`
+	Currency   string             `bson:"currency" json:"currency"`
+	Status     PaymentStatus      `bson:"status" json:"status"`
+	CreatedAt  time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt  time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+func (p *Payment) OwnerID() primitive.ObjectID {
+	return p.UserID
+}
@@ -0,0 +1,124 @@
+package types
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// PriceRuleKind says how a price rule takes part in the price of a rent.
+type PriceRuleKind string
+
+const (
+	// PriceBase sets the price of a rent, for a movie, a format or both.
+	PriceBase PriceRuleKind = "base"
+	// PriceNewRelease adds a premium to movies released at most MaxAge
+	// years ago.
+	PriceNewRelease PriceRuleKind = "new_release"
+	// PriceDuration scales the price of rents of Hours hours.
+	PriceDuration PriceRuleKind = "duration"
+	// PriceWeekend scales the price of rents starting on a Saturday or a
+	// Sunday.
+	PriceWeekend PriceRuleKind = "weekend"
+)
+
+func (k PriceRuleKind) IsValid() bool {
+	switch k {
+	case PriceBase, PriceNewRelease, PriceDuration, PriceWeekend:
+		return true
+	}
+	return false
+}
+
+// MaxPercent caps the multiplier of a price rule at ten times the price.
+const MaxPercent = 1000
+
+// PriceRule is one rule of the price list. Amounts are in the minor units
+// of the rental currency, e.g. cents. Base rules set Amount, the others
+// scale the price by Percent, 150 making it one and a half times as much.
+// Rules with a Format only apply to copies of that format.
+type PriceRule struct {
+	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	Kind    PriceRuleKind      `bson:"kind" json:"kind"`
+	MovieID primitive.ObjectID `bson:"movieID,omitempty" json:"movieID,omitempty"`
+	Format  CopyFormat         `bson:"format,omitempty" json:"format,omitempty"`
+	Amount  int64              `bson:"amount,omitempty" json:"amount,omitempty"`
+	Percent int                `bson:"percent,omitempty" json:"percent,omitempty"`
+	MaxAge  int                `bson:"maxAge,omitempty" json:"maxAge,omitempty"`
+	Hours   int                `bson:"hours,omitempty" json:"hours,omitempty"`
+}
+
+// PriceRuleParams creates or replaces a price rule. Only the fields of its
+// kind may be set: MovieID and Amount for base rules, MaxAge for new
+// releases and Hours for durations.
+type PriceRuleParams struct {
+	Kind    PriceRuleKind `json:"kind"`
+	MovieID string        `json:"movieID"`
+	Format  CopyFormat    `json:"format"`
+	Amount  int64         `json:"amount"`
+	Percent int           `json:"percent"`
+	MaxAge  int           `json:"maxAge"`
+	Hours   int           `json:"hours"`
+}
+
+func (p PriceRuleParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if !p.Kind.IsValid() {
+		errors["kind"] = fmt.Sprintf("invalid kind %q, expected one of base, new_release, duration, weekend", p.Kind)
+		return errors
+	}
+	if p.Format != "" && !p.Format.IsValid() {
+		errors["format"] = fmt.Sprintf("invalid format %q, expected one of dvd, bluray, 4k, digital", p.Format)
+	}
+	if p.MovieID != "" {
+		if p.Kind != PriceBase {
+			errors["movieID"] = "only base prices can be set per movie"
+		} else if _, err := primitive.ObjectIDFromHex(p.MovieID); err != nil {
+			errors["movieID"] = "invalid movie id"
+		}
+	}
+	if p.Kind == PriceBase {
+		if p.Amount < 0 {
+			errors["amount"] = "amount can't be negative"
+		}
+		if p.Percent != 0 {
+			errors["percent"] = "base prices set an amount, not a percent"
+		}
+	} else {
+		if p.Amount != 0 {
+			errors["amount"] = "only base prices set an amount"
+		}
+		if p.Percent < 1 || p.Percent > MaxPercent {
+			errors["percent"] = fmt.Sprintf("percent should be between 1 and %d", MaxPercent)
+		}
+	}
+	if p.Kind == PriceNewRelease {
+		if p.MaxAge < 0 {
+			errors["maxAge"] = "max age can't be negative"
+		}
+	} else if p.MaxAge != 0 {
+		errors["maxAge"] = "only new release premiums set a max age"
+	}
+	if p.Kind == PriceDuration {
+		if p.Hours < 1 || p.Hours > MaxRentalHours {
+			errors["hours"] = fmt.Sprintf("hours should be between 1 and %d", MaxRentalHours)
+		}
+	} else if p.Hours != 0 {
+		errors["hours"] = "only duration rules set hours"
+	}
+	return errors
+}
+
+// NewPriceRuleFromParams expects validated params.
+func NewPriceRuleFromParams(params PriceRuleParams) *PriceRule {
+	movieID, _ := primitive.ObjectIDFromHex(params.MovieID)
+	return &PriceRule{
+		Kind:    params.Kind,
+		MovieID: movieID,
+		Format:  params.Format,
+		Amount:  params.Amount,
+		Percent: params.Percent,
+		MaxAge:  params.MaxAge,
+		Hours:   params.Hours,
+	}
+}
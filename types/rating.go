@@ -0,0 +1,44 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Rating is a single user's rating of a movie. Every user has at most one
+// rating per movie, rating again replaces the previous value.
+type Rating struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userID" json:"userID"`
+	MovieID   primitive.ObjectID `bson:"movieID" json:"movieID"`
+	Rating    int                `bson:"rating" json:"rating"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+// RatingStats is the aggregate of every rating of a movie.
+type RatingStats struct {
+	Average float64 `json:"average"`
+	Count   int     `json:"count"`
+}
+
+func (p UpdateMovieRating) Validate() map[string]string {
+	errors := map[string]string{}
+	if p.Rating < minRating || p.Rating > maxRating {
+		errors["rating"] = fmt.Sprintf("rating should be between %d and %d", minRating, maxRating)
+	}
+	return errors
+}
+
+func NewRatingFromParams(userID, movieID primitive.ObjectID, params UpdateMovieRating) *Rating {
+	now := time.Now()
+	return &Rating{
+		UserID:    userID,
+		MovieID:   movieID,
+		Rating:    params.Rating,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
@@ -6,12 +6,88 @@ import (
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+type RentState string
+
+const (
+	// RentPending is a rent waiting for its payment to be captured.
+	RentPending   RentState = "pending"
+	RentActive    RentState = "active"
+	RentReturned  RentState = "returned"
+	RentOverdue   RentState = "overdue"
+	RentCancelled RentState = "cancelled"
+)
+
+// rentTransitions lists the states each state can move to. Returned and
+// cancelled rents are final.
+var rentTransitions = map[RentState][]RentState{
+	RentPending: {RentActive, RentCancelled},
+	RentActive:  {RentReturned, RentOverdue, RentCancelled},
+	RentOverdue: {RentReturned},
+}
+
+func (s RentState) IsValid() bool {
+	switch s {
+	case RentPending, RentActive, RentReturned, RentOverdue, RentCancelled:
+		return true
+	}
+	return false
+}
+
+func (s RentState) CanTransitionTo(next RentState) bool {
+	for _, state := range rentTransitions[s] {
+		if state == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsOpen reports whether a rent in this state still holds the movie.
+func (s RentState) IsOpen() bool {
+	return s == RentPending || s == RentActive || s == RentOverdue
+}
+
+// AccruesLateFees reports whether a rent in this state owes late fees once
+// it is past its end. Pending rents aren't paid for yet, so they don't.
+func (s RentState) AccruesLateFees() bool {
+	return s == RentActive || s == RentOverdue
+}
+
 type Rent struct {
-	ID      primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
-	UserID  primitive.ObjectID `bson:"userID" json:"userID"`
-	MovieID primitive.ObjectID `bson:"movieID" json:"movieID"`
-	From    time.Time          `bson:"from" json:"from"`
-	To      time.Time          `bson:"to" json:"to"`
+	ID         primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID     primitive.ObjectID `bson:"userID" json:"userID"`
+	MovieID    primitive.ObjectID `bson:"movieID" json:"movieID"`
+	CopyID     primitive.ObjectID `bson:"copyID,omitempty" json:"copyID,omitempty"`
+	Format     CopyFormat         `bson:"format,omitempty" json:"format,omitempty"`
+	From       time.Time          `bson:"from" json:"from"`
+	To         time.Time          `bson:"to" json:"to"`
+	State      RentState          `bson:"state" json:"state"`
+	ReturnedAt *time.Time         `bson:"returnedAt,omitempty" json:"returnedAt,omitempty"`
+	Late       bool               `bson:"late" json:"late"`
+	Extensions int                `bson:"extensions" json:"extensions"`
+	// Price is what the rent and its extensions cost, in the minor units
+	// of Currency.
+	Price    int64  `bson:"price" json:"price"`
+	Currency string `bson:"currency,omitempty" json:"currency,omitempty"`
+	// LateFee accrues while the rent is overdue and is final once it is
+	// returned, in the same currency. LateFeePaid is set once it is paid
+	// from the renter's wallet, paid fees are no longer owed.
+	LateFee     int64 `bson:"lateFee" json:"lateFee"`
+	LateFeePaid bool  `bson:"lateFeePaid,omitempty" json:"lateFeePaid,omitempty"`
+}
+
+func (r *Rent) OwnerID() primitive.ObjectID {
+	return r.UserID
+}
+
+// SetState moves the rent to state at the given time. Callers are expected
+// to check CanTransitionTo first.
+func (r *Rent) SetState(state RentState, at time.Time) {
+	r.State = state
+	if state == RentReturned {
+		r.ReturnedAt = &at
+		r.Late = at.After(r.To)
+	}
 }
 
 type CheckRentParams struct {
@@ -21,17 +97,39 @@ type CheckRentParams struct {
 	To      time.Time          `bson:"to" json:"to"`
 }
 
+// DefaultRentalHours is the rental duration of rents created without one.
+const DefaultRentalHours = 24
+
+// MaxRentalHours caps every configured rental duration at 30 days.
+const MaxRentalHours = 30 * 24
+
 type CreateRentParams struct {
 	UserID  primitive.ObjectID `bson:"userID" json:"userID"`
 	MovieID primitive.ObjectID `json:"movieID"`
+	CopyID  primitive.ObjectID `json:"copyID"`
+	Format  CopyFormat         `json:"format"`
+	// Hours is the rental duration, DefaultRentalHours when zero.
+	Hours    int    `json:"hours"`
+	Price    int64  `json:"price"`
+	Currency string `json:"currency"`
 }
 
 func NewRentFromParams(params CreateRentParams) *Rent {
+	hours := params.Hours
+	if hours == 0 {
+		hours = DefaultRentalHours
+	}
+	now := time.Now()
 	return &Rent{
-		UserID:  params.UserID,
-		MovieID: params.MovieID,
-		From:    time.Now(),
-		To:      time.Now().Add(time.Hour * 24),
+		UserID:   params.UserID,
+		MovieID:  params.MovieID,
+		CopyID:   params.CopyID,
+		Format:   params.Format,
+		From:     now,
+		To:       now.Add(time.Duration(hours) * time.Hour),
+		State:    RentActive,
+		Price:    params.Price,
+		Currency: params.Currency,
 	}
 }
 
@@ -0,0 +1,69 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	minReviewLen = 3
+	maxReviewLen = 5000
+)
+
+type ReviewStatus string
+
+// New and edited reviews wait in the moderation queue as pending and only
+// approved reviews are listed publicly.
+const (
+	ReviewPending  ReviewStatus = "pending"
+	ReviewApproved ReviewStatus = "approved"
+	ReviewHidden   ReviewStatus = "hidden"
+)
+
+func (s ReviewStatus) IsValid() bool {
+	switch s {
+	case ReviewPending, ReviewApproved, ReviewHidden:
+		return true
+	}
+	return false
+}
+
+type Review struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userID" json:"userID"`
+	MovieID   primitive.ObjectID `bson:"movieID" json:"movieID"`
+	Body      string             `bson:"body" json:"body"`
+	Status    ReviewStatus       `bson:"status" json:"status"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+	UpdatedAt time.Time          `bson:"updatedAt" json:"updatedAt"`
+}
+
+func (r *Review) OwnerID() primitive.ObjectID {
+	return r.UserID
+}
+
+type ReviewParams struct {
+	Body string `json:"body"`
+}
+
+func (p ReviewParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if len(p.Body) < minReviewLen || len(p.Body) > maxReviewLen {
+		errors["body"] = fmt.Sprintf("review should be at least %d and max %d characters", minReviewLen, maxReviewLen)
+	}
+	return errors
+}
+
+func NewReviewFromParams(userID, movieID primitive.ObjectID, params ReviewParams) *Review {
+	now := time.Now()
+	return &Review{
+		UserID:    userID,
+		MovieID:   movieID,
+		Body:      params.Body,
+		Status:    ReviewPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+}
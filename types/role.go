@@ -0,0 +1,107 @@
+package types
+
+import (
+	"fmt"
+	"slices"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Permission is a named action a route can require.
+type Permission string
+
+const (
+	PermRentMovies      Permission = "movies:rent"
+	PermReviewMovies    Permission = "movies:review"
+	PermManageCatalog   Permission = "catalog:manage"
+	PermManageRents     Permission = "rents:manage"
+	PermModerateReviews Permission = "reviews:moderate"
+	PermReadUsers       Permission = "users:read"
+	PermManageUsers     Permission = "users:manage"
+	PermAssignRoles     Permission = "roles:assign"
+	PermReadAudit       Permission = "audit:read"
+	PermManageAPIKeys   Permission = "apikeys:manage"
+	PermManagePricing   Permission = "pricing:manage"
+	PermManageWallets   Permission = "wallets:manage"
+)
+
+// Role is a named set of permissions. Users can hold several roles and get
+// the union of their permissions.
+type Role string
+
+const (
+	RoleCustomer       Role = "customer"
+	RoleClerk          Role = "clerk"
+	RoleCatalogManager Role = "catalog_manager"
+	RoleAdmin          Role = "admin"
+)
+
+var customerPermissions = []Permission{PermRentMovies, PermReviewMovies}
+
+// RolePermissions lists the permissions of every role.
+var RolePermissions = map[Role][]Permission{
+	RoleCustomer: customerPermissions,
+	RoleClerk: append(slices.Clip(customerPermissions),
+		PermManageRents,
+		PermReadUsers,
+		PermManageWallets,
+	),
+	RoleCatalogManager: append(slices.Clip(customerPermissions),
+		PermManageCatalog,
+		PermModerateReviews,
+		PermManagePricing,
+	),
+	RoleAdmin: append(slices.Clip(customerPermissions),
+		PermManageCatalog,
+		PermManageRents,
+		PermModerateReviews,
+		PermReadUsers,
+		PermManageUsers,
+		PermAssignRoles,
+		PermReadAudit,
+		PermManageAPIKeys,
+		PermManagePricing,
+		PermManageWallets,
+	),
+}
+
+// IsValid reports whether any role grants the permission.
+func (p Permission) IsValid() bool {
+	for _, perms := range RolePermissions {
+		if slices.Contains(perms, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Role) IsValid() bool {
+	_, ok := RolePermissions[r]
+	return ok
+}
+
+func (r Role) Can(perm Permission) bool {
+	return slices.Contains(RolePermissions[r], perm)
+}
+
+// Owned is implemented by resources that belong to a user.
+type Owned interface {
+	OwnerID() primitive.ObjectID
+}
+
+type UpdateRolesParams struct {
+	Roles []Role `json:"roles"`
+}
+
+func (p UpdateRolesParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if len(p.Roles) == 0 {
+		errors["roles"] = "at least one role is required"
+	}
+	for _, role := range p.Roles {
+		if !role.IsValid() {
+			errors["roles"] = fmt.Sprintf("invalid role: %s", role)
+		}
+	}
+	return errors
+}
@@ -0,0 +1,55 @@
+package types
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Session is a login. Access tokens carry its id and stop being accepted
+// once it is revoked; the refresh token is stored only as a hash and is
+// replaced on every refresh. TwoFactor is set when the login was completed
+// with a second factor.
+type Session struct {
+	ID          primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID      primitive.ObjectID `bson:"userID" json:"userID"`
+	RefreshHash string             `bson:"refreshHash" json:"-"`
+	TwoFactor   bool               `bson:"twoFactor" json:"twoFactor"`
+	CreatedAt   time.Time          `bson:"createdAt" json:"createdAt"`
+	ExpiresAt   time.Time          `bson:"expiresAt" json:"expiresAt"`
+	RevokedAt   *time.Time         `bson:"revokedAt" json:"revokedAt,omitempty"`
+}
+
+// IsActive reports whether the session is neither revoked nor expired.
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+func NewSession(userID primitive.ObjectID, refreshHash string, ttl time.Duration) *Session {
+	now := time.Now()
+	return &Session{
+		UserID:      userID,
+		RefreshHash: refreshHash,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+	}
+}
+
+// NewSecret returns a random URL safe token.
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashSecret is the form a secret token is stored in.
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,68 @@
+package types
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// TOTP is the authenticator app enrolment of a user. It only guards logins
+// once Confirmed, which takes a first valid code. LastStep is the time step
+// of the last accepted code so no code is accepted twice.
+type TOTP struct {
+	UserID         primitive.ObjectID `bson:"_id" json:"userID"`
+	Secret         string             `bson:"secret" json:"-"`
+	Confirmed      bool               `bson:"confirmed" json:"confirmed"`
+	RecoveryHashes []string           `bson:"recoveryHashes" json:"-"`
+	LastStep       int64              `bson:"lastStep" json:"-"`
+	CreatedAt      time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+func NewTOTP(userID primitive.ObjectID, secret string) *TOTP {
+	return &TOTP{
+		UserID:    userID,
+		Secret:    secret,
+		CreatedAt: time.Now(),
+	}
+}
+
+const recoveryCodeCount = 10
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewRecoveryCodes returns single use codes that replace a TOTP code when
+// the authenticator is lost, and the hashes they are stored as.
+func NewRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, recoveryCodeCount)
+	hashes := make([]string, recoveryCodeCount)
+	for i := range codes {
+		b := make([]byte, 10)
+		if _, err := rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		s := strings.ToLower(recoveryEncoding.EncodeToString(b))
+		codes[i] = s[:8] + "-" + s[8:]
+		hashes[i] = HashRecoveryCode(codes[i])
+	}
+	return codes, hashes, nil
+}
+
+// HashRecoveryCode ignores case and dashes so codes can be typed loosely.
+func HashRecoveryCode(code string) string {
+	return HashSecret(strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", "")))
+}
+
+// TOTPCodeParams carries a code of the authenticator app.
+type TOTPCodeParams struct {
+	Code string `json:"code"`
+}
+
+// DisableTOTPParams asks for the password as well as a code, so a stolen
+// session alone can't turn the second factor off.
+type DisableTOTPParams struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
@@ -3,6 +3,8 @@ package types
 import (
 	"fmt"
 	"regexp"
+	"slices"
+	"strings"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"golang.org/x/crypto/bcrypt"
@@ -23,7 +25,57 @@ type User struct {
 	LastName          string             `bson:"lastName" json:"lastName"`
 	EncryptedPassword string             `bson:"encryptedPassword" json:"-"`
 	Email             string             `bson:"email" json:"email"`
-	IsAdmin           bool               `bson:"isAdmin" json:"isAdmin"`
+	Roles             []Role             `bson:"roles" json:"roles"`
+	EmailVerified     bool               `bson:"emailVerified" json:"emailVerified"`
+	Identity          *Identity          `bson:"identity,omitempty" json:"-"`
+
+	// scopes limit the permissions of the user while acting through an
+	// API key, see Restrict.
+	restricted bool
+	scopes     []Permission
+}
+
+// Identity links a user to the account of an OIDC provider they log in
+// with. Users created through the provider have no password.
+type Identity struct {
+	Issuer  string `bson:"issuer" json:"issuer"`
+	Subject string `bson:"subject" json:"subject"`
+}
+
+// OwnerID makes users the owners of their own profile.
+func (u *User) OwnerID() primitive.ObjectID {
+	return u.ID
+}
+
+// Restrict limits the permissions of the user to scopes, even when scopes
+// is empty.
+func (u *User) Restrict(scopes []Permission) {
+	u.restricted = true
+	u.scopes = scopes
+}
+
+// Can reports whether any role of the user grants perm and, for a
+// restricted user, whether perm is in scope.
+func (u *User) Can(perm Permission) bool {
+	if u.restricted && !slices.Contains(u.scopes, perm) {
+		return false
+	}
+	for _, role := range u.Roles {
+		if role.Can(perm) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStaff reports whether the user holds a role other than customer.
+func (u *User) IsStaff() bool {
+	for _, role := range u.Roles {
+		if role != RoleCustomer {
+			return true
+		}
+	}
+	return false
 }
 
 type CreateUserParams struct {
@@ -34,8 +86,74 @@ type CreateUserParams struct {
 	Email     string `json:"email"`
 }
 
+// UpdateUserParams changes the profile of a user, empty fields are left
+// as they are. Changing the email requires CurrentPassword.
+type UpdateUserParams struct {
+	Username        string `json:"username"`
+	FirstName       string `json:"firstName"`
+	LastName        string `json:"lastName"`
+	Email           string `json:"email"`
+	CurrentPassword string `json:"currentPassword"`
+}
+
+// Validate applies the CreateUserParams rules to the fields being changed.
+func (p UpdateUserParams) Validate() map[string]string {
+	errors := CreateUserParams{
+		Username:  p.Username,
+		FirstName: p.FirstName,
+		LastName:  p.LastName,
+		Email:     p.Email,
+	}.Validate()
+	delete(errors, "password")
+	for field, value := range map[string]string{
+		"username":  p.Username,
+		"firstName": p.FirstName,
+		"lastName":  p.LastName,
+		"email":     p.Email,
+	} {
+		if value == "" {
+			delete(errors, field)
+		}
+	}
+	return errors
+}
+
+// ChangePasswordParams sets a new password, proven by the current one.
+type ChangePasswordParams struct {
+	CurrentPassword string `json:"currentPassword"`
+	Password        string `json:"password"`
+}
+
+func (p ChangePasswordParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if p.CurrentPassword == "" {
+		errors["currentPassword"] = "current password is required"
+	}
+	if msg := validatePassword(p.Password); msg != "" {
+		errors["password"] = msg
+	}
+	return errors
+}
+
+// ResetPasswordParams sets a new password with a mailed reset token.
+type ResetPasswordParams struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+func (p ResetPasswordParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if p.Token == "" {
+		errors["token"] = "token is required"
+	}
+	if msg := validatePassword(p.Password); msg != "" {
+		errors["password"] = msg
+	}
+	return errors
+}
+
 func NewUserFromParams(params CreateUserParams) (*User, error) {
-	encpwd, err := bcrypt.GenerateFromPassword([]byte(params.Password), bcrypt.DefaultCost)
+	encpwd, err := EncryptPassword(params.Password)
 	if err != nil {
 		return nil, err
 	}
@@ -43,14 +161,15 @@ func NewUserFromParams(params CreateUserParams) (*User, error) {
 		Username:          params.Username,
 		FirstName:         params.FirstName,
 		LastName:          params.LastName,
-		EncryptedPassword: string(encpwd),
-		Email:             params.Email,
+		EncryptedPassword: encpwd,
+		Email:             NormalizeEmail(params.Email),
+		Roles:             []Role{RoleCustomer},
 	}, nil
 }
 
 func (p CreateUserParams) Validate() map[string]string {
 	errors := map[string]string{}
-	if len(p.Username) < minUsernameLen {
+	if !IsValidUsername(p.Username) {
 		errors["username"] = fmt.Sprintf("username must be at least %d characters long", minUsernameLen)
 	}
 	if len(p.FirstName) < minFirstNameLen {
@@ -59,20 +178,45 @@ func (p CreateUserParams) Validate() map[string]string {
 	if len(p.LastName) < minLastNameLen {
 		errors["lastName"] = fmt.Sprintf("last name must be at least %d characters long", minLastNameLen)
 	}
-	if len(p.Password) < minPasswordLen {
-		errors["password"] = fmt.Sprintf("password must be at least %d characters long", minPasswordLen)
+	if msg := validatePassword(p.Password); msg != "" {
+		errors["password"] = msg
 	}
-	if isValidEmail(p.Email) == false {
+	if isValidEmail(NormalizeEmail(p.Email)) == false {
 		errors["email"] = fmt.Sprintf("invalid email address: %s", p.Email)
 	}
 	return errors
 }
 
+func IsValidUsername(username string) bool {
+	return len(username) >= minUsernameLen
+}
+
+// NormalizeEmail is the form emails are stored and looked up in, so they
+// are unique regardless of case.
+func NormalizeEmail(e string) string {
+	return strings.ToLower(strings.TrimSpace(e))
+}
+
 func isValidEmail(e string) bool {
 	email := regexp.MustCompile(emailRegex)
 	return email.MatchString(e)
 }
 
+func validatePassword(pw string) string {
+	if len(pw) < minPasswordLen {
+		return fmt.Sprintf("password must be at least %d characters long", minPasswordLen)
+	}
+	return ""
+}
+
+func EncryptPassword(pw string) (string, error) {
+	encpw, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(encpw), nil
+}
+
 func IsValidPassword(encpw, pw string) bool {
 	return bcrypt.CompareHashAndPassword([]byte(encpw), []byte(pw)) == nil
 }
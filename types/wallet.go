@@ -0,0 +1,81 @@
+package types
+
+import (
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// WalletEntryKind says why the balance of a wallet changed.
+type WalletEntryKind string
+
+const (
+	WalletTopUp        WalletEntryKind = "top_up"
+	WalletRentalCharge WalletEntryKind = "rental_charge"
+	WalletRefund       WalletEntryKind = "refund"
+	WalletLateFee      WalletEntryKind = "late_fee"
+	// WalletAdjustment is a credit or debit made by staff, with a reason.
+	WalletAdjustment WalletEntryKind = "adjustment"
+)
+
+func (k WalletEntryKind) IsValid() bool {
+	switch k {
+	case WalletTopUp, WalletRentalCharge, WalletRefund, WalletLateFee, WalletAdjustment:
+		return true
+	}
+	return false
+}
+
+// MaxReasonLength caps the reason staff give for a wallet adjustment.
+const MaxReasonLength = 200
+
+// WalletEntry is one line of the append-only ledger of a user's prepaid
+// wallet. Amount is positive for credits and negative for debits, in the
+// minor units of Currency. Seq numbers the entries of a wallet from 1 and
+// Balance is the balance right after the entry, so the latest entry holds
+// the balance of the wallet. RentID links charges, refunds and late fees to
+// their rent and ActorID is the staff member who made an adjustment or
+// top-up.
+type WalletEntry struct {
+	ID        primitive.ObjectID `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    primitive.ObjectID `bson:"userID" json:"userID"`
+	Seq       int64              `bson:"seq" json:"seq"`
+	Kind      WalletEntryKind    `bson:"kind" json:"kind"`
+	Amount    int64              `bson:"amount" json:"amount"`
+	Balance   int64              `bson:"balance" json:"balance"`
+	Currency  string             `bson:"currency" json:"currency"`
+	RentID    primitive.ObjectID `bson:"rentID,omitempty" json:"rentID,omitempty"`
+	ActorID   primitive.ObjectID `bson:"actorID,omitempty" json:"actorID,omitempty"`
+	Reason    string             `bson:"reason,omitempty" json:"reason,omitempty"`
+	CreatedAt time.Time          `bson:"createdAt" json:"createdAt"`
+}
+
+func (e *WalletEntry) OwnerID() primitive.ObjectID {
+	return e.UserID
+}
+
+// WalletAdjustmentParams credits or debits a wallet by Amount, which is
+// always positive. Credits can be recorded as a top-up instead of an
+// adjustment by setting Kind.
+type WalletAdjustmentParams struct {
+	Amount int64           `json:"amount"`
+	Reason string          `json:"reason"`
+	Kind   WalletEntryKind `json:"kind"`
+}
+
+func (p WalletAdjustmentParams) Validate() map[string]string {
+	errors := map[string]string{}
+	if p.Amount <= 0 {
+		errors["amount"] = "amount should be positive"
+	}
+	if p.Reason == "" {
+		errors["reason"] = "reason is required"
+	} else if len(p.Reason) > MaxReasonLength {
+		errors["reason"] = fmt.Sprintf("reason should be at most %d characters", MaxReasonLength)
+	}
+	if p.Kind != "" && p.Kind != WalletTopUp && p.Kind != WalletAdjustment {
+		errors["kind"] = fmt.Sprintf("invalid kind %q, expected one of top_up, adjustment", p.Kind)
+	}
+	return errors
+}